@@ -0,0 +1,349 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// clients are the gitea clients of all provisioned handlers.
+var clients = struct {
+	sync.Mutex
+	m map[*gitea.Client]struct{}
+}{m: make(map[*gitea.Client]struct{})}
+
+func registerClient(c *gitea.Client) {
+	clients.Lock()
+	defer clients.Unlock()
+
+	clients.m[c] = struct{}{}
+}
+
+func unregisterClient(c *gitea.Client) {
+	clients.Lock()
+	defer clients.Unlock()
+
+	delete(clients.m, c)
+}
+
+// eachClient calls fn for the client of every provisioned handler.
+func eachClient(fn func(*gitea.Client)) {
+	clients.Lock()
+	defer clients.Unlock()
+
+	for c := range clients.m {
+		fn(c)
+	}
+}
+
+// adminAPI is a module that serves the gitea endpoints of the caddy admin API,
+// for the clients of all handlers. The gitea_pages_api handlers serve them
+// for the clients of their handlers with each.
+type adminAPI struct {
+	each func(fn func(*gitea.Client))
+}
+
+// eachClient calls fn for the clients the endpoints are for.
+func (a adminAPI) eachClient(fn func(*gitea.Client)) {
+	if a.each != nil {
+		a.each(fn)
+		return
+	}
+
+	eachClient(fn)
+}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.gitea",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes of the gitea handlers.
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/gitea/flags",
+			Handler: caddy.AdminHandlerFunc(a.handleFlags),
+		},
+		{
+			Pattern: "/gitea/purge",
+			Handler: caddy.AdminHandlerFunc(a.handlePurge),
+		},
+		{
+			Pattern: "/gitea/purge-not-found",
+			Handler: caddy.AdminHandlerFunc(a.handlePurgeNotFound),
+		},
+		{
+			Pattern: "/gitea/sites",
+			Handler: caddy.AdminHandlerFunc(a.handleSites),
+		},
+		{
+			Pattern: "/gitea/takedowns",
+			Handler: caddy.AdminHandlerFunc(a.handleTakedowns),
+		},
+		{
+			Pattern: "/gitea/warm",
+			Handler: caddy.AdminHandlerFunc(a.handleWarm),
+		},
+	}
+}
+
+// handleFlags lists the experimental features of the handlers and the sites
+// they're enabled for, a feature not listed is enabled for all sites.
+func (a adminAPI) handleFlags(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	flags := []gitea.FeatureFlag{}
+
+	a.eachClient(func(c *gitea.Client) {
+		flags = append(flags, c.FeatureFlags()...)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(flags)
+}
+
+// handlePurge purges the cached content of the site given in the site query
+// parameter (owner/repo) on this node and its peers: the files below the path
+// parameters (any number) at the ref parameter, or the whole repo without
+// paths. The id parameter is the idempotency key of the purge, the reason
+// parameter defaults to admin.
+func (a adminAPI) handlePurge(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	q := r.URL.Query()
+
+	owner, repo, ok := strings.Cut(q.Get("site"), "/")
+	if !ok || owner == "" || repo == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("site must be owner/repo"),
+		}
+	}
+
+	p := gitea.NewPurge(owner, repo, gitea.PurgeReasonAdmin)
+	p.Ref = q.Get("ref")
+	p.Paths = q["path"]
+
+	if id := q.Get("id"); id != "" {
+		p.ID = id
+	}
+
+	if reason := q.Get("reason"); reason != "" {
+		p.Reason = reason
+	}
+
+	applied := 0
+
+	// every handler has its own caches
+	a.eachClient(func(c *gitea.Client) {
+		if c.Purge(p) {
+			applied++
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(map[string]any{"id": p.ID, "applied": applied})
+}
+
+// handlePurgeNotFound forgets the cached not found decisions,
+// of the owner given in the owner query parameter or all of them.
+func (a adminAPI) handlePurgeNotFound(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	owner := r.URL.Query().Get("owner")
+	purged := 0
+
+	a.eachClient(func(c *gitea.Client) {
+		purged += c.PurgeNotFound(owner)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+// handleSites lists all sites served by the handlers with their statistics.
+func (a adminAPI) handleSites(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	sites := []gitea.SiteStats{}
+
+	a.eachClient(func(c *gitea.Client) {
+		sites = append(sites, c.Sites()...)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(sites)
+}
+
+// handleTakedowns lists the takedown list (GET), takes down the target given
+// in the target query parameter for the reason parameter (POST) or serves it
+// again (DELETE).
+func (a adminAPI) handleTakedowns(w http.ResponseWriter, r *http.Request) error {
+	target := r.URL.Query().Get("target")
+
+	switch r.Method {
+	case http.MethodGet:
+		takedowns := []gitea.Takedown{}
+
+		a.eachClient(func(c *gitea.Client) {
+			takedowns = append(takedowns, c.Takedowns()...)
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+
+		return json.NewEncoder(w).Encode(takedowns)
+	case http.MethodPost, http.MethodDelete:
+		if target == "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("missing target"),
+			}
+		}
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	var err error
+
+	a.eachClient(func(c *gitea.Client) {
+		var cerr error
+
+		if r.Method == http.MethodPost {
+			cerr = c.AddTakedown(target, r.URL.Query().Get("reason"))
+		} else {
+			_, cerr = c.RemoveTakedown(target)
+		}
+
+		if err == nil {
+			err = cerr
+		}
+	})
+
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("saving takedown list: %w", err),
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// handleWarm fetches all files of the site given in the site query parameter
+// (owner/repo) at the ref parameter into the cache, concurrency parameter
+// files at once.
+func (a adminAPI) handleWarm(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	owner, repo, ok := strings.Cut(r.URL.Query().Get("site"), "/")
+	if !ok || owner == "" || repo == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("site must be owner/repo"),
+		}
+	}
+
+	var concurrency int
+
+	if v := r.URL.Query().Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid concurrency %q", v),
+			}
+		}
+
+		concurrency = n
+	}
+
+	var (
+		warmed int
+		err    error
+	)
+
+	// the site is usually served by one of the handlers
+	a.eachClient(func(c *gitea.Client) {
+		n, cerr := c.WarmSite(owner, repo, r.URL.Query().Get("ref"), concurrency)
+		warmed += n
+
+		if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, gitea.ErrNoCache) {
+			err = cerr
+		}
+	})
+
+	if warmed == 0 && err != nil {
+		status := http.StatusBadGateway
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			status = http.StatusNotFound
+		case errors.Is(err, gitea.ErrNoCache):
+			status = http.StatusConflict
+		}
+
+		return caddy.APIError{
+			HTTPStatus: status,
+			Err:        fmt.Errorf("warming %s/%s: %w", owner, repo, err),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(map[string]int{"warmed": warmed})
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
@@ -0,0 +1,217 @@
+package gitea
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(new(App))
+	httpcaddyfile.RegisterGlobalOption("gitea_pages", parseAppCaddyfile)
+}
+
+// App is the gitea_pages app. It owns the gitea pages of its config by name:
+// their gitea client, caches, site inventory and background jobs (exports,
+// refreshes, health checks), provisioned once however many handlers serve
+// them. The gitea handlers serve them with use, the gitea_pages_api handlers
+// serve their endpoints. The gitea handlers with their own config register
+// their client too, under their name.
+type App struct {
+	// Pages are the gitea pages by name, configured like the gitea handler.
+	Pages map[string]*Middleware `json:"pages,omitempty"`
+
+	mu      sync.Mutex
+	clients map[*gitea.Client]string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "gitea_pages",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision implements caddy.Provisioner, it provisions the pages in the
+// order of their names.
+func (a *App) Provision(ctx caddy.Context) error {
+	names := make([]string, 0, len(a.Pages))
+	for name := range a.Pages {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := a.Pages[name]
+		if m.Use != "" {
+			return fmt.Errorf("pages %s: use is for the gitea handler", name)
+		}
+
+		m.Name = name
+		m.app = a
+
+		if err := m.Provision(ctx); err != nil {
+			return fmt.Errorf("pages %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Start implements caddy.App.
+func (*App) Start() error {
+	return nil
+}
+
+// Stop implements caddy.App.
+func (*App) Stop() error {
+	return nil
+}
+
+// Cleanup implements caddy.CleanerUpper, it cleans up the pages.
+func (a *App) Cleanup() error {
+	for _, m := range a.Pages {
+		if err := m.Cleanup(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// register adds the client of the pages named name, names are unique.
+func (a *App) register(name string, c *gitea.Client) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.clients == nil {
+		a.clients = make(map[*gitea.Client]string)
+	}
+
+	if name != "" {
+		for _, n := range a.clients {
+			if n == name {
+				return fmt.Errorf("other gitea pages are named %q", name)
+			}
+		}
+	}
+
+	a.clients[c] = name
+
+	return nil
+}
+
+func (a *App) unregister(c *gitea.Client) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.clients, c)
+}
+
+// each calls fn for the client of the pages named name, or of all pages if
+// name is empty, and returns how many it called it for.
+func (a *App) each(name string, fn func(*gitea.Client)) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+
+	for c, cname := range a.clients {
+		if name == "" || cname == name {
+			fn(c)
+			n++
+		}
+	}
+
+	return n
+}
+
+// provisionUse provisions a gitea handler serving the pages of the app named
+// m.Use, it has no other options.
+func (m *Middleware) provisionUse(ctx caddy.Context) error {
+	other := *m
+	other.Use, other.Tiers = "", nil
+
+	if !reflect.ValueOf(other).IsZero() {
+		return fmt.Errorf("use %s: the options but tiers are set on the pages", m.Use)
+	}
+
+	app, err := ctx.App("gitea_pages")
+	if err != nil {
+		return fmt.Errorf("getting gitea_pages app: %w", err)
+	}
+
+	m.shared = app.(*App).Pages[m.Use]
+	if m.shared == nil {
+		return fmt.Errorf("use %s: no such gitea pages", m.Use)
+	}
+
+	if err := checkTiers(m.Tiers, m.shared.TopicTiers); err != nil {
+		return fmt.Errorf("use %s: %w", m.Use, err)
+	}
+
+	return nil
+}
+
+// parseAppCaddyfile unmarshals the gitea_pages global option, the pages
+// have the options of the gitea directive.
+//
+//	gitea_pages {
+//		<name> {
+//			server https://yourgitea.yourdomain.com
+//			token agiteatoken
+//			...
+//		}
+//	}
+func parseAppCaddyfile(d *caddyfile.Dispenser, existing any) (any, error) {
+	app := new(App)
+
+	// the option may be given more than once
+	if existing != nil {
+		if err := json.Unmarshal(existing.(httpcaddyfile.App).Value, app); err != nil {
+			return nil, err
+		}
+	}
+
+	if app.Pages == nil {
+		app.Pages = make(map[string]*Middleware)
+	}
+
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			name := d.Val()
+			if _, ok := app.Pages[name]; ok {
+				return nil, d.Errf("duplicate gitea pages %q", name)
+			}
+
+			m := new(Middleware)
+			if err := m.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+				return nil, err
+			}
+
+			app.Pages[name] = m
+		}
+	}
+
+	return httpcaddyfile.App{
+		Name:  "gitea_pages",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// Interface guards
+var (
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
+)
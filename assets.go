@@ -0,0 +1,87 @@
+package gitea
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// defaultAssetExtensions are the extensions of fonts, WASM modules and other
+// assets that are commonly embedded from other origins.
+var defaultAssetExtensions = []string{
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".wasm", ".js", ".mjs", ".css", ".json",
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico",
+}
+
+// AssetHeaders are the headers that allow static assets of a site to be
+// embedded by other origins, with the Cross-Origin-Resource-Policy,
+// Access-Control-Allow-Origin and Timing-Allow-Origin headers.
+type AssetHeaders struct {
+	// Extensions are the extensions of the assets, defaults to fonts, WASM,
+	// scripts, styles and images.
+	Extensions []string `json:"extensions,omitempty"`
+	// AllowOrigin is the origin allowed to load the assets, defaults to *.
+	AllowOrigin string `json:"allow_origin,omitempty"`
+}
+
+// setHeaders sets the asset headers on w if p is an asset.
+func (a *AssetHeaders) setHeaders(w http.ResponseWriter, p string) {
+	extensions := a.Extensions
+	if len(extensions) == 0 {
+		extensions = defaultAssetExtensions
+	}
+
+	if !containsFold(extensions, path.Ext(p)) {
+		return
+	}
+
+	origin := a.AllowOrigin
+	if origin == "" {
+		origin = "*"
+	}
+
+	h := w.Header()
+	h.Set("Cross-Origin-Resource-Policy", "cross-origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Timing-Allow-Origin", origin)
+
+	if origin != "*" {
+		h.Add("Vary", "Origin")
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnmarshalCaddyfile unmarshals the assets block of a Caddyfile.
+//
+//	assets {
+//		extensions .woff2 .wasm
+//		allow_origin https://www.example.com
+//	}
+func (a *AssetHeaders) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "extensions":
+			a.Extensions = append(a.Extensions, d.RemainingArgs()...)
+		case "allow_origin":
+			if !d.Args(&a.AllowOrigin) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown assets option %q", d.Val())
+		}
+	}
+
+	return nil
+}
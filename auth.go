@@ -0,0 +1,213 @@
+package gitea
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(TokenAuth{})
+}
+
+// AuthProvider is implemented by the modules in the http.handlers.gitea.auth
+// namespace, which decide who may visit the pages of private repos.
+type AuthProvider interface {
+	// Authenticate returns the identity of the visitor of r. When the visitor
+	// isn't authenticated it returns a nil identity and may have written a
+	// response to w (e.g. a redirect to a login page), reported by handled.
+	Authenticate(w http.ResponseWriter, r *http.Request, c *gitea.Client) (id *gitea.Identity, handled bool, err error)
+
+	// Authorize reports if id may read the pages of owner/repo.
+	Authorize(id *gitea.Identity, owner, repo string, c *gitea.Client) (bool, error)
+}
+
+// authorize runs the auth provider for requests of private repos. It reports
+// if a response has already been written.
+func (m Middleware) authorize(w http.ResponseWriter, r *http.Request, fp, ref string) (bool, error) {
+	// requests for the auth endpoints (e.g. the oauth callback) always go to the provider
+	if !strings.Contains(r.URL.Path, authPathPrefix) {
+		info, err := m.Client.RepoInfo(r.Context(), fp, ref)
+		if errors.Is(err, fs.ErrNotExist) {
+			// served as not found
+			return false, nil
+		}
+
+		if err != nil {
+			return true, m.lookupError(err)
+		}
+
+		if !info.Private {
+			return false, nil
+		}
+
+		id, handled, err := m.auth.Authenticate(w, r, m.Client)
+		if handled || err != nil {
+			return true, err
+		}
+
+		if id == nil {
+			m.Client.Emit(gitea.EventAuthDenied, map[string]any{"owner": info.Owner, "repo": info.Repo})
+			return true, caddyhttp.Error(http.StatusUnauthorized, nil)
+		}
+
+		ok, err := m.auth.Authorize(id, info.Owner, info.Repo, m.Client)
+		if err != nil {
+			return true, caddyhttp.Error(http.StatusBadGateway, err)
+		}
+
+		// like gitea we don't tell a private repo exists
+		if !ok {
+			m.Client.Emit(gitea.EventAuthDenied, map[string]any{
+				"owner": info.Owner,
+				"repo":  info.Repo,
+				"user":  id.Username,
+			})
+
+			return true, caddyhttp.Error(http.StatusNotFound, nil)
+		}
+
+		return false, nil
+	}
+
+	_, handled, err := m.auth.Authenticate(w, r, m.Client)
+	if !handled && err == nil {
+		return true, caddyhttp.Error(http.StatusNotFound, nil)
+	}
+
+	return true, err
+}
+
+// lookupError refuses a request whose repo can't be looked up: the repo may
+// be private and the stale copy of its site mustn't be served to anyone.
+func (m Middleware) lookupError(err error) error {
+	if errors.Is(err, gitea.ErrTimeout) || errors.Is(err, gitea.ErrUpstreamBusy) ||
+		errors.Is(err, gitea.ErrMaintenance) || errors.Is(err, gitea.ErrTakenDown) {
+		return m.httpError(err)
+	}
+
+	return caddyhttp.Error(http.StatusBadGateway, err)
+}
+
+// replayable reports if r arrived as 0-RTT early data, directly or through
+// a proxy that says so with the Early-Data header (RFC 8470), and would do
+// harm if replayed: requests of the auth endpoints (logging in, the oauth
+// callback) and requests with methods that aren't idempotent.
+func replayable(r *http.Request) bool {
+	early := r.Header.Get("Early-Data") == "1" || r.TLS != nil && !r.TLS.HandshakeComplete
+	if !early {
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.Contains(r.URL.Path, authPathPrefix)
+	}
+
+	return true
+}
+
+// forbidPrivate refuses requests of private repos, used when no auth provider
+// protects them.
+func (m Middleware) forbidPrivate(r *http.Request, fp, ref string) error {
+	info, err := m.Client.RepoInfo(r.Context(), fp, ref)
+	if errors.Is(err, fs.ErrNotExist) {
+		// served as not found
+		return nil
+	}
+
+	if err != nil {
+		return m.lookupError(err)
+	}
+
+	if !info.Private {
+		return nil
+	}
+
+	m.logger.Warn("refusing to serve private repo without auth",
+		zap.String("owner", info.Owner), zap.String("repo", info.Repo))
+
+	// like gitea we don't tell a private repo exists
+	return caddyhttp.Error(http.StatusNotFound, nil)
+}
+
+// TokenAuth authenticates visitors with a gitea access token, sent as
+// password using basic auth or in an "Authorization: token" header.
+type TokenAuth struct {
+	// Realm is the basic auth realm, defaults to "gitea pages".
+	Realm string `json:"realm,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (TokenAuth) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.auth.token",
+		New: func() caddy.Module { return new(TokenAuth) },
+	}
+}
+
+// Authenticate implements AuthProvider.
+func (a TokenAuth) Authenticate(w http.ResponseWriter, r *http.Request, c *gitea.Client) (*gitea.Identity, bool, error) {
+	token := ""
+
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	} else if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "token ") {
+		token = strings.TrimPrefix(h, "token ")
+	}
+
+	if token != "" {
+		if id, err := c.User(token); err == nil {
+			return id, false, nil
+		}
+	}
+
+	realm := a.Realm
+	if realm == "" {
+		realm = "gitea pages"
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+
+	return nil, true, caddyhttp.Error(http.StatusUnauthorized, nil)
+}
+
+// Authorize implements AuthProvider.
+func (TokenAuth) Authorize(id *gitea.Identity, owner, repo string, c *gitea.Client) (bool, error) {
+	return c.CanRead(id, owner, repo)
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	auth token {
+//		realm "gitea pages"
+//	}
+func (a *TokenAuth) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "realm":
+				if !d.Args(&a.Realm) {
+					return d.ArgErr()
+				}
+			default:
+				return d.Errf("unknown token auth option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ AuthProvider          = (*TokenAuth)(nil)
+	_ caddyfile.Unmarshaler = (*TokenAuth)(nil)
+)
@@ -0,0 +1,335 @@
+package gitea
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(OAuth{})
+}
+
+const (
+	// authPathPrefix is the path prefix of the auth endpoints of a site.
+	authPathPrefix = "/.gitea-pages/auth/"
+	// oauthCallbackPath is the path gitea redirects to after login.
+	oauthCallbackPath = authPathPrefix + "callback"
+	// oauthStateCookie keeps the oauth state and the url to return to.
+	oauthStateCookie = "gitea_pages_oauth_state"
+)
+
+// OAuth authenticates visitors by logging them in to gitea using OAuth2.
+// The callback url of the OAuth2 application in gitea is
+// https://<site host>/.gitea-pages/auth/callback for every site host.
+type OAuth struct {
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// CookieSecret is used to encrypt the session cookies.
+	CookieSecret string `json:"cookie_secret,omitempty"`
+	// CookieName is the name of the session cookie, defaults to gitea_pages_session.
+	CookieName string `json:"cookie_name,omitempty"`
+	// SessionTTL is how long a login is valid, defaults to 24h.
+	SessionTTL caddy.Duration `json:"session_ttl,omitempty"`
+
+	aead cipher.AEAD
+}
+
+// session is the content of the session cookie.
+type session struct {
+	gitea.Identity
+	Expires int64 `json:"expires"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (OAuth) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.auth.gitea_oauth",
+		New: func() caddy.Module { return new(OAuth) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (a *OAuth) Provision(ctx caddy.Context) error {
+	if a.CookieName == "" {
+		a.CookieName = "gitea_pages_session"
+	}
+
+	if a.SessionTTL == 0 {
+		a.SessionTTL = caddy.Duration(24 * time.Hour)
+	}
+
+	key := sha256.Sum256([]byte(a.CookieSecret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+
+	a.aead, err = cipher.NewGCM(block)
+
+	return err
+}
+
+// Validate implements caddy.Validator.
+func (a *OAuth) Validate() error {
+	if a.ClientID == "" || a.ClientSecret == "" {
+		return errors.New("gitea_oauth: client_id and client_secret are required")
+	}
+
+	if len(a.CookieSecret) < 32 {
+		return errors.New("gitea_oauth: cookie_secret must be at least 32 characters")
+	}
+
+	return nil
+}
+
+// Authenticate implements AuthProvider.
+func (a *OAuth) Authenticate(w http.ResponseWriter, r *http.Request, c *gitea.Client) (*gitea.Identity, bool, error) {
+	if strings.HasSuffix(r.URL.Path, oauthCallbackPath) {
+		return nil, true, a.callback(w, r, c)
+	}
+
+	if cookie, err := r.Cookie(a.CookieName); err == nil {
+		var s session
+		if err := a.open(cookie.Value, &s); err == nil && time.Now().Unix() < s.Expires {
+			return &s.Identity, false, nil
+		}
+	}
+
+	return nil, true, a.login(w, r, c)
+}
+
+// Authorize implements AuthProvider.
+func (a *OAuth) Authorize(id *gitea.Identity, owner, repo string, c *gitea.Client) (bool, error) {
+	return c.CanRead(id, owner, repo)
+}
+
+// login redirects the visitor to the gitea login.
+func (a *OAuth) login(w http.ResponseWriter, r *http.Request, c *gitea.Client) error {
+	state := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, state); err != nil {
+		return err
+	}
+
+	stateValue := base64.RawURLEncoding.EncodeToString(state)
+
+	sealed, err := a.seal(map[string]string{"state": stateValue, "return": r.URL.RequestURI()})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    sealed,
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{}
+	q.Set("client_id", a.ClientID)
+	q.Set("redirect_uri", callbackURL(r))
+	q.Set("response_type", "code")
+	q.Set("state", stateValue)
+
+	http.Redirect(w, r, c.ServerURL()+"/login/oauth/authorize?"+q.Encode(), http.StatusFound)
+
+	return nil
+}
+
+// callback exchanges the code gitea sent for an access token and starts the session.
+func (a *OAuth) callback(w http.ResponseWriter, r *http.Request, c *gitea.Client) error {
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	var state map[string]string
+	if err := a.open(cookie.Value, &state); err != nil || state["state"] != r.URL.Query().Get("state") {
+		return caddyhttp.Error(http.StatusBadRequest, errors.New("invalid oauth state"))
+	}
+
+	token, err := a.exchange(r, c)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadGateway, err)
+	}
+
+	id, err := c.User(token)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadGateway, err)
+	}
+
+	sealed, err := a.seal(session{
+		Identity: *id,
+		Expires:  time.Now().Add(time.Duration(a.SessionTTL)).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.CookieName,
+		Value:    sealed,
+		Path:     "/",
+		MaxAge:   int(time.Duration(a.SessionTTL).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	ret := state["return"]
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") {
+		ret = "/"
+	}
+
+	http.Redirect(w, r, ret, http.StatusFound)
+
+	return nil
+}
+
+// exchange exchanges the authorization code of r for an access token.
+func (a *OAuth) exchange(r *http.Request, c *gitea.Client) (string, error) {
+	form := url.Values{}
+	form.Set("client_id", a.ClientID)
+	form.Set("client_secret", a.ClientSecret)
+	form.Set("code", r.URL.Query().Get("code"))
+	form.Set("grant_type", "authorization_code")
+	form.Set("redirect_uri", callbackURL(r))
+
+	resp, err := http.PostForm(c.ServerURL()+"/login/oauth/access_token", form)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	}
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+
+	if res.AccessToken == "" {
+		return "", errors.New("no access token")
+	}
+
+	return res.AccessToken, nil
+}
+
+func callbackURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + oauthCallbackPath
+}
+
+// seal encrypts v into a cookie value.
+func (a *OAuth) seal(v any) (string, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(a.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// open decrypts a cookie value sealed by seal into v.
+func (a *OAuth) open(value string, v any) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+
+	if len(sealed) < a.aead.NonceSize() {
+		return errors.New("invalid cookie")
+	}
+
+	plain, err := a.aead.Open(nil, sealed[:a.aead.NonceSize()], sealed[a.aead.NonceSize():], nil)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plain, v)
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	auth gitea_oauth {
+//		client_id <id>
+//		client_secret <secret>
+//		cookie_secret <secret>
+//		cookie_name <name>
+//		session_ttl <duration>
+//	}
+func (a *OAuth) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "client_id":
+				if !d.Args(&a.ClientID) {
+					return d.ArgErr()
+				}
+			case "client_secret":
+				if !d.Args(&a.ClientSecret) {
+					return d.ArgErr()
+				}
+			case "cookie_secret":
+				if !d.Args(&a.CookieSecret) {
+					return d.ArgErr()
+				}
+			case "cookie_name":
+				if !d.Args(&a.CookieName) {
+					return d.ArgErr()
+				}
+			case "session_ttl":
+				if err := parseDurationArg(d, &a.SessionTTL); err != nil {
+					return err
+				}
+			default:
+				return d.Errf("unknown gitea_oauth option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ AuthProvider          = (*OAuth)(nil)
+	_ caddy.Provisioner     = (*OAuth)(nil)
+	_ caddy.Validator       = (*OAuth)(nil)
+	_ caddyfile.Unmarshaler = (*OAuth)(nil)
+)
@@ -0,0 +1,56 @@
+package gitea
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// bucketCount is the number of A/B testing buckets, so a bucket is a percentile.
+const bucketCount = 100
+
+// bucketHeader is the response header with the bucket of the visitor.
+const bucketHeader = "X-Gitea-Pages-Bucket"
+
+// visitorBucket returns the A/B testing bucket (0-99) of the visitor of r.
+// It's deterministic per site: a hash of the cookie named cookie if the
+// visitor has it, otherwise of its IP address and user agent.
+func visitorBucket(r *http.Request, cookie string) int {
+	h := fnv.New32a()
+	h.Write([]byte(r.Host))
+	h.Write([]byte{0})
+
+	if c, err := r.Cookie(cookie); cookie != "" && err == nil {
+		h.Write([]byte(c.Value))
+	} else {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		h.Write([]byte(ip))
+		h.Write([]byte{0})
+		h.Write([]byte(r.UserAgent()))
+	}
+
+	return int(h.Sum32() % bucketCount)
+}
+
+// setBucket sets the {http.gitea.bucket} placeholder and, if enabled, the
+// bucket header.
+func (m Middleware) setBucket(w http.ResponseWriter, r *http.Request) int {
+	bucket := visitorBucket(r, m.BucketCookie)
+
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.gitea.bucket", bucket)
+	}
+
+	if m.BucketHeader {
+		w.Header().Set(bucketHeader, strconv.Itoa(bucket))
+	}
+
+	return bucket
+}
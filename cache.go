@@ -0,0 +1,228 @@
+package gitea
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	caddy.RegisterModule(MemoryCache{})
+	caddy.RegisterModule(DiskCache{})
+	caddy.RegisterModule(RedisCache{})
+}
+
+// defaultCacheTTL is how long files are cached when no cache_ttl is set.
+const defaultCacheTTL = 5 * time.Minute
+
+// The cache backends are modules in the http.handlers.gitea.cache namespace
+// implementing gitea.Cache, other storage can be plugged in the same way.
+
+// MemoryCache caches files in memory.
+type MemoryCache struct {
+	// MaxSize is the maximum size of the cache in bytes, defaults to 100MB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	*gitea.MemoryCache
+}
+
+// CaddyModule returns the Caddy module information.
+func (MemoryCache) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.cache.memory",
+		New: func() caddy.Module { return new(MemoryCache) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (c *MemoryCache) Provision(ctx caddy.Context) error {
+	if c.MaxSize == 0 {
+		c.MaxSize = 100 << 20
+	}
+
+	c.MemoryCache = gitea.NewMemoryCache(c.MaxSize)
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	cache memory {
+//		max_size 100MB
+//	}
+func (c *MemoryCache) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "max_size":
+				if err := parseSizeArg(d, &c.MaxSize); err != nil {
+					return err
+				}
+			default:
+				return d.Errf("unknown memory cache option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// DiskCache caches files on disk.
+type DiskCache struct {
+	// Dir is the directory of the cache.
+	Dir string `json:"dir,omitempty"`
+	// MaxSize is the maximum size of the cache in bytes, defaults to 1GB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	*gitea.DiskCache
+}
+
+// CaddyModule returns the Caddy module information.
+func (DiskCache) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.cache.disk",
+		New: func() caddy.Module { return new(DiskCache) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (c *DiskCache) Provision(ctx caddy.Context) error {
+	if c.Dir == "" {
+		return errors.New("disk cache: dir is required")
+	}
+
+	if c.MaxSize == 0 {
+		c.MaxSize = 1 << 30
+	}
+
+	var err error
+	c.DiskCache, err = gitea.NewDiskCache(c.Dir, c.MaxSize)
+
+	return err
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	cache disk {
+//		dir /var/cache/caddy-gitea
+//		max_size 1GB
+//	}
+func (c *DiskCache) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "dir":
+				if !d.Args(&c.Dir) {
+					return d.ArgErr()
+				}
+			case "max_size":
+				if err := parseSizeArg(d, &c.MaxSize); err != nil {
+					return err
+				}
+			default:
+				return d.Errf("unknown disk cache option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// RedisCache caches files in redis.
+type RedisCache struct {
+	// Address of the redis server, defaults to localhost:6379.
+	Address  string `json:"address,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+
+	*gitea.RedisCache
+}
+
+// CaddyModule returns the Caddy module information.
+func (RedisCache) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.cache.redis",
+		New: func() caddy.Module { return new(RedisCache) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (c *RedisCache) Provision(ctx caddy.Context) error {
+	if c.Address == "" {
+		c.Address = "localhost:6379"
+	}
+
+	c.RedisCache = gitea.NewRedisCache(c.Address, c.Password, c.DB)
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	cache redis {
+//		address localhost:6379
+//		password <password>
+//		db 0
+//	}
+func (c *RedisCache) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "address":
+				if !d.Args(&c.Address) {
+					return d.ArgErr()
+				}
+			case "password":
+				if !d.Args(&c.Password) {
+					return d.ArgErr()
+				}
+			case "db":
+				if err := parseIntArg(d, &c.DB); err != nil {
+					return err
+				}
+			default:
+				return d.Errf("unknown redis cache option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseSizeArg(d *caddyfile.Dispenser, size *int64) error {
+	var v string
+	if !d.Args(&v) {
+		return d.ArgErr()
+	}
+
+	n, err := humanize.ParseBytes(v)
+	if err != nil {
+		return d.Errf("invalid size %q: %v", v, err)
+	}
+
+	if n > uint64(1<<63-1) {
+		return d.Errf("size too large: %s", strconv.FormatUint(n, 10))
+	}
+
+	*size = int64(n)
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ gitea.Cache           = (*MemoryCache)(nil)
+	_ gitea.Cache           = (*DiskCache)(nil)
+	_ gitea.Cache           = (*RedisCache)(nil)
+	_ caddy.Provisioner     = (*MemoryCache)(nil)
+	_ caddy.Provisioner     = (*DiskCache)(nil)
+	_ caddy.Provisioner     = (*RedisCache)(nil)
+	_ caddyfile.Unmarshaler = (*MemoryCache)(nil)
+	_ caddyfile.Unmarshaler = (*DiskCache)(nil)
+	_ caddyfile.Unmarshaler = (*RedisCache)(nil)
+)
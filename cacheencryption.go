@@ -0,0 +1,80 @@
+package gitea
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// cacheKeyStorageKey is the key of the generated cache encryption key in the
+// storage of caddy.
+const cacheKeyStorageKey = "gitea-pages/cache-encryption.key"
+
+// CacheEncryption encrypts the cached content of private and internal repos,
+// so a leaked cache (e.g. the directory of the disk cache) doesn't expose it.
+type CacheEncryption struct {
+	// Key is the base64 encoded 32 byte key. Without it a key is generated
+	// once and kept in the storage of caddy, next to its certificates, so
+	// the instances sharing that storage share the key.
+	Key string `json:"key,omitempty"`
+}
+
+// validate returns an error for a key that isn't 32 base64 encoded bytes.
+func (ce *CacheEncryption) validate() error {
+	if ce.Key == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(ce.Key)
+	if err != nil || len(key) != gitea.CacheKeySize {
+		return fmt.Errorf("the key must be %d base64 encoded bytes", gitea.CacheKeySize)
+	}
+
+	return nil
+}
+
+// option returns the client option encrypting the cache with the key, loaded
+// or generated in the storage of ctx if it's not configured.
+func (ce *CacheEncryption) option(ctx caddy.Context) (gitea.ClientOption, error) {
+	if ce.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(ce.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		return gitea.SetCacheEncryption(key), nil
+	}
+
+	storage := ctx.Storage()
+
+	// instances provisioning together generate a single key
+	if err := storage.Lock(ctx, cacheKeyStorageKey); err != nil {
+		return nil, fmt.Errorf("locking the cache key: %w", err)
+	}
+
+	defer storage.Unlock(context.Background(), cacheKeyStorageKey)
+
+	key, err := storage.Load(ctx, cacheKeyStorageKey)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("loading the cache key: %w", err)
+	}
+
+	if err != nil {
+		key = make([]byte, gitea.CacheKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		if err := storage.Store(ctx, cacheKeyStorageKey, key); err != nil {
+			return nil, fmt.Errorf("storing the cache key: %w", err)
+		}
+	}
+
+	return gitea.SetCacheEncryption(key), nil
+}
@@ -0,0 +1,105 @@
+package gitea
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// repeatableOptions are the options of the gitea directive that may be given
+// more than once, the others are refused as duplicates.
+var repeatableOptions = map[string]bool{
+	"owner":              true,
+	"renderer":           true,
+	"query_params":       true,
+	"soft_not_found":     true,
+	"topic_tier":         true,
+	"allow_owners":       true,
+	"deny_owners":        true,
+	"block_extensions":   true,
+	"extension_opt_outs": true,
+}
+
+// caddyfileNames are the Caddyfile options of the fields of Middleware that
+// aren't named like in the JSON config.
+var caddyfileNames = map[string]string{
+	"owner_names":      "owner",
+	"renderers":        "renderer",
+	"archive_max_size": "archives",
+	"memory_pressure":  "memory_limit",
+	"topic_tiers":      "topic_tier",
+}
+
+// unknownOption returns the error for the unknown option of the gitea
+// directive d is at, suggesting the option that was likely meant.
+func unknownOption(d *caddyfile.Dispenser) error {
+	if option := suggestOption(d.Val()); option != "" {
+		return d.Errf("unknown gitea option %q, did you mean %q?", d.Val(), option)
+	}
+
+	return d.Errf("unknown gitea option %q", d.Val())
+}
+
+// suggestOption returns the option of the gitea directive closest to name,
+// if it's close enough to be a typo.
+func suggestOption(name string) string {
+	normalize := strings.NewReplacer("_", "", "-", "")
+
+	best, bestDistance := "", 3
+
+	t := reflect.TypeOf(Middleware{})
+	for i := 0; i < t.NumField(); i++ {
+		option, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if option == "" || option == "-" {
+			continue
+		}
+
+		if o, ok := caddyfileNames[option]; ok {
+			option = o
+		}
+
+		if normalize.Replace(option) == normalize.Replace(strings.ToLower(name)) {
+			return option
+		}
+
+		if dist := editDistance(option, name); dist < bestDistance {
+			best, bestDistance = option, dist
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
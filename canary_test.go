@@ -0,0 +1,87 @@
+package gitea
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestCanary(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:         "user",
+		Name:          "gitea-pages",
+		DefaultBranch: "gitea-pages",
+		Topics:        []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {
+				"index.html":       "stable",
+				"gitea-pages.toml": "allowedrefs=[\"*\"]\n\n[canary]\nref=\"redesign\"\npercent=50\n",
+			},
+			"redesign": {"index.html": "canary"},
+		},
+	})
+
+	m := newTestHandler(t, srv)
+
+	get := func(cookie, query string) (string, *http.Response) {
+		t.Helper()
+
+		r := siteRequest("user", "/"+query)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: gitea.CanaryCookie, Value: cookie})
+		}
+
+		resp := serve(m, r)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("cookie %q, query %q: %s", cookie, query, resp.Status)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return string(body), resp
+	}
+
+	// new visitors see either variant and are kept on it
+	seen := make(map[string]int)
+
+	for i := 0; i < 100; i++ {
+		body, resp := get("", "")
+		seen[body]++
+
+		cookies := resp.Cookies()
+		if len(cookies) != 1 || cookies[0].Name != gitea.CanaryCookie ||
+			cookies[0].Value == gitea.VariantCanary != (body == "canary") {
+			t.Fatalf("%q served with cookies %v", body, cookies)
+		}
+
+		if resp.Header.Get("Vary") != "Cookie" {
+			t.Errorf("Vary %q, want Cookie", resp.Header.Get("Vary"))
+		}
+	}
+
+	if seen["stable"] == 0 || seen["canary"] == 0 {
+		t.Errorf("variants seen by 100 visitors: %v", seen)
+	}
+
+	for _, variant := range []string{gitea.VariantStable, gitea.VariantCanary} {
+		body, resp := get(variant, "")
+		if body != variant {
+			t.Errorf("cookie %s: served %q", variant, body)
+		}
+
+		if len(resp.Cookies()) != 0 {
+			t.Errorf("cookie %s: set again %v", variant, resp.Cookies())
+		}
+	}
+
+	// a ref asked for explicitly is served as is
+	if body, resp := get(gitea.VariantCanary, "?ref=gitea-pages"); body != "stable" || len(resp.Cookies()) != 0 {
+		t.Errorf("explicit ref: served %q with cookies %v", body, resp.Cookies())
+	}
+}
@@ -0,0 +1,107 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Chaos injects artificial latency and errors into the requests to gitea,
+// so operators can validate their cache, serve stale and timeout settings
+// before a real gitea outage. It's a debug option.
+type Chaos struct {
+	// Latency is added to LatencyPercent percent of the requests.
+	Latency        caddy.Duration `json:"latency,omitempty"`
+	LatencyPercent float64        `json:"latency_percent,omitempty"`
+	// ErrorPercent percent of the requests fail with ErrorStatus, or with a
+	// connection error if it's not set.
+	ErrorPercent float64 `json:"error_percent,omitempty"`
+	ErrorStatus  int     `json:"error_status,omitempty"`
+}
+
+func (ch *Chaos) option() gitea.ClientOption {
+	return gitea.SetChaos(gitea.Chaos{
+		Latency:        time.Duration(ch.Latency),
+		LatencyPercent: ch.LatencyPercent,
+		ErrorPercent:   ch.ErrorPercent,
+		ErrorStatus:    ch.ErrorStatus,
+	})
+}
+
+// validate returns an error if a percentage or the status is out of range.
+func (ch *Chaos) validate() error {
+	if ch.LatencyPercent < 0 || ch.LatencyPercent > 100 || ch.ErrorPercent < 0 || ch.ErrorPercent > 100 {
+		return errors.New("percentages must be between 0 and 100")
+	}
+
+	if ch.ErrorStatus != 0 && (ch.ErrorStatus < 100 || ch.ErrorStatus > 599) {
+		return fmt.Errorf("invalid status %d", ch.ErrorStatus)
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals the chaos block of a Caddyfile, the
+// percentages default to 100.
+//
+//	chaos {
+//		latency 2s 10%
+//		errors 5% 503
+//	}
+func (ch *Chaos) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "latency":
+			if err := parseDurationArg(d, &ch.Latency); err != nil {
+				return err
+			}
+
+			ch.LatencyPercent = 100
+
+			if d.NextArg() {
+				if err := parsePercent(d, &ch.LatencyPercent); err != nil {
+					return err
+				}
+			}
+		case "errors":
+			ch.ErrorPercent = 100
+
+			if d.NextArg() {
+				if err := parsePercent(d, &ch.ErrorPercent); err != nil {
+					return err
+				}
+			}
+
+			if d.NextArg() {
+				status, err := strconv.Atoi(d.Val())
+				if err != nil || status < 100 || status > 599 {
+					return d.Errf("invalid status %q", d.Val())
+				}
+
+				ch.ErrorStatus = status
+			}
+		default:
+			return d.Errf("unknown chaos option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// parsePercent parses the current token, a percentage with an optional %.
+func parsePercent(d *caddyfile.Dispenser, percent *float64) error {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(d.Val(), "%"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return d.Errf("invalid percentage %q", d.Val())
+	}
+
+	*percent = p
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package gitea
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "gitea-warm",
+		Func:  cmdWarm,
+		Usage: "--site <owner/repo> [--ref <ref>] [--concurrency <n>] [--address <admin>] [--config <path> [--adapter <name>]]",
+		Short: "Fetches all files of a site into the cache",
+		Long: `
+Fetches all files of the gitea pages site owner/repo at ref (its default
+version if not set) into the cache of the running caddy instance, e.g.
+right before an announcement. The files are fetched concurrency at once
+(4 if not set).
+
+The admin API of the instance is found like caddy stop does: at --address,
+in the admin config of --config or at the default address.`,
+		Flags: func() *flag.FlagSet {
+			fs := flag.NewFlagSet("gitea-warm", flag.ExitOnError)
+			fs.String("site", "", "The site to warm (owner/repo)")
+			fs.String("ref", "", "The ref of the site to warm")
+			fs.Int("concurrency", 0, "The number of files fetched at once")
+			fs.String("address", "", "The address of the admin API")
+			fs.String("config", "", "The config file of the instance")
+			fs.String("adapter", "", "The name of the config adapter")
+
+			return fs
+		}(),
+	})
+}
+
+// cmdWarm warms the cache with a site through the admin API.
+func cmdWarm(fl caddycmd.Flags) (int, error) {
+	site := fl.String("site")
+	if site == "" {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("--site is required")
+	}
+
+	adminAddr, err := caddycmd.DetermineAdminAPIAddress(fl.String("address"), nil, fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("finding the admin API: %w", err)
+	}
+
+	q := url.Values{"site": {site}}
+
+	if ref := fl.String("ref"); ref != "" {
+		q.Set("ref", ref)
+	}
+
+	if n := fl.Int("concurrency"); n > 0 {
+		q.Set("concurrency", strconv.Itoa(n))
+	}
+
+	resp, err := caddycmd.AdminAPIRequest(adminAddr, http.MethodPost, "/gitea/warm?"+q.Encode(), nil, nil)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	defer resp.Body.Close()
+
+	var res struct {
+		Warmed int `json:"warmed"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("decoding the response: %w", err)
+	}
+
+	fmt.Printf("warmed %d files of %s\n", res.Warmed, site)
+
+	return 0, nil
+}
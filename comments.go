@@ -0,0 +1,88 @@
+package gitea
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// commentsPath is the path suffix on which the comments of a page are served.
+	commentsPath = "/.gitea-pages/comments"
+	// commentsScriptPath is the path suffix of the script embedding the comments in a page.
+	commentsScriptPath = "/.gitea-pages/comments.js"
+)
+
+// commentsScript shows the comments of the page it's included in, in the
+// element with the id gitea-comments or after the script.
+const commentsScript = `(function () {
+  var script = document.currentScript;
+  var root = script.src.replace(/\/\.gitea-pages\/comments\.js.*$/, "");
+  var path = location.pathname.slice(new URL(root).pathname.length) || "/";
+  var url = root + "/.gitea-pages/comments?path=" + encodeURIComponent(path);
+  var ref = new URLSearchParams(location.search).get("ref");
+  if (ref) url += "&ref=" + encodeURIComponent(ref);
+
+  var el = document.getElementById("gitea-comments");
+  if (!el) {
+    el = document.createElement("section");
+    el.id = "gitea-comments";
+    script.parentNode.insertBefore(el, script.nextSibling);
+  }
+
+  fetch(url).then(function (r) { return r.ok ? r.json() : null; }).then(function (data) {
+    if (!data) return;
+    data.comments.forEach(function (c) {
+      var article = document.createElement("article");
+      var header = document.createElement("header");
+      if (c.avatar_url) {
+        var img = document.createElement("img");
+        img.src = c.avatar_url;
+        img.alt = "";
+        img.width = img.height = 32;
+        header.appendChild(img);
+      }
+      var author = document.createElement("strong");
+      author.textContent = c.author;
+      header.appendChild(author);
+      var time = document.createElement("time");
+      time.dateTime = c.created;
+      time.textContent = " " + new Date(c.created).toLocaleString();
+      header.appendChild(time);
+      article.appendChild(header);
+      var body = document.createElement("div");
+      body.innerHTML = c.body;
+      article.appendChild(body);
+      el.appendChild(article);
+    });
+    var link = document.createElement("a");
+    link.href = data.issue_url;
+    link.textContent = "Comment on Gitea";
+    el.appendChild(link);
+  });
+})();
+`
+
+// serveComments serves the comments on the page of the path parameter of
+// the site fp at ref as JSON.
+func (m Middleware) serveComments(w http.ResponseWriter, r *http.Request, fp, ref string) error {
+	comments, err := m.Client.Comments(r.Context(), fp, ref, r.URL.Query().Get("path"))
+	if err != nil {
+		return m.httpError(err)
+	}
+
+	// the comments are cached for a minute anyway
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(comments)
+}
+
+// serveCommentsScript serves the script that embeds the comments in pages.
+func (m Middleware) serveCommentsScript(w http.ResponseWriter) error {
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+
+	_, err := w.Write([]byte(commentsScript))
+
+	return err
+}
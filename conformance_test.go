@@ -0,0 +1,33 @@
+package gitea
+
+import (
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestGitHubPagesConformance(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(giteatest.GitHubPagesRepo("user"))
+
+	m := newTestHandler(t, srv)
+
+	for _, b := range giteatest.GitHubPages {
+		b := b
+
+		t.Run(b.Name, func(t *testing.T) {
+			resp := serve(m, siteRequest("user", b.Path))
+			defer resp.Body.Close()
+
+			if err := b.Check(resp); err != nil {
+				if !b.GitHub {
+					t.Log(b.Note)
+				}
+
+				t.Error(err)
+			}
+		})
+	}
+}
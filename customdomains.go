@@ -0,0 +1,136 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// domainAskPath is the path, on any host, that tells caddy's on-demand TLS
+// (ask or permission http) whether a certificate may be issued for a domain.
+const domainAskPath = "/.well-known/gitea-pages-domain"
+
+// pagesHost reports if host is the domain of the sites or below it.
+func (m Middleware) pagesHost(host string) bool {
+	return host == m.Domain || strings.HasSuffix(host, "."+m.Domain)
+}
+
+// maxSiteLabels is the most labels below the domain a site is served on,
+// branch.repo.owner.
+const maxSiteLabels = 3
+
+// siteExists reports if the pages host serves the site of the file fp at
+// ref. Hosts with more labels than any site has don't.
+func (m Middleware) siteExists(r *http.Request, host, fp, ref string) bool {
+	if below := strings.TrimSuffix(host, "."+m.Domain); below != host &&
+		strings.Count(below, ".") >= maxSiteLabels {
+		return false
+	}
+
+	return m.Client.SiteExists(r.Context(), fp, ref)
+}
+
+// How requests to the bare domain are answered, if not redirected.
+const (
+	bareDomainNotFound = "not_found"
+	bareDomainCatchAll = "catch_all"
+)
+
+// bareDomain returns how requests to the domain itself are answered, see
+// Middleware.BareDomain.
+func (m Middleware) bareDomain() string {
+	switch {
+	case m.BareDomain != "":
+		return m.BareDomain
+	case m.CatchAll != "":
+		return bareDomainCatchAll
+	}
+
+	return bareDomainNotFound
+}
+
+// customDomainSite returns the name (owner/repo/filepath) of the file the
+// custom domain host serves at urlPath.
+func (m Middleware) customDomainSite(host, urlPath string) (string, error) {
+	owner, repo, err := m.Client.CustomDomain(host)
+	if err != nil {
+		return "", err
+	}
+
+	// the gitea-pages repo of an owner serves its other repos below it
+	if repo == "" {
+		return owner + urlPath, nil
+	}
+
+	return owner + "/" + repo + urlPath, nil
+}
+
+// canonicalTarget returns the url on the custom domain of the site serving
+// fp, with CanonicalHost set, or "" if the request stays on its host. Only
+// the default version of sites is redirected, and only GET and HEAD requests,
+// as browsers post the forms of sites again as GET after a 301.
+func (m Middleware) canonicalTarget(r *http.Request, fp, ref string) string {
+	if !m.CanonicalHost || ref != "" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+
+	domain, filepath, err := m.Client.CanonicalDomain(r.Context(), fp)
+	if err != nil || domain == "" {
+		return ""
+	}
+
+	return gitea.PublicURL(domain, filepath, r.URL.RawQuery)
+}
+
+// secondaryTarget returns the url on the canonical domain of the site
+// serving fp on the custom domain host, if host is a secondary domain of a
+// site that redirects them, or "" if the request stays on host.
+func (m Middleware) secondaryTarget(r *http.Request, host, fp, ref string) string {
+	if ref != "" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+
+	domain, filepath, err := m.Client.SecondaryRedirect(r.Context(), host, fp)
+	if err != nil || domain == "" {
+		return ""
+	}
+
+	return gitea.PublicURL(domain, filepath, r.URL.RawQuery)
+}
+
+// unclaimedTarget returns the url of the site a custom domain that the site
+// doesn't claim points to (on its canonical domain or below Domain), with
+// CanonicalHost set, or "" if err isn't about such a domain.
+func (m Middleware) unclaimedTarget(r *http.Request, err error) string {
+	var unclaimed *gitea.UnclaimedDomainError
+	if !m.CanonicalHost || !errors.As(err, &unclaimed) {
+		return ""
+	}
+
+	target := m.Client.SiteURL(unclaimed.Owner, unclaimed.Repo, "", r.URL.Path)
+	if target != "" && r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	return target
+}
+
+// serveDomainAsk answers 200 OK if the domain parameter is a custom domain
+// claimed by a site, so caddy issues a certificate for it on demand.
+func (m Middleware) serveDomainAsk(w http.ResponseWriter, r *http.Request) error {
+	domain := gitea.NormalizeHost(r.URL.Query().Get("domain"))
+	if domain == "" {
+		return caddyhttp.Error(http.StatusBadRequest, errors.New("missing domain"))
+	}
+
+	if _, _, err := m.Client.CustomDomain(domain); err != nil {
+		return m.httpError(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package gitea
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestBareDomain(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	for owner, content := range map[string]string{"landing": "<p>landing</p>", "user": "<p>user</p>"} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:    owner,
+			Name:     "gitea-pages",
+			Topics:   []string{"gitea-pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {"index.html": content}},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		bareDomain string
+		catchAll   string
+		host       string
+
+		status   int
+		location string
+		body     string
+	}{
+		{name: "not found by default", host: testDomain, status: http.StatusNotFound},
+		{name: "not found", bareDomain: "not_found", catchAll: "landing", host: testDomain, status: http.StatusNotFound},
+		{name: "catch all by default", catchAll: "landing", host: testDomain, status: http.StatusOK, body: "landing"},
+		{name: "catch all", bareDomain: "catch_all", catchAll: "landing", host: testDomain, status: http.StatusOK, body: "landing"},
+		{
+			name: "redirect", bareDomain: "https://www.example.com/", host: testDomain,
+			status: http.StatusFound, location: "https://www.example.com/",
+		},
+		{
+			name: "redirect of a normalized host", bareDomain: "https://www.example.com/", host: "PAGES.example.com.",
+			status: http.StatusFound, location: "https://www.example.com/",
+		},
+		{name: "sites are still served", bareDomain: "not_found", host: "user." + testDomain, status: http.StatusOK, body: "user"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestHandler(t, srv)
+			m.BareDomain, m.CatchAll = tt.bareDomain, tt.catchAll
+
+			if err := m.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.Host = tt.host
+
+			resp := serve(m, r)
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			if loc := resp.Header.Get("Location"); loc != tt.location {
+				t.Errorf("location %q, want %q", loc, tt.location)
+			}
+
+			if !strings.Contains(string(body), tt.body) {
+				t.Errorf("body %q doesn't contain %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestBareDomainValidate(t *testing.T) {
+	tests := []struct {
+		domain, bareDomain, catchAll string
+		err                          string
+	}{
+		{domain: testDomain, bareDomain: "not_found"},
+		{domain: testDomain, bareDomain: "catch_all", catchAll: "landing"},
+		{domain: testDomain, bareDomain: "https://www.example.com/"},
+		{domain: testDomain, bareDomain: "catch_all", err: "bare_domain catch_all needs catch_all"},
+		{domain: testDomain, bareDomain: "www.example.com", err: `bare_domain must be not_found, catch_all or an url, not "www.example.com"`},
+		{domain: testDomain, bareDomain: "ftp://example.com/", err: `bare_domain must be not_found, catch_all or an url, not "ftp://example.com/"`},
+		{bareDomain: "not_found", err: "bare_domain needs a domain"},
+		{domain: testDomain, catchAll: "owner/repo/dir", err: `catch_all must be an owner or owner/repo, not "owner/repo/dir"`},
+	}
+
+	for _, tt := range tests {
+		m := Middleware{Domain: tt.domain, BareDomain: tt.bareDomain, CatchAll: tt.catchAll}
+
+		err := m.Validate()
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("bare_domain %q: %v", tt.bareDomain, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("bare_domain %q: error %v, want %s", tt.bareDomain, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gitea
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"go.uber.org/zap"
+)
+
+// embargoTemplate counts down to the time an embargoed page unlocks.
+var embargoTemplate = template.Must(template.New("embargo").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Not yet available</title>
+</head>
+<body>
+<h1>Not yet available</h1>
+<p>This page is available from <time datetime="{{.Until}}">{{.Until}}</time>.</p>
+<p id="countdown"></p>
+<script>
+(function() {
+  var until = Date.parse("{{.Until}}"), el = document.getElementById("countdown");
+  function tick() {
+    var s = Math.max(0, Math.ceil((until - Date.now()) / 1000));
+    if (s === 0) { location.reload(); return; }
+    el.textContent = Math.floor(s / 86400) + "d " + Math.floor(s % 86400 / 3600) + "h " +
+      Math.floor(s % 3600 / 60) + "m " + s % 60 + "s";
+    setTimeout(tick, 1000);
+  }
+  tick();
+})();
+</script>
+</body>
+</html>
+`))
+
+// serveEmbargo answers a request for an embargoed page with 403 and a
+// countdown to when it unlocks.
+func (m Middleware) serveEmbargo(w http.ResponseWriter, embargo *gitea.EmbargoError) error {
+	m.logger.Debug("refused request for embargoed page", zap.Error(embargo))
+
+	// the page must not be cached past the embargo
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(embargo.Until).Seconds())+1))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+
+	return embargoTemplate.Execute(w, map[string]any{
+		"Until": embargo.Until.UTC().Format(time.RFC3339),
+	})
+}
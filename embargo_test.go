@@ -0,0 +1,100 @@
+package gitea
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestEmbargo(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"gitea-pages.toml": pagesConfig + `
+[[embargo]]
+path="/solutions/*"
+until=` + until.Format(time.RFC3339) + `
+
+[[embargo]]
+path="/press/launch.html"
+until="` + until.Format(time.RFC3339) + `"
+countries=["US"]
+
+[[embargo]]
+path="/old.html"
+until="2020-01-01T00:00:00Z"
+`,
+			"index.html":           "<p>home</p>",
+			"old.html":             "<p>old</p>",
+			"solutions/index.html": "<p>solutions</p>",
+			"solutions/a.html":     "<p>a</p>",
+			"press/launch.html":    "<p>launch</p>",
+		}},
+	})
+
+	m := newTestHandler(t, srv)
+	m.geoip = HeaderGeoIP{}
+
+	tests := []struct {
+		path    string
+		country string
+		locked  bool
+	}{
+		{path: "/"},
+		{path: "/old.html"},
+		{path: "/solutions/a.html", locked: true},
+		// the index file serving the directory is locked too
+		{path: "/solutions/", locked: true},
+		{path: "/press/launch.html", country: "US", locked: true},
+		{path: "/press/launch.html", country: "DE"},
+		// visitors of unknown countries are locked out
+		{path: "/press/launch.html", locked: true},
+	}
+
+	for _, tt := range tests {
+		r := siteRequest("user", tt.path)
+		if tt.country != "" {
+			r.Header.Set("CF-IPCountry", tt.country)
+		}
+
+		resp := serve(m, r)
+		body, _ := io.ReadAll(resp.Body)
+
+		if !tt.locked {
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s (%q): %s, want 200", tt.path, tt.country, resp.Status)
+			}
+
+			continue
+		}
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s (%q): %s, want 403", tt.path, tt.country, resp.Status)
+			continue
+		}
+
+		if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control %q, want no-store", tt.path, cc)
+		}
+
+		if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retry <= 0 || retry > 3601 {
+			t.Errorf("%s: Retry-After %q", tt.path, resp.Header.Get("Retry-After"))
+		}
+
+		if !strings.Contains(string(body), until.Format(time.RFC3339)) {
+			t.Errorf("%s: countdown page without %s", tt.path, until.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// defaultExportInterval is how often the sites are exported when no
+// interval is set.
+const defaultExportInterval = time.Hour
+
+// ObjectStorage is a bucket of an S3 compatible object storage holding the
+// snapshots of the sites.
+type ObjectStorage struct {
+	// Endpoint is the url of the object storage, e.g. https://s3.eu-west-1.amazonaws.com.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region is the region of the bucket, defaults to us-east-1.
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	// Prefix is prepended to the <owner>/<repo>/<ref>/<path> keys of the files.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func (o *ObjectStorage) store() (*gitea.ObjectStore, error) {
+	if o.Endpoint == "" || o.Bucket == "" {
+		return nil, errors.New("object storage: endpoint and bucket are required")
+	}
+
+	return gitea.NewObjectStore(o.Endpoint, o.Region, o.Bucket, o.AccessKey, o.SecretKey)
+}
+
+// unmarshalOption unmarshals the object storage option of a Caddyfile block
+// the dispenser is at, it reports false for other options.
+func (o *ObjectStorage) unmarshalOption(d *caddyfile.Dispenser) (bool, error) {
+	var target *string
+
+	switch d.Val() {
+	case "endpoint":
+		target = &o.Endpoint
+	case "region":
+		target = &o.Region
+	case "bucket":
+		target = &o.Bucket
+	case "access_key":
+		target = &o.AccessKey
+	case "secret_key":
+		target = &o.SecretKey
+	case "prefix":
+		target = &o.Prefix
+	default:
+		return false, nil
+	}
+
+	if !d.Args(target) {
+		return true, d.ArgErr()
+	}
+
+	return true, nil
+}
+
+// UnmarshalCaddyfile unmarshals the shield block of a Caddyfile.
+//
+//	shield {
+//		endpoint https://s3.eu-west-1.amazonaws.com
+//		region eu-west-1
+//		bucket pages-snapshots
+//		access_key <key>
+//		secret_key <secret>
+//		prefix snapshots/
+//	}
+func (o *ObjectStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		ok, err := o.unmarshalOption(d)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return d.Errf("unknown shield option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Export periodically exports snapshots of the served sites to S3
+// compatible object storage, for disaster recovery or as CDN origin.
+type Export struct {
+	ObjectStorage
+
+	// Interval is how often the sites that changed are exported, defaults to 1h.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	store *gitea.ObjectStore
+}
+
+func (e *Export) provision() error {
+	if e.Interval == 0 {
+		e.Interval = caddy.Duration(defaultExportInterval)
+	}
+
+	var err error
+	e.store, err = e.ObjectStorage.store()
+
+	return err
+}
+
+// run exports the sites of c every interval until ctx is done.
+func (e *Export) run(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Duration(e.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ExportSites(e.store, e.Prefix); err != nil {
+				logger.Warn("exporting sites incomplete", zap.Error(err))
+			}
+		}
+	}
+}
+
+// UnmarshalCaddyfile unmarshals the export block of a Caddyfile.
+//
+//	export {
+//		endpoint https://s3.eu-west-1.amazonaws.com
+//		region eu-west-1
+//		bucket pages-snapshots
+//		access_key <key>
+//		secret_key <secret>
+//		prefix snapshots/
+//		interval 1h
+//	}
+func (e *Export) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		if d.Val() == "interval" {
+			if err := parseDurationArg(d, &e.Interval); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		ok, err := e.unmarshalOption(d)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return d.Errf("unknown export option %q", d.Val())
+		}
+	}
+
+	return nil
+}
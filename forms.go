@@ -0,0 +1,97 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// formPath is the path below the root of a site the forms are posted to,
+// followed by the name of the form.
+const formPath = "/.gitea-pages/form/"
+
+// maxFormSize is the size of the largest form submission accepted.
+const maxFormSize = 64 << 10
+
+// Forms files the submissions of the forms of sites as gitea issues.
+type Forms struct {
+	// CaptchaVerifyURL is the siteverify endpoint of Turnstile, hCaptcha or
+	// reCAPTCHA, submissions need a valid captcha if it's set.
+	CaptchaVerifyURL string `json:"captcha_verify_url,omitempty"`
+	// CaptchaSecret is the secret of the captcha for the siteverify endpoint.
+	CaptchaSecret string `json:"captcha_secret,omitempty"`
+}
+
+// UnmarshalCaddyfile unmarshals the forms block of a Caddyfile.
+//
+//	forms {
+//		captcha https://challenges.cloudflare.com/turnstile/v0/siteverify {env.TURNSTILE_SECRET}
+//	}
+func (f *Forms) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "captcha":
+			if !d.Args(&f.CaptchaVerifyURL, &f.CaptchaSecret) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown subdirective %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// serveForm files the submission of the form of the site fp at ref and
+// redirects to the page the form names, urlPath is the root of the site.
+func (m Middleware) serveForm(w http.ResponseWriter, r *http.Request, fp, ref, urlPath, form string) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return caddyhttp.Error(http.StatusMethodNotAllowed, errors.New("forms are posted"))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseForm(); err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	redirect, err := m.Client.SubmitForm(r.Context(), fp, ref, form, r.PostForm, remoteIP)
+	if errors.Is(err, gitea.ErrFormRejected) {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	if err != nil {
+		return m.httpError(err)
+	}
+
+	if redirect != "" {
+		if strings.HasPrefix(redirect, "/") {
+			redirect = strings.TrimSuffix(urlPath, "/") + redirect
+		}
+
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+
+		return nil
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte("Thank you, your submission was received.\n"))
+
+	return err
+}
@@ -0,0 +1,163 @@
+package gitea
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+// pagesConfig makes a gitea-pages repo with a config serve its gitea-pages
+// branch.
+const pagesConfig = "defaultref = \"gitea-pages\"\nallowedrefs = [\"gitea-pages\"]\n"
+
+// formRequest returns a request posting values to the form of the site of
+// owner.
+func formRequest(owner, form string, values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://"+owner+"."+testDomain+formPath+form, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
+
+func TestForms(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"index.html": "<p>home</p>",
+			"gitea-pages.toml": pagesConfig + `
+[forms.contact]
+title="Contact form"
+fields=["name", "message"]
+honeypot="website"
+redirect="/thanks/"
+
+[forms.feedback]
+repo="inbox"
+issue=1
+`,
+		}},
+	})
+	srv.AddRepo(&giteatest.Repo{Owner: "user", Name: "inbox"})
+
+	m := newTestHandler(t, srv)
+	m.Forms = new(Forms)
+
+	resp := serve(m, formRequest("user", "contact", url.Values{
+		"name":    {"Ann"},
+		"message": {"Hello\n@admin"},
+		"extra":   {"not filed"},
+	}))
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/thanks/" {
+		t.Fatalf("contact: %s to %q, want 303 to /thanks/", resp.Status, resp.Header.Get("Location"))
+	}
+
+	issues := srv.Issues()
+	if len(issues) != 1 || issues[0].Repo != "gitea-pages" || issues[0].Title != "Contact form" {
+		t.Fatalf("issues = %+v, want the contact form in gitea-pages", issues)
+	}
+
+	// values are code, they can't mention users
+	if body := issues[0].Body; !strings.Contains(body, "    Ann\n") || !strings.Contains(body, "    @admin\n") ||
+		strings.Contains(body, "not filed") {
+		t.Errorf("issue body = %q", body)
+	}
+
+	// bots filling the honeypot are told they succeeded
+	resp = serve(m, formRequest("user", "contact", url.Values{"name": {"Bot"}, "website": {"spam.example"}}))
+	if resp.StatusCode != http.StatusSeeOther || len(srv.Issues()) != 1 {
+		t.Errorf("honeypot: %s, %d issues", resp.Status, len(srv.Issues()))
+	}
+
+	r := formRequest("user", "feedback", url.Values{"rating": {"5"}})
+	r.Header.Set("Accept", "application/json")
+
+	resp = serve(m, r)
+
+	var result map[string]bool
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK || !result["ok"] {
+		t.Errorf("feedback: %s, %v, %v", resp.Status, result, err)
+	}
+
+	if comments := srv.Comments(); len(comments) != 1 || comments[0].Repo != "inbox" || comments[0].Index != 1 {
+		t.Errorf("comments = %+v, want the feedback on inbox#1", comments)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		r      *http.Request
+		status int
+	}{
+		{"get", httptest.NewRequest(http.MethodGet, "http://user."+testDomain+formPath+"contact", nil), http.StatusMethodNotAllowed},
+		{"no values", formRequest("user", "contact", url.Values{"extra": {"x"}}), http.StatusBadRequest},
+		{"unknown form", formRequest("user", "other", url.Values{"name": {"Ann"}}), http.StatusNotFound},
+	} {
+		if resp := serve(m, tc.r); resp.StatusCode != tc.status {
+			t.Errorf("%s: %s, want %d", tc.name, resp.Status, tc.status)
+		}
+	}
+
+	if n := len(srv.Issues()) + len(srv.Comments()); n != 2 {
+		t.Errorf("%d issues and comments filed, want 2", n)
+	}
+}
+
+func TestFormCaptcha(t *testing.T) {
+	verify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		ok := r.PostForm.Get("secret") == "secret" && r.PostForm.Get("response") == "solved"
+
+		_ = json.NewEncoder(w).Encode(map[string]bool{"success": ok})
+	}))
+	defer verify.Close()
+
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"gitea-pages.toml": pagesConfig + "[forms.contact]\n"}},
+	})
+
+	m := newTestHandler(t, srv, gitea.SetFormCaptcha(verify.URL, "secret"))
+	m.Forms = &Forms{CaptchaVerifyURL: verify.URL, CaptchaSecret: "secret"}
+
+	for _, tc := range []struct {
+		captcha string
+		status  int
+	}{
+		{"", http.StatusBadRequest},
+		{"wrong", http.StatusBadRequest},
+		{"solved", http.StatusOK},
+	} {
+		values := url.Values{"message": {"Hello"}}
+		if tc.captcha != "" {
+			values.Set("cf-turnstile-response", tc.captcha)
+		}
+
+		resp := serve(m, formRequest("user", "contact", values))
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("captcha %q: %s, want %d", tc.captcha, resp.Status, tc.status)
+		}
+	}
+
+	// the captcha response isn't filed
+	if issues := srv.Issues(); len(issues) != 1 || strings.Contains(issues[0].Body, "solved") {
+		t.Errorf("issues = %+v, want one without the captcha", issues)
+	}
+}
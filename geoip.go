@@ -0,0 +1,82 @@
+package gitea
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(HeaderGeoIP{})
+}
+
+// GeoIPProvider is implemented by the modules in the http.handlers.gitea.geoip
+// namespace, which tell the country of visitors for the Country condition of
+// redirect rules.
+type GeoIPProvider interface {
+	// Country returns the ISO 3166 country code of the visitor of r, or ""
+	// if it's unknown.
+	Country(r *http.Request) string
+}
+
+// HeaderGeoIP takes the country of visitors from a request header set by a
+// CDN or proxy in front of caddy.
+type HeaderGeoIP struct {
+	// Header is the request header with the country, defaults to CF-IPCountry.
+	Header string `json:"header,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (HeaderGeoIP) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.geoip.header",
+		New: func() caddy.Module { return new(HeaderGeoIP) },
+	}
+}
+
+// Country implements GeoIPProvider.
+func (g HeaderGeoIP) Country(r *http.Request) string {
+	header := g.Header
+	if header == "" {
+		header = "CF-IPCountry"
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(header)))
+
+	// cloudflare uses XX for unknown and T1 for tor
+	if country == "XX" || country == "T1" {
+		return ""
+	}
+
+	return country
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	geoip header {
+//		header X-Country-Code
+//	}
+func (g *HeaderGeoIP) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "header":
+				if !d.Args(&g.Header) {
+					return d.ArgErr()
+				}
+			default:
+				return d.Errf("unknown header geoip option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ GeoIPProvider         = (*HeaderGeoIP)(nil)
+	_ caddyfile.Unmarshaler = (*HeaderGeoIP)(nil)
+)
@@ -1,17 +1,48 @@
 package gitea
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/42wim/caddy-gitea/pkg/gitea"
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
 )
 
+// defaultMaintenanceBanner is shown on top of stale pages served while gitea
+// is in maintenance.
+const defaultMaintenanceBanner = `<div style="padding:.5em;background:#fff3cd;color:#664d03;text-align:center">` +
+	`This page may be outdated, gitea is in maintenance.</div>`
+
+// canaryCookieTTL is how long a visitor stays on the variant of a site with a canary.
+const canaryCookieTTL = 30 * 24 * time.Hour
+
+// manifestPath is the path suffix on which the manifest of a site is served.
+const manifestPath = "/.gitea-pages/manifest.json"
+
+// defaultDrainTimeout is how long unloading waits for the fetches in flight.
+const defaultDrainTimeout = 10 * time.Second
+
+// defaultArchiveMaxSize is the size of the largest site served from its
+// archive when no size is set.
+const defaultArchiveMaxSize = 64 << 20
+
 func init() {
 	caddy.RegisterModule(Middleware{})
 	httpcaddyfile.RegisterHandlerDirective("gitea", parseCaddyfile)
@@ -32,6 +63,299 @@ type Middleware struct {
 	GiteaPages         string        `json:"gitea_pages,omitempty"`
 	GiteaPagesAllowAll string        `json:"gitea_pages_allowall,omitempty"`
 	Domain             string        `json:"domain,omitempty"`
+
+	// UpstreamOAuth2 authenticates the requests to gitea with the access
+	// tokens of an OAuth2 application instead of Token.
+	UpstreamOAuth2 *UpstreamOAuth2 `json:"upstream_oauth2,omitempty"`
+
+	// Name names the handler for the gitea_pages_api handlers serving its
+	// endpoints, names are unique in a config.
+	Name string `json:"name,omitempty"`
+	// Use serves the pages of the gitea_pages app with that name, shared
+	// with the other handlers using them, instead of a config of its own.
+	Use string `json:"use,omitempty"`
+
+	// TopicTiers are topics opting in repos like gitea_pages, for the sites
+	// of a tier (e.g. gitea-pages-internal for the internal tier).
+	TopicTiers []gitea.TopicTier `json:"topic_tiers,omitempty"`
+	// Tiers are the tiers served by the handler, the sites of other tiers
+	// aren't found. All tiers are served if not set; the sites of the
+	// gitea_pages topic are in the default tier.
+	Tiers []string `json:"tiers,omitempty"`
+
+	// UpstreamQPS limits the requests to the gitea API per second (0 is unlimited).
+	UpstreamQPS float64 `json:"upstream_qps,omitempty"`
+	// UpstreamBurst is the number of requests allowed in a burst above UpstreamQPS.
+	UpstreamBurst int `json:"upstream_burst,omitempty"`
+	// UpstreamQueue is the number of requests that may wait for the rate limit,
+	// others are shed with 503. Defaults to 100.
+	UpstreamQueue int `json:"upstream_queue,omitempty"`
+	// UpstreamMaxWait is the longest a request waits for the rate limit.
+	UpstreamMaxWait caddy.Duration `json:"upstream_max_wait,omitempty"`
+	// UpstreamMaxBody is the size of the largest response body read from
+	// gitea (0 is unlimited), apart from streamed files and archives.
+	UpstreamMaxBody int64 `json:"upstream_max_body,omitempty"`
+	// UpstreamValidate refuses responses that can't be from gitea, like the
+	// html error pages of a misconfigured proxy sent with 200 OK.
+	UpstreamValidate bool `json:"upstream_validate,omitempty"`
+	// SoftNotFound are the markers of the pages a misconfigured proxy in
+	// front of gitea sends with 200 OK for missing files (e.g. its login
+	// page), files starting with one are not found.
+	SoftNotFound []string `json:"soft_not_found,omitempty"`
+	// SudoOwners sends the requests for a repo as its owner, with the sudo of
+	// gitea, so an admin token reads only what the owner can.
+	SudoOwners bool `json:"sudo_owners,omitempty"`
+
+	// TreeCacheTTL is how long the git tree of a ref is cached to resolve pretty urls.
+	TreeCacheTTL caddy.Duration `json:"tree_cache_ttl,omitempty"`
+	// TreeBloomThreshold is the number of files above which only a bloom
+	// filter of the git tree is cached.
+	TreeBloomThreshold int `json:"tree_bloom_threshold,omitempty"`
+
+	// NotFoundTTL caches owners and repos that don't exist or don't allow pages.
+	NotFoundTTL caddy.Duration `json:"not_found_ttl,omitempty"`
+
+	// OpenTimeout limits the time of all requests to gitea needed to serve a
+	// file, defaults to 5s.
+	OpenTimeout caddy.Duration `json:"open_timeout,omitempty"`
+	// DrainTimeout is how long unloading the config waits for the fetches
+	// from gitea in flight to be cached, defaults to 10s.
+	DrainTimeout caddy.Duration `json:"drain_timeout,omitempty"`
+
+	// Chaos injects artificial latency and errors into the requests to gitea
+	// to test the configuration, never enable it in production.
+	Chaos *Chaos `json:"chaos,omitempty"`
+
+	// Experimental limits risky features (archives, auth) to the owners and
+	// sites (owner/repo) of their flag while they're rolled out, features
+	// without flag are enabled for all sites.
+	Experimental gitea.FeatureFlags `json:"experimental,omitempty"`
+
+	// LogSampling is the percentage of the successful responses and redirects
+	// that get in the access logs, errors are always logged.
+	LogSampling LogSampling `json:"log_sampling,omitempty"`
+
+	// ServeStale is the number of files kept to serve while gitea is in maintenance.
+	ServeStale int `json:"serve_stale,omitempty"`
+	// MaintenanceBanner is the html shown on top of stale pages.
+	MaintenanceBanner string `json:"maintenance_banner,omitempty"`
+
+	// RenderersRaw are the renderer modules by name, they replace the
+	// built-in markdown rendering for the types they handle.
+	RenderersRaw caddy.ModuleMap `json:"renderers,omitempty" caddy:"namespace=http.handlers.gitea.renderers"`
+
+	// CommitStatus posts a pages/deployed commit status when a new version
+	// of a site is published.
+	CommitStatus bool `json:"commit_status,omitempty"`
+
+	// RenderMarkdown renders markdown files into html, defaults to true.
+	RenderMarkdown *bool `json:"render_markdown,omitempty"`
+
+	// ConfigFromDescription reads the config of a repo from its description
+	// before fetching its config file.
+	ConfigFromDescription bool `json:"config_from_description,omitempty"`
+
+	// Search serves a search of the pages of a site on /.gitea-pages/search
+	// and its OpenSearch description on /.gitea-pages/opensearch.xml.
+	Search bool `json:"search,omitempty"`
+
+	// Comments serves the comments of the issues the pages of a site are
+	// mapped to on /.gitea-pages/comments and a script embedding them on
+	// /.gitea-pages/comments.js.
+	Comments bool `json:"comments,omitempty"`
+
+	// PageJSON serves markdown and html pages requested with ?format=json
+	// as JSON: their front matter, rendered body and last commit.
+	PageJSON bool `json:"page_json,omitempty"`
+
+	// GitHeaders adds the last commit of a file and a link to edit it on
+	// gitea to its response headers.
+	GitHeaders bool `json:"git_headers,omitempty"`
+	// DefaultLayout is the Go template file the markdown pages of sites
+	// without a layout are rendered into.
+	DefaultLayout string `json:"default_layout,omitempty"`
+
+	// DefaultBranchFallback serves the default branch of repos with the
+	// allowall topic that have neither a config nor a gitea-pages branch.
+	DefaultBranchFallback bool `json:"default_branch_fallback,omitempty"`
+
+	// DomainPolicy is which internationalized custom domains sites may
+	// declare (any, single_script or ascii), defaults to single_script.
+	DomainPolicy string `json:"domain_policy,omitempty"`
+
+	// CustomDomains serves sites on the custom domains they claim, which
+	// point to their host below Domain.
+	CustomDomains bool `json:"custom_domains,omitempty"`
+	// CanonicalHost redirects the requests of sites with a custom domain on
+	// their host below Domain to the custom domain with 301, and those of
+	// custom domains a site doesn't claim (anymore) to the site below Domain.
+	CanonicalHost bool `json:"canonical_host,omitempty"`
+
+	// LegacyDeprecationHeader marks the responses of the legacy path mode
+	// (without Domain) as deprecated with a Deprecation header.
+	LegacyDeprecationHeader bool `json:"legacy_deprecation_header,omitempty"`
+	// CatchAll is the site (owner or owner/repo) served on the hosts below
+	// Domain that don't resolve to a published site, e.g. a landing page on
+	// how to publish a site, instead of a 404.
+	CatchAll string `json:"catch_all,omitempty"`
+
+	// BareDomain is how requests to Domain itself, which has no owner, are
+	// answered: not_found, catch_all (serving CatchAll) or an url they're
+	// redirected to. It defaults to catch_all if CatchAll is set, else
+	// not_found.
+	BareDomain string `json:"bare_domain,omitempty"`
+
+	// LegacyRedirect redirects the requests of the legacy path mode with 308
+	// to the subdomain form of their site below this domain.
+	LegacyRedirect string `json:"legacy_redirect,omitempty"`
+
+	// Sanitize is the minimum sanitization policy (none, relaxed or strict)
+	// of rendered pages, defaults to none.
+	Sanitize string `json:"sanitize,omitempty"`
+
+	// PRPreviews serves pull request previews on the pr-<index> ref.
+	PRPreviews bool `json:"pr_previews,omitempty"`
+	// PRComments comments the preview url on pull requests.
+	PRComments bool `json:"pr_comments,omitempty"`
+
+	// Assets sets headers that allow static assets (fonts, WASM) to be
+	// embedded by other origins.
+	Assets *AssetHeaders `json:"assets,omitempty"`
+
+	// SafeServiceWorkers refuses service worker scripts of branches and pull
+	// request previews and limits the scope of service workers to their directory.
+	SafeServiceWorkers bool `json:"safe_service_workers,omitempty"`
+
+	// OwnerNames overrides the gitea_pages and gitea_pages_allowall names
+	// and the default repo for some owners.
+	OwnerNames map[string]gitea.OwnerNames `json:"owner_names,omitempty"`
+
+	// QueryPolicy is how the query parameters of file requests that don't
+	// change the response are handled: ignore (default), redirect to the url
+	// without them or reject with 400. Files are cached by path and ref
+	// either way, redirecting keeps downstream caches from being busted by
+	// random parameters too.
+	QueryPolicy string `json:"query_policy,omitempty"`
+	// QueryParams are the query parameters kept besides ref and format, e.g.
+	// those the scripts of sites read.
+	QueryParams []string `json:"query_params,omitempty"`
+
+	// AllowOwners are the only owners whose sites are served (all if empty).
+	AllowOwners []string `json:"allow_owners,omitempty"`
+	// DenyOwners are owners whose sites are never served.
+	DenyOwners []string `json:"deny_owners,omitempty"`
+
+	// BlockExtensions are file extensions (.exe, .php, .env) no site serves.
+	BlockExtensions []string `json:"block_extensions,omitempty"`
+	// ExtensionOptOuts are the owners and owner/repo that may serve blocked
+	// extensions listed as unblockextensions in their repo config.
+	ExtensionOptOuts []string `json:"extension_opt_outs,omitempty"`
+
+	// Forms files the submissions of the forms of sites, posted to
+	// /.gitea-pages/form/<name>, as gitea issues.
+	Forms *Forms `json:"forms,omitempty"`
+
+	// Isolation sets headers that isolate the sites of different owners
+	// from each other.
+	Isolation *Isolation `json:"isolation,omitempty"`
+
+	// AuthRaw is the auth provider module that protects the pages of private repos.
+	AuthRaw json.RawMessage `json:"auth,omitempty" caddy:"namespace=http.handlers.gitea.auth inline_key=provider"`
+
+	// ForbidPrivate refuses to serve the pages of private repos when no auth
+	// provider protects them.
+	ForbidPrivate bool `json:"forbid_private,omitempty"`
+
+	// RejectEarlyData answers requests sent as TLS 1.3 or QUIC 0-RTT early
+	// data, which can be replayed, with 425 Too Early if they are for the
+	// auth endpoints or not idempotent, so the client retries them after
+	// the handshake.
+	RejectEarlyData bool `json:"reject_early_data,omitempty"`
+
+	// TakedownFile is the file with the takedown list, sites on it are
+	// never served.
+	TakedownFile string `json:"takedown_file,omitempty"`
+	// TakedownStatus is the status of taken down sites, 451 (default) or 410.
+	TakedownStatus int `json:"takedown_status,omitempty"`
+
+	// SiteMaxCacheSize is the total size of the cached files of a site,
+	// files above it are served without caching them (0 is unlimited).
+	SiteMaxCacheSize int64 `json:"site_max_cache_size,omitempty"`
+	// SiteMaxFiles is the number of files a site may have (0 is unlimited).
+	SiteMaxFiles int `json:"site_max_files,omitempty"`
+	// SiteMaxFileSize is the size of the largest file that is served (0 is unlimited).
+	SiteMaxFileSize int64 `json:"site_max_file_size,omitempty"`
+	// StreamThreshold streams larger files served as is from gitea instead of
+	// reading them into memory (0 never streams).
+	StreamThreshold int64 `json:"stream_threshold,omitempty"`
+
+	// Archives serves sites from an in-memory snapshot of their repo archive,
+	// downloaded once per version, for sites up to ArchiveMaxSize (64MB by
+	// default) unpacked.
+	Archives       bool  `json:"archives,omitempty"`
+	ArchiveMaxSize int64 `json:"archive_max_size,omitempty"`
+
+	// BucketHeader sends the A/B testing bucket (0-99) of the visitor in the
+	// X-Gitea-Pages-Bucket header, it's always in the {http.gitea.bucket} placeholder.
+	BucketHeader bool `json:"bucket_header,omitempty"`
+	// BucketCookie is the cookie that identifies a visitor for its bucket,
+	// visitors without it are identified by their IP address and user agent.
+	BucketCookie string `json:"bucket_cookie,omitempty"`
+
+	// GeoIPRaw is the geo IP provider module for the Country condition of
+	// redirect rules.
+	GeoIPRaw json.RawMessage `json:"geoip,omitempty" caddy:"namespace=http.handlers.gitea.geoip inline_key=provider"`
+
+	// CacheRaw is the cache backend module for files fetched from gitea.
+	CacheRaw json.RawMessage `json:"cache,omitempty" caddy:"namespace=http.handlers.gitea.cache inline_key=backend"`
+	// StoreRaw is the store module keeping the takedown list, the claimed
+	// custom domains and the site statistics across restarts.
+	StoreRaw json.RawMessage `json:"store,omitempty" caddy:"namespace=http.handlers.gitea.store inline_key=backend"`
+	// CacheTTL is how long files are cached, defaults to 5m.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+	// EncryptPrivateCache encrypts the cached content of private repos.
+	EncryptPrivateCache *CacheEncryption `json:"encrypt_private_cache,omitempty"`
+	// WebhookSecret is the secret of the gitea webhooks posted to
+	// /.well-known/gitea-pages-hook, which invalidate the cached content of
+	// their repo. The endpoint is disabled without a secret.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	// Export periodically exports snapshots of the served sites to S3
+	// compatible object storage.
+	Export *Export `json:"export,omitempty"`
+	// Refresh refreshes the cached files of sites on a schedule, e.g. at
+	// night, instead of when they expire.
+	Refresh *Refresh `json:"refresh,omitempty"`
+	// UsageReports reports the usage of the sites of every owner on a
+	// schedule, to the store and/or a webhook.
+	UsageReports *UsageReports `json:"usage_reports,omitempty"`
+	// HealthChecks checks the sites served on an interval and opens issues
+	// about those that keep failing in their repos.
+	HealthChecks *HealthChecks `json:"health_checks,omitempty"`
+	// Shield serves files from the exported snapshots in object storage
+	// when they're of the deployed version of the site, sparing gitea.
+	Shield *ObjectStorage `json:"shield,omitempty"`
+	// Peers fetches the files of every repo through its home node in the
+	// cluster, so they're fetched from gitea once per cluster.
+	Peers *Peers `json:"peers,omitempty"`
+
+	// MemoryPressure sheds the in-memory caches when the process gets close
+	// to MemoryLimit, or the soft memory limit of the runtime (GOMEMLIMIT)
+	// if it's not set.
+	MemoryPressure bool `json:"memory_pressure,omitempty"`
+	// MemoryLimit is the memory limit of the process in bytes, it's set as
+	// the soft memory limit of the runtime if there is none.
+	MemoryLimit int64 `json:"memory_limit,omitempty"`
+
+	auth   AuthProvider
+	geoip  GeoIPProvider
+	store  gitea.Store
+	events *caddyevents.App
+	app    *App
+	shared *Middleware
+	ctx    caddy.Context
+
+	logger *zap.Logger
 }
 
 // CaddyModule returns the Caddy module information.
@@ -44,72 +368,1836 @@ func (Middleware) CaddyModule() caddy.ModuleInfo {
 
 // Provision provisions gitea client.
 func (m *Middleware) Provision(ctx caddy.Context) error {
-	var err error
-	m.Client, err = gitea.NewClient(m.Server, m.Token, m.GiteaPages, m.GiteaPagesAllowAll)
+	if m.Use != "" {
+		return m.provisionUse(ctx)
+	}
 
-	return err
-}
+	m.logger = ctx.Logger()
+	m.ctx = ctx
 
-// Validate implements caddy.Validator.
-func (m *Middleware) Validate() error {
-	return nil
-}
+	// conflicting options fail before anything is loaded
+	if err := m.Validate(); err != nil {
+		return err
+	}
 
-// UnmarshalCaddyfile unmarshals a Caddyfile.
-func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
-	for d.Next() {
-		for n := d.Nesting(); d.NextBlock(n); {
-			switch d.Val() {
-			case "server":
-				d.Args(&m.Server)
-			case "token":
-				d.Args(&m.Token)
-			case "gitea_pages":
-				d.Args(&m.GiteaPages)
-			case "gitea_pages_allowall":
-				d.Args(&m.GiteaPagesAllowAll)
-			case "domain":
-				d.Args(&m.Domain)
+	eventsApp, err := ctx.App("events")
+	if err != nil {
+		return fmt.Errorf("getting events app: %w", err)
+	}
+
+	m.events = eventsApp.(*caddyevents.App)
+
+	if m.MaintenanceBanner == "" {
+		m.MaintenanceBanner = defaultMaintenanceBanner
+	}
+
+	// hosts are matched in their ASCII form
+	if m.Domain != "" {
+		m.Domain = gitea.NormalizeHost(m.Domain)
+	}
+
+	if m.LegacyRedirect != "" {
+		m.LegacyRedirect = gitea.NormalizeHost(m.LegacyRedirect)
+	}
+
+	options := []gitea.ClientOption{
+		gitea.SetLogger(m.logger),
+		gitea.SetEventHandler(m.emit),
+	}
+
+	// the faults are injected closest to gitea, below the rate limit
+	if m.Chaos != nil {
+		options = append(options, m.Chaos.option())
+	}
+
+	if m.UpstreamMaxBody > 0 || m.UpstreamValidate {
+		options = append(options, gitea.SetUpstreamGuard(m.UpstreamMaxBody, m.UpstreamValidate))
+	}
+
+	if len(m.SoftNotFound) > 0 {
+		options = append(options, gitea.SetSoftNotFound(m.SoftNotFound))
+	}
+
+	if len(m.TopicTiers) > 0 {
+		options = append(options, gitea.SetTopicTiers(m.TopicTiers))
+	}
+
+	// the access tokens are set below sudo, which looks at the token
+	if m.UpstreamOAuth2 != nil {
+		options = append(options, m.UpstreamOAuth2.option())
+	}
+
+	if m.SudoOwners {
+		options = append(options, gitea.SetSudoOwners())
+	}
+
+	if m.UpstreamQPS > 0 {
+		options = append(options, gitea.SetUpstreamLimit(m.UpstreamQPS, m.UpstreamBurst,
+			m.UpstreamQueue, time.Duration(m.UpstreamMaxWait)))
+	}
+
+	if m.TreeCacheTTL > 0 {
+		options = append(options, gitea.SetTreeTTL(time.Duration(m.TreeCacheTTL)))
+	}
+
+	if m.TreeBloomThreshold > 0 {
+		options = append(options, gitea.SetTreeBloomThreshold(m.TreeBloomThreshold))
+	}
+
+	if m.OpenTimeout > 0 {
+		options = append(options, gitea.SetOpenTimeout(time.Duration(m.OpenTimeout)))
+	}
+
+	if m.NotFoundTTL > 0 {
+		options = append(options, gitea.SetNotFoundTTL(time.Duration(m.NotFoundTTL)))
+	}
+
+	if m.RenderersRaw != nil {
+		mods, err := ctx.LoadModule(m, "RenderersRaw")
+		if err != nil {
+			return fmt.Errorf("loading renderers: %w", err)
+		}
+
+		for _, mod := range mods.(map[string]any) {
+			r := mod.(Renderer)
+			for _, typ := range r.Types() {
+				options = append(options, gitea.SetRenderer(typ, r))
 			}
 		}
 	}
 
-	return nil
-}
+	if m.AuthRaw != nil {
+		mod, err := ctx.LoadModule(m, "AuthRaw")
+		if err != nil {
+			return fmt.Errorf("loading auth provider: %w", err)
+		}
 
-// ServeHTTP performs gitea content fetcher.
-func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, _ caddyhttp.Handler) error {
-	// remove the domain if it's set (works fine if it's empty)
-	host := strings.TrimRight(strings.TrimSuffix(r.Host, m.Domain), ".")
-	h := strings.Split(host, ".")
+		m.auth = mod.(AuthProvider)
+	}
 
-	fp := h[0] + r.URL.Path
-	ref := r.URL.Query().Get("ref")
+	if m.GeoIPRaw != nil {
+		mod, err := ctx.LoadModule(m, "GeoIPRaw")
+		if err != nil {
+			return fmt.Errorf("loading geoip provider: %w", err)
+		}
+
+		m.geoip = mod.(GeoIPProvider)
+	}
+
+	if m.CacheRaw != nil {
+		mod, err := ctx.LoadModule(m, "CacheRaw")
+		if err != nil {
+			return fmt.Errorf("loading cache: %w", err)
+		}
+
+		ttl := time.Duration(m.CacheTTL)
+		if ttl == 0 {
+			ttl = defaultCacheTTL
+		}
+
+		options = append(options, gitea.SetCache(mod.(gitea.Cache), ttl))
+	}
+
+	if m.EncryptPrivateCache != nil {
+		opt, err := m.EncryptPrivateCache.option(ctx)
+		if err != nil {
+			return fmt.Errorf("encrypt_private_cache: %w", err)
+		}
+
+		options = append(options, opt)
+	}
+
+	if m.RenderMarkdown != nil {
+		options = append(options, gitea.SetRenderMarkdown(*m.RenderMarkdown))
+	}
+
+	if m.ConfigFromDescription {
+		options = append(options, gitea.SetDescriptionConfig())
+	}
+
+	if m.DefaultBranchFallback {
+		options = append(options, gitea.SetDefaultBranchFallback())
+	}
+
+	if m.GitHeaders {
+		options = append(options, gitea.SetGitHeaders())
+	}
+
+	if m.DefaultLayout != "" {
+		options = append(options, gitea.SetDefaultLayout(m.DefaultLayout))
+	}
+
+	if m.Sanitize != "" {
+		options = append(options, gitea.SetSanitize(m.Sanitize))
+	}
+
+	if m.DomainPolicy != "" {
+		options = append(options, gitea.SetDomainPolicy(m.DomainPolicy))
+	}
 
-	// if we haven't specified a domain, do not support repo.username and branch.repo.username
 	if m.Domain != "" {
-		switch {
-		case len(h) == 2:
-			fp = h[1] + "/" + h[0] + r.URL.Path
-		case len(h) == 3:
-			fp = h[2] + "/" + h[1] + r.URL.Path
-			ref = h[0]
+		options = append(options, gitea.SetPagesDomain(m.Domain))
+	}
+
+	if m.CustomDomains {
+		options = append(options, gitea.SetCustomDomains(m.Domain))
+	}
+
+	if m.PRPreviews {
+		options = append(options, gitea.SetPRPreviews())
+	}
+
+	for owner, names := range m.OwnerNames {
+		options = append(options, gitea.SetOwnerNames(owner, names))
+	}
+
+	if len(m.AllowOwners) > 0 || len(m.DenyOwners) > 0 {
+		options = append(options, gitea.SetOwners(m.AllowOwners, m.DenyOwners))
+	}
+
+	if m.Forms != nil && m.Forms.CaptchaVerifyURL != "" {
+		options = append(options, gitea.SetFormCaptcha(m.Forms.CaptchaVerifyURL, m.Forms.CaptchaSecret))
+	}
+
+	if len(m.BlockExtensions) > 0 {
+		options = append(options, gitea.SetBlockedExtensions(m.BlockExtensions, m.ExtensionOptOuts))
+	}
+
+	if m.MemoryPressure || m.MemoryLimit > 0 {
+		options = append(options, gitea.SetMemoryLimit(m.MemoryLimit))
+	}
+
+	if m.SiteMaxCacheSize > 0 || m.SiteMaxFiles > 0 || m.SiteMaxFileSize > 0 {
+		options = append(options, gitea.SetSiteLimits(m.SiteMaxCacheSize, m.SiteMaxFiles, m.SiteMaxFileSize))
+	}
+
+	if m.StreamThreshold > 0 {
+		options = append(options, gitea.SetStreamThreshold(m.StreamThreshold))
+	}
+
+	if m.Archives {
+		if m.ArchiveMaxSize == 0 {
+			m.ArchiveMaxSize = defaultArchiveMaxSize
+		}
+
+		options = append(options, gitea.SetArchives(m.ArchiveMaxSize))
+	}
+
+	if len(m.Experimental) > 0 {
+		options = append(options, gitea.SetFeatureFlags(m.Experimental))
+	}
+
+	if m.StoreRaw != nil {
+		mod, err := ctx.LoadModule(m, "StoreRaw")
+		if err != nil {
+			return fmt.Errorf("loading store: %w", err)
+		}
+
+		m.store = mod.(gitea.Store)
+		options = append(options, gitea.SetStore(m.store))
+	}
+
+	if m.TakedownFile != "" {
+		options = append(options, gitea.SetTakedownFile(m.TakedownFile))
+	}
+
+	if m.ServeStale > 0 {
+		options = append(options, gitea.SetServeStale(m.ServeStale))
+	}
+
+	if m.Shield != nil {
+		store, err := m.Shield.store()
+		if err != nil {
+			return fmt.Errorf("shield: %w", err)
 		}
+
+		options = append(options, gitea.SetShield(store, m.Shield.Prefix))
+	}
+
+	if m.Peers != nil {
+		options = append(options, m.Peers.option())
 	}
 
-	f, err := m.Client.Open(fp, ref)
+	m.Client, err = gitea.NewClient(m.Server, m.Token, m.GiteaPages, m.GiteaPagesAllowAll, options...)
 	if err != nil {
-		return caddyhttp.Error(http.StatusNotFound, err)
+		return err
+	}
+
+	if m.SudoOwners {
+		err := m.Client.CheckSudo()
+		if errors.Is(err, gitea.ErrSudoNotAdmin) {
+			return err
+		}
+
+		if err != nil {
+			m.logger.Warn("checking the token can sudo failed", zap.Error(err))
+		}
 	}
 
-	_, err = io.Copy(w, f)
+	registerClient(m.Client)
 
-	return err
+	// the pages of the app are provisioned by it
+	if m.app == nil {
+		app, err := ctx.App("gitea_pages")
+		if err != nil {
+			return fmt.Errorf("getting gitea_pages app: %w", err)
+		}
+
+		m.app = app.(*App)
+	}
+
+	if err := m.app.register(m.Name, m.Client); err != nil {
+		return err
+	}
+
+	for _, flag := range m.Client.FeatureFlags() {
+		m.logger.Info("experimental feature limited to some sites", zap.String("feature", flag.Feature),
+			zap.Strings("sites", flag.Sites))
+	}
+
+	if m.CommitStatus || m.PRComments || m.Forms != nil || m.HealthChecks != nil {
+		m.detectTokenScopes()
+	}
+
+	if m.Export != nil {
+		if err := m.Export.provision(); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+
+		go m.Export.run(m.ctx, m.Client, m.logger)
+	}
+
+	if m.store != nil {
+		go saveState(m.ctx, m.Client, m.logger)
+	}
+
+	if m.Refresh != nil {
+		if err := m.Refresh.provision(); err != nil {
+			return fmt.Errorf("refresh: %w", err)
+		}
+
+		go m.Refresh.run(m.ctx, m.Client, m.logger)
+	}
+
+	if m.UsageReports != nil {
+		if err := m.UsageReports.provision(); err != nil {
+			return fmt.Errorf("usage_reports: %w", err)
+		}
+
+		go m.UsageReports.run(m.ctx, m.Client, m.logger)
+	}
+
+	if m.HealthChecks != nil {
+		m.HealthChecks.provision()
+		go m.HealthChecks.run(m.ctx, m.Client, m.logger)
+	}
+
+	return nil
+}
+
+// sizedCache reports if the cache backend configured by raw can tell the
+// size of the cached files of a site, as needed for site_max_cache_size.
+// Unknown backends are left to loading the cache to report.
+func sizedCache(raw json.RawMessage) (string, bool) {
+	var cfg struct {
+		Backend string `json:"backend"`
+	}
+
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return "", true
+	}
+
+	info, err := caddy.GetModule("http.handlers.gitea.cache." + cfg.Backend)
+	if err != nil {
+		return cfg.Backend, true
+	}
+
+	_, ok := info.New().(gitea.PrefixSizer)
+
+	return cfg.Backend, ok
+}
+
+// Validate implements caddy.Validator. It refuses invalid values and options
+// that conflict with or need another option, however the config is written.
+func (m *Middleware) Validate() error {
+	switch m.TakedownStatus {
+	case 0, http.StatusUnavailableForLegalReasons, http.StatusGone:
+	default:
+		return fmt.Errorf("takedown_status must be 451 or 410, not %d", m.TakedownStatus)
+	}
+
+	if m.Sanitize != "" && !gitea.ValidSanitizePolicy(m.Sanitize) {
+		return fmt.Errorf("unknown sanitize policy %q", m.Sanitize)
+	}
+
+	if m.DomainPolicy != "" && !gitea.ValidDomainPolicy(m.DomainPolicy) {
+		return fmt.Errorf("unknown domain policy %q", m.DomainPolicy)
+	}
+
+	if !validQueryPolicy(m.QueryPolicy) {
+		return fmt.Errorf("unknown query policy %q", m.QueryPolicy)
+	}
+
+	if len(m.QueryParams) > 0 && (m.QueryPolicy == "" || m.QueryPolicy == queryIgnore) {
+		return errors.New("query_params needs query_policy redirect or reject")
+	}
+
+	if err := checkTiers(m.Tiers, m.TopicTiers); err != nil {
+		return err
+	}
+
+	for _, marker := range m.SoftNotFound {
+		if marker == "" {
+			return errors.New("soft_not_found markers must not be empty")
+		}
+	}
+
+	for _, o := range []struct {
+		name  string
+		value float64
+	}{
+		{"upstream_qps", m.UpstreamQPS},
+		{"upstream_burst", float64(m.UpstreamBurst)},
+		{"upstream_queue", float64(m.UpstreamQueue)},
+		{"upstream_max_wait", float64(m.UpstreamMaxWait)},
+		{"upstream_max_body", float64(m.UpstreamMaxBody)},
+		{"tree_cache_ttl", float64(m.TreeCacheTTL)},
+		{"tree_bloom_threshold", float64(m.TreeBloomThreshold)},
+		{"not_found_ttl", float64(m.NotFoundTTL)},
+		{"open_timeout", float64(m.OpenTimeout)},
+		{"drain_timeout", float64(m.DrainTimeout)},
+		{"serve_stale", float64(m.ServeStale)},
+		{"cache_ttl", float64(m.CacheTTL)},
+		{"site_max_cache_size", float64(m.SiteMaxCacheSize)},
+		{"site_max_files", float64(m.SiteMaxFiles)},
+		{"site_max_file_size", float64(m.SiteMaxFileSize)},
+		{"stream_threshold", float64(m.StreamThreshold)},
+		{"archive_max_size", float64(m.ArchiveMaxSize)},
+		{"memory_limit", float64(m.MemoryLimit)},
+	} {
+		if o.value < 0 {
+			return fmt.Errorf("%s can't be negative", o.name)
+		}
+	}
+
+	if m.Domain != "" && m.LegacyRedirect != "" {
+		return errors.New("legacy_redirect is for the path mode, without domain")
+	}
+
+	if m.Domain != "" && m.LegacyDeprecationHeader {
+		return errors.New("legacy_deprecation_header is for the path mode, without domain")
+	}
+
+	if m.CustomDomains && m.Domain == "" {
+		return errors.New("custom domains need a domain")
+	}
+
+	if m.CanonicalHost && !m.CustomDomains {
+		return errors.New("canonical_host needs custom_domains")
+	}
+
+	if m.CatchAll != "" {
+		if m.Domain == "" {
+			return errors.New("catch_all needs a domain")
+		}
+
+		if owner, repo, ok := strings.Cut(m.CatchAll, "/"); owner == "" || ok && (repo == "" || strings.Contains(repo, "/")) {
+			return fmt.Errorf("catch_all must be an owner or owner/repo, not %q", m.CatchAll)
+		}
+	}
+
+	switch m.BareDomain {
+	case "", bareDomainNotFound:
+	case bareDomainCatchAll:
+		if m.CatchAll == "" {
+			return errors.New("bare_domain catch_all needs catch_all")
+		}
+	default:
+		if u, err := url.Parse(m.BareDomain); err != nil || u.Host == "" ||
+			u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("bare_domain must be not_found, catch_all or an url, not %q", m.BareDomain)
+		}
+	}
+
+	if m.BareDomain != "" && m.Domain == "" {
+		return errors.New("bare_domain needs a domain")
+	}
+
+	if m.UpstreamQPS == 0 && (m.UpstreamBurst > 0 || m.UpstreamQueue > 0 || m.UpstreamMaxWait > 0) {
+		return errors.New("upstream_burst, upstream_queue and upstream_max_wait need upstream_qps")
+	}
+
+	if m.CacheRaw == nil && m.Refresh != nil {
+		return errors.New("refresh needs a cache")
+	}
+
+	if m.UpstreamOAuth2 != nil {
+		if m.Token != "" {
+			return errors.New("token and upstream_oauth2 are mutually exclusive")
+		}
+
+		if err := m.UpstreamOAuth2.validate(); err != nil {
+			return fmt.Errorf("upstream_oauth2: %w", err)
+		}
+	}
+
+	if m.HealthChecks != nil {
+		if err := m.HealthChecks.validate(); err != nil {
+			return fmt.Errorf("health_checks: %w", err)
+		}
+	}
+
+	if m.UsageReports != nil {
+		if err := m.UsageReports.validate(); err != nil {
+			return fmt.Errorf("usage_reports: %w", err)
+		}
+
+		if m.StoreRaw == nil && m.UsageReports.Webhook == "" {
+			return errors.New("usage_reports needs a store or a webhook")
+		}
+	}
+
+	if m.CacheRaw == nil && m.CacheTTL > 0 {
+		return errors.New("cache_ttl needs a cache")
+	}
+
+	if m.SiteMaxCacheSize > 0 {
+		if m.CacheRaw == nil {
+			return errors.New("site_max_cache_size needs a cache")
+		}
+
+		if backend, ok := sizedCache(m.CacheRaw); !ok {
+			return fmt.Errorf("site_max_cache_size needs a cache that can tell the size of a site, like memory or disk, not %s", backend)
+		}
+	}
+
+	if m.EncryptPrivateCache != nil {
+		if m.CacheRaw == nil {
+			return errors.New("encrypt_private_cache needs a cache")
+		}
+
+		if err := m.EncryptPrivateCache.validate(); err != nil {
+			return fmt.Errorf("encrypt_private_cache: %w", err)
+		}
+	}
+
+	if !m.Archives && m.ArchiveMaxSize > 0 {
+		return errors.New("archive_max_size needs archives")
+	}
+
+	if err := m.Experimental.Validate(); err != nil {
+		return fmt.Errorf("experimental: %w", err)
+	}
+
+	if _, ok := m.Experimental[gitea.FeatureArchives]; ok && !m.Archives {
+		return errors.New("the experimental archives flag needs archives")
+	}
+
+	if _, ok := m.Experimental[gitea.FeatureAuth]; ok && m.AuthRaw == nil {
+		return errors.New("the experimental auth flag needs auth")
+	}
+
+	if !m.PRPreviews && m.PRComments {
+		return errors.New("pr_comments needs pr_previews")
+	}
+
+	if len(m.ExtensionOptOuts) > 0 && len(m.BlockExtensions) == 0 {
+		return errors.New("extension_opt_outs needs block_extensions")
+	}
+
+	if m.Chaos != nil {
+		if err := m.Chaos.validate(); err != nil {
+			return fmt.Errorf("chaos: %w", err)
+		}
+	}
+
+	if err := m.LogSampling.validate(); err != nil {
+		return fmt.Errorf("log_sampling: %w", err)
+	}
+
+	if m.Forms != nil && (m.Forms.CaptchaVerifyURL == "") != (m.Forms.CaptchaSecret == "") {
+		return errors.New("the captcha of forms needs a verify url and a secret")
+	}
+
+	return nil
+}
+
+// detectTokenScopes finds out if the token can write what the features
+// configured need, and turns those off it can't, as a read-only token is
+// enough to serve sites.
+func (m *Middleware) detectTokenScopes() {
+	scopes, err := m.Client.DetectTokenScopes()
+	if err != nil {
+		m.logger.Warn("detecting token scopes failed, assuming the token can write", zap.Error(err))
+		return
+	}
+
+	if m.CommitStatus && !scopes.Statuses {
+		m.logger.Warn("token lacks the write:repository scope, not posting commit statuses")
+		m.CommitStatus = false
+	}
+
+	if m.PRComments && !scopes.Issues {
+		m.logger.Warn("token lacks the write:issue scope, not commenting preview urls")
+		m.PRComments = false
+	}
+
+	if m.Forms != nil && !scopes.Issues {
+		m.logger.Warn("token lacks the write:issue scope, forms are unavailable")
+	}
+
+	if m.HealthChecks != nil && !scopes.Issues {
+		m.logger.Warn("token lacks the write:issue scope, not opening issues about failing sites")
+	}
+}
+
+// emit emits a gitea event through the caddy events app.
+func (m *Middleware) emit(name string, data map[string]any) {
+	m.events.Emit(m.ctx, name, data)
+
+	if m.CommitStatus && name == gitea.EventSitePublished {
+		go m.postDeployStatus(data)
+	}
+
+	if m.PRComments && name == gitea.EventPreviewAvailable {
+		go m.commentPreview(data)
+	}
+}
+
+// commentPreview comments the url of a pull request preview on the pull request.
+func (m *Middleware) commentPreview(data map[string]any) {
+	owner, _ := data["owner"].(string)
+	repo, _ := data["repo"].(string)
+	ref, _ := data["ref"].(string)
+	sha, _ := data["sha"].(string)
+	index, _ := data["index"].(int64)
+
+	previewURL := m.siteURL(owner, repo, ref)
+	if previewURL == "" {
+		return
+	}
+
+	if err := m.Client.CommentPreview(owner, repo, index, previewURL, sha); err != nil {
+		m.logger.Error("commenting preview url failed",
+			zap.String("owner", owner), zap.String("repo", repo), zap.Int64("index", index), zap.Error(err))
+	}
+}
+
+// postDeployStatus posts the deploy status of a published site.
+func (m *Middleware) postDeployStatus(data map[string]any) {
+	owner, _ := data["owner"].(string)
+	repo, _ := data["repo"].(string)
+	ref, _ := data["ref"].(string)
+
+	siteURL := m.siteURL(owner, repo, ref)
+
+	if err := m.Client.PostDeployStatus(owner, repo, ref, siteURL); err != nil {
+		m.logger.Error("posting deploy status failed",
+			zap.String("owner", owner), zap.String("repo", repo), zap.String("ref", ref), zap.Error(err))
+	}
+}
+
+// siteURL returns the public url of the site of owner/repo (the gitea-pages
+// repo if repo is empty) at ref, which is unknown if no domain is configured.
+func (m *Middleware) siteURL(owner, repo, ref string) string {
+	return m.Client.SiteURL(owner, repo, ref, "/")
+}
+
+// Cleanup implements caddy.CleanerUpper, it waits (at most the drain
+// timeout) for the fetches in flight so they don't leave truncated cache
+// entries behind on reload.
+func (m *Middleware) Cleanup() error {
+	if m.Client != nil {
+		unregisterClient(m.Client)
+
+		if m.app != nil {
+			m.app.unregister(m.Client)
+		}
+
+		timeout := time.Duration(m.DrainTimeout)
+		if timeout == 0 {
+			timeout = defaultDrainTimeout
+		}
+
+		if err := m.Client.Drain(timeout); err != nil {
+			m.logger.Warn("unloading with fetches from gitea in flight", zap.Error(err))
+		}
+
+		if err := m.Client.SaveState(); err != nil {
+			m.logger.Warn("saving state failed", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		seen := make(map[string]bool)
+
+		for n := d.Nesting(); d.NextBlock(n); {
+			if seen[d.Val()] && !repeatableOptions[d.Val()] {
+				return d.Errf("duplicate gitea option %q", d.Val())
+			}
+
+			seen[d.Val()] = true
+
+			switch d.Val() {
+			case "server":
+				if !d.Args(&m.Server) {
+					return d.ArgErr()
+				}
+			case "token":
+				if !d.Args(&m.Token) {
+					return d.ArgErr()
+				}
+			case "gitea_pages":
+				if !d.Args(&m.GiteaPages) {
+					return d.ArgErr()
+				}
+			case "gitea_pages_allowall":
+				if !d.Args(&m.GiteaPagesAllowAll) {
+					return d.ArgErr()
+				}
+			case "domain":
+				if !d.Args(&m.Domain) {
+					return d.ArgErr()
+				}
+			case "name":
+				if !d.Args(&m.Name) {
+					return d.ArgErr()
+				}
+			case "use":
+				if !d.Args(&m.Use) {
+					return d.ArgErr()
+				}
+			case "topic_tier":
+				var t gitea.TopicTier
+				if !d.Args(&t.Topic, &t.Tier) {
+					return d.ArgErr()
+				}
+
+				if d.NextArg() {
+					if d.Val() != "auth" {
+						return d.Errf("unexpected argument %q, only auth", d.Val())
+					}
+
+					t.Auth = true
+				}
+
+				m.TopicTiers = append(m.TopicTiers, t)
+			case "tiers":
+				m.Tiers = d.RemainingArgs()
+				if len(m.Tiers) == 0 {
+					return d.ArgErr()
+				}
+			case "upstream_qps":
+				if err := parseFloatArg(d, &m.UpstreamQPS); err != nil {
+					return err
+				}
+			case "upstream_burst":
+				if err := parseIntArg(d, &m.UpstreamBurst); err != nil {
+					return err
+				}
+			case "upstream_queue":
+				if err := parseIntArg(d, &m.UpstreamQueue); err != nil {
+					return err
+				}
+			case "upstream_max_wait":
+				if err := parseDurationArg(d, &m.UpstreamMaxWait); err != nil {
+					return err
+				}
+			case "upstream_max_body":
+				if err := parseSizeArg(d, &m.UpstreamMaxBody); err != nil {
+					return err
+				}
+			case "upstream_validate":
+				m.UpstreamValidate = true
+			case "soft_not_found":
+				markers := d.RemainingArgs()
+				if len(markers) == 0 {
+					return d.ArgErr()
+				}
+
+				m.SoftNotFound = append(m.SoftNotFound, markers...)
+			case "sudo_owners":
+				m.SudoOwners = true
+			case "tree_cache_ttl":
+				if err := parseDurationArg(d, &m.TreeCacheTTL); err != nil {
+					return err
+				}
+			case "tree_bloom_threshold":
+				if err := parseIntArg(d, &m.TreeBloomThreshold); err != nil {
+					return err
+				}
+			case "not_found_ttl":
+				if err := parseDurationArg(d, &m.NotFoundTTL); err != nil {
+					return err
+				}
+			case "open_timeout":
+				if err := parseDurationArg(d, &m.OpenTimeout); err != nil {
+					return err
+				}
+			case "drain_timeout":
+				if err := parseDurationArg(d, &m.DrainTimeout); err != nil {
+					return err
+				}
+			case "serve_stale":
+				if err := parseIntArg(d, &m.ServeStale); err != nil {
+					return err
+				}
+			case "commit_status":
+				m.CommitStatus = true
+			case "render_markdown":
+				var v string
+				if !d.Args(&v) {
+					return d.ArgErr()
+				}
+
+				if v != "on" && v != "off" {
+					return d.Errf("render_markdown must be on or off, not %q", v)
+				}
+
+				render := v == "on"
+				m.RenderMarkdown = &render
+			case "config_from_description":
+				m.ConfigFromDescription = true
+			case "default_branch_fallback":
+				m.DefaultBranchFallback = true
+			case "search":
+				m.Search = true
+			case "comments":
+				m.Comments = true
+			case "page_json":
+				m.PageJSON = true
+			case "git_headers":
+				m.GitHeaders = true
+			case "default_layout":
+				if !d.Args(&m.DefaultLayout) {
+					return d.ArgErr()
+				}
+			case "sanitize":
+				if !d.Args(&m.Sanitize) {
+					return d.ArgErr()
+				}
+
+				if !gitea.ValidSanitizePolicy(m.Sanitize) {
+					return d.Errf("unknown sanitize policy %q", m.Sanitize)
+				}
+			case "domain_policy":
+				if !d.Args(&m.DomainPolicy) {
+					return d.ArgErr()
+				}
+
+				if !gitea.ValidDomainPolicy(m.DomainPolicy) {
+					return d.Errf("unknown domain policy %q", m.DomainPolicy)
+				}
+			case "custom_domains":
+				m.CustomDomains = true
+			case "canonical_host":
+				m.CanonicalHost = true
+			case "legacy_deprecation_header":
+				m.LegacyDeprecationHeader = true
+			case "catch_all":
+				if !d.Args(&m.CatchAll) {
+					return d.ArgErr()
+				}
+			case "bare_domain":
+				if !d.Args(&m.BareDomain) {
+					return d.ArgErr()
+				}
+			case "legacy_redirect":
+				if !d.Args(&m.LegacyRedirect) {
+					return d.ArgErr()
+				}
+			case "pr_previews":
+				m.PRPreviews = true
+			case "pr_comments":
+				m.PRComments = true
+			case "forbid_private":
+				m.ForbidPrivate = true
+			case "reject_early_data":
+				m.RejectEarlyData = true
+			case "site_max_cache_size":
+				if err := parseSizeArg(d, &m.SiteMaxCacheSize); err != nil {
+					return err
+				}
+			case "site_max_files":
+				if err := parseIntArg(d, &m.SiteMaxFiles); err != nil {
+					return err
+				}
+			case "site_max_file_size":
+				if err := parseSizeArg(d, &m.SiteMaxFileSize); err != nil {
+					return err
+				}
+			case "stream_threshold":
+				if err := parseSizeArg(d, &m.StreamThreshold); err != nil {
+					return err
+				}
+			case "archives":
+				m.Archives = true
+
+				if d.CountRemainingArgs() > 0 {
+					if err := parseSizeArg(d, &m.ArchiveMaxSize); err != nil {
+						return err
+					}
+				}
+			case "memory_limit":
+				m.MemoryPressure = true
+
+				if d.CountRemainingArgs() > 0 {
+					if err := parseSizeArg(d, &m.MemoryLimit); err != nil {
+						return err
+					}
+				}
+			case "safe_service_workers":
+				m.SafeServiceWorkers = true
+			case "bucket_header":
+				m.BucketHeader = true
+			case "bucket_cookie":
+				if !d.Args(&m.BucketCookie) {
+					return d.ArgErr()
+				}
+			case "takedown_file":
+				if !d.Args(&m.TakedownFile) {
+					return d.ArgErr()
+				}
+			case "takedown_status":
+				if err := parseIntArg(d, &m.TakedownStatus); err != nil {
+					return err
+				}
+			case "maintenance_banner":
+				if !d.Args(&m.MaintenanceBanner) {
+					return d.ArgErr()
+				}
+			case "owner":
+				if err := m.unmarshalOwnerNames(d); err != nil {
+					return err
+				}
+			case "query_policy":
+				if !d.Args(&m.QueryPolicy) {
+					return d.ArgErr()
+				}
+
+				if !validQueryPolicy(m.QueryPolicy) {
+					return d.Errf("unknown query policy %q", m.QueryPolicy)
+				}
+			case "query_params":
+				if d.CountRemainingArgs() == 0 {
+					return d.ArgErr()
+				}
+
+				m.QueryParams = append(m.QueryParams, d.RemainingArgs()...)
+			case "allow_owners":
+				if d.CountRemainingArgs() == 0 {
+					return d.ArgErr()
+				}
+
+				m.AllowOwners = append(m.AllowOwners, d.RemainingArgs()...)
+			case "deny_owners":
+				if d.CountRemainingArgs() == 0 {
+					return d.ArgErr()
+				}
+
+				m.DenyOwners = append(m.DenyOwners, d.RemainingArgs()...)
+			case "block_extensions":
+				if d.CountRemainingArgs() == 0 {
+					return d.ArgErr()
+				}
+
+				m.BlockExtensions = append(m.BlockExtensions, d.RemainingArgs()...)
+			case "extension_opt_outs":
+				if d.CountRemainingArgs() == 0 {
+					return d.ArgErr()
+				}
+
+				m.ExtensionOptOuts = append(m.ExtensionOptOuts, d.RemainingArgs()...)
+			case "forms":
+				m.Forms = new(Forms)
+				if err := m.Forms.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "isolation":
+				m.Isolation = new(Isolation)
+				if err := m.Isolation.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "export":
+				m.Export = new(Export)
+				if err := m.Export.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "refresh":
+				m.Refresh = new(Refresh)
+				if err := m.Refresh.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "shield":
+				m.Shield = new(ObjectStorage)
+				if err := m.Shield.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "peers":
+				m.Peers = new(Peers)
+				if err := m.Peers.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "log_sampling":
+				m.LogSampling = make(LogSampling)
+				if err := m.LogSampling.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "upstream_oauth2":
+				m.UpstreamOAuth2 = new(UpstreamOAuth2)
+				if err := m.UpstreamOAuth2.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "health_checks":
+				m.HealthChecks = new(HealthChecks)
+				if err := m.HealthChecks.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "usage_reports":
+				m.UsageReports = new(UsageReports)
+				if err := m.UsageReports.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "experimental":
+				if m.Experimental == nil {
+					m.Experimental = make(gitea.FeatureFlags)
+				}
+
+				if err := unmarshalFeatureFlags(d, m.Experimental); err != nil {
+					return err
+				}
+			case "chaos":
+				m.Chaos = new(Chaos)
+				if err := m.Chaos.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "assets":
+				m.Assets = new(AssetHeaders)
+				if err := m.Assets.UnmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "renderer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				name := d.Val()
+
+				unm, err := caddyfile.UnmarshalModule(d, "http.handlers.gitea.renderers."+name)
+				if err != nil {
+					return err
+				}
+
+				if m.RenderersRaw == nil {
+					m.RenderersRaw = make(caddy.ModuleMap)
+				}
+
+				m.RenderersRaw[name] = caddyconfig.JSON(unm, nil)
+			case "auth":
+				raw, err := unmarshalModule(d, "http.handlers.gitea.auth", "provider", "", nil)
+				if err != nil {
+					return err
+				}
+
+				m.AuthRaw = raw
+			case "geoip":
+				raw, err := unmarshalModule(d, "http.handlers.gitea.geoip", "provider", "", nil)
+				if err != nil {
+					return err
+				}
+
+				m.GeoIPRaw = raw
+			case "cache":
+				raw, err := unmarshalModule(d, "http.handlers.gitea.cache", "backend", "memory", m.unmarshalCacheOption)
+				if err != nil {
+					return err
+				}
+
+				m.CacheRaw = raw
+			case "store":
+				raw, err := unmarshalModule(d, "http.handlers.gitea.store", "backend", "", nil)
+				if err != nil {
+					return err
+				}
+
+				m.StoreRaw = raw
+			case "cache_ttl":
+				if err := parseDurationArg(d, &m.CacheTTL); err != nil {
+					return err
+				}
+			case "encrypt_private_cache":
+				m.EncryptPrivateCache = new(CacheEncryption)
+				d.Args(&m.EncryptPrivateCache.Key)
+			case "webhook_secret":
+				if !d.Args(&m.WebhookSecret) {
+					return d.ArgErr()
+				}
+			default:
+				return unknownOption(d)
+			}
+
+			// the options read all their arguments
+			if d.NextArg() {
+				return d.Errf("unexpected argument %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalFeatureFlags unmarshals the experimental block of a Caddyfile
+// into ff, a feature per line followed by the owners and sites (owner/repo)
+// it's enabled for:
+//
+//	experimental {
+//		archives someowner otherowner/site
+//		auth *
+//	}
+func unmarshalFeatureFlags(d *caddyfile.Dispenser, ff gitea.FeatureFlags) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		feature := d.Val()
+		if !gitea.ValidFeature(feature) {
+			return d.Errf("unknown experimental feature %q", feature)
+		}
+
+		sites := d.RemainingArgs()
+		if len(sites) == 0 {
+			return d.ArgErr()
+		}
+
+		ff[feature] = append(ff[feature], sites...)
+	}
+
+	return nil
+}
+
+// unmarshalModule unmarshals the module of namespace a directive names, as
+// argument or with the key option of its block (name if there's none). own
+// handles the options of the middleware in the block, the other options are
+// those of the module. Both forms are the same:
+//
+//	cache disk {
+//		dir /var/cache/caddy-gitea
+//	}
+//	cache_ttl 5m
+//
+//	cache {
+//		backend disk
+//		ttl 5m
+//		dir /var/cache/caddy-gitea
+//	}
+func unmarshalModule(d *caddyfile.Dispenser, namespace, key, name string,
+	own func(d *caddyfile.Dispenser) (bool, error),
+) (json.RawMessage, error) {
+	directive := d.Token()
+
+	if d.NextArg() {
+		name = d.Val()
+
+		unm, err := caddyfile.UnmarshalModule(d, namespace+"."+name)
+		if err != nil {
+			return nil, err
+		}
+
+		return caddyconfig.JSONModuleObject(unm, key, name, nil), nil
+	}
+
+	var options []caddyfile.Token
+
+	for n := d.Nesting(); d.NextBlock(n); {
+		if d.Val() == key {
+			if !d.Args(&name) {
+				return nil, d.ArgErr()
+			}
+
+			continue
+		}
+
+		if own != nil {
+			ok, err := own(d)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				continue
+			}
+		}
+
+		options = append(options, d.NextSegment()...)
+	}
+
+	if name == "" {
+		return nil, d.Errf("%s needs a %s", directive.Text, key)
+	}
+
+	// the module gets its options in the block of the first form
+	first, open, closing := directive, directive, d.Token()
+	first.Text, open.Text, closing.Text = name, "{", "}"
+
+	md := caddyfile.NewDispenser(append(append([]caddyfile.Token{first, open}, options...), closing))
+	md.Next()
+
+	unm, err := caddyfile.UnmarshalModule(md, namespace+"."+name)
+	if err != nil {
+		return nil, err
+	}
+
+	return caddyconfig.JSONModuleObject(unm, key, name, nil), nil
+}
+
+// unmarshalCacheOption unmarshals the options of the cache block that aren't
+// options of the cache backend.
+func (m *Middleware) unmarshalCacheOption(d *caddyfile.Dispenser) (bool, error) {
+	if d.Val() != "ttl" {
+		return false, nil
+	}
+
+	return true, parseDurationArg(d, &m.CacheTTL)
+}
+
+// unmarshalOwnerNames unmarshals the names of an owner.
+//
+//	owner yourorg {
+//		gitea_pages pages
+//		gitea_pages_allowall pages-allowall
+//		default_repo www
+//	}
+func (m *Middleware) unmarshalOwnerNames(d *caddyfile.Dispenser) error {
+	var owner string
+	if !d.Args(&owner) {
+		return d.ArgErr()
+	}
+
+	var names gitea.OwnerNames
+
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "gitea_pages":
+			if !d.Args(&names.GiteaPages) {
+				return d.ArgErr()
+			}
+		case "gitea_pages_allowall":
+			if !d.Args(&names.GiteaPagesAllowAll) {
+				return d.ArgErr()
+			}
+		case "default_repo":
+			if !d.Args(&names.DefaultRepo) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown owner option %q", d.Val())
+		}
+	}
+
+	if m.OwnerNames == nil {
+		m.OwnerNames = make(map[string]gitea.OwnerNames)
+	}
+
+	m.OwnerNames[owner] = names
+
+	return nil
+}
+
+func parseFloatArg(d *caddyfile.Dispenser, f *float64) error {
+	var v string
+	if !d.Args(&v) {
+		return d.ArgErr()
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return d.Errf("invalid number %q: %v", v, err)
+	}
+
+	*f = n
+
+	return nil
+}
+
+func parseIntArg(d *caddyfile.Dispenser, i *int) error {
+	var v string
+	if !d.Args(&v) {
+		return d.ArgErr()
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return d.Errf("invalid number %q: %v", v, err)
+	}
+
+	*i = n
+
+	return nil
+}
+
+func parseDurationArg(d *caddyfile.Dispenser, dur *caddy.Duration) error {
+	var v string
+	if !d.Args(&v) {
+		return d.ArgErr()
+	}
+
+	n, err := caddy.ParseDuration(v)
+	if err != nil {
+		return d.Errf("invalid duration %q: %v", v, err)
+	}
+
+	*dur = caddy.Duration(n)
+
+	return nil
+}
+
+// siteName returns the name (owner/repo/filepath) and ref of the file the
+// host (without port) serves at urlPath, ref is the ref query parameter.
+// Both come from the request; the client rejects names and refs that are
+// unsafe to send to gitea.
+func (m Middleware) siteName(host, urlPath, ref string) (string, string) {
+	// remove the domain if it's set (works fine if it's empty)
+	host = strings.TrimRight(strings.TrimSuffix(host, m.Domain), ".")
+	h := strings.Split(host, ".")
+
+	fp := h[0] + urlPath
+
+	// if we haven't specified a domain, do not support repo.username and branch.repo.username
+	if m.Domain != "" {
+		switch {
+		case len(h) == 2:
+			fp = h[1] + "/" + h[0] + urlPath
+		case len(h) == 3:
+			fp = h[2] + "/" + h[1] + urlPath
+			ref = h[0]
+		}
+	}
+
+	return fp, ref
+}
+
+// ServeHTTP performs gitea content fetcher.
+func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if m.shared != nil {
+		shared := *m.shared
+		if len(m.Tiers) > 0 {
+			shared.Tiers = m.Tiers
+		}
+
+		return shared.ServeHTTP(w, r, next)
+	}
+
+	if len(m.LogSampling) > 0 {
+		return m.sampleLog(w, r, func(w http.ResponseWriter) error {
+			return m.serveHTTP(w, r)
+		})
+	}
+
+	return m.serveHTTP(w, r)
+}
+
+// serveHTTP serves r, a request of a site or an endpoint of the module.
+func (m Middleware) serveHTTP(w http.ResponseWriter, r *http.Request) error {
+	if m.Peers != nil && strings.HasPrefix(r.URL.Path, gitea.PeerPath+"/") {
+		m.Client.ServePeer(w, r)
+		return nil
+	}
+
+	if m.WebhookSecret != "" && r.URL.Path == webhookPath {
+		return m.serveWebhook(w, r)
+	}
+
+	if m.CustomDomains && r.URL.Path == domainAskPath {
+		return m.serveDomainAsk(w, r)
+	}
+
+	hostname, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		hostname = r.Host
+	}
+
+	// internationalized hosts map to the punycode owner names
+	hostname = gitea.NormalizeHost(hostname)
+
+	if err := m.Client.TakenDown(hostname); err != nil {
+		return m.httpError(err)
+	}
+
+	urlPath := r.URL.Path
+
+	// the endpoints of a site are served below its root
+	var endpoint, form string
+
+	for _, p := range []string{manifestPath, searchPath, openSearchPath, commentsPath, commentsScriptPath} {
+		if strings.HasSuffix(urlPath, p) && m.endpointEnabled(p) {
+			endpoint = p
+			urlPath = strings.TrimSuffix(urlPath, p)
+
+			break
+		}
+	}
+
+	// forms are named, they're posted to a path below the endpoint
+	if i := strings.LastIndex(urlPath, formPath); i >= 0 && endpoint == "" && m.Forms != nil {
+		endpoint, form = formPath, urlPath[i+len(formPath):]
+		urlPath = urlPath[:i]
+	}
+
+	fp, ref := m.siteName(hostname, urlPath, r.URL.Query().Get("ref"))
+
+	// the domain itself has no owner label to take a site from
+	if m.Domain != "" && hostname == m.Domain {
+		switch m.bareDomain() {
+		case bareDomainNotFound:
+			return m.httpError(fs.ErrNotExist)
+		case bareDomainCatchAll:
+			fp, ref = m.CatchAll+urlPath, ""
+		default:
+			http.Redirect(w, r, m.BareDomain, http.StatusFound)
+			return nil
+		}
+	}
+
+	// requests of the legacy path mode are counted, they may be moved to the
+	// subdomain form
+	if m.Domain == "" {
+		if target := m.legacyRequest(w, r, fp, ref); target != "" {
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return nil
+		}
+	}
+
+	if m.CustomDomains && !m.pagesHost(hostname) {
+		if fp, err = m.customDomainSite(hostname, urlPath); err != nil {
+			if target := m.unclaimedTarget(r, err); target != "" {
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return nil
+			}
+
+			return m.httpError(err)
+		}
+
+		if endpoint == "" {
+			if target := m.secondaryTarget(r, hostname, fp, ref); target != "" {
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return nil
+			}
+		}
+	} else if endpoint == "" {
+		if target := m.canonicalTarget(r, fp, ref); target != "" {
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return nil
+		}
+	}
+
+	if m.CatchAll != "" && m.pagesHost(hostname) && !m.siteExists(r, hostname, fp, ref) {
+		fp, ref = m.CatchAll+urlPath, ""
+	}
+
+	if m.Isolation != nil {
+		m.Isolation.setHeaders(w)
+	}
+
+	// asset headers override the resource policy of the isolation headers
+	if m.Assets != nil {
+		m.Assets.setHeaders(w, r.URL.Path)
+	}
+
+	bucket := m.setBucket(w, r)
+
+	if m.RejectEarlyData && replayable(r) {
+		return caddyhttp.Error(http.StatusTooEarly, errors.New("request sent as early data"))
+	}
+
+	// symbolic refs are checked as the default version they name, they're
+	// only canonicalized once the visitor may see the site
+	checkRef := ref
+	if gitea.IsSymbolicRef(ref) {
+		checkRef = ""
+	}
+
+	// the sites of other tiers are served by other handlers
+	if len(m.Tiers) > 0 || len(m.TopicTiers) > 0 {
+		if err := m.checkTier(r, fp, checkRef); err != nil {
+			return err
+		}
+	}
+
+	// sites auth isn't rolled out to yet don't serve private repos at all
+	if m.auth != nil && m.Client.FeatureEnabled(r.Context(), gitea.FeatureAuth, fp, checkRef) {
+		if handled, err := m.authorize(w, r, fp, checkRef); handled || err != nil {
+			return err
+		}
+	} else if m.auth != nil || m.ForbidPrivate {
+		if err := m.forbidPrivate(r, fp, checkRef); err != nil {
+			return err
+		}
+	}
+
+	// abbreviated shas and symbolic refs (HEAD, default) of the query are
+	// redirected to their canonical ref, those of the host served with it
+	if ref != "" {
+		canonical, err := m.Client.CanonicalRef(r.Context(), fp, ref)
+		if err != nil {
+			return m.httpError(err)
+		}
+
+		if canonical != ref && r.URL.Query().Get("ref") == ref &&
+			(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			u := *r.URL
+
+			q := u.Query()
+			if canonical == "" {
+				q.Del("ref")
+			} else {
+				q.Set("ref", canonical)
+			}
+
+			u.RawQuery = q.Encode()
+
+			http.Redirect(w, r, u.RequestURI(), http.StatusFound)
+
+			return nil
+		}
+
+		ref = canonical
+	}
+
+	switch endpoint {
+	case manifestPath:
+		return m.serveManifest(w, r, fp, ref)
+	case searchPath:
+		return m.serveSearch(w, r, fp, ref, urlPath)
+	case openSearchPath:
+		return m.serveOpenSearch(w, r, urlPath)
+	case formPath:
+		return m.serveForm(w, r, fp, ref, urlPath, form)
+	case commentsPath:
+		return m.serveComments(w, r, fp, ref)
+	case commentsScriptPath:
+		return m.serveCommentsScript(w)
+	}
+
+	// files are only read, the endpoints that are posted to are served above
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return caddyhttp.Error(http.StatusMethodNotAllowed, errors.New("files are only read"))
+	}
+
+	target, err := m.checkQuery(r)
+	if err != nil {
+		return err
+	}
+
+	if target != "" {
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return nil
+	}
+
+	if err := m.checkServiceWorker(r, ref); err != nil {
+		return err
+	}
+
+	visitor := &gitea.Visitor{Header: r.Header, Bucket: bucket}
+	if m.geoip != nil {
+		visitor.Country = m.geoip.Country(r)
+	}
+
+	if m.PageJSON && r.URL.Query().Get("format") == "json" {
+		return m.servePage(w, r, fp, ref, urlPath, visitor)
+	}
+
+	f, err := m.Client.OpenVisitor(r.Context(), fp, ref, visitor)
+
+	var notModified *gitea.NotModifiedError
+	if errors.As(err, &notModified) {
+		for k, v := range notModified.Header {
+			w.Header()[k] = v
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	var redirect *gitea.RedirectError
+	if errors.As(err, &redirect) {
+		http.Redirect(w, r, redirectTarget(urlPath, redirect), redirect.Status)
+		return nil
+	}
+
+	var embargo *gitea.EmbargoError
+	if errors.As(err, &embargo) {
+		return m.serveEmbargo(w, embargo)
+	}
+
+	if err != nil {
+		return m.serveError(w, r, fp, ref, err)
+	}
+
+	defer f.Close()
+
+	setValidators(w, f)
+	setVariant(w, r, f)
+	m.limitServiceWorkerScope(w, r)
+
+	if sf, ok := f.(interface{ Stale() bool }); ok && sf.Stale() {
+		return m.serveStale(w, f)
+	}
+
+	if sf, ok := f.(interface{ Streamed() bool }); ok && sf.Streamed() || fileStatus(f) != http.StatusOK {
+		return serveAsIs(w, r, f)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// generated pages have no source to take ranges of, they're sent whole;
+	// ranges of rendered pages are of their source
+	if gf, ok := f.(interface{ Generated() bool }); ok && gf.Generated() {
+		r.Header.Del("Range")
+	}
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return caddyhttp.Error(http.StatusInternalServerError, errors.New("file can't seek"))
+	}
+
+	// files are read completely (rendered, LFS objects resolved), so ranges,
+	// HEAD and conditional requests are answered from memory; the type of
+	// files gitea doesn't know is derived from their extension
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), rs)
+
+	return nil
+}
+
+// serveAsIs serves f with its status, without ranges: files streamed from
+// gitea, which answered their range (if any) already, and 404 pages.
+func serveAsIs(w http.ResponseWriter, r *http.Request, f fs.File) error {
+	if fi, err := f.Stat(); err == nil && fi.Size() >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	}
+
+	w.WriteHeader(fileStatus(f))
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	_, err := io.Copy(w, f)
+
+	return err
+}
+
+// fileStatus returns the status f is served with, 200 unless it tells.
+func fileStatus(f fs.File) int {
+	if sf, ok := f.(interface{ Status() int }); ok {
+		return sf.Status()
+	}
+
+	return http.StatusOK
+}
+
+// endpointEnabled reports if the endpoint p of sites is served.
+func (m Middleware) endpointEnabled(p string) bool {
+	switch p {
+	case searchPath, openSearchPath:
+		return m.Search
+	case commentsPath, commentsScriptPath:
+		return m.Comments
+	}
+
+	return true
+}
+
+// redirectTarget returns where the redirect of the site served on urlPath
+// goes, paths are relative to the site, which may be served below a path.
+func redirectTarget(urlPath string, redirect *gitea.RedirectError) string {
+	if !strings.HasPrefix(redirect.To, "/") {
+		return redirect.To
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(urlPath, redirect.Path), "/") + redirect.To
+}
+
+// serveStale serves a stale copy of f with a warning and for html pages a banner.
+func (m Middleware) serveStale(w http.ResponseWriter, f fs.File) error {
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if name := fi.Name(); strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".md") {
+		content = injectBanner(content, m.MaintenanceBanner)
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+
+	_, err = w.Write(content)
+
+	return err
+}
+
+// injectBanner inserts banner right after the <body> tag of page, or at the
+// start if it has none.
+func injectBanner(page []byte, banner string) []byte {
+	i := bytes.Index(bytes.ToLower(page), []byte("<body"))
+	if i >= 0 {
+		if end := bytes.IndexByte(page[i:], '>'); end >= 0 {
+			i += end + 1
+		} else {
+			i = 0
+		}
+	} else {
+		i = 0
+	}
+
+	res := make([]byte, 0, len(page)+len(banner))
+	res = append(res, page[:i]...)
+	res = append(res, banner...)
+	res = append(res, page[i:]...)
+
+	return res
+}
+
+// serveError serves the limit page of the site fp at ref for err, if it's a
+// limit the site has a page for, otherwise it maps err to a HTTP error.
+func (m Middleware) serveError(w http.ResponseWriter, r *http.Request, fp, ref string, err error) error {
+	herr := m.httpError(err)
+
+	page, ok := m.Client.LimitPage(r.Context(), fp, ref, err)
+	if !ok {
+		return herr
+	}
+
+	var he caddyhttp.HandlerError
+	if !errors.As(herr, &he) {
+		return herr
+	}
+
+	m.logger.Debug("serving limit page", zap.String("site", fp), zap.Error(err))
+
+	// the limit may be over by the next request
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(he.StatusCode)
+
+	_, err = w.Write(page)
+
+	return err
+}
+
+// httpError maps errors of the gitea client to a HTTP error.
+func (m Middleware) httpError(err error) error {
+	// the client went away, it won't see the status anyway (like the
+	// reverse proxy, 499 as nginx logs it)
+	if errors.Is(err, context.Canceled) {
+		return caddyhttp.Error(499, err)
+	}
+
+	if errors.Is(err, gitea.ErrTakenDown) {
+		m.logger.Info("refused request for taken down site", zap.Error(err))
+
+		status := m.TakedownStatus
+		if status == 0 {
+			status = http.StatusUnavailableForLegalReasons
+		}
+
+		return caddyhttp.Error(status, err)
+	}
+
+	// tell the owner which limit the site exceeds or why the file is refused
+	if errors.Is(err, gitea.ErrSiteLimit) || errors.Is(err, gitea.ErrBlockedExtension) {
+		return caddyhttp.Error(http.StatusForbidden, err)
+	}
+
+	if errors.Is(err, gitea.ErrTimeout) {
+		return caddyhttp.Error(http.StatusGatewayTimeout, err)
+	}
+
+	if errors.Is(err, gitea.ErrUpstreamBusy) || errors.Is(err, gitea.ErrMaintenance) ||
+		errors.Is(err, gitea.ErrReadOnlyToken) {
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+
+	if errors.Is(err, gitea.ErrUpstreamInvalid) || errors.Is(err, gitea.ErrUpstreamTooLarge) {
+		return caddyhttp.Error(http.StatusBadGateway, err)
+	}
+
+	return caddyhttp.Error(http.StatusNotFound, err)
+}
+
+// setValidators sets the ETag and Last-Modified headers gitea sent for f,
+// its Content-Type if known and the headers its site sets.
+func setValidators(w http.ResponseWriter, f fs.File) {
+	if ct, ok := f.(interface{ ContentType() string }); ok && ct.ContentType() != "" {
+		w.Header().Set("Content-Type", ct.ContentType())
+	}
+
+	if fh, ok := f.(interface{ Header() http.Header }); ok {
+		for k, v := range fh.Header() {
+			w.Header()[k] = v
+		}
+	}
+
+	if e, ok := f.(interface{ ETag() string }); ok && e.ETag() != "" {
+		w.Header().Set("ETag", e.ETag())
+	}
+
+	if fi, err := f.Stat(); err == nil && !fi.ModTime().IsZero() {
+		w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+}
+
+// setVariant keeps the visitor of a site with a canary on the variant it saw.
+func setVariant(w http.ResponseWriter, r *http.Request, f fs.File) {
+	v, ok := f.(interface{ Variant() string })
+	if !ok || v.Variant() == "" {
+		return
+	}
+
+	w.Header().Add("Vary", "Cookie")
+
+	if cookie, err := r.Cookie(gitea.CanaryCookie); err == nil && cookie.Value == v.Variant() {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     gitea.CanaryCookie,
+		Value:    v.Variant(),
+		Path:     "/",
+		MaxAge:   int(canaryCookieTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// serveManifest writes the manifest of all files of the site as JSON.
+func (m Middleware) serveManifest(w http.ResponseWriter, r *http.Request, fp, ref string) error {
+	manifest, err := m.Client.Manifest(r.Context(), fp, ref)
+	if err != nil {
+		return m.httpError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(manifest)
 }
 
 // Interface guards
 var (
 	_ caddy.Provisioner           = (*Middleware)(nil)
+	_ caddy.CleanerUpper          = (*Middleware)(nil)
 	_ caddy.Validator             = (*Middleware)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Middleware)(nil)
 	_ caddyfile.Unmarshaler       = (*Middleware)(nil)
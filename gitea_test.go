@@ -0,0 +1,442 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// testDomain is the domain the sites of the test handlers are served on.
+const testDomain = "pages.example.com"
+
+// newTestHandler returns a handler of the sites of srv on testDomain, with a
+// client made with options. It isn't provisioned, so it has no events, app
+// or background jobs.
+func newTestHandler(t *testing.T, srv *giteatest.Server, options ...gitea.ClientOption) Middleware {
+	t.Helper()
+
+	c, err := gitea.NewClient(srv.URL, "token", "", "", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Middleware{
+		Client:            c,
+		Server:            srv.URL,
+		Domain:            testDomain,
+		MaintenanceBanner: defaultMaintenanceBanner,
+		logger:            zap.NewNop(),
+	}
+}
+
+// handler returns m as http.Handler, errors of m answered with their status
+// like caddy does without error pages.
+func handler(m Middleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := m.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return caddyhttp.Error(http.StatusNotFound, nil)
+		}))
+
+		var he caddyhttp.HandlerError
+
+		switch {
+		case errors.As(err, &he):
+			w.WriteHeader(he.StatusCode)
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
+
+// serve serves r with m and returns the response.
+func serve(m Middleware, r *http.Request) *http.Response {
+	rec := httptest.NewRecorder()
+	handler(m).ServeHTTP(rec, r)
+
+	return rec.Result()
+}
+
+// siteRequest returns a GET request of p on the host of the sites of owner.
+func siteRequest(owner, p string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "http://"+owner+"."+testDomain+p, nil)
+}
+
+func TestInternationalizedSites(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	// files committed on macOS are decomposed, browsers request them composed
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "xn--bcher-kva",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {
+				"index.html":                       "<p>bücher</p>",
+				norm.NFD.String("café.html"):       "<p>café</p>",
+				"日本語/index.html":                   "<p>日本語</p>",
+				"naïve.md":                         "---\naliases: [\"/alt-caf%C3%A9\"]\n---\n# Alias\n",
+				norm.NFD.String("über/index.html"): "<p>über</p>",
+			},
+		},
+	})
+
+	m := newTestHandler(t, srv)
+
+	tests := []struct {
+		host, path string
+		status     int
+		location   string
+		body       string
+	}{
+		{host: "bücher." + testDomain, path: "/", status: http.StatusOK, body: "bücher"},
+		{host: "BÜCHER." + testDomain + ".", path: "/", status: http.StatusOK, body: "bücher"},
+		{host: "xn--bcher-kva." + testDomain, path: "/", status: http.StatusOK, body: "bücher"},
+		{host: "bücher." + testDomain, path: "/caf%C3%A9.html", status: http.StatusOK, body: "café"},
+		{host: "bücher." + testDomain, path: "/caf%C3%A9", status: http.StatusOK, body: "café"},
+		{host: "bücher." + testDomain, path: "/%E6%97%A5%E6%9C%AC%E8%AA%9E/", status: http.StatusOK, body: "日本語"},
+		{host: "bücher." + testDomain, path: "/%C3%BCber/", status: http.StatusOK, body: "über"},
+		{
+			host: "bücher." + testDomain, path: "/alt-caf%C3%A9", status: http.StatusMovedPermanently,
+			location: "/na%C3%AFve.md",
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil)
+		r.Host = tt.host
+
+		resp := serve(m, r)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s%s: status %d, want %d", tt.host, tt.path, resp.StatusCode, tt.status)
+			continue
+		}
+
+		if loc := resp.Header.Get("Location"); loc != tt.location {
+			t.Errorf("%s%s: location %q, want %q", tt.host, tt.path, loc, tt.location)
+		}
+
+		if !strings.Contains(string(body), tt.body) {
+			t.Errorf("%s%s: body %q doesn't contain %q", tt.host, tt.path, body, tt.body)
+		}
+	}
+}
+
+func TestUnmarshalRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+		err   string
+	}{
+		{value: "on", want: true},
+		{value: "off", want: false},
+		{value: "of", err: `render_markdown must be on or off, not "of"`},
+		{value: "false", err: `render_markdown must be on or off, not "false"`},
+		{value: "OFF", err: `render_markdown must be on or off, not "OFF"`},
+	}
+
+	for _, tt := range tests {
+		var m Middleware
+
+		err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("gitea {\n render_markdown " + tt.value + "\n}"))
+
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("render_markdown %s: error %v, want %s", tt.value, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("render_markdown %s: %v", tt.value, err)
+		case m.RenderMarkdown == nil || *m.RenderMarkdown != tt.want:
+			t.Errorf("render_markdown %s: %v, want %v", tt.value, m.RenderMarkdown, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {"index.html": "<p>index</p>", "page.md": "# Page\n"},
+		},
+	})
+
+	lastModified := srv.Modified.Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		options      []gitea.ClientOption
+		path         string
+		cacheControl string
+	}{
+		{name: "file", path: "/index.html", cacheControl: "private, max-age=300"},
+		{name: "rendered page", path: "/page.md", cacheControl: "private, max-age=300"},
+		{name: "cached file", options: []gitea.ClientOption{gitea.SetCache(gitea.NewMemoryCache(1<<20), time.Hour)}, path: "/index.html"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestHandler(t, srv, tt.options...)
+
+			resp := serve(m, siteRequest("user", tt.path))
+			resp.Body.Close()
+
+			etag := resp.Header.Get("ETag")
+			if resp.StatusCode != http.StatusOK || etag == "" {
+				t.Fatalf("status %d, etag %q", resp.StatusCode, etag)
+			}
+
+			r := siteRequest("user", tt.path)
+			r.Header.Set("If-None-Match", etag)
+
+			resp = serve(m, r)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNotModified {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusNotModified)
+			}
+
+			for k, want := range map[string]string{"ETag": etag, "Last-Modified": lastModified, "Cache-Control": tt.cacheControl} {
+				if got := resp.Header.Get(k); got != want {
+					t.Errorf("%s %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPagesRepoSubdirectories(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {
+				"docs/index.html":       "<p>docs</p>",
+				"docs/guide/intro.html": "<p>intro</p>",
+			},
+		},
+	})
+
+	m := newTestHandler(t, srv)
+
+	// docs isn't a repo, the paths are files of the gitea-pages repo
+	for p, want := range map[string]string{"/docs/": "docs", "/docs/guide/intro.html": "intro"} {
+		resp := serve(m, siteRequest("user", p))
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("%s: status %d, body %q, want %q", p, resp.StatusCode, body, want)
+		}
+	}
+}
+
+func TestCancelledRequest(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(giteatest.GitHubPagesRepo("user"))
+
+	// a token every 2s, requests that would wait over 5s are shed
+	m := newTestHandler(t, srv, gitea.SetUpstreamLimit(0.5, 1, 10, 5*time.Second))
+
+	// the visitor goes away after 100ms
+	cancelled := func() (int, time.Duration) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		start := time.Now()
+		resp := serve(m, siteRequest("user", "/about.html").WithContext(ctx))
+		resp.Body.Close()
+
+		return resp.StatusCode, time.Since(start)
+	}
+
+	// the requests to gitea waiting for their turn are cancelled with the
+	// request of the visitor
+	status, took := cancelled()
+	if took > time.Second {
+		t.Errorf("cancelled request took %s", took)
+	}
+
+	if status != 499 {
+		t.Errorf("cancelled request: status %d, want 499", status)
+	}
+
+	// and give back their turn, the next request waits instead of being shed
+	if status, _ := cancelled(); status != 499 {
+		t.Errorf("request after a cancelled one: status %d, want 499", status)
+	}
+}
+
+func TestCanonicalRef(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser("pages", "token")
+
+	for _, owner := range []string{"public", "private"} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:    owner,
+			Name:     "gitea-pages",
+			Private:  owner == "private",
+			Topics:   []string{"gitea-pages"},
+			Readers:  []string{"pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {"index.html": "<p>" + owner + "</p>"}},
+		})
+	}
+
+	// sha returns the commit of the site of owner
+	sha := func(owner string) string {
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/repos/"+owner+"/gitea-pages/branches/gitea-pages", nil)
+		r.Header.Set("Authorization", "token token")
+
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var branch struct {
+			Commit struct {
+				ID string `json:"id"`
+			} `json:"commit"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&branch); err != nil {
+			t.Fatal(err)
+		}
+
+		return branch.Commit.ID
+	}
+
+	public, private := sha("public"), sha("private")
+
+	m := newTestHandler(t, srv, gitea.SetCache(gitea.NewMemoryCache(1<<20), time.Hour))
+	m.ForbidPrivate = true
+
+	tests := []struct {
+		owner, ref string
+		status     int
+		location   string
+	}{
+		{owner: "public", ref: public[:7], status: http.StatusFound, location: "/?ref=" + public},
+		{owner: "public", ref: "HEAD", status: http.StatusFound, location: "/"},
+		{owner: "public", ref: "HEAD", status: http.StatusFound, location: "/"},
+		// the visitor doesn't learn the sha (or that the site exists)
+		{owner: "private", ref: private[:7], status: http.StatusNotFound},
+		{owner: "private", ref: "HEAD", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		resp := serve(m, siteRequest(tt.owner, "/?ref="+tt.ref))
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s ?ref=%s: status %d, want %d", tt.owner, tt.ref, resp.StatusCode, tt.status)
+		}
+
+		if loc := resp.Header.Get("Location"); loc != tt.location {
+			t.Errorf("%s ?ref=%s: location %q, want %q", tt.owner, tt.ref, loc, tt.location)
+		}
+	}
+
+	// symbolic refs are looked up once, with the branches
+	if n := srv.Requests("/api/v1/repos/public/gitea-pages/branches/HEAD"); n != 1 {
+		t.Errorf("HEAD looked up %d times, want 1", n)
+	}
+
+	if n := srv.Requests("/api/v1/repos/private/gitea-pages/git/commits/" + private[:7]); n != 0 {
+		t.Errorf("%s of the private site looked up %d times, want 0", private[:7], n)
+	}
+}
+
+func TestSiteMaxCacheSizeValidate(t *testing.T) {
+	tests := []struct {
+		cache string
+		err   string
+	}{
+		{cache: `{"backend":"memory"}`},
+		{cache: `{"backend":"disk","dir":"/var/cache/pages"}`},
+		{cache: `{"backend":"redis"}`, err: "site_max_cache_size needs a cache that can tell the size of a site, like memory or disk, not redis"},
+		{err: "site_max_cache_size needs a cache"},
+	}
+
+	for _, tt := range tests {
+		m := Middleware{SiteMaxCacheSize: 20 << 20}
+		if tt.cache != "" {
+			m.CacheRaw = json.RawMessage(tt.cache)
+		}
+
+		err := m.Validate()
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("cache %s: %v", tt.cache, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("cache %s: error %v, want %s", tt.cache, err, tt.err)
+		}
+	}
+}
+
+func TestStreamContentLength(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	large := strings.Repeat("x", 8<<10)
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "owner",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"large.bin": large, "index.html": "<p>index</p>"}},
+	})
+
+	m := newTestHandler(t, srv, gitea.SetStreamThreshold(4<<10))
+
+	for _, chunked := range []bool{false, true} {
+		srv.Chunked = chunked
+
+		resp := serve(m, siteRequest("owner", "/large.bin"))
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != large {
+			t.Errorf("chunked %v: status %d, %d bytes", chunked, resp.StatusCode, len(body))
+		}
+
+		if cl := resp.Header.Get("Content-Length"); cl != strconv.Itoa(len(large)) {
+			t.Errorf("chunked %v: Content-Length %q, want %d", chunked, cl, len(large))
+		}
+	}
+}
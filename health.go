@@ -0,0 +1,167 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// HealthChecks checks that the sites of the inventory serve their index, and
+// notifies the owners of sites that keep failing with an issue in the repo.
+type HealthChecks struct {
+	// Interval is the time between two checks of all sites, defaults to 1h.
+	Interval caddy.Duration `json:"interval,omitempty"`
+	// Failures is the number of checks in a row a site has to fail before
+	// its owner is notified, defaults to 3.
+	Failures int `json:"failures,omitempty"`
+	// NotifyInterval is the least time between two notifications about a
+	// site, defaults to 24h.
+	NotifyInterval caddy.Duration `json:"notify_interval,omitempty"`
+
+	// sites is the health of the sites checked, by owner/repo@ref; it's only
+	// used by run.
+	sites map[string]*siteHealth
+}
+
+// siteHealth is the health of a site over the last checks.
+type siteHealth struct {
+	failures int
+	notified time.Time
+}
+
+func (h *HealthChecks) provision() {
+	if h.Interval == 0 {
+		h.Interval = caddy.Duration(time.Hour)
+	}
+
+	if h.Failures == 0 {
+		h.Failures = 3
+	}
+
+	if h.NotifyInterval == 0 {
+		h.NotifyInterval = caddy.Duration(24 * time.Hour)
+	}
+
+	h.sites = make(map[string]*siteHealth)
+}
+
+// validate returns an error for negative options.
+func (h *HealthChecks) validate() error {
+	if h.Interval < 0 || h.Failures < 0 || h.NotifyInterval < 0 {
+		return errors.New("interval, failures and notify_interval must not be negative")
+	}
+
+	return nil
+}
+
+// run checks the sites of c every interval until ctx is done.
+func (h *HealthChecks) run(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Duration(h.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx, c, logger)
+		}
+	}
+}
+
+// check checks the sites of the inventory one after another, notifying the
+// owners of the sites that failed Failures times in a row (at most once per
+// NotifyInterval) and of those that recovered.
+func (h *HealthChecks) check(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	for _, s := range c.Sites() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		key := s.Owner + "/" + s.Repo + "@" + s.Ref
+		health, ok := h.sites[key]
+
+		err := c.CheckSiteHealth(s.Owner, s.Repo, s.Ref)
+
+		// the site can't be checked, e.g. gitea is unavailable
+		var failure *gitea.SiteFailure
+		if err != nil && !errors.Is(err, gitea.ErrSiteUnpublished) && !errors.As(err, &failure) {
+			logger.Debug("checking site health failed", zap.String("site", key), zap.Error(err))
+			continue
+		}
+
+		switch {
+		case errors.Is(err, gitea.ErrSiteUnpublished):
+			delete(h.sites, key)
+			continue
+		case err == nil:
+			if ok && !health.notified.IsZero() {
+				if err := c.NotifySiteHealth(s.Owner, s.Repo, s.Ref, nil); err != nil {
+					logger.Warn("notifying recovered site failed", zap.String("site", key), zap.Error(err))
+				}
+			}
+
+			delete(h.sites, key)
+
+			continue
+		}
+
+		if !ok {
+			health = &siteHealth{}
+			h.sites[key] = health
+		}
+
+		health.failures++
+
+		logger.Debug("site health check failed", zap.String("site", key), zap.Int("failures", health.failures),
+			zap.Error(err))
+
+		if health.failures < h.Failures || time.Since(health.notified) < time.Duration(h.NotifyInterval) {
+			continue
+		}
+
+		if nerr := c.NotifySiteHealth(s.Owner, s.Repo, s.Ref, err); nerr != nil {
+			logger.Warn("notifying failing site failed", zap.String("site", key), zap.Error(nerr))
+			continue
+		}
+
+		health.notified = time.Now()
+
+		logger.Info("notified owner of failing site", zap.String("site", key), zap.Error(err))
+	}
+}
+
+// UnmarshalCaddyfile unmarshals the health_checks block of a Caddyfile.
+//
+//	health_checks {
+//		interval 30m
+//		failures 3
+//		notify_interval 24h
+//	}
+func (h *HealthChecks) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "interval":
+			if err := parseDurationArg(d, &h.Interval); err != nil {
+				return err
+			}
+		case "failures":
+			if err := parseIntArg(d, &h.Failures); err != nil {
+				return err
+			}
+		case "notify_interval":
+			if err := parseDurationArg(d, &h.NotifyInterval); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unknown health_checks option %q", d.Val())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build integration
+
+package gitea
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+	"go.uber.org/zap"
+)
+
+// TestIntegration serves a site of a gitea in docker, from the cache until a
+// webhook of gitea purges it.
+func TestIntegration(t *testing.T) {
+	srv, err := giteatest.StartInstance("")
+	if err != nil {
+		t.Skip("no docker:", err)
+	}
+
+	defer srv.Close()
+
+	if err := srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"index.html": "<p>v1</p>"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := gitea.NewClient(srv.URL, srv.Token, "", "", gitea.SetCache(gitea.NewMemoryCache(1<<20), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := Middleware{
+		Client:            c,
+		Server:            srv.URL,
+		Domain:            testDomain,
+		WebhookSecret:     "integration",
+		MaintenanceBanner: defaultMaintenanceBanner,
+		logger:            zap.NewNop(),
+	}
+
+	get := func() string {
+		t.Helper()
+
+		resp := serve(m, siteRequest("user", "/"))
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return strconv.Itoa(resp.StatusCode) + " " + string(body)
+	}
+
+	if got := get(); got != "200 <p>v1</p>" {
+		t.Fatalf("site: %s", got)
+	}
+
+	// without webhook the cached page is served after a push
+	if err := srv.AddFile("user", "gitea-pages", "gitea-pages", "index.html", "<p>v2</p>"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(); got != "200 <p>v1</p>" {
+		t.Fatalf("cached site: %s", got)
+	}
+
+	// gitea reaches the webhook of the handler on the docker host
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := httptest.NewUnstartedServer(handler(m))
+	hook.Listener = l
+	hook.Start()
+
+	defer hook.Close()
+
+	target := "http://host.docker.internal:" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port) + webhookPath
+	if err := srv.AddWebhook("user", "gitea-pages", target, m.WebhookSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.AddFile("user", "gitea-pages", "gitea-pages", "index.html", "<p>v3</p>"); err != nil {
+		t.Fatal(err)
+	}
+
+	// webhooks are delivered asynchronously
+	deadline := time.Now().Add(30 * time.Second)
+
+	for {
+		got := get()
+		if got == "200 <p>v3</p>" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("site after the webhook: %s", got)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,87 @@
+package gitea
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// defaultOpenerPolicy keeps pages of other sites out of the browsing context
+// group of a site.
+const defaultOpenerPolicy = "same-origin"
+
+// Isolation are the headers that isolate the sites of different owners
+// sharing one wildcard domain from each other.
+type Isolation struct {
+	// OpenerPolicy is the Cross-Origin-Opener-Policy, defaults to same-origin.
+	OpenerPolicy string `json:"opener_policy,omitempty"`
+	// ResourcePolicy is the Cross-Origin-Resource-Policy, not sent by default.
+	ResourcePolicy string `json:"resource_policy,omitempty"`
+	// AllowDocumentDomain allows pages to relax document.domain, which is
+	// prevented by sending Origin-Agent-Cluster by default.
+	AllowDocumentDomain bool `json:"allow_document_domain,omitempty"`
+	// Sandbox sandboxes every site with a "Content-Security-Policy: sandbox"
+	// with these allowed tokens (e.g. allow-scripts).
+	Sandbox []string `json:"sandbox,omitempty"`
+	// SandboxEnabled sends the sandbox policy even without allowed tokens.
+	SandboxEnabled bool `json:"sandbox_enabled,omitempty"`
+}
+
+// setHeaders sets the isolation headers on w.
+func (i *Isolation) setHeaders(w http.ResponseWriter) {
+	h := w.Header()
+
+	opener := i.OpenerPolicy
+	if opener == "" {
+		opener = defaultOpenerPolicy
+	}
+
+	if opener != "off" {
+		h.Set("Cross-Origin-Opener-Policy", opener)
+	}
+
+	if i.ResourcePolicy != "" {
+		h.Set("Cross-Origin-Resource-Policy", i.ResourcePolicy)
+	}
+
+	if !i.AllowDocumentDomain {
+		h.Set("Origin-Agent-Cluster", "?1")
+	}
+
+	if i.SandboxEnabled || len(i.Sandbox) > 0 {
+		h.Add("Content-Security-Policy", strings.TrimSpace("sandbox "+strings.Join(i.Sandbox, " ")))
+	}
+}
+
+// UnmarshalCaddyfile unmarshals the isolation block of a Caddyfile.
+//
+//	isolation {
+//		opener_policy same-origin-allow-popups
+//		resource_policy same-site
+//		allow_document_domain
+//		sandbox allow-scripts allow-forms
+//	}
+func (i *Isolation) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "opener_policy":
+			if !d.Args(&i.OpenerPolicy) {
+				return d.ArgErr()
+			}
+		case "resource_policy":
+			if !d.Args(&i.ResourcePolicy) {
+				return d.ArgErr()
+			}
+		case "allow_document_domain":
+			i.AllowDocumentDomain = true
+		case "sandbox":
+			i.SandboxEnabled = true
+			i.Sandbox = append(i.Sandbox, d.RemainingArgs()...)
+		default:
+			return d.Errf("unknown isolation option %q", d.Val())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package gitea
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// legacyRequests counts the requests of the legacy path mode, where no domain
+// is configured and sites are only served on the path of their owner host.
+var legacyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "caddy",
+	Subsystem: "gitea",
+	Name:      "legacy_path_requests_total",
+	Help:      "Counter of requests in the legacy path mode (without domain) by action (served, redirected).",
+}, []string{"action"})
+
+// legacyRequest counts a request of the legacy path mode for the site fp at
+// ref and marks it as deprecated if configured. It returns the url of the
+// site in the subdomain form below LegacyRedirect the request is redirected
+// to, or "" if it's served as is.
+func (m Middleware) legacyRequest(w http.ResponseWriter, r *http.Request, fp, ref string) string {
+	if m.LegacyDeprecationHeader {
+		w.Header().Set("Deprecation", "true")
+	}
+
+	if m.LegacyRedirect != "" {
+		if target := m.legacyTarget(r, fp, ref); target != "" {
+			legacyRequests.WithLabelValues("redirected").Inc()
+			return target
+		}
+	}
+
+	legacyRequests.WithLabelValues("served").Inc()
+
+	return ""
+}
+
+// legacyTarget returns the url of the site fp at ref in the subdomain form
+// below LegacyRedirect, or "" if it doesn't resolve to a site.
+func (m Middleware) legacyTarget(r *http.Request, fp, ref string) string {
+	info, err := m.Client.RepoInfo(r.Context(), fp, ref)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(info.Owner) + "." + m.LegacyRedirect
+	p := r.URL.Path
+
+	// the first segment is the repo, unless the file is in the gitea-pages repo
+	if info.Repo != m.Client.PagesRepo(info.Owner) {
+		host = strings.ToLower(info.Repo) + "." + host
+
+		p = strings.TrimPrefix(p, "/")
+		if _, rest, ok := strings.Cut(p, "/"); ok {
+			p = "/" + rest
+		} else {
+			p = "/"
+		}
+	}
+
+	return gitea.PublicURL(host, p, r.URL.RawQuery)
+}
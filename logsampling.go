@@ -0,0 +1,111 @@
+package gitea
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// logSkipVars are the request variables that keep a request out of the access
+// logs of caddy, the name changed in caddy 2.8.
+var logSkipVars = []string{"skip_log", "log_skip"}
+
+// LogSampling is the percentage of the responses of a status class ("2xx" or
+// "3xx") that get in the access logs, so busy pages hosts don't drown their
+// logs in successful requests. Classes not listed are all logged, as are
+// errors (4xx and 5xx) always.
+type LogSampling map[string]float64
+
+// validate returns an error for a class that can't be sampled or a
+// percentage out of range.
+func (ls LogSampling) validate() error {
+	for class, percent := range ls {
+		if class != "2xx" && class != "3xx" {
+			return fmt.Errorf("only 2xx and 3xx responses can be sampled, not %q", class)
+		}
+
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("percentage of %s must be between 0 and 100", class)
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals the log_sampling block of a Caddyfile.
+//
+//	log_sampling {
+//		2xx 1%
+//		3xx 10%
+//	}
+func (ls LogSampling) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		class := d.Val()
+		if class != "2xx" && class != "3xx" {
+			return d.Errf("only 2xx and 3xx responses can be sampled, not %q", class)
+		}
+
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+
+		percent := 0.0
+		if err := parsePercent(d, &percent); err != nil {
+			return err
+		}
+
+		ls[class] = percent
+	}
+
+	return nil
+}
+
+// skip reports if the response with status is kept out of the access logs.
+func (ls LogSampling) skip(status int) bool {
+	percent, ok := ls[fmt.Sprintf("%dxx", status/100)]
+
+	return ok && rand.Float64()*100 >= percent
+}
+
+// statusRecorder is a http.ResponseWriter that records the status of the
+// response.
+type statusRecorder struct {
+	*caddyhttp.ResponseWriterWrapper
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	// informational responses precede the response
+	if sr.status == 0 && status >= http.StatusOK {
+		sr.status = status
+	}
+
+	sr.ResponseWriterWrapper.WriteHeader(status)
+}
+
+// sampleLog serves r with serve and keeps its response out of the access logs
+// if it's sampled out. Errors are left to the error handling of caddy and
+// always logged.
+func (m Middleware) sampleLog(w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter) error) error {
+	sr := &statusRecorder{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}}
+
+	if err := serve(sr); err != nil {
+		return err
+	}
+
+	// responses written without a status are 200 OK
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+
+	if m.LogSampling.skip(sr.status) {
+		for _, v := range logSkipVars {
+			caddyhttp.SetVar(r.Context(), v, true)
+		}
+	}
+
+	return nil
+}
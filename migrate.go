@@ -0,0 +1,388 @@
+package gitea
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "gitea-migrate",
+		Func:  cmdMigrate,
+		Usage: "--config <path> [--adapter <caddyfile|json>] [--output <path>]",
+		Short: "Converts a config of 42wim/caddy-gitea to this plugin",
+		Long: `
+Converts the gitea handlers of a config written for 42wim/caddy-gitea (a
+Caddyfile, or JSON with --adapter json or a .json file) so they load with
+this plugin and serve the sites like before, and reports every change.
+
+42wim/caddy-gitea ignored unknown options, options without argument and
+extra arguments, and applied the last of duplicate options; this plugin
+refuses them. They're commented out or dropped the way 42wim/caddy-gitea
+applied them. Handlers without domain serve the legacy path mode, which is
+kept; the report points at the options that move its sites to subdomains.
+
+The converted config is written to --output (stdout if not set), the report
+to stderr. Load it with caddy reload, the sites are served throughout.`,
+		Flags: func() *flag.FlagSet {
+			fs := flag.NewFlagSet("gitea-migrate", flag.ExitOnError)
+			fs.String("config", "", "The config file to convert")
+			fs.String("adapter", "", "The format of the config: caddyfile or json")
+			fs.String("output", "", "The file the converted config is written to")
+
+			return fs
+		}(),
+	})
+}
+
+// upstreamOptions are the options of the gitea directive of 42wim/caddy-gitea,
+// which have the same name and JSON field in this plugin.
+var upstreamOptions = map[string]bool{
+	"server":               true,
+	"token":                true,
+	"gitea_pages":          true,
+	"gitea_pages_allowall": true,
+	"domain":               true,
+}
+
+// cmdMigrate converts a config of 42wim/caddy-gitea.
+func cmdMigrate(fl caddycmd.Flags) (int, error) {
+	path := fl.String("config")
+	if path == "" {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("--config is required")
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	adapter := fl.String("adapter")
+	if adapter == "" {
+		adapter = "caddyfile"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			adapter = "json"
+		}
+	}
+
+	var (
+		out    []byte
+		report []string
+	)
+
+	switch adapter {
+	case "caddyfile":
+		out, report = migrateCaddyfile(body)
+
+		// the converted Caddyfile must load, or the reload would fail
+		if _, _, err := caddyconfig.GetAdapter("caddyfile").Adapt(out, map[string]any{"filename": path}); err != nil {
+			report = append(report, fmt.Sprintf("the converted Caddyfile doesn't load: %v", err))
+		}
+	case "json":
+		if out, report, err = migrateJSON(body); err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("decoding %s: %w", path, err)
+		}
+	default:
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("unknown adapter %q, known are caddyfile and json", adapter)
+	}
+
+	if output := fl.String("output"); output != "" {
+		err = os.WriteFile(output, out, 0o644)
+	} else {
+		_, err = os.Stdout.Write(out)
+	}
+
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	fmt.Fprintf(os.Stderr, "migration report of %s:\n", path)
+
+	for _, line := range report {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+
+	return 0, nil
+}
+
+// caddyfileFields returns the tokens of a line of a Caddyfile, without its
+// comment.
+func caddyfileFields(line string) []string {
+	fields := strings.Fields(line)
+
+	for i, f := range fields {
+		if strings.HasPrefix(f, "#") {
+			return fields[:i]
+		}
+	}
+
+	return fields
+}
+
+// blockEnd returns the index of the line closing the block opened on line
+// start, or -1 if it isn't closed.
+func blockEnd(lines []string, start int) int {
+	depth := 0
+
+	for i := start; i < len(lines); i++ {
+		fields := caddyfileFields(lines[i])
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "}" {
+			depth--
+		}
+
+		if fields[len(fields)-1] == "{" {
+			depth++
+		}
+
+		if depth == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// migrateCaddyfile converts the gitea blocks of a Caddyfile and returns it
+// with the report of the changes. The rest of the Caddyfile, its comments and
+// formatting are kept.
+func migrateCaddyfile(body []byte) ([]byte, []string) {
+	lines := strings.Split(string(body), "\n")
+
+	var report []string
+
+	blocks := 0
+
+	for i := 0; i < len(lines); i++ {
+		fields := caddyfileFields(lines[i])
+		if len(fields) == 0 || fields[0] != "gitea" {
+			continue
+		}
+
+		if fields[len(fields)-1] != "{" {
+			report = append(report, fmt.Sprintf("line %d: gitea without block, it has no server", i+1))
+			continue
+		}
+
+		end := blockEnd(lines, i)
+		if end < 0 {
+			report = append(report, fmt.Sprintf("line %d: the gitea block isn't closed", i+1))
+			break
+		}
+
+		report = append(report, migrateBlock(lines, i, end)...)
+		blocks++
+		i = end
+	}
+
+	report = append(report, fmt.Sprintf("%d gitea blocks converted", blocks))
+
+	return []byte(strings.Join(lines, "\n")), report
+}
+
+// migrateBlock converts the options of the gitea block from line start to
+// line end in place and returns the report of the changes.
+func migrateBlock(lines []string, start, end int) []string {
+	var report []string
+
+	notef := func(i int, format string, args ...any) {
+		report = append(report, fmt.Sprintf("line %d: ", i+1)+fmt.Sprintf(format, args...))
+	}
+
+	// 42wim/caddy-gitea applied the last of duplicate options
+	last := make(map[string]int)
+
+	for i := start + 1; i < end; i++ {
+		fields := caddyfileFields(lines[i])
+		if len(fields) == 0 {
+			continue
+		}
+
+		if upstreamOptions[fields[0]] && len(fields) > 1 {
+			last[fields[0]] = i
+		}
+
+		if fields[len(fields)-1] == "{" {
+			i = blockEnd(lines, i)
+		}
+	}
+
+	for i := start + 1; i < end; i++ {
+		fields := caddyfileFields(lines[i])
+		if len(fields) == 0 {
+			continue
+		}
+
+		option, args := fields[0], fields[1:]
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+
+		switch {
+		case fields[len(fields)-1] == "{":
+			nestedEnd := blockEnd(lines, i)
+			if nestedEnd < 0 || nestedEnd > end {
+				nestedEnd = end - 1
+			}
+
+			for j := i; j <= nestedEnd; j++ {
+				lines[j] = commentOut(lines[j])
+			}
+
+			notef(i, "the %s block was ignored by 42wim/caddy-gitea, commented out", option)
+
+			i = nestedEnd
+		case !upstreamOptions[option]:
+			lines[i] = commentOut(lines[i])
+
+			switch suggested := suggestOption(option); suggested {
+			case option:
+				notef(i, "%s was ignored by 42wim/caddy-gitea, commented out so the sites are served like before; "+
+					"enable it once migrated", option)
+			case "":
+				notef(i, "unknown option %s was ignored by 42wim/caddy-gitea, commented out", option)
+			default:
+				notef(i, "unknown option %s (%s?) was ignored by 42wim/caddy-gitea, commented out", option, suggested)
+			}
+		case len(args) == 0:
+			lines[i] = commentOut(lines[i])
+			notef(i, "%s without argument was ignored by 42wim/caddy-gitea, commented out", option)
+		case last[option] != i:
+			lines[i] = commentOut(lines[i])
+			notef(i, "duplicate %s, commented out, the one on line %d applies", option, last[option]+1)
+		default:
+			arg := args[0]
+
+			if option == "domain" && strings.HasPrefix(arg, ".") {
+				arg = strings.TrimLeft(arg, ".")
+				notef(i, "domain without leading dot: %s", arg)
+			}
+
+			if len(args) > 1 {
+				notef(i, "the extra arguments of %s were ignored by 42wim/caddy-gitea, dropped", option)
+			}
+
+			if arg != args[0] || len(args) > 1 {
+				lines[i] = indent + option + " " + arg + lineComment(lines[i])
+			}
+		}
+	}
+
+	if _, ok := last["server"]; !ok {
+		notef(start, "the gitea block has no server")
+	}
+
+	if _, ok := last["domain"]; !ok {
+		notef(start, "without domain the sites are served in the legacy path mode (org.host/repo/file.html) like "+
+			"before; legacy_deprecation_header and legacy_redirect move them to subdomains")
+	}
+
+	return report
+}
+
+// lineComment returns the comment at the end of line with the space before
+// it, if any.
+func lineComment(line string) string {
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return " " + line[i:]
+		}
+	}
+
+	return ""
+}
+
+// commentOut returns line commented out, keeping its indentation.
+func commentOut(line string) string {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return line
+	}
+
+	return line[:len(line)-len(trimmed)] + "# " + trimmed
+}
+
+// migrateJSON converts the gitea handlers of a JSON config and returns it
+// with the report of the changes. The fields of the handlers of
+// 42wim/caddy-gitea are those of this plugin, caddy refused other fields.
+func migrateJSON(body []byte) ([]byte, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var config any
+	if err := dec.Decode(&config); err != nil {
+		return nil, nil, err
+	}
+
+	var report []string
+
+	handlers := 0
+
+	walkJSON(config, "", func(path string, h map[string]any) {
+		handlers++
+		path = strings.TrimPrefix(path, ".")
+
+		domain, _ := h["domain"].(string)
+
+		if strings.HasPrefix(domain, ".") {
+			h["domain"] = strings.TrimLeft(domain, ".")
+			report = append(report, fmt.Sprintf("%s: domain without leading dot: %s", path, h["domain"]))
+		}
+
+		if _, ok := h["server"]; !ok {
+			report = append(report, fmt.Sprintf("%s: the gitea handler has no server", path))
+		}
+
+		if domain == "" {
+			report = append(report, fmt.Sprintf("%s: without domain the sites are served in the legacy path mode "+
+				"(org.host/repo/file.html) like before; legacy_deprecation_header and legacy_redirect move them to "+
+				"subdomains", path))
+		}
+	})
+
+	report = append(report, fmt.Sprintf("%d gitea handlers converted", handlers))
+
+	out, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(out, '\n'), report, nil
+}
+
+// walkJSON calls f with the gitea handlers of v and their path, in order.
+func walkJSON(v any, path string, f func(path string, h map[string]any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		if v["handler"] == "gitea" {
+			f(path, v)
+			return
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			walkJSON(v[k], path+"."+k, f)
+		}
+	case []any:
+		for i, e := range v {
+			walkJSON(e, fmt.Sprintf("%s[%d]", path, i), f)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package gitea
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+func TestMigrateCaddyfile(t *testing.T) {
+	in := `{
+	order gitea before file_server
+}
+
+:80 {
+	gitea {
+		server https://old.example.com
+		server https://gitea.example.com # the new one
+		token
+		domain .pages.example.com extra
+		gitea_pages_allowall true
+		nonsense value
+		headers {
+			X-Test 1
+		}
+	}
+}
+
+legacy.example.com {
+	gitea {
+		server https://gitea.example.com
+	}
+}
+`
+
+	want := `{
+	order gitea before file_server
+}
+
+:80 {
+	gitea {
+		# server https://old.example.com
+		server https://gitea.example.com # the new one
+		# token
+		domain pages.example.com
+		gitea_pages_allowall true
+		# nonsense value
+		# headers {
+			# X-Test 1
+		# }
+	}
+}
+
+legacy.example.com {
+	gitea {
+		server https://gitea.example.com
+	}
+}
+`
+
+	out, report := migrateCaddyfile([]byte(in))
+	if string(out) != want {
+		t.Errorf("converted Caddyfile:\n%s\nwant:\n%s", out, want)
+	}
+
+	for _, line := range []string{
+		"line 7: duplicate server, commented out, the one on line 8 applies",
+		"line 9: token without argument was ignored by 42wim/caddy-gitea, commented out",
+		"line 10: domain without leading dot: pages.example.com",
+		"line 10: the extra arguments of domain were ignored by 42wim/caddy-gitea, dropped",
+		"line 12: unknown option nonsense was ignored by 42wim/caddy-gitea, commented out",
+		"line 13: the headers block was ignored by 42wim/caddy-gitea, commented out",
+		"line 20: without domain the sites are served in the legacy path mode",
+		"2 gitea blocks converted",
+	} {
+		if !containsPrefix(report, line) {
+			t.Errorf("report without %q:\n%s", line, strings.Join(report, "\n"))
+		}
+	}
+
+	if containsPrefix(report, "line 6: without domain") {
+		t.Errorf("legacy path mode reported for the block with domain:\n%s", strings.Join(report, "\n"))
+	}
+
+	if _, _, err := caddyconfig.GetAdapter("caddyfile").Adapt(out, nil); err != nil {
+		t.Errorf("converted Caddyfile doesn't load: %v", err)
+	}
+
+	// the original Caddyfile is refused by this plugin
+	if _, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(in), nil); err == nil {
+		t.Error("original Caddyfile loaded")
+	}
+
+	// unclosed blocks and blocks without server are reported
+	_, report = migrateCaddyfile([]byte("gitea {\n\tdomain pages.example.com\n"))
+	if !containsPrefix(report, "line 1: the gitea block isn't closed") {
+		t.Errorf("unclosed block reported as:\n%s", strings.Join(report, "\n"))
+	}
+
+	_, report = migrateCaddyfile([]byte("gitea {\n\tdomain pages.example.com\n}\n"))
+	if !containsPrefix(report, "line 1: the gitea block has no server") {
+		t.Errorf("block without server reported as:\n%s", strings.Join(report, "\n"))
+	}
+}
+
+func TestMigrateJSON(t *testing.T) {
+	in := `{"apps": {"http": {"servers": {"srv0": {"routes": [{"handle": [
+		{"handler": "gitea", "server": "https://gitea.example.com", "domain": ".pages.example.com"},
+		{"handler": "gitea", "token": "secret", "max_age": 60}
+	]}]}}}}}`
+
+	out, report, err := migrateJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]struct {
+					Routes []struct {
+						Handle []map[string]any `json:"handle"`
+					} `json:"routes"`
+				} `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+
+	if err := json.Unmarshal(out, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	handle := config.Apps.HTTP.Servers["srv0"].Routes[0].Handle
+	if handle[0]["domain"] != "pages.example.com" {
+		t.Errorf("domain %v, want pages.example.com", handle[0]["domain"])
+	}
+
+	// the other fields are kept as is
+	if handle[1]["token"] != "secret" || handle[1]["max_age"] != float64(60) {
+		t.Errorf("handler converted to %v", handle[1])
+	}
+
+	const path = "apps.http.servers.srv0.routes[0].handle"
+
+	for _, line := range []string{
+		path + "[0]: domain without leading dot: pages.example.com",
+		path + "[1]: the gitea handler has no server",
+		path + "[1]: without domain the sites are served in the legacy path mode",
+		"2 gitea handlers converted",
+	} {
+		if !containsPrefix(report, line) {
+			t.Errorf("report without %q:\n%s", line, strings.Join(report, "\n"))
+		}
+	}
+
+	if _, _, err := migrateJSON([]byte("{")); err == nil {
+		t.Error("invalid JSON converted")
+	}
+}
+
+// containsPrefix reports whether a line of report starts with prefix.
+func containsPrefix(report []string, prefix string) bool {
+	for _, line := range report {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// servePage serves the page of the site fp at ref for the visitor v as
+// JSON, for apps using a docs repo as headless CMS. urlPath is the path of
+// the request.
+func (m Middleware) servePage(w http.ResponseWriter, r *http.Request, fp, ref, urlPath string, v *gitea.Visitor) error {
+	page, err := m.Client.Page(r.Context(), fp, ref, v)
+
+	// a moved page is asked for as JSON again
+	var redirect *gitea.RedirectError
+	if errors.As(err, &redirect) {
+		to := redirectTarget(urlPath, redirect)
+		if strings.HasPrefix(to, "/") && !strings.Contains(to, "?") {
+			to += "?format=json"
+		}
+
+		http.Redirect(w, r, to, redirect.Status)
+
+		return nil
+	}
+
+	// embargoed pages aren't available as JSON either
+	var embargo *gitea.EmbargoError
+	if errors.As(err, &embargo) {
+		w.Header().Set("Cache-Control", "no-store")
+		return caddyhttp.Error(http.StatusForbidden, err)
+	}
+
+	if err != nil {
+		return m.httpError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// the body is html, keep it readable
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(page)
+}
@@ -0,0 +1,159 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(PagesAPI{})
+	httpcaddyfile.RegisterHandlerDirective("gitea_pages_api", parsePagesAPICaddyfile)
+}
+
+// PagesAPI serves the endpoints of the gitea handlers that aren't for
+// visitors, on a listener of its own (e.g. only reachable internally): the
+// webhook and the endpoints of the admin API (/gitea/purge, /gitea/sites,
+// ...) with the same paths and parameters. Other requests are passed on.
+type PagesAPI struct {
+	// Pages is the name of the gitea handler the endpoints are for, all
+	// gitea handlers of the config if not set.
+	Pages string `json:"pages,omitempty"`
+	// WebhookSecret is the secret of the gitea webhooks posted to
+	// /.well-known/gitea-pages-hook, the webhook is disabled without it.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	app    *App
+	admin  adminAPI
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (PagesAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea_pages_api",
+		New: func() caddy.Module { return new(PagesAPI) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (h *PagesAPI) Provision(ctx caddy.Context) error {
+	h.logger = ctx.Logger()
+
+	app, err := ctx.App("gitea_pages")
+	if err != nil {
+		return fmt.Errorf("getting gitea_pages app: %w", err)
+	}
+
+	h.app = app.(*App)
+	h.admin = adminAPI{each: h.each}
+
+	return nil
+}
+
+// each calls fn for the clients of the handlers the endpoints are for.
+func (h PagesAPI) each(fn func(*gitea.Client)) {
+	h.app.each(h.Pages, fn)
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (h PagesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	webhookRequest := h.WebhookSecret != "" && r.URL.Path == webhookPath
+
+	var handler caddy.AdminHandler
+
+	for _, route := range h.admin.Routes() {
+		if r.URL.Path == route.Pattern {
+			handler = route.Handler
+		}
+	}
+
+	if !webhookRequest && handler == nil {
+		return next.ServeHTTP(w, r)
+	}
+
+	// the gitea handlers of other servers may not be provisioned yet
+	if h.app.each(h.Pages, func(*gitea.Client) {}) == 0 {
+		err := errors.New("no gitea handler")
+		if h.Pages != "" {
+			err = fmt.Errorf("no gitea handler named %q", h.Pages)
+		}
+
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+
+	if webhookRequest {
+		return webhook{secret: h.WebhookSecret, each: h.each, logger: h.logger}.serve(w, r)
+	}
+
+	err := handler.ServeHTTP(w, r)
+
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) {
+		return caddyhttp.Error(apiErr.HTTPStatus, apiErr.Err)
+	}
+
+	return err
+}
+
+func parsePagesAPICaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var api PagesAPI
+	err := api.UnmarshalCaddyfile(h.Dispenser)
+
+	return api, err
+}
+
+// UnmarshalCaddyfile unmarshals the gitea_pages_api directive.
+//
+//	gitea_pages_api {
+//		pages <name>
+//		webhook_secret <secret>
+//	}
+func (h *PagesAPI) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		seen := make(map[string]bool)
+
+		for n := d.Nesting(); d.NextBlock(n); {
+			if seen[d.Val()] {
+				return d.Errf("duplicate gitea_pages_api option %q", d.Val())
+			}
+
+			seen[d.Val()] = true
+
+			var dst *string
+
+			switch d.Val() {
+			case "pages":
+				dst = &h.Pages
+			case "webhook_secret":
+				dst = &h.WebhookSecret
+			default:
+				return d.Errf("unknown gitea_pages_api option %q", d.Val())
+			}
+
+			if !d.Args(dst) {
+				return d.ArgErr()
+			}
+
+			if d.NextArg() {
+				return d.Errf("unexpected argument %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*PagesAPI)(nil)
+	_ caddyhttp.MiddlewareHandler = (*PagesAPI)(nil)
+	_ caddyfile.Unmarshaler       = (*PagesAPI)(nil)
+)
@@ -0,0 +1,56 @@
+package gitea
+
+import (
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Peers are the nodes of a (geo-distributed) pages cluster sharing the
+// files fetched from gitea: every repo has a home node fetching its files,
+// the other nodes fetch them from it.
+type Peers struct {
+	// Self is the base url of this node, as listed in Nodes.
+	Self string `json:"self,omitempty"`
+	// Nodes are the base urls of all nodes of the cluster, including this one.
+	Nodes []string `json:"nodes,omitempty"`
+	// Secret authenticates the nodes to each other.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (p *Peers) option() gitea.ClientOption {
+	return gitea.SetPeers(p.Self, p.Nodes, p.Secret)
+}
+
+// UnmarshalCaddyfile unmarshals the peers block of a Caddyfile.
+//
+//	peers {
+//		self https://pages-1.internal
+//		peer https://pages-1.internal https://pages-2.internal
+//		peer https://pages-3.internal
+//		secret <secret>
+//	}
+func (p *Peers) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "self":
+			if !d.Args(&p.Self) {
+				return d.ArgErr()
+			}
+		case "peer":
+			nodes := d.RemainingArgs()
+			if len(nodes) == 0 {
+				return d.ArgErr()
+			}
+
+			p.Nodes = append(p.Nodes, nodes...)
+		case "secret":
+			if !d.Args(&p.Secret) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown peers option %q", d.Val())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package gitea
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// aliasIndex maps the old paths of pages (the aliases in their front matter)
+// to the url paths of the pages, per tree.
+type aliasIndex struct {
+	mu      sync.Mutex
+	preview bool
+	paths   map[string]string
+}
+
+// aliasRedirect returns a *RedirectError to the page that lists the path of
+// the site s as one of its aliases (Hugo's `aliases: [/old-url/]`), if any.
+// The aliases are read from the front matter of the markdown and html pages
+// the first time a path doesn't exist in a tree, drafts only count in previews.
+func (c *Client) aliasRedirect(s *site) error {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil
+	}
+
+	t.aliases.mu.Lock()
+	defer t.aliases.mu.Unlock()
+
+	// previews have the aliases of drafts, the same tree may be served without
+	if t.aliases.paths == nil || t.aliases.preview != s.preview {
+		t.aliases.paths, t.aliases.preview = c.readAliases(s, t), s.preview
+	}
+
+	to, ok := t.aliases.paths[strings.Trim(s.filepath, "/")]
+	if !ok {
+		return nil
+	}
+
+	return &RedirectError{Path: "/" + s.filepath, To: to, Status: http.StatusMovedPermanently}
+}
+
+// readAliases reads the aliases of the pages of the site s in the tree t.
+func (c *Client) readAliases(s *site, t *siteTree) map[string]string {
+	paths := make(map[string]string)
+
+	c.readPages(s, t, "", func(name string, meta map[string]any, _ []byte) {
+		for _, alias := range frontMatterStrings(meta["aliases"]) {
+			// aliases may be written escaped, request paths aren't
+			if alias = strings.Trim(unescapePath(alias), "/"); alias != "" {
+				paths[alias] = pageURLPath(name)
+			}
+		}
+	})
+
+	return paths
+}
+
+// pageURLPath returns the pretty url path of the page name, escaped.
+func pageURLPath(name string) string {
+	return escapePath(pagePath(name))
+}
+
+// pagePath returns the pretty url path of the page name, unescaped.
+func pagePath(name string) string {
+	switch {
+	case name == "index.html":
+		name = ""
+	case strings.HasSuffix(name, "/index.html"):
+		name = strings.TrimSuffix(name, "index.html")
+	case strings.HasSuffix(name, ".html"):
+		name = strings.TrimSuffix(name, ".html")
+	}
+
+	return "/" + name
+}
@@ -0,0 +1,316 @@
+package gitea
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	gclient "code.gitea.io/sdk/gitea"
+	"go.uber.org/zap"
+)
+
+// lfsPointerPrefix starts the pointer files that stand in for LFS objects in
+// archives, their content is fetched from the media api.
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// errArchiveTooLarge is returned for archives larger than the archive limit.
+var errArchiveTooLarge = errors.New("archive too large")
+
+// SetArchives serves the files of sites from an in-memory snapshot of their
+// repo archive, downloaded once per version of the site, instead of fetching
+// every file from gitea. Sites whose unpacked archive is larger than maxSize
+// are served file by file.
+func SetArchives(maxSize int64) ClientOption {
+	return func(c *Client) error {
+		c.archives = &archiveStore{
+			maxSize: maxSize,
+			sites:   make(map[string]*archive),
+		}
+
+		return nil
+	}
+}
+
+// archiveStore holds the unpacked archives of sites.
+type archiveStore struct {
+	maxSize int64
+
+	mu    sync.Mutex
+	sites map[string]*archive
+	order []string
+}
+
+// archive is the unpacked archive of a version (tree sha) of a site. It has
+// no files while it's downloaded or if it couldn't be. Archives fetched with
+// git keep the objects of the version, to fetch the next one against.
+type archive struct {
+	sha    string
+	files  map[string]*rawFile
+	size   int64
+	mirror *gitMirror
+}
+
+// get returns the archive of the site key at the version sha, nil if it
+// isn't there yet; load is called to download it once per version, with the
+// archive of the previous version if it has a git mirror.
+func (as *archiveStore) get(key, sha string, load func(prev *gitMirror)) *archive {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	a, ok := as.sites[key]
+	if ok && a.sha == sha {
+		if a.files == nil {
+			return nil
+		}
+
+		return a
+	}
+
+	// the files of an older version are outdated, they're served file by
+	// file until the new version is there
+	var prev *gitMirror
+
+	if ok {
+		prev = a.mirror
+	} else {
+		as.order = append(as.order, key)
+	}
+
+	// the mirror is kept while the new version is fetched against it
+	as.sites[key] = &archive{sha: sha, mirror: prev}
+
+	go load(prev)
+
+	return nil
+}
+
+func (as *archiveStore) put(key string, a *archive) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	// a newer version may have been requested meanwhile
+	if cur, ok := as.sites[key]; ok && cur.sha == a.sha {
+		as.sites[key] = a
+	}
+}
+
+// Shed drops the archives loaded first until fraction of their size is freed
+// and returns the number of bytes freed.
+func (as *archiveStore) Shed(fraction float64) int64 {
+	if as == nil {
+		return 0
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	var total int64
+	for _, a := range as.sites {
+		total += a.size
+	}
+
+	var freed int64
+
+	for len(as.order) > 0 && float64(freed) < fraction*float64(total) {
+		key := as.order[0]
+		as.order = as.order[1:]
+
+		if a, ok := as.sites[key]; ok {
+			freed += a.size
+			delete(as.sites, key)
+		}
+	}
+
+	return freed
+}
+
+// archived returns filepath of owner/repo at ref from the archive of the
+// site, if its tree is known and the archive of that version is loaded. The
+// archive is downloaded in the background the first time it's missing.
+func (c *Client) archived(owner, repo, filepath, ref string) (*rawFile, bool) {
+	if c.archives == nil || !c.flags.enabled(FeatureArchives, owner, repo) {
+		return nil, false
+	}
+
+	key := owner + "/" + repo + "@" + ref
+
+	// other files of the repo (like the config) don't need its tree
+	t := c.trees.get(key)
+	if t == nil || t.sha == "" {
+		return nil, false
+	}
+
+	a := c.archives.get(key, t.sha, func(prev *gitMirror) {
+		c.loadArchive(key, owner, repo, t, prev)
+	})
+	if a == nil {
+		return nil, false
+	}
+
+	f, ok := a.files[filepath]
+
+	return f, ok
+}
+
+// loadArchive fetches the version t of the site owner/repo with git, against
+// the mirror of the previous version prev (if any), or else downloads and
+// unpacks its archive. A failed download leaves the version without files,
+// so it's served file by file.
+func (c *Client) loadArchive(key, owner, repo string, t *siteTree, prev *gitMirror) {
+	c.fetches.begin()
+	defer c.fetches.end()
+
+	a, err := c.fetchGit(owner, repo, t, prev)
+	if err != nil && !errors.Is(err, errArchiveTooLarge) {
+		c.logger.Debug("fetching site with git failed, downloading its archive", zap.String("owner", owner),
+			zap.String("repo", repo), zap.String("ref", t.ref), zap.Error(err))
+
+		a, err = c.fetchArchive(owner, repo, t)
+	}
+
+	if err != nil {
+		c.logger.Warn("loading archive of site failed", zap.String("owner", owner), zap.String("repo", repo),
+			zap.String("ref", t.ref), zap.Error(err))
+
+		return
+	}
+
+	c.archives.put(key, a)
+}
+
+// fetchGit fetches the version t of owner/repo as a shallow git pack, thin
+// against the mirror prev, and returns its files; LFS pointers, like
+// symlinks and submodules, are left out.
+func (c *Client) fetchGit(owner, repo string, t *siteTree, prev *gitMirror) (*archive, error) {
+	ctx := context.Background()
+
+	commit, err := c.resolveCommit(ctx, owner, repo, t.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]gitObject)
+
+	if prev == nil || prev.commit != commit {
+		if objects, err = c.fetchPack(ctx, owner, repo, commit, prev, c.archives.maxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// unchanged objects aren't sent again
+	if prev != nil {
+		for oid, o := range prev.objects {
+			if _, ok := objects[oid]; !ok {
+				objects[oid] = o
+			}
+		}
+	}
+
+	mirror, blobs, modTime, err := checkout(commit, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archive{sha: t.sha, files: make(map[string]*rawFile), mirror: mirror}
+
+	for name, b := range blobs {
+		// files above the site limit are refused by the media api
+		if c.limits.maxFileSize > 0 && int64(len(b.data)) > c.limits.maxFileSize ||
+			bytes.HasPrefix(b.data, []byte(lfsPointerPrefix)) {
+			continue
+		}
+
+		if a.size += int64(len(b.data)); c.archives.maxSize > 0 && a.size > c.archives.maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, c.archives.maxSize)
+		}
+
+		a.files[name] = &rawFile{
+			content: b.data,
+			etag:    `"` + blobSHA(b.data) + `"`,
+			modTime: modTime,
+		}
+	}
+
+	return a, nil
+}
+
+// fetchArchive fetches the tar.gz archive of owner/repo at the version t and
+// unpacks it; LFS pointers are left out.
+func (c *Client) fetchArchive(owner, repo string, t *siteTree) (*archive, error) {
+	body, _, err := c.gc.GetArchiveReader(owner, repo, t.ref, gclient.TarGZArchive)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gz.Close()
+
+	a := &archive{sha: t.sha, files: make(map[string]*rawFile)}
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// files above the site limit are refused by the media api
+		if hdr.Typeflag != tar.TypeReg || c.limits.maxFileSize > 0 && hdr.Size > c.limits.maxFileSize {
+			continue
+		}
+
+		if a.size += hdr.Size; c.archives.maxSize > 0 && a.size > c.archives.maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, c.archives.maxSize)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.HasPrefix(content, []byte(lfsPointerPrefix)) {
+			continue
+		}
+
+		// the files are below a directory named after the repo
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || name == "" {
+			continue
+		}
+
+		a.files[name] = &rawFile{
+			content: content,
+			etag:    `"` + blobSHA(content) + `"`,
+			modTime: hdr.ModTime,
+		}
+	}
+
+	return a, nil
+}
+
+// blobSHA returns the git blob id of content, which gitea sends as etag.
+func blobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package gitea
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestArchiveGitFetch(t *testing.T) {
+	const (
+		media   = "/api/v1/repos/user/gitea-pages/media/"
+		archive = "/api/v1/repos/user/gitea-pages/archive/gitea-pages.tar.gz"
+		css     = "/* the styles of the site, shared by all pages */\n"
+	)
+
+	for _, disableGit := range []bool{false, true} {
+		srv := giteatest.NewServer()
+		srv.DisableGit = disableGit
+
+		srv.AddRepo(&giteatest.Repo{
+			Owner:  "user",
+			Name:   "gitea-pages",
+			Topics: []string{"gitea-pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {
+				"index.html":       "<p>home</p>",
+				"about/index.html": "<p>about</p>",
+			}},
+		})
+
+		c, err := NewClient(srv.URL, "token", "", "", SetArchives(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		read := func(name string) string {
+			t.Helper()
+
+			f, err := c.Open("user/gitea-pages/"+name, "")
+			if err != nil {
+				t.Fatalf("git %v: %s: %v", !disableGit, name, err)
+			}
+
+			defer f.Close()
+
+			content, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return string(content)
+		}
+
+		// the archive of a version is loaded in the background once its
+		// tree is known
+		loaded := func() {
+			t.Helper()
+
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				c.archives.mu.Lock()
+				a := c.archives.sites["user/gitea-pages@gitea-pages"]
+				done := a != nil && a.files != nil
+				c.archives.mu.Unlock()
+
+				if done {
+					return
+				}
+
+				if time.Now().After(deadline) {
+					t.Fatalf("git %v: archive not loaded", !disableGit)
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		read("index.html")
+		loaded()
+
+		before := srv.Requests(media + "about/index.html")
+
+		if got := read("about/index.html"); got != "<p>about</p>" {
+			t.Errorf("git %v: about = %q", !disableGit, got)
+		}
+
+		if n := srv.Requests(media + "about/index.html"); n != before {
+			t.Errorf("git %v: about fetched from the media api, not the archive", !disableGit)
+		}
+
+		// the next version is fetched against the previous one: the
+		// changed page as delta of its old version, the new styles one of
+		// the other, the unchanged page not at all
+		srv.AddFile("user", "gitea-pages", "gitea-pages", "index.html", "<p>home, again</p>")
+		srv.AddFile("user", "gitea-pages", "gitea-pages", "css/a.css", css+"a { color: red }\n")
+		srv.AddFile("user", "gitea-pages", "gitea-pages", "css/b.css", css+"b { color: blue }\n")
+		c.trees.expire("user", "gitea-pages")
+
+		read("index.html")
+		loaded()
+
+		for name, want := range map[string]string{
+			"index.html":       "<p>home, again</p>",
+			"about/index.html": "<p>about</p>",
+			"css/a.css":        css + "a { color: red }\n",
+			"css/b.css":        css + "b { color: blue }\n",
+		} {
+			before := srv.Requests(media + name)
+
+			if got := read(name); got != want {
+				t.Errorf("git %v: %s = %q, want %q", !disableGit, name, got, want)
+			}
+
+			if srv.Requests(media+name) != before {
+				t.Errorf("git %v: %s fetched from the media api, not the archive", !disableGit, name)
+			}
+		}
+
+		want := 0
+		if disableGit {
+			want = 2
+		}
+
+		if n := srv.Requests(archive); n != want {
+			t.Errorf("git %v: archive downloaded %d times, want %d", !disableGit, n, want)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestApplyDeltaBounds(t *testing.T) {
+	base := []byte("hello")
+
+	for _, delta := range [][]byte{
+		{5},                      // truncated header
+		{4, 5, 0x90, 5},          // base of another size
+		{5, 5, 0x91, 3, 5},       // copy beyond the base
+		{5, 5, 0x90, 5, 0x10, 1}, // more than the size
+		{5, 5, 9, 'a'},           // truncated insert
+		{5, 5, 0},                // reserved op
+	} {
+		if _, err := applyDelta(base, delta); err == nil {
+			t.Errorf("applyDelta(%v) succeeded", delta)
+		}
+	}
+
+	got, err := applyDelta(base, []byte{5, 6, 0x90, 4, 2, 'p', '!'})
+	if err != nil || string(got) != "hellp!" {
+		t.Errorf("applyDelta = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,191 @@
+package gitea
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gclient "code.gitea.io/sdk/gitea"
+)
+
+// accessTTL is how long the owners of tokens, their read access to repos and
+// whether repos are restricted are known, so the pages and assets of a site
+// don't ask gitea again for every request.
+const accessTTL = time.Minute
+
+// Identity is an authenticated visitor of a site.
+type Identity struct {
+	// Username is the gitea username of the visitor.
+	Username string `json:"username"`
+	// Token is the gitea access token of the visitor.
+	Token string `json:"token"`
+}
+
+// RepoInfo describes the repo that serves a site.
+type RepoInfo struct {
+	Owner string
+	Repo  string
+	Ref   string
+	// Private is set for private and internal repos, and for public repos
+	// whose site is restricted with the pages:auth topic, a topic tier with
+	// auth or auth in its config.
+	Private bool
+	// Tier is the tier of the site, DefaultTier or that of its topic tier.
+	Tier string
+}
+
+// accessCache remembers the lookups of the auth providers for accessTTL.
+type accessCache struct {
+	mu      sync.Mutex
+	entries map[string]accessEntry
+}
+
+type accessEntry struct {
+	id      *Identity
+	ok      bool
+	expires time.Time
+}
+
+func (ac *accessCache) get(key string) (accessEntry, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	e, ok := ac.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(ac.entries, key)
+		return accessEntry{}, false
+	}
+
+	return e, ok
+}
+
+func (ac *accessCache) set(key string, e accessEntry) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	// drop the expired entries once in a while instead of growing forever
+	if len(ac.entries) >= 10000 {
+		now := time.Now()
+
+		for k, old := range ac.entries {
+			if now.After(old.expires) {
+				delete(ac.entries, k)
+			}
+		}
+	}
+
+	e.expires = time.Now().Add(accessTTL)
+	ac.entries[key] = e
+}
+
+// forget forgets whether owner/repo is restricted and who can read it.
+func (ac *accessCache) forget(owner, repo string) {
+	key := repoKey(owner, repo)
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for k := range ac.entries {
+		if strings.HasSuffix(k, ":"+key) {
+			delete(ac.entries, k)
+		}
+	}
+}
+
+// ServerURL returns the url of the gitea server.
+func (c *Client) ServerURL() string {
+	return c.serverURL
+}
+
+// RepoInfo returns the repo that serves name at ref.
+func (c *Client) RepoInfo(ctx context.Context, name, ref string) (*RepoInfo, error) {
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := c.repoPrivate(s.ctx, s.owner, s.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	tier := s.tier
+	if tier == "" {
+		tier = DefaultTier
+	}
+
+	return &RepoInfo{
+		Owner:   s.owner,
+		Repo:    s.repo,
+		Ref:     s.ref,
+		Private: private || s.auth,
+		Tier:    tier,
+	}, nil
+}
+
+// userClient returns a gitea API client acting as the owner of token.
+func (c *Client) userClient(token string) (*gclient.Client, error) {
+	return gclient.NewClient(c.serverURL, gclient.SetToken(token), gclient.SetGiteaVersion(""),
+		gclient.SetHTTPClient(c.httpClient))
+}
+
+// User returns the identity of the owner of token.
+func (c *Client) User(token string) (*Identity, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := "user:" + hex.EncodeToString(sum[:])
+
+	if e, ok := c.access.get(key); ok {
+		return e.id, nil
+	}
+
+	uc, err := c.userClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _, err := uc.GetMyUserInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		Username: u.UserName,
+		Token:    token,
+	}
+
+	c.access.set(key, accessEntry{id: id})
+
+	return id, nil
+}
+
+// CanRead reports if id has read access to owner/repo.
+func (c *Client) CanRead(id *Identity, owner, repo string) (bool, error) {
+	key := "read:" + strings.ToLower(id.Username) + ":" + repoKey(owner, repo)
+
+	if e, ok := c.access.get(key); ok {
+		return e.ok, nil
+	}
+
+	uc, err := c.userClient(id.Token)
+	if err != nil {
+		return false, err
+	}
+
+	_, resp, err := uc.GetRepo(owner, repo)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden) {
+			c.access.set(key, accessEntry{ok: false})
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	c.access.set(key, accessEntry{ok: true})
+
+	return true, nil
+}
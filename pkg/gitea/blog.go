@@ -0,0 +1,374 @@
+package gitea
+
+import (
+	"bytes"
+	"hash/crc32"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultBlogPageSize is the number of posts per listing page.
+const defaultBlogPageSize = 10
+
+// defaultBlogTemplate renders the listing pages of blogs without a template.
+var defaultBlogTemplate = template.Must(template.New("blog").Funcs(blogFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Term}}{{.}} - {{end}}{{.Title}}</title>
+</head>
+<body>
+<h1>{{if .Term}}<a href="{{.Root}}">{{.Title}}</a>: {{.Term}}{{else}}{{.Title}}{{end}}</h1>
+<ul>
+{{range .Posts}}<li><a href="{{.URL}}">{{.Title}}</a>{{if not .Date.IsZero}} <time datetime="{{.Date.Format "2006-01-02"}}">{{.Date.Format "2006-01-02"}}</time>{{end}}{{with .Summary}}<p>{{.}}</p>{{end}}{{range .Tags}} <a href="{{$.Root}}tags/{{urlize .}}/">#{{.}}</a>{{end}}</li>
+{{end}}</ul>
+<nav>{{with .Prev}}<a href="{{.}}">Newer posts</a>{{end}} {{with .Next}}<a href="{{.}}">Older posts</a>{{end}}</nav>
+</body>
+</html>
+`))
+
+// blogConfig is the blog section of the config of a repo:
+//
+//	[blog]
+//	posts="posts"
+//	pagesize=10
+//	template="_layouts/list.html"
+//	title="My blog"
+type blogConfig struct {
+	posts    string
+	pageSize int
+	template string
+	title    string
+}
+
+// blogSettings returns the blog config of the repo config cfg, or nil if
+// it isn't a blog.
+func blogSettings(cfg *repoConfig) *blogConfig {
+	if cfg == nil || !cfg.IsSet("blog") {
+		return nil
+	}
+
+	b := &blogConfig{
+		posts:    strings.Trim(cfg.GetString("blog.posts"), "/"),
+		pageSize: cfg.GetInt("blog.pagesize"),
+		template: strings.TrimPrefix(cfg.GetString("blog.template"), "/"),
+		title:    cfg.GetString("blog.title"),
+	}
+
+	if b.posts == "" {
+		b.posts = "posts"
+	}
+
+	if b.pageSize <= 0 {
+		b.pageSize = defaultBlogPageSize
+	}
+
+	return b
+}
+
+// blogPost is a post on the listing pages of a blog, as passed to the template.
+type blogPost struct {
+	Title string
+	// URL is relative to the listing page.
+	URL        string
+	Date       time.Time
+	Summary    string
+	Tags       []string
+	Categories []string
+
+	path string
+}
+
+// blogListing is the data of the template of a listing page.
+type blogListing struct {
+	Title string
+	// Taxonomy (tags or categories) and Term are set on the listing pages
+	// of the posts with a tag or category.
+	Taxonomy string
+	Term     string
+	Posts    []blogPost
+	// Page is the number of the listing page, of Pages.
+	Page  int
+	Pages int
+	// Root is the relative url of the root of the site.
+	Root string
+	// Prev and Next are the relative urls of the newer and older listing pages.
+	Prev string
+	Next string
+}
+
+// blogIndex are the posts of a blog (newest first) and its template, per tree.
+type blogIndex struct {
+	mu       sync.Mutex
+	built    bool
+	preview  bool
+	config   blogConfig
+	posts    []blogPost
+	template *template.Template
+}
+
+// blogTaxonomies are the front matter lists of posts that get index pages.
+var blogTaxonomies = []string{"tags", "categories"}
+
+// blogFuncs are the functions of the blog templates.
+var blogFuncs = template.FuncMap{
+	"urlize": urlize,
+}
+
+// urlize returns the path segment of the taxonomy term (tag or category) term.
+func urlize(term string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(term)), " ", "-")
+}
+
+// blogRoute parses the path p of a listing page of a blog: "", "page/<n>/",
+// "<taxonomy>/<term>/" or "<taxonomy>/<term>/page/<n>/".
+func blogRoute(p string) (taxonomy, term string, page int, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	if p == "" {
+		parts = nil
+	}
+
+	if len(parts) >= 2 {
+		for _, tax := range blogTaxonomies {
+			if parts[0] == tax && parts[1] != "" {
+				taxonomy, term, parts = tax, parts[1], parts[2:]
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(parts) == 0:
+		return taxonomy, term, 1, true
+	case len(parts) == 2 && parts[0] == "page":
+		n, err := strconv.Atoi(parts[1])
+		return taxonomy, term, n, err == nil && n >= 1
+	}
+
+	return "", "", 0, false
+}
+
+// blogPage returns the generated listing page of the blog of the site s
+// (/ and /page/<n>/), or of the posts with a tag or category
+// (/tags/<tag>/, /categories/<category>/ and their /page/<n>/), or nil if
+// the site isn't a blog or the path isn't a listing page.
+func (c *Client) blogPage(s *site) (*openFile, error) {
+	if s.blog == nil {
+		return nil, nil
+	}
+
+	p := strings.TrimSuffix(s.filepath, "index.html")
+
+	taxonomy, term, page, ok := blogRoute(p)
+	if !ok {
+		return nil, nil
+	}
+
+	// the links on the page are relative to the directory
+	if p != "" && !strings.HasSuffix(p, "/") {
+		return nil, &RedirectError{Path: "/" + s.filepath, To: escapePath("/" + s.filepath + "/"), Status: http.StatusMovedPermanently}
+	}
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, tmpl := c.blogIndex(s, t)
+
+	// embargoed posts aren't listed before they unlock
+	listed := make([]blogPost, 0, len(posts))
+	for _, post := range posts {
+		if s.embargo(post.path, nil) == nil {
+			listed = append(listed, post)
+		}
+	}
+
+	posts = listed
+
+	l := blogListing{
+		Title:    s.blog.title,
+		Taxonomy: taxonomy,
+		Page:     page,
+		Root:     "./",
+	}
+
+	if l.Title == "" {
+		l.Title = s.repo
+	}
+
+	if p != "" {
+		l.Root = strings.Repeat("../", strings.Count(p, "/"))
+	}
+
+	base := l.Root
+
+	if taxonomy != "" {
+		base += taxonomy + "/" + term + "/"
+		posts = postsWith(posts, taxonomy, term, &l.Term)
+
+		if len(posts) == 0 {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	l.Pages = (len(posts) + s.blog.pageSize - 1) / s.blog.pageSize
+	if l.Pages == 0 {
+		l.Pages = 1
+	}
+
+	if page > l.Pages {
+		return nil, fs.ErrNotExist
+	}
+
+	switch {
+	case page == 2:
+		l.Prev = base
+	case page > 2:
+		l.Prev = base + "page/" + strconv.Itoa(page-1) + "/"
+	}
+
+	if page < l.Pages {
+		l.Next = base + "page/" + strconv.Itoa(page+1) + "/"
+	}
+
+	start := (page - 1) * s.blog.pageSize
+	end := start + s.blog.pageSize
+
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	for _, post := range posts[start:end] {
+		post.URL = l.Root + strings.TrimPrefix(pageURLPath(post.path), "/")
+		l.Posts = append(l.Posts, post)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, l); err != nil {
+		return nil, err
+	}
+
+	return &openFile{
+		content:     sanitizePage(buf.Bytes(), s.sanitize),
+		name:        "index.html",
+		etag:        `W/"` + t.sha + "-" + strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(p))), 16) + `"`,
+		contentType: "text/html; charset=utf-8",
+		variant:     s.variant,
+		generated:   true,
+	}, nil
+}
+
+// postsWith returns the posts with the term (urlized) in their taxonomy, the
+// term as written in the posts is stored in name.
+func postsWith(posts []blogPost, taxonomy, term string, name *string) []blogPost {
+	var res []blogPost
+
+	for _, post := range posts {
+		terms := post.Tags
+		if taxonomy == "categories" {
+			terms = post.Categories
+		}
+
+		for _, t := range terms {
+			if urlize(t) == term {
+				*name = t
+				res = append(res, post)
+
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// blogIndex returns the posts and template of the blog of the site s in the
+// tree t, read once per tree.
+func (c *Client) blogIndex(s *site, t *siteTree) ([]blogPost, *template.Template) {
+	idx := &t.blog
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	// previews list drafts, the same tree may be served without; the config
+	// may come from the description of the repo
+	if idx.built && idx.preview == s.preview && idx.config == *s.blog {
+		return idx.posts, idx.template
+	}
+
+	idx.built, idx.preview, idx.config, idx.posts = true, s.preview, *s.blog, nil
+
+	c.readPages(s, t, s.blog.posts, func(name string, meta map[string]any, _ []byte) {
+		post := blogPost{
+			Date:       frontMatterTime(meta["date"]),
+			Tags:       frontMatterStrings(meta["tags"]),
+			Categories: frontMatterStrings(meta["categories"]),
+			path:       name,
+		}
+
+		post.Title, _ = meta["title"].(string)
+		if post.Title == "" {
+			post.Title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+		}
+
+		post.Summary, _ = meta["summary"].(string)
+		if post.Summary == "" {
+			post.Summary, _ = meta["description"].(string)
+		}
+
+		idx.posts = append(idx.posts, post)
+	})
+
+	sort.Slice(idx.posts, func(i, j int) bool {
+		if !idx.posts[i].Date.Equal(idx.posts[j].Date) {
+			return idx.posts[i].Date.After(idx.posts[j].Date)
+		}
+
+		return idx.posts[i].path > idx.posts[j].path
+	})
+
+	idx.template = defaultBlogTemplate
+
+	if s.blog.template != "" {
+		raw, err := c.fetchRaw(s.ctx, s.owner, s.repo, s.blog.template, s.ref, nil)
+		if err == nil {
+			idx.template, err = template.New("blog").Funcs(blogFuncs).Parse(string(raw.content))
+		}
+
+		if err != nil {
+			c.logger.Warn("blog template unusable, using the default", zap.String("owner", s.owner),
+				zap.String("repo", s.repo), zap.String("template", s.blog.template), zap.Error(err))
+
+			idx.template = defaultBlogTemplate
+		}
+	}
+
+	return idx.posts, idx.template
+}
+
+// frontMatterTime returns the date v of front matter, which is decoded as
+// time or string depending on the format.
+func frontMatterTime(v any) time.Time {
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,67 @@
+package gitea
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFalsePositiveRate is the false positive rate bloom filters are sized for.
+const bloomFalsePositiveRate = 0.01
+
+// bloomFilter is a compact probabilistic set of paths: has never returns a
+// false negative and returns a false positive for about 1% of the paths.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes returns two independent hashes of s used for double hashing.
+func (b *bloomFilter) hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+
+	h = fnv.New64()
+	h.Write([]byte(s))
+	h2 := h.Sum64() | 1
+
+	return h1, h2
+}
+
+func (b *bloomFilter) add(s string) {
+	h1, h2 := b.hashes(s)
+
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) has(s string) bool {
+	h1, h2 := b.hashes(s)
+
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
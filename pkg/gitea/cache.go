@@ -0,0 +1,943 @@
+package gitea
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores content fetched from gitea. Keys start with "owner/repo/".
+// Caches are best effort: implementations handle their own errors and
+// report them as misses.
+type Cache interface {
+	// Get returns the value stored for key, if it hasn't expired.
+	Get(key string) ([]byte, bool)
+	// Set stores value for key for ttl.
+	Set(key string, value []byte, ttl time.Duration)
+	// DeletePrefix removes all entries with keys that start with prefix.
+	DeletePrefix(prefix string)
+}
+
+// SetCache caches the files fetched from gitea in cache for ttl, and the
+// topics and branches of repos in memory.
+func SetCache(cache Cache, ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.cache = cache
+		c.cacheTTL = ttl
+		c.lookups = newLookupCache(ttl)
+
+		return nil
+	}
+}
+
+// cachedFile is a rawFile as stored in the cache, its content is stored
+// separately under the key of its hash.
+type cachedFile struct {
+	Hash    string
+	ETag    string
+	ModTime time.Time
+	// Encrypted is set for the content of private repos, see
+	// SetCacheEncryption.
+	Encrypted bool
+}
+
+// sitePrefix returns the owner/repo/ prefix of key, which the keys of the
+// entries of a site start with.
+func sitePrefix(key string) string {
+	owner, rest, ok := strings.Cut(key, "/")
+	if !ok {
+		return ""
+	}
+
+	repo, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ""
+	}
+
+	return owner + "/" + repo + "/"
+}
+
+func fileCacheKey(owner, repo, filepath, ref string) string {
+	return owner + "/" + repo + "/file/" + ref + ":" + filepath
+}
+
+// blobCacheKey is the key of content with hash in the cache. Content is
+// addressed by its hash, so identical files of different sites have the same
+// value and the backends store it once, shared by the keys of all sites.
+// The key is of the repo, so purges and the limits of the site count it.
+func blobCacheKey(owner, repo, hash string) string {
+	return owner + "/" + repo + "/blob/" + hash
+}
+
+// cachedRaw returns the file of owner/repo stored at key from the cache.
+func (c *Client) cachedRaw(owner, repo, key string) (*rawFile, bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+
+	v, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	var cf cachedFile
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&cf); err != nil || cf.Hash == "" {
+		return nil, false
+	}
+
+	blobKey := blobCacheKey(owner, repo, cf.Hash)
+
+	content, ok := c.cache.Get(blobKey)
+	if !ok {
+		return nil, false
+	}
+
+	// content encrypted with a key the client doesn't have is fetched again
+	if cf.Encrypted {
+		if c.cacheCipher == nil {
+			return nil, false
+		}
+
+		var err error
+		if content, err = c.cacheCipher.open(owner, repo, blobKey, content); err != nil {
+			return nil, false
+		}
+	}
+
+	return &rawFile{content: content, etag: cf.ETag, modTime: cf.ModTime}, true
+}
+
+// cacheRaw stores the file of owner/repo at key in the cache.
+func (c *Client) cacheRaw(ctx context.Context, owner, repo, key string, f *rawFile) {
+	if c.cache == nil {
+		return
+	}
+
+	cf := cachedFile{ETag: f.etag, ModTime: f.modTime}
+	content := f.content
+
+	if c.encryptCached(ctx, owner, repo) {
+		cf.Hash = c.cacheCipher.hash(owner, repo, f.content)
+		cf.Encrypted = true
+
+		var err error
+		if content, err = c.cacheCipher.seal(owner, repo, blobCacheKey(owner, repo, cf.Hash), f.content); err != nil {
+			return
+		}
+	} else {
+		sum := sha256.Sum256(f.content)
+		cf.Hash = hex.EncodeToString(sum[:])
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cf); err != nil {
+		return
+	}
+
+	// the content first, a file is never cached without it
+	c.cache.Set(blobCacheKey(owner, repo, cf.Hash), content, c.cacheTTL)
+	c.cache.Set(key, buf.Bytes(), c.cacheTTL)
+}
+
+// MemoryCache is an in-memory Cache that evicts the least recently used
+// entries when it holds more than its maximum size. Identical values (e.g.
+// the same CSS framework in many forks) are stored once, they're reference
+// counted by the entries holding them.
+type MemoryCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	blobs   map[[sha256.Size]byte]*memoryBlob
+	// sites is the size of the entries of every site prefix.
+	sites map[string]int64
+}
+
+type memoryEntry struct {
+	key     string
+	blob    *memoryBlob
+	expires time.Time
+}
+
+// memoryBlob is a value shared by the entries with the same content.
+type memoryBlob struct {
+	hash  [sha256.Size]byte
+	value []byte
+	refs  int
+}
+
+// NewMemoryCache returns an in-memory cache of at most maxSize bytes.
+func NewMemoryCache(maxSize int64) *MemoryCache {
+	return &MemoryCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		blobs:   make(map[[sha256.Size]byte]*memoryBlob),
+		sites:   make(map[string]int64),
+	}
+}
+
+// Get implements Cache.
+func (mc *MemoryCache) Get(key string) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	el, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		mc.remove(el)
+		return nil, false
+	}
+
+	mc.lru.MoveToFront(el)
+
+	return e.blob.value, true
+}
+
+// Set implements Cache.
+func (mc *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	if int64(len(value)) > mc.maxSize {
+		return
+	}
+
+	hash := sha256.Sum256(value)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if el, ok := mc.entries[key]; ok {
+		mc.remove(el)
+	}
+
+	blob, ok := mc.blobs[hash]
+	if !ok {
+		blob = &memoryBlob{hash: hash, value: value}
+		mc.blobs[hash] = blob
+		mc.size += int64(len(value))
+		memoryMetrics.held.WithLabelValues("cache").Add(float64(len(value)))
+	}
+
+	blob.refs++
+
+	mc.entries[key] = mc.lru.PushFront(&memoryEntry{key: key, blob: blob, expires: time.Now().Add(ttl)})
+	addSiteSize(mc.sites, key, int64(len(value)))
+
+	for mc.size > mc.maxSize {
+		mc.remove(mc.lru.Back())
+	}
+}
+
+// DeletePrefix implements Cache.
+func (mc *MemoryCache) DeletePrefix(prefix string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for key, el := range mc.entries {
+		if strings.HasPrefix(key, prefix) {
+			mc.remove(el)
+		}
+	}
+}
+
+// PrefixSize implements PrefixSizer. Values shared with other entries
+// count for every entry.
+func (mc *MemoryCache) PrefixSize(prefix string) int64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.sites[prefix]
+}
+
+// Shed implements Shedder.
+func (mc *MemoryCache) Shed(fraction float64) int64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	target := mc.size - int64(float64(mc.size)*fraction)
+	freed := mc.size
+
+	for mc.size > target && mc.lru.Len() > 0 {
+		mc.remove(mc.lru.Back())
+	}
+
+	return freed - mc.size
+}
+
+// remove removes the entry el and its value once no other entry holds it.
+func (mc *MemoryCache) remove(el *list.Element) {
+	e := el.Value.(*memoryEntry)
+	mc.lru.Remove(el)
+	delete(mc.entries, e.key)
+	addSiteSize(mc.sites, e.key, -int64(len(e.blob.value)))
+
+	e.blob.refs--
+	if e.blob.refs > 0 {
+		return
+	}
+
+	delete(mc.blobs, e.blob.hash)
+	mc.size -= int64(len(e.blob.value))
+	memoryMetrics.held.WithLabelValues("cache").Sub(float64(len(e.blob.value)))
+}
+
+// DiskCache is a Cache that stores every entry in a file of a directory,
+// evicting the least recently used entries when it holds more than its
+// maximum size. Large values are stored once in a blob file named after
+// their hash, shared by the entries with the same content (e.g. the same CSS
+// framework in many forks) and removed with the last of them. The keys of
+// the entries are kept in memory, the directory belongs to a single cache.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+
+	mu        sync.Mutex
+	size      int64
+	lru       *list.List
+	entries   map[string]*list.Element
+	blobs     map[string]*diskBlob
+	lastSweep time.Time
+	// sites is the size of the entries of every site prefix.
+	sites map[string]int64
+}
+
+// diskIndexEntry is an entry of the DiskCache as kept in memory.
+type diskIndexEntry struct {
+	key     string
+	size    int64
+	blob    *diskBlob
+	expires time.Time
+}
+
+// valueSize returns the size of the entry with the blob of its value.
+func (e *diskIndexEntry) valueSize() int64 {
+	if e.blob == nil {
+		return e.size
+	}
+
+	return e.size + e.blob.size
+}
+
+// diskBlob is a blob file shared by the entries with the same value.
+type diskBlob struct {
+	hash string
+	size int64
+	refs int
+}
+
+// diskSweepInterval is how often the expired entries of a DiskCache are
+// removed, by the next Set.
+const diskSweepInterval = time.Minute
+
+// diskTempPrefix starts the names of the files Set writes before renaming
+// them to their entry.
+const diskTempPrefix = ".tmp-"
+
+// diskBlobPrefix starts the names of the blob files, followed by the
+// SHA-256 hash of their content.
+const diskBlobPrefix = "blob-"
+
+// diskBlobMinSize is the size of the smallest value stored in a blob file,
+// smaller values are kept in the file of their entry.
+const diskBlobMinSize = 1 << 10
+
+// NewDiskCache returns a cache storing at most maxSize bytes of entries in
+// dir. The entries left in dir are kept, the files of another format
+// version and those left partially written are removed.
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	dc := &DiskCache{
+		dir:       dir,
+		maxSize:   maxSize,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		blobs:     make(map[string]*diskBlob),
+		lastSweep: time.Now(),
+		sites:     make(map[string]int64),
+	}
+
+	if err := dc.load(); err != nil {
+		return nil, err
+	}
+
+	return dc, nil
+}
+
+const (
+	// diskCacheMagic starts every cache file, followed by the format version
+	// and the SHA-256 checksum of the rest of the file: the expiry (unix
+	// nanoseconds), the length of the key, the kind of value, the key and the
+	// value or the hash of its blob file.
+	diskCacheMagic = "gpc"
+	// diskCacheVersion is the version of the format of the cache files,
+	// increase it when the format changes incompatibly.
+	diskCacheVersion = 3
+	diskHeaderSize   = len(diskCacheMagic) + 1 + sha256.Size
+	// diskKeyHeaderSize is the size of the expiry, key length and kind.
+	diskKeyHeaderSize = 8 + 4 + 1
+	// diskMaxKeySize is the size of the longest key read back.
+	diskMaxKeySize = 64 << 10
+)
+
+// The kinds of value of a cache file.
+const (
+	diskValueInline byte = iota
+	diskValueBlob
+)
+
+// diskEntry is the content of a cache file.
+type diskEntry struct {
+	Key     string
+	Value   []byte
+	Expires time.Time
+	// Blob is the hash of the blob file of the value, if it has one.
+	Blob string
+}
+
+// diskHeader is the start of a cache file, as read when the cache is loaded.
+type diskHeader struct {
+	key     string
+	expires time.Time
+	size    int64
+	blob    string
+}
+
+func (dc *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dc.dir, hex.EncodeToString(sum[:]))
+}
+
+func (dc *DiskCache) blobPath(hash string) string {
+	return filepath.Join(dc.dir, diskBlobPrefix+hash)
+}
+
+// discard removes the cache file name, counted for reason.
+func discard(name, reason string) {
+	diskCacheMetrics.discarded.WithLabelValues(reason).Inc()
+	os.Remove(name)
+}
+
+// load indexes the entries in the directory of the cache, reading only the
+// header and key of the files. Files of another format version and partially
+// written files are removed, as well as expired entries, entries whose blob
+// is missing and blobs no entry has.
+func (dc *DiskCache) load() error {
+	entries, err := os.ReadDir(dc.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	blobs := make(map[string]int64)
+
+	var headers []diskHeader
+
+	for _, de := range entries {
+		name := filepath.Join(dc.dir, de.Name())
+
+		// writes of a previous run that didn't finish
+		if strings.HasPrefix(de.Name(), diskTempPrefix) {
+			os.Remove(name)
+			continue
+		}
+
+		if !de.Type().IsRegular() {
+			continue
+		}
+
+		if hash := strings.TrimPrefix(de.Name(), diskBlobPrefix); hash != de.Name() {
+			if fi, err := de.Info(); err == nil {
+				blobs[hash] = fi.Size()
+			}
+
+			continue
+		}
+
+		h, ok := readDiskHeader(name)
+		if !ok {
+			continue
+		}
+
+		if now.After(h.expires) || dc.path(h.key) != name {
+			os.Remove(name)
+			continue
+		}
+
+		headers = append(headers, h)
+	}
+
+	for _, h := range headers {
+		var blob *diskBlob
+
+		if h.blob != "" {
+			size, ok := blobs[h.blob]
+			if !ok {
+				discard(dc.path(h.key), "corrupt")
+				continue
+			}
+
+			if blob = dc.blobs[h.blob]; blob == nil {
+				blob = dc.addBlob(h.blob, size)
+			}
+		}
+
+		dc.index(h.key, h.size, h.expires, blob)
+	}
+
+	for hash := range blobs {
+		if _, ok := dc.blobs[hash]; !ok {
+			os.Remove(dc.blobPath(hash))
+		}
+	}
+
+	dc.evict()
+
+	return nil
+}
+
+// readDiskHeader reads the key, expiry and blob of the cache file name and
+// its size, the files of another format version are removed.
+func readDiskHeader(name string) (diskHeader, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return diskHeader{}, false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return diskHeader{}, false
+	}
+
+	header := make([]byte, diskHeaderSize+diskKeyHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		discard(name, "corrupt")
+		return diskHeader{}, false
+	}
+
+	if string(header[:len(diskCacheMagic)]) != diskCacheMagic || header[len(diskCacheMagic)] != diskCacheVersion {
+		discard(name, "version")
+		return diskHeader{}, false
+	}
+
+	h := diskHeader{
+		expires: time.Unix(0, int64(binary.BigEndian.Uint64(header[diskHeaderSize:]))),
+		size:    fi.Size(),
+	}
+
+	keySize := binary.BigEndian.Uint32(header[diskHeaderSize+8:])
+	kind := header[diskHeaderSize+12]
+
+	if keySize > diskMaxKeySize || kind != diskValueInline && kind != diskValueBlob {
+		discard(name, "corrupt")
+		return diskHeader{}, false
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(f, key); err != nil {
+		discard(name, "corrupt")
+		return diskHeader{}, false
+	}
+
+	h.key = string(key)
+
+	if kind == diskValueBlob {
+		sum := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(f, sum); err != nil {
+			discard(name, "corrupt")
+			return diskHeader{}, false
+		}
+
+		h.blob = hex.EncodeToString(sum)
+	}
+
+	return h, true
+}
+
+// read reads the cache file name, files of another format version (e.g.
+// written before an upgrade) or with a wrong checksum are removed. The value
+// in a blob file isn't read.
+func (dc *DiskCache) read(name string) (*diskEntry, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+
+	if len(data) < diskHeaderSize || string(data[:len(diskCacheMagic)]) != diskCacheMagic ||
+		data[len(diskCacheMagic)] != diskCacheVersion {
+		discard(name, "version")
+		return nil, false
+	}
+
+	payload := data[diskHeaderSize:]
+
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(sum[:], data[len(diskCacheMagic)+1:diskHeaderSize]) || len(payload) < diskKeyHeaderSize {
+		discard(name, "corrupt")
+		return nil, false
+	}
+
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	keySize := int64(binary.BigEndian.Uint32(payload[8:]))
+	kind := payload[12]
+
+	if int64(len(payload)-diskKeyHeaderSize) < keySize {
+		discard(name, "corrupt")
+		return nil, false
+	}
+
+	payload = payload[diskKeyHeaderSize:]
+	e := &diskEntry{Key: string(payload[:keySize]), Expires: expires}
+
+	switch value := payload[keySize:]; {
+	case kind == diskValueInline:
+		e.Value = value
+	case kind == diskValueBlob && len(value) == sha256.Size:
+		e.Blob = hex.EncodeToString(value)
+	default:
+		discard(name, "corrupt")
+		return nil, false
+	}
+
+	return e, true
+}
+
+// readBlob reads the blob file with hash, a blob whose content doesn't have
+// its hash is removed.
+func (dc *DiskCache) readBlob(hash string) ([]byte, bool) {
+	name := dc.blobPath(hash)
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		discard(name, "corrupt")
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Get implements Cache.
+func (dc *DiskCache) Get(key string) ([]byte, bool) {
+	dc.mu.Lock()
+	el, ok := dc.entries[key]
+	if ok {
+		dc.lru.MoveToFront(el)
+	}
+	dc.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	name := dc.path(key)
+
+	e, ok := dc.read(name)
+	if !ok || e.Key != key {
+		dc.forget(key)
+		return nil, false
+	}
+
+	if time.Now().After(e.Expires) {
+		dc.remove(key)
+		return nil, false
+	}
+
+	if e.Blob != "" {
+		if e.Value, ok = dc.readBlob(e.Blob); !ok {
+			dc.remove(key)
+			return nil, false
+		}
+	}
+
+	return e.Value, true
+}
+
+// Set implements Cache.
+func (dc *DiskCache) Set(key string, value []byte, ttl time.Duration) {
+	if len(key) > diskMaxKeySize {
+		return
+	}
+
+	expires := time.Now().Add(ttl)
+
+	payload := make([]byte, diskKeyHeaderSize, diskKeyHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(expires.UnixNano()))
+	binary.BigEndian.PutUint32(payload[8:], uint32(len(key)))
+	payload = append(payload, key...)
+
+	var hash string
+
+	if len(value) >= diskBlobMinSize {
+		sum := sha256.Sum256(value)
+		hash = hex.EncodeToString(sum[:])
+
+		payload[12] = diskValueBlob
+		payload = append(payload, sum[:]...)
+	} else {
+		payload[12] = diskValueInline
+		payload = append(payload, value...)
+	}
+
+	size := int64(diskHeaderSize + len(payload))
+	if size+int64(len(value)) > dc.maxSize {
+		return
+	}
+
+	sum := sha256.Sum256(payload)
+
+	header := append([]byte(diskCacheMagic), diskCacheVersion)
+	header = append(header, sum[:]...)
+
+	entryTemp, ok := dc.writeTemp(append(header, payload...))
+	if !ok {
+		return
+	}
+
+	// the value of a blob another entry has already isn't written again
+	var blobTemp string
+
+	if hash != "" {
+		dc.mu.Lock()
+		_, shared := dc.blobs[hash]
+		dc.mu.Unlock()
+
+		if !shared {
+			if blobTemp, ok = dc.writeTemp(value); !ok {
+				os.Remove(entryTemp)
+				return
+			}
+		}
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	var blob *diskBlob
+
+	if hash != "" {
+		switch blob = dc.blobs[hash]; {
+		case blob != nil:
+			if blobTemp != "" {
+				os.Remove(blobTemp)
+			}
+		case blobTemp == "":
+			// the blob was removed since it was looked up
+			os.Remove(entryTemp)
+			return
+		default:
+			if err := os.Rename(blobTemp, dc.blobPath(hash)); err != nil {
+				os.Remove(blobTemp)
+				os.Remove(entryTemp)
+
+				return
+			}
+
+			blob = dc.addBlob(hash, int64(len(value)))
+		}
+	}
+
+	// rename to never leave a partially written entry
+	if err := os.Rename(entryTemp, dc.path(key)); err != nil {
+		os.Remove(entryTemp)
+
+		if blob != nil && blob.refs == 0 {
+			dc.removeBlob(blob)
+		}
+
+		return
+	}
+
+	dc.index(key, size, expires, blob)
+
+	if time.Since(dc.lastSweep) > diskSweepInterval {
+		dc.sweep()
+	}
+
+	dc.evict()
+}
+
+// writeTemp writes data to a new temporary file of the cache and returns its
+// name.
+func (dc *DiskCache) writeTemp(data []byte) (string, bool) {
+	f, err := os.CreateTemp(dc.dir, diskTempPrefix+"*")
+	if err != nil {
+		return "", false
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", false
+	}
+
+	return f.Name(), true
+}
+
+// DeletePrefix implements Cache.
+func (dc *DiskCache) DeletePrefix(prefix string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for key, el := range dc.entries {
+		if strings.HasPrefix(key, prefix) {
+			dc.removeElement(el)
+		}
+	}
+}
+
+// addBlob adds the blob file with hash of size bytes to the index, without
+// entries yet. The lock must be held.
+func (dc *DiskCache) addBlob(hash string, size int64) *diskBlob {
+	blob := &diskBlob{hash: hash, size: size}
+
+	dc.blobs[hash] = blob
+	dc.size += size
+
+	return blob
+}
+
+// removeBlob removes the blob and its file. The lock must be held.
+func (dc *DiskCache) removeBlob(blob *diskBlob) {
+	os.Remove(dc.blobPath(blob.hash))
+	delete(dc.blobs, blob.hash)
+	dc.size -= blob.size
+}
+
+// index adds the entry of key with the value in blob (if any) to the index,
+// replacing the entry it had. The lock must be held.
+func (dc *DiskCache) index(key string, size int64, expires time.Time, blob *diskBlob) {
+	// held first, the replaced entry may have the same blob
+	if blob != nil {
+		blob.refs++
+	}
+
+	if el, ok := dc.entries[key]; ok {
+		dc.unindex(el)
+	}
+
+	e := &diskIndexEntry{key: key, size: size, blob: blob, expires: expires}
+
+	dc.entries[key] = dc.lru.PushFront(e)
+	dc.size += size
+	addSiteSize(dc.sites, key, e.valueSize())
+}
+
+// PrefixSize implements PrefixSizer, from the index. The size of an entry
+// is the size of its file and blob, blobs shared with other entries count
+// for every entry.
+func (dc *DiskCache) PrefixSize(prefix string) int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	return dc.sites[prefix]
+}
+
+// sweep removes the expired entries. The lock must be held.
+func (dc *DiskCache) sweep() {
+	now := time.Now()
+	dc.lastSweep = now
+
+	for _, el := range dc.entries {
+		if now.After(el.Value.(*diskIndexEntry).expires) {
+			dc.removeElement(el)
+		}
+	}
+}
+
+// evict removes the least recently used entries until the cache holds at
+// most its maximum size. The lock must be held.
+func (dc *DiskCache) evict() {
+	for dc.size > dc.maxSize && dc.lru.Len() > 0 {
+		dc.removeElement(dc.lru.Back())
+	}
+}
+
+// removeElement removes the entry of el and its file. The lock must be held.
+func (dc *DiskCache) removeElement(el *list.Element) {
+	os.Remove(dc.path(el.Value.(*diskIndexEntry).key))
+	dc.unindex(el)
+}
+
+// unindex removes the entry of el from the index, and its blob once no other
+// entry has it. The lock must be held.
+func (dc *DiskCache) unindex(el *list.Element) {
+	e := el.Value.(*diskIndexEntry)
+
+	dc.lru.Remove(el)
+	delete(dc.entries, e.key)
+	dc.size -= e.size
+	addSiteSize(dc.sites, e.key, -e.valueSize())
+
+	if e.blob == nil {
+		return
+	}
+
+	if e.blob.refs--; e.blob.refs == 0 {
+		dc.removeBlob(e.blob)
+	}
+}
+
+// remove removes the entry of key and its file.
+func (dc *DiskCache) remove(key string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if el, ok := dc.entries[key]; ok {
+		dc.removeElement(el)
+	}
+}
+
+// forget removes the entry of key from the index, its file was discarded.
+func (dc *DiskCache) forget(key string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if el, ok := dc.entries[key]; ok {
+		dc.unindex(el)
+	}
+}
+
+// addSiteSize adds delta to the size of the site of key in sites, the lock
+// of the cache must be held.
+func addSiteSize(sites map[string]int64, key string, delta int64) {
+	prefix := sitePrefix(key)
+
+	sites[prefix] += delta
+	if sites[prefix] == 0 {
+		delete(sites, prefix)
+	}
+}
+
+// Interface guards
+var (
+	_ Cache       = (*MemoryCache)(nil)
+	_ PrefixSizer = (*MemoryCache)(nil)
+	_ Shedder     = (*MemoryCache)(nil)
+	_ Cache       = (*DiskCache)(nil)
+	_ PrefixSizer = (*DiskCache)(nil)
+)
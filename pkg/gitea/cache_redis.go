@@ -0,0 +1,297 @@
+package gitea
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisKeyPrefix namespaces the keys of the cache in redis, the number is
+// the version of the format of their values.
+const redisKeyPrefix = "gitea-pages:2:"
+
+// redisBlobPrefix follows redisKeyPrefix in the keys of the values stored
+// by their hash. Owners can't have colons, so it's never a site prefix.
+const redisBlobPrefix = "blobs:"
+
+// redisBlobMinSize is the size of the smallest value stored by its hash,
+// smaller values are stored with their key.
+const redisBlobMinSize = 1 << 10
+
+// The kinds of value of an entry, its first byte: the value follows, or the
+// hash of the value stored by its hash.
+const (
+	redisValueInline = 'v'
+	redisValueBlob   = 'b'
+)
+
+// RedisCache is a Cache stored in redis, so it can be shared by several
+// caddy instances. Large values are stored once by their hash (e.g. the same
+// CSS framework in many forks), for as long as the last entry set with them;
+// they're left to expire when their entries are deleted.
+type RedisCache struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedisCache returns a cache stored in the redis server at addr.
+func NewRedisCache(addr, password string, db int) *RedisCache {
+	return &RedisCache{
+		addr:     addr,
+		password: password,
+		db:       db,
+		pool:     make(chan *redisConn, 8),
+	}
+}
+
+// Get implements Cache.
+func (rc *RedisCache) Get(key string) ([]byte, bool) {
+	v, err := rc.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		return nil, false
+	}
+
+	b, ok := v.([]byte)
+	if !ok || len(b) == 0 {
+		return nil, false
+	}
+
+	switch b[0] {
+	case redisValueInline:
+		return b[1:], true
+	case redisValueBlob:
+		v, err := rc.do("GET", redisKeyPrefix+redisBlobPrefix+string(b[1:]))
+		if err != nil {
+			return nil, false
+		}
+
+		value, ok := v.([]byte)
+
+		return value, ok
+	}
+
+	return nil, false
+}
+
+// Set implements Cache.
+func (rc *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	px := strconv.FormatInt(ttl.Milliseconds(), 10)
+
+	if len(value) < redisBlobMinSize {
+		_, _ = rc.do("SET", redisKeyPrefix+key, string(redisValueInline)+string(value), "PX", px)
+		return
+	}
+
+	sum := sha256.Sum256(value)
+	hash := hex.EncodeToString(sum[:])
+	blobKey := redisKeyPrefix + redisBlobPrefix + hash
+
+	// a value stored already gets the ttl of the entry, it isn't sent again
+	n, err := rc.do("PEXPIRE", blobKey, px)
+	if err != nil {
+		return
+	}
+
+	if n != int64(1) {
+		if _, err := rc.do("SET", blobKey, string(value), "PX", px); err != nil {
+			return
+		}
+	}
+
+	_, _ = rc.do("SET", redisKeyPrefix+key, string(redisValueBlob)+hash, "PX", px)
+}
+
+// DeletePrefix implements Cache.
+func (rc *RedisCache) DeletePrefix(prefix string) {
+	pattern := redisKeyPrefix + globEscaper.Replace(prefix) + "*"
+	cursor := "0"
+
+	for {
+		v, err := rc.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return
+		}
+
+		res, ok := v.([]any)
+		if !ok || len(res) != 2 {
+			return
+		}
+
+		next, _ := res[0].([]byte)
+		keys, _ := res[1].([]any)
+
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+
+			if _, err := rc.do(args...); err != nil {
+				return
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// do runs a redis command on a pooled connection.
+func (rc *RedisCache) do(args ...string) (any, error) {
+	var (
+		c   *redisConn
+		err error
+	)
+
+	select {
+	case c = <-rc.pool:
+	default:
+		c, err = rc.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := c.do(args...)
+
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		// the connection is broken
+		c.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case rc.pool <- c:
+	default:
+		c.conn.Close()
+	}
+
+	return v, err
+}
+
+func (rc *RedisCache) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", rc.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if rc.password != "" {
+		if _, err := c.do("AUTH", rc.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if rc.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(rc.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := io.WriteString(c.conn, sb.String()); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// read reads a reply, bulk strings are returned as []byte and arrays as []any.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		res := make([]any, n)
+		for i := range res {
+			if res[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Interface guards
+var (
+	_ Cache = (*RedisCache)(nil)
+)
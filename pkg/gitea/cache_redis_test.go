@@ -0,0 +1,172 @@
+package gitea
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the redis commands RedisCache uses from a map, ignoring
+// expiry.
+type fakeRedis struct {
+	net.Listener
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr := &fakeRedis{Listener: l, values: make(map[string]string)}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go fr.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+
+			args[i] = string(b[:size])
+		}
+
+		io.WriteString(conn, fr.do(args))
+	}
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func (fr *fakeRedis) do(args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if v, ok := fr.values[args[1]]; ok {
+			return bulk(v)
+		}
+
+		return "$-1\r\n"
+	case "SET":
+		fr.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "PEXPIRE":
+		if _, ok := fr.values[args[1]]; ok {
+			return ":1\r\n"
+		}
+
+		return ":0\r\n"
+	case "DEL":
+		for _, k := range args[1:] {
+			delete(fr.values, k)
+		}
+
+		return ":" + strconv.Itoa(len(args)-1) + "\r\n"
+	case "SCAN":
+		// only the prefix patterns of DeletePrefix
+		prefix := strings.NewReplacer(`\\`, `\`, `\*`, `*`, `\?`, `?`, `\[`, `[`, `\]`, `]`).
+			Replace(strings.TrimSuffix(args[3], "*"))
+
+		var keys []string
+		for k := range fr.values {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, bulk(k))
+			}
+		}
+
+		return "*2\r\n" + bulk("0") + "*" + strconv.Itoa(len(keys)) + "\r\n" + strings.Join(keys, "")
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisCacheSharesValues(t *testing.T) {
+	fr := newFakeRedis(t)
+	rc := NewRedisCache(fr.Addr().String(), "", 0)
+
+	value := strings.Repeat("x", 4<<10)
+
+	rc.Set("owner/repo/file/main:index.html", []byte("index"), time.Hour)
+	rc.Set("owner/repo/blob/x", []byte(value), time.Hour)
+	rc.Set("owner/fork/blob/x", []byte(value), time.Hour)
+
+	blobs := 0
+
+	for k, v := range fr.values {
+		if strings.HasPrefix(k, redisKeyPrefix+redisBlobPrefix) {
+			blobs++
+		} else if len(v) > redisBlobMinSize {
+			t.Errorf("%s holds the value", k)
+		}
+	}
+
+	if blobs != 1 {
+		t.Errorf("%d blobs of identical values, want 1", blobs)
+	}
+
+	for _, key := range []string{"owner/repo/blob/x", "owner/fork/blob/x"} {
+		if v, ok := rc.Get(key); !ok || string(v) != value {
+			t.Errorf("%s: %d bytes, %v", key, len(v), ok)
+		}
+	}
+
+	if v, ok := rc.Get("owner/repo/file/main:index.html"); !ok || string(v) != "index" {
+		t.Errorf("small value: %q, %v", v, ok)
+	}
+
+	// the entries of a purged repo are gone, the shared value isn't
+	rc.DeletePrefix("owner/repo/")
+
+	if _, ok := rc.Get("owner/repo/blob/x"); ok {
+		t.Error("purged entry read")
+	}
+
+	if v, ok := rc.Get("owner/fork/blob/x"); !ok || string(v) != value {
+		t.Errorf("shared value lost: %d bytes, %v", len(v), ok)
+	}
+}
@@ -0,0 +1,291 @@
+package gitea
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+
+	dc, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc.Set("owner/repo/file/main:index.html", []byte("index"), time.Hour)
+	dc.Set("owner/repo/blob/abc", []byte("blob"), time.Hour)
+	dc.Set("owner/other/file/main:index.html", []byte("other"), time.Hour)
+
+	if v, ok := dc.Get("owner/repo/file/main:index.html"); !ok || string(v) != "index" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+
+	if _, ok := dc.Get("owner/repo/file/main:missing.html"); ok {
+		t.Error("Get of a missing key")
+	}
+
+	// a Set in progress isn't read, let alone removed, by a purge
+	tmp := filepath.Join(dir, diskTempPrefix+"inflight")
+	if err := os.WriteFile(tmp, []byte("half"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dc.DeletePrefix("owner/repo/")
+
+	if _, err := os.Stat(tmp); err != nil {
+		t.Errorf("in-flight write removed: %v", err)
+	}
+
+	os.Remove(tmp)
+
+	for _, key := range []string{"owner/repo/file/main:index.html", "owner/repo/blob/abc"} {
+		if _, ok := dc.Get(key); ok {
+			t.Errorf("%s kept after purge", key)
+		}
+	}
+
+	if v, ok := dc.Get("owner/other/file/main:index.html"); !ok || string(v) != "other" {
+		t.Errorf("other repo purged: %q, %v", v, ok)
+	}
+
+	// the entries are kept when the cache is loaded again
+	dc, err = NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := dc.Get("owner/other/file/main:index.html"); !ok || string(v) != "other" {
+		t.Errorf("entry lost after reload: %q, %v", v, ok)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files, want 1", len(files))
+	}
+}
+
+func TestDiskCacheMaxSize(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 10<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// identical values would be stored once
+	value := func(c string) []byte {
+		return []byte(strings.Repeat(c, 3<<10))
+	}
+
+	dc.Set("owner/repo/blob/a", value("a"), time.Hour)
+	dc.Set("owner/repo/blob/b", value("b"), time.Hour)
+	dc.Set("owner/repo/blob/c", value("c"), time.Hour)
+
+	// a is used, so b is the least recently used
+	dc.Get("owner/repo/blob/a")
+	dc.Set("owner/repo/blob/d", value("d"), time.Hour)
+
+	if _, ok := dc.Get("owner/repo/blob/b"); ok {
+		t.Error("least recently used entry kept")
+	}
+
+	for _, key := range []string{"owner/repo/blob/a", "owner/repo/blob/c", "owner/repo/blob/d"} {
+		if _, ok := dc.Get(key); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+
+	if dc.size > dc.maxSize {
+		t.Errorf("size %d over %d", dc.size, dc.maxSize)
+	}
+
+	// values larger than the cache aren't stored
+	dc.Set("owner/repo/blob/large", make([]byte, 11<<10), time.Hour)
+
+	if _, ok := dc.Get("owner/repo/blob/large"); ok {
+		t.Error("value larger than the cache stored")
+	}
+}
+
+func TestDiskCacheSharesValues(t *testing.T) {
+	dir := t.TempDir()
+
+	dc, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte(strings.Repeat("x", 4<<10))
+
+	dc.Set("owner/repo/blob/x", value, time.Hour)
+	dc.Set("owner/fork/blob/x", value, time.Hour)
+
+	blobs := func() int {
+		names, _ := filepath.Glob(filepath.Join(dir, diskBlobPrefix+"*"))
+		return len(names)
+	}
+
+	if n := blobs(); n != 1 {
+		t.Fatalf("%d blobs of identical values, want 1", n)
+	}
+
+	if dc.size >= 2*int64(len(value)) {
+		t.Errorf("size %d, the value is counted twice", dc.size)
+	}
+
+	// the blob stays with the entries that have it, across reloads
+	dc.DeletePrefix("owner/repo/")
+
+	if dc, err = NewDiskCache(dir, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := dc.Get("owner/fork/blob/x"); !ok || string(v) != string(value) {
+		t.Errorf("shared value lost: %d bytes, %v", len(v), ok)
+	}
+
+	dc.DeletePrefix("owner/fork/")
+
+	if n := blobs(); n != 0 {
+		t.Errorf("%d blobs left without entries", n)
+	}
+
+	// a corrupted blob is fetched again
+	dc.Set("owner/repo/blob/x", value, time.Hour)
+
+	names, _ := filepath.Glob(filepath.Join(dir, diskBlobPrefix+"*"))
+	for _, name := range names {
+		if err := os.WriteFile(name, []byte("corrupted"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := dc.Get("owner/repo/blob/x"); ok {
+		t.Error("corrupted blob read")
+	}
+}
+
+func TestDiskCacheSweep(t *testing.T) {
+	dir := t.TempDir()
+
+	dc, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc.Set("owner/repo/blob/expired", []byte("expired"), -time.Second)
+
+	dc.lastSweep = time.Now().Add(-2 * diskSweepInterval)
+	dc.Set("owner/repo/blob/fresh", []byte("fresh"), time.Hour)
+
+	if len(dc.entries) != 1 {
+		t.Errorf("%d entries, want 1", len(dc.entries))
+	}
+
+	if _, err := os.Stat(dc.path("owner/repo/blob/expired")); !os.IsNotExist(err) {
+		t.Errorf("expired entry kept on disk: %v", err)
+	}
+}
+
+func TestDiskCacheDiscards(t *testing.T) {
+	dir := t.TempDir()
+
+	dc, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc.Set("owner/repo/blob/a", []byte("content"), time.Hour)
+	name := dc.path("owner/repo/blob/a")
+
+	// a corrupted entry is fetched again
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] ^= 0xff
+
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := dc.Get("owner/repo/blob/a"); ok {
+		t.Error("corrupted entry read")
+	}
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("corrupted entry kept: %v", err)
+	}
+
+	// files of another version and writes that didn't finish are removed
+	// when the cache is loaded
+	if err := os.WriteFile(filepath.Join(dir, strings.Repeat("0", 64)), []byte("gpc\x01old entry"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, diskTempPrefix+"crashed"), []byte("half"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDiskCache(dir, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left", len(files))
+	}
+}
+
+func TestPrefixSize(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cache := range map[string]interface {
+		Cache
+		PrefixSizer
+	}{
+		"memory": NewMemoryCache(1 << 20),
+		"disk":   dc,
+	} {
+		cache.Set("owner/repo/file/main:index.html", []byte("index"), time.Hour)
+		cache.Set("owner/repo/blob/a", []byte(strings.Repeat("a", 100)), time.Hour)
+		cache.Set("owner/other/blob/a", []byte(strings.Repeat("a", 100)), time.Hour)
+
+		repo, other := cache.PrefixSize("owner/repo/"), cache.PrefixSize("owner/other/")
+		if repo <= other || other < 100 {
+			t.Errorf("%s: sizes %d and %d", name, repo, other)
+		}
+
+		// replacing an entry counts it once
+		cache.Set("owner/repo/blob/a", []byte(strings.Repeat("a", 100)), time.Hour)
+
+		if got := cache.PrefixSize("owner/repo/"); got != repo {
+			t.Errorf("%s: size %d after replacing an entry, want %d", name, got, repo)
+		}
+
+		cache.DeletePrefix("owner/repo/")
+
+		if got := cache.PrefixSize("owner/repo/"); got != 0 {
+			t.Errorf("%s: size %d after purge, want 0", name, got)
+		}
+
+		if got := cache.PrefixSize("owner/other/"); got != other {
+			t.Errorf("%s: size of the other repo %d after purge, want %d", name, got, other)
+		}
+	}
+
+	// the index of the files left counts them again
+	dc, err = NewDiskCache(dc.dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := dc.PrefixSize("owner/other/"); got < 100 {
+		t.Errorf("size %d after reload", got)
+	}
+}
@@ -0,0 +1,140 @@
+package gitea
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// CacheKeySize is the size of the key the cached content of private repos is
+// encrypted with, see SetCacheEncryption.
+const CacheKeySize = 32
+
+// errCacheDecrypt is returned for cached content that can't be decrypted,
+// e.g. encrypted with another key.
+var errCacheDecrypt = errors.New("cached content can't be decrypted")
+
+// SetCacheEncryption encrypts the content of private (and internal) repos in
+// the cache with AES-256-GCM, under a key derived from key for every repo, so
+// a leaked cache (e.g. the directory of a disk cache) doesn't expose it. The
+// keys of the entries (the paths of the files) and their etags aren't
+// encrypted. Content cached with another key is fetched again.
+func SetCacheEncryption(key []byte) ClientOption {
+	return func(c *Client) error {
+		if len(key) != CacheKeySize {
+			return fmt.Errorf("the cache key must be %d bytes, not %d", CacheKeySize, len(key))
+		}
+
+		c.cacheCipher = &cacheCipher{key: append([]byte(nil), key...)}
+
+		return nil
+	}
+}
+
+// cacheCipher encrypts the cached content of the private repos.
+type cacheCipher struct {
+	key []byte
+}
+
+// repoKey returns the key of owner/repo, derived from the key of the cache.
+func (cc *cacheCipher) repoKey(owner, repo string) []byte {
+	mac := hmac.New(sha256.New, cc.key)
+	mac.Write([]byte("cache:" + repoKey(owner, repo)))
+
+	return mac.Sum(nil)
+}
+
+// hash returns the hash content is cached under for owner/repo, keyed so it
+// doesn't tell if the repo has a known file.
+func (cc *cacheCipher) hash(owner, repo string, content []byte) string {
+	mac := hmac.New(sha256.New, cc.repoKey(owner, repo))
+	mac.Write(content)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (cc *cacheCipher) aead(owner, repo string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cc.repoKey(owner, repo))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts content of owner/repo cached under key, the nonce first.
+func (cc *cacheCipher) seal(owner, repo, key string, content []byte) ([]byte, error) {
+	aead, err := cc.aead(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(content)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// the key is authenticated, the content can't be moved to another entry
+	return aead.Seal(nonce, nonce, content, []byte(key)), nil
+}
+
+// open decrypts the sealed content of owner/repo cached under key.
+func (cc *cacheCipher) open(owner, repo, key string, sealed []byte) ([]byte, error) {
+	aead, err := cc.aead(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errCacheDecrypt
+	}
+
+	content, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, errCacheDecrypt
+	}
+
+	return content, nil
+}
+
+// privateKey is the key of the access cache entry of whether owner/repo is
+// private.
+func privateKey(owner, repo string) string {
+	return "private:" + repoKey(owner, repo)
+}
+
+// repoPrivate reports if owner/repo is private or internal.
+func (c *Client) repoPrivate(ctx context.Context, owner, repo string) (bool, error) {
+	key := privateKey(owner, repo)
+
+	e, ok := c.access.get(key)
+	if !ok {
+		r, err := c.repoMeta(ctx, owner, repo)
+		if err != nil {
+			return false, err
+		}
+
+		e = accessEntry{ok: r.Private || r.Internal}
+		c.access.set(key, e)
+	}
+
+	return e.ok, nil
+}
+
+// encryptCached reports if the content of owner/repo is encrypted in the
+// cache. A repo that can't be looked up is taken as private.
+func (c *Client) encryptCached(ctx context.Context, owner, repo string) bool {
+	if c.cacheCipher == nil {
+		return false
+	}
+
+	private, err := c.repoPrivate(ctx, owner, repo)
+
+	return err != nil || private
+}
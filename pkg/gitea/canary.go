@@ -0,0 +1,39 @@
+package gitea
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// CanaryCookie is the cookie that keeps a visitor on the canary or the stable
+// version of a site.
+const CanaryCookie = "gitea-pages-canary"
+
+// The variants of a site with a canary.
+const (
+	VariantStable = "stable"
+	VariantCanary = "canary"
+)
+
+// canary routes a percentage of the visitors of a site to another ref.
+type canary struct {
+	ref     string
+	percent int
+}
+
+// variant returns the variant of the site the visitor sending header sees,
+// the one of its cookie or a random one for new visitors.
+func (cn *canary) variant(header http.Header) string {
+	if cookie, err := (&http.Request{Header: header}).Cookie(CanaryCookie); err == nil {
+		switch cookie.Value {
+		case VariantStable, VariantCanary:
+			return cookie.Value
+		}
+	}
+
+	if rand.Intn(100) < cn.percent {
+		return VariantCanary
+	}
+
+	return VariantStable
+}
@@ -0,0 +1,86 @@
+package gitea
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrChaos is the connection error injected into requests to gitea.
+var ErrChaos = errors.New("chaos: injected gitea connection error")
+
+// Chaos injects faults into the requests to gitea, to validate the cache,
+// serve stale and timeout configuration before a real gitea outage.
+type Chaos struct {
+	// Latency is added to LatencyPercent percent of the requests.
+	Latency        time.Duration
+	LatencyPercent float64
+	// ErrorPercent percent of the requests fail with ErrorStatus, or with
+	// a connection error if it's 0.
+	ErrorPercent float64
+	ErrorStatus  int
+}
+
+// chaosTransport is a http.RoundTripper that injects the faults of chaos.
+type chaosTransport struct {
+	chaos Chaos
+	next  http.RoundTripper
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.chaos.Latency > 0 && rand.Float64()*100 < t.chaos.LatencyPercent {
+		timer := time.NewTimer(t.chaos.Latency)
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rand.Float64()*100 >= t.chaos.ErrorPercent {
+		return t.next.RoundTrip(req)
+	}
+
+	if t.chaos.ErrorStatus == 0 {
+		return nil, ErrChaos
+	}
+
+	return &http.Response{
+		Status:     http.StatusText(t.chaos.ErrorStatus),
+		StatusCode: t.chaos.ErrorStatus,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader("chaos: injected gitea error\n")),
+		Request:    req,
+	}, nil
+}
+
+// SetChaos injects the faults of chaos into all requests to gitea. It's a
+// debug option, never enable it on a server that serves real visitors.
+func SetChaos(chaos Chaos) ClientOption {
+	return func(c *Client) error {
+		if chaos.LatencyPercent < 0 || chaos.LatencyPercent > 100 || chaos.ErrorPercent < 0 || chaos.ErrorPercent > 100 {
+			return errors.New("chaos percentages must be between 0 and 100")
+		}
+
+		c.logger.Warn("injecting faults into gitea requests",
+			zap.Duration("latency", chaos.Latency), zap.Float64("latency_percent", chaos.LatencyPercent),
+			zap.Float64("error_percent", chaos.ErrorPercent), zap.Int("error_status", chaos.ErrorStatus))
+
+		c.httpClient.Transport = &chaosTransport{
+			chaos: chaos,
+			next:  c.httpClient.Transport,
+		}
+
+		return nil
+	}
+}
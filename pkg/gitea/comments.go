@@ -0,0 +1,188 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gclient "code.gitea.io/sdk/gitea"
+)
+
+const (
+	// commentsTTL is how long the comments of an issue are cached.
+	commentsTTL = time.Minute
+	// maxComments is the number of comments of an issue shown.
+	maxComments = 200
+)
+
+// PageComment is a comment on a page, a comment of the issue of the page.
+type PageComment struct {
+	Author    string    `json:"author"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+	Body      string    `json:"body"`
+	Created   time.Time `json:"created"`
+	URL       string    `json:"url,omitempty"`
+}
+
+// PageComments are the comments on a page.
+type PageComments struct {
+	// IssueURL is the issue on gitea to comment on.
+	IssueURL string        `json:"issue_url"`
+	Comments []PageComment `json:"comments"`
+}
+
+// commentsConfig is the comments section of the config of a repo, mapping
+// the paths of pages to the issues with their comments:
+//
+//	[comments]
+//	repo="blog-comments"
+//
+//	[comments.issues]
+//	"/posts/hello/"=12
+//
+// repo is a repo of the same owner, the repo of the site by default.
+type commentsConfig struct {
+	repo   string
+	issues map[string]int64
+}
+
+// commentsSettings returns the comments config of the repo config cfg, or
+// nil if its pages have no comments.
+func commentsSettings(cfg *repoConfig) *commentsConfig {
+	if cfg == nil || !cfg.IsSet("comments.issues") {
+		return nil
+	}
+
+	cc := &commentsConfig{
+		repo:   cfg.GetString("comments.repo"),
+		issues: make(map[string]int64),
+	}
+
+	for p, issue := range cfg.GetStringMap("comments.issues") {
+		if index, err := strconv.ParseInt(fmt.Sprint(issue), 10, 64); err == nil && index > 0 {
+			cc.issues[commentsKey(p)] = index
+		}
+	}
+
+	return cc
+}
+
+// commentsKey returns the path p of a page without what pretty urls make
+// optional, so /posts/hello/, /posts/hello/index.html and /posts/hello.html
+// are the same page.
+func commentsKey(p string) string {
+	p = strings.Trim(unescapePath(p), "/")
+	p = strings.TrimSuffix(strings.TrimSuffix(p, "index.html"), "/")
+
+	if ext := path.Ext(p); ext == ".html" || ext == ".md" {
+		p = strings.TrimSuffix(p, ext)
+	}
+
+	return p
+}
+
+// commentCache are the comments of issues fetched from gitea.
+type commentCache struct {
+	mu      sync.Mutex
+	entries map[string]commentEntry
+}
+
+type commentEntry struct {
+	comments []PageComment
+	fetched  time.Time
+}
+
+// Comments returns the comments on the page p of the site name at ref,
+// those of the issue the config of the site maps the page to. They're
+// fetched from gitea at most once a minute.
+func (c *Client) Comments(ctx context.Context, name, ref, p string) (*PageComments, error) {
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.comments == nil {
+		return nil, fs.ErrNotExist
+	}
+
+	index, ok := s.comments.issues[commentsKey(p)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	// the token may read any repo, sites only show comments of their owner's
+	repo := s.repo
+	if s.comments.repo != "" && !strings.Contains(s.comments.repo, "/") {
+		repo = s.comments.repo
+	}
+
+	comments, err := c.issueComments(s.owner, repo, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PageComments{
+		IssueURL: c.serverURL + "/" + s.owner + "/" + repo + "/issues/" + strconv.FormatInt(index, 10),
+		Comments: comments,
+	}, nil
+}
+
+// issueComments returns the comments of the issue index of owner/repo,
+// rendered as sanitized html.
+func (c *Client) issueComments(owner, repo string, index int64) ([]PageComment, error) {
+	key := owner + "/" + repo + "#" + strconv.FormatInt(index, 10)
+
+	c.comments.mu.Lock()
+	e, ok := c.comments.entries[key]
+	c.comments.mu.Unlock()
+
+	if ok && time.Since(e.fetched) < commentsTTL {
+		return e.comments, nil
+	}
+
+	comments := []PageComment{}
+
+	for page := 1; len(comments) < maxComments; page++ {
+		list, _, err := c.gc.ListIssueComments(owner, repo, index, gclient.ListIssueCommentOptions{
+			ListOptions: gclient.ListOptions{Page: page, PageSize: 50},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range list {
+			body, err := markdown([]byte(comment.Body))
+			if err != nil {
+				return nil, err
+			}
+
+			pc := PageComment{
+				// comments are written by anyone, they're always sanitized
+				Body:    string(sanitizePage(body, SanitizeStrict)),
+				Created: comment.Created,
+				URL:     comment.HTMLURL,
+			}
+
+			if comment.Poster != nil {
+				pc.Author, pc.AvatarURL = comment.Poster.UserName, comment.Poster.AvatarURL
+			}
+
+			comments = append(comments, pc)
+		}
+
+		if len(list) < 50 {
+			break
+		}
+	}
+
+	c.comments.mu.Lock()
+	c.comments.entries[key] = commentEntry{comments: comments, fetched: time.Now()}
+	c.comments.mu.Unlock()
+
+	return comments, nil
+}
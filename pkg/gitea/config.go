@@ -0,0 +1,234 @@
+package gitea
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/net/http/httpguts"
+)
+
+// defaultIndexFiles are the files served for a directory of a site.
+var defaultIndexFiles = []string{"index.html"}
+
+// reservedHeaders are the response headers a repo config can't set, they
+// describe the content or are set by the server.
+var reservedHeaders = map[string]bool{
+	"Accept-Ranges":     true,
+	"Age":               true,
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Content-Type":      true,
+	"Date":              true,
+	"Etag":              true,
+	"Keep-Alive":        true,
+	"Last-Modified":     true,
+	"Location":          true,
+	"Set-Cookie":        true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Vary":              true,
+	"Warning":           true,
+}
+
+// repoConfig is the parsed config of a repo, from its gitea-pages.toml or its
+// description. Every repo has its own, a nil config is a repo without one.
+//
+//	defaultref = "main"
+//	indexfiles = ["index.html", "index.htm"]
+//	canonicaldomain = "www.example.org"
+//	secondarydomains = "redirect"
+//
+//	[headers]
+//	Content-Security-Policy = "default-src 'self'"
+//	Access-Control-Allow-Origin = "*"
+type repoConfig struct {
+	*viper.Viper
+
+	// headers are set on every response of the site
+	headers http.Header
+	// indexFiles are the files served for a directory, the first that exists
+	indexFiles []string
+	// defaultRef is served when no ref is asked for, instead of the default
+	// branch
+	defaultRef string
+	// canonicalDomain is the primary custom domain of the site, the others
+	// are its secondaries, which redirect to it if redirectSecondary is set
+	// (or else mirror it)
+	canonicalDomain   string
+	redirectSecondary bool
+}
+
+// configCache are the parsed configs of the repos, a config is parsed again
+// when its content changes.
+type configCache struct {
+	mu      sync.Mutex
+	entries map[string]configEntry
+}
+
+type configEntry struct {
+	sum [sha256.Size]byte
+	cfg *repoConfig
+	err error
+}
+
+// repoConfig returns the parsed config content of owner/repo.
+func (c *Client) repoConfig(owner, repo string, content []byte) (*repoConfig, error) {
+	key := strings.ToLower(owner + "/" + repo)
+	sum := sha256.Sum256(content)
+
+	c.configs.mu.Lock()
+	e, ok := c.configs.entries[key]
+	c.configs.mu.Unlock()
+
+	if ok && e.sum == sum {
+		return e.cfg, e.err
+	}
+
+	e = configEntry{sum: sum}
+	e.cfg, e.err = c.parseConfig(owner, repo, content)
+
+	c.configs.mu.Lock()
+	c.configs.entries[key] = e
+	c.configs.mu.Unlock()
+
+	return e.cfg, e.err
+}
+
+// forget drops the parsed config of owner/repo.
+func (cc *configCache) forget(owner, repo string) {
+	cc.mu.Lock()
+	delete(cc.entries, strings.ToLower(owner+"/"+repo))
+	cc.mu.Unlock()
+}
+
+// parseConfig parses the toml config content of owner/repo. Invalid options
+// are logged and left out, only a config that isn't toml is an error.
+func (c *Client) parseConfig(owner, repo string, content []byte) (*repoConfig, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, err
+	}
+
+	cfg := &repoConfig{Viper: v, indexFiles: defaultIndexFiles}
+
+	invalid := func(option string, err error) {
+		c.logger.Warn("invalid config option", zap.String("owner", owner), zap.String("repo", repo),
+			zap.String("option", option), zap.Error(err))
+	}
+
+	if v.IsSet("headers") {
+		cfg.headers = http.Header{}
+
+		for name, value := range v.GetStringMapString("headers") {
+			if err := checkConfigHeader(name, value); err != nil {
+				invalid("headers", err)
+				continue
+			}
+
+			cfg.headers.Set(name, value)
+		}
+	}
+
+	if v.IsSet("indexfiles") {
+		var files []string
+
+		for _, f := range v.GetStringSlice("indexfiles") {
+			if f == "" || strings.Contains(f, "/") || !validFilepath(f) {
+				invalid("indexfiles", fmt.Errorf("%q isn't a file name", f))
+				continue
+			}
+
+			files = append(files, f)
+		}
+
+		if len(files) > 0 {
+			cfg.indexFiles = files
+		}
+	}
+
+	if ref := v.GetString("defaultref"); ref != "" {
+		switch {
+		case !validRef(ref):
+			invalid("defaultref", fmt.Errorf("%q isn't a valid ref", ref))
+		case !cfg.allowsRef(ref):
+			invalid("defaultref", fmt.Errorf("%q isn't in allowedrefs", ref))
+		default:
+			cfg.defaultRef = ref
+		}
+	}
+
+	if domain := v.GetString("canonicaldomain"); domain != "" {
+		ascii, err := c.NormalizeDomain(domain)
+		if err != nil {
+			invalid("canonicaldomain", err)
+		} else {
+			cfg.canonicalDomain = ascii
+		}
+	}
+
+	switch mode := v.GetString("secondarydomains"); mode {
+	case "", "mirror":
+	case "redirect":
+		cfg.redirectSecondary = true
+	default:
+		invalid("secondarydomains", fmt.Errorf("%q isn't mirror or redirect", mode))
+	}
+
+	return cfg, nil
+}
+
+// checkConfigHeader returns an error if a config can't set the response
+// header name to value.
+func checkConfigHeader(name, value string) error {
+	if !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("%q isn't a header name", name)
+	}
+
+	if reservedHeaders[http.CanonicalHeaderKey(name)] {
+		return fmt.Errorf("header %q can't be set", name)
+	}
+
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("invalid value of header %q", name)
+	}
+
+	return nil
+}
+
+// allowsRef reports if the allowedrefs of the config expose ref.
+func (cfg *repoConfig) allowsRef(ref string) bool {
+	if cfg == nil {
+		return false
+	}
+
+	for _, r := range cfg.GetStringSlice("allowedrefs") {
+		if r == ref || r == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// siteHeaders returns the response headers of name for the site: those of
+// its config and the web app headers.
+func siteHeaders(s *site, name string) http.Header {
+	h := webAppHeaders(s, name)
+
+	for k, v := range s.headers {
+		h[k] = v
+	}
+
+	return h
+}
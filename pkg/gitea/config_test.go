@@ -0,0 +1,214 @@
+package gitea
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+
+		headers           http.Header
+		indexFiles        []string
+		defaultRef        string
+		canonicalDomain   string
+		redirectSecondary bool
+
+		// invalid are the errors logged for the invalid options
+		invalid []string
+	}{
+		{
+			name:       "empty",
+			indexFiles: defaultIndexFiles,
+		},
+		{
+			name: "all options",
+			content: `allowedrefs = ["main", "v1"]
+defaultref = "v1"
+indexfiles = ["index.html", "index.htm"]
+canonicaldomain = "WWW.Example.org."
+secondarydomains = "redirect"
+
+[headers]
+Content-Security-Policy = "default-src 'self'"
+access-control-allow-origin = "*"
+`,
+			headers: http.Header{
+				"Content-Security-Policy":     {"default-src 'self'"},
+				"Access-Control-Allow-Origin": {"*"},
+			},
+			indexFiles:        []string{"index.html", "index.htm"},
+			defaultRef:        "v1",
+			canonicalDomain:   "www.example.org",
+			redirectSecondary: true,
+		},
+		{
+			name:       "mirrored secondary domains",
+			content:    `secondarydomains = "mirror"`,
+			indexFiles: defaultIndexFiles,
+		},
+		{
+			name: "reserved and invalid headers",
+			content: `[headers]
+Content-Type = "text/plain"
+"bad name" = "x"
+X-Frame-Options = "DENY"
+X-Split = "a\nb"
+`,
+			headers:    http.Header{"X-Frame-Options": {"DENY"}},
+			indexFiles: defaultIndexFiles,
+			invalid: []string{
+				`header "content-type" can't be set`,
+				`"bad name" isn't a header name`,
+				`invalid value of header "x-split"`,
+			},
+		},
+		{
+			name:       "index files outside the directory",
+			content:    `indexfiles = ["../index.html", "sub/index.html", ""]`,
+			indexFiles: defaultIndexFiles,
+			invalid: []string{
+				`"../index.html" isn't a file name`,
+				`"sub/index.html" isn't a file name`,
+				`"" isn't a file name`,
+			},
+		},
+		{
+			name: "some valid index files",
+			content: `indexfiles = ["..", "README.html"]
+`,
+			indexFiles: []string{"README.html"},
+			invalid:    []string{`".." isn't a file name`},
+		},
+		{
+			name:       "invalid default ref",
+			content:    `allowedrefs = ["*"]` + "\n" + `defaultref = "../main"`,
+			indexFiles: defaultIndexFiles,
+			invalid:    []string{`"../main" isn't a valid ref`},
+		},
+		{
+			name:       "default ref not allowed",
+			content:    `allowedrefs = ["main"]` + "\n" + `defaultref = "dev"`,
+			indexFiles: defaultIndexFiles,
+			invalid:    []string{`"dev" isn't in allowedrefs`},
+		},
+		{
+			name:       "invalid canonical domain",
+			content:    `canonicaldomain = "exa mple.org"`,
+			indexFiles: defaultIndexFiles,
+			invalid:    []string{`invalid domain "exa mple.org"`},
+		},
+		{
+			name:       "unknown secondary domains mode",
+			content:    `secondarydomains = "proxy"`,
+			indexFiles: defaultIndexFiles,
+			invalid:    []string{`"proxy" isn't mirror or redirect`},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+
+			c, err := NewClient("http://gitea.invalid", "", "", "", SetLogger(zap.New(core)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := c.parseConfig("owner", "repo", []byte(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(cfg.headers) > 0 || len(tt.headers) > 0 {
+				if !reflect.DeepEqual(cfg.headers, tt.headers) {
+					t.Errorf("headers = %v, want %v", cfg.headers, tt.headers)
+				}
+			}
+
+			if !reflect.DeepEqual(cfg.indexFiles, tt.indexFiles) {
+				t.Errorf("index files = %q, want %q", cfg.indexFiles, tt.indexFiles)
+			}
+
+			if cfg.defaultRef != tt.defaultRef {
+				t.Errorf("default ref = %q, want %q", cfg.defaultRef, tt.defaultRef)
+			}
+
+			if cfg.canonicalDomain != tt.canonicalDomain {
+				t.Errorf("canonical domain = %q, want %q", cfg.canonicalDomain, tt.canonicalDomain)
+			}
+
+			if cfg.redirectSecondary != tt.redirectSecondary {
+				t.Errorf("redirect secondary = %v, want %v", cfg.redirectSecondary, tt.redirectSecondary)
+			}
+
+			var invalid []string
+
+			for _, e := range logs.All() {
+				invalid = append(invalid, e.ContextMap()["error"].(string))
+			}
+
+			if len(invalid) != len(tt.invalid) {
+				t.Fatalf("invalid options %q, want %q", invalid, tt.invalid)
+			}
+
+			for _, want := range tt.invalid {
+				found := false
+
+				for _, got := range invalid {
+					found = found || strings.Contains(got, want)
+				}
+
+				if !found {
+					t.Errorf("invalid options %q, want %q", invalid, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfigNotTOML(t *testing.T) {
+	c, err := NewClient("http://gitea.invalid", "", "", "", SetLogger(zap.NewNop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.parseConfig("owner", "repo", []byte("allowedrefs = [")); err == nil {
+		t.Error("config that isn't toml parsed")
+	}
+}
+
+func TestCheckConfigHeader(t *testing.T) {
+	tests := []struct {
+		name, value string
+		err         string
+	}{
+		{name: "X-Robots-Tag", value: "noindex"},
+		{name: "Cache-Control", value: "max-age=60"},
+		{name: "Set-Cookie", value: "a=b", err: `header "Set-Cookie" can't be set`},
+		{name: "etag", value: `"x"`, err: `header "etag" can't be set`},
+		{name: "X:Bad", value: "x", err: `"X:Bad" isn't a header name`},
+		{name: "X-Bad", value: "a\r\nSet-Cookie: a=b", err: `invalid value of header "X-Bad"`},
+	}
+
+	for _, tt := range tests {
+		err := checkConfigHeader(tt.name, tt.value)
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%s: error %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
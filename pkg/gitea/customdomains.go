@@ -0,0 +1,494 @@
+package gitea
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cnameFile is the file in the root of a site with its custom domains, one
+// per line.
+const cnameFile = "CNAME"
+
+// websiteDomain is the value of the pages:domain topic claiming the host of
+// the website of the repo, a repo setting that isn't limited to the length
+// of a topic and isn't committed to the content.
+const websiteDomain = "website"
+
+const (
+	// customDomainTTL is how long the site of a custom domain is known.
+	customDomainTTL = 5 * time.Minute
+	// unclaimedDomainTTL is how long a domain no site claims is known.
+	unclaimedDomainTTL = time.Minute
+)
+
+// ErrUnclaimedDomain is returned for custom domains no site claims.
+var ErrUnclaimedDomain = errors.New("domain isn't claimed by a site")
+
+// UnclaimedDomainError is returned for a custom domain that points to the
+// site of Owner/Repo (an empty Repo is the gitea-pages repo of Owner), which
+// doesn't claim it. It wraps ErrUnclaimedDomain.
+type UnclaimedDomainError struct {
+	Owner string
+	Repo  string
+}
+
+func (e *UnclaimedDomainError) Error() string {
+	return ErrUnclaimedDomain.Error()
+}
+
+func (e *UnclaimedDomainError) Unwrap() error {
+	return ErrUnclaimedDomain
+}
+
+// lookupCNAME and lookupTXT resolve the DNS records of custom domains.
+var (
+	lookupCNAME = net.DefaultResolver.LookupCNAME
+	lookupTXT   = net.DefaultResolver.LookupTXT
+)
+
+// SetCustomDomains serves sites on the custom domains they claim. A custom
+// domain points to the site <repo>.<owner>.<pagesDomain> (or the gitea-pages
+// repo at <owner>.<pagesDomain>) with a DNS CNAME record, or a TXT record for
+// apex domains.
+func SetCustomDomains(pagesDomain string) ClientOption {
+	return func(c *Client) error {
+		c.domains = &domainMap{
+			pagesDomain: strings.Trim(NormalizeHost(pagesDomain), "."),
+			hosts:       make(map[string]domainEntry),
+			canonical:   make(map[string]canonicalEntry),
+		}
+
+		return nil
+	}
+}
+
+// domainMap maps custom domains to the sites that claim them.
+type domainMap struct {
+	pagesDomain string
+	// store keeps the claimed domains across restarts, if set
+	store Store
+
+	mu    sync.Mutex
+	hosts map[string]domainEntry
+	// canonical are the canonical domains of the sites, by owner/repo
+	canonical map[string]canonicalEntry
+}
+
+// domainEntry is the site a domain points to, which claims it unless
+// unclaimed is set.
+type domainEntry struct {
+	owner     string
+	repo      string
+	unclaimed bool
+	expires   time.Time
+}
+
+type canonicalEntry struct {
+	domain  string
+	expires time.Time
+}
+
+// storedDomain is a claimed domain in the store.
+type storedDomain struct {
+	Owner   string    `json:"owner"`
+	Repo    string    `json:"repo,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+func (dm *domainMap) get(host string) (domainEntry, bool) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	e, ok := dm.hosts[host]
+	if ok && time.Now().After(e.expires) {
+		delete(dm.hosts, host)
+		return domainEntry{}, false
+	}
+
+	if !ok && dm.store != nil {
+		var sd storedDomain
+		if err := loadJSON(dm.store, domainsKeyPrefix+host, &sd); err == nil && time.Now().Before(sd.Expires) {
+			e = domainEntry{owner: sd.Owner, repo: sd.Repo, expires: sd.Expires}
+			dm.hosts[host] = e
+
+			return e, true
+		}
+	}
+
+	return e, ok
+}
+
+func (dm *domainMap) set(host string, e domainEntry, ttl time.Duration) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	e.expires = time.Now().Add(ttl)
+	dm.hosts[host] = e
+
+	// unclaimed domains are only known for a short while
+	if dm.store != nil && e.owner != "" && !e.unclaimed {
+		value, _ := json.Marshal(storedDomain{Owner: e.owner, Repo: e.repo, Expires: e.expires})
+		_ = dm.store.Store(domainsKeyPrefix+host, value)
+	}
+}
+
+// forget forgets the custom domains of owner/repo, they may have changed.
+// The domains of the gitea-pages repo of the owner are forgotten too.
+func (dm *domainMap) forget(owner, repo string) {
+	if dm == nil {
+		return
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	for host, e := range dm.hosts {
+		if strings.EqualFold(e.owner, owner) && (e.repo == "" || strings.EqualFold(e.repo, repo)) {
+			delete(dm.hosts, host)
+
+			if dm.store != nil && !e.unclaimed {
+				_ = dm.store.Delete(domainsKeyPrefix + host)
+			}
+		}
+	}
+
+	// the canonical domains of the owner may be claimed by the gitea-pages
+	// repo, they're few
+	for site := range dm.canonical {
+		if strings.HasPrefix(site, strings.ToLower(owner)+"/") {
+			delete(dm.canonical, site)
+		}
+	}
+}
+
+// CustomDomain returns the owner and repo of the site the custom domain host
+// points to, if the site claims it. It returns ErrUnclaimedDomain otherwise.
+func (c *Client) CustomDomain(host string) (string, string, error) {
+	if c.domains == nil {
+		return "", "", ErrUnclaimedDomain
+	}
+
+	host, err := c.NormalizeDomain(host)
+	if err != nil {
+		return "", "", ErrUnclaimedDomain
+	}
+
+	if e, ok := c.domains.get(host); ok {
+		if e.unclaimed {
+			return "", "", unclaimedDomain(e.owner, e.repo)
+		}
+
+		return e.owner, e.repo, nil
+	}
+
+	owner, repo, ok := c.domainTarget(host)
+	if ok {
+		ok, err = c.claimsDomain(owner, repo, host)
+		if err != nil {
+			// gitea may be down, ask again next time
+			return "", "", err
+		}
+	}
+
+	if !ok {
+		c.domains.set(host, domainEntry{owner: owner, repo: repo, unclaimed: true}, unclaimedDomainTTL)
+		return "", "", unclaimedDomain(owner, repo)
+	}
+
+	c.domains.set(host, domainEntry{owner: owner, repo: repo}, customDomainTTL)
+
+	return owner, repo, nil
+}
+
+// unclaimedDomain returns the error of a domain that points to the site of
+// owner/repo, or to no site if owner is empty.
+func unclaimedDomain(owner, repo string) error {
+	if owner == "" {
+		return ErrUnclaimedDomain
+	}
+
+	return &UnclaimedDomainError{Owner: owner, Repo: repo}
+}
+
+// CanonicalDomain returns the custom domain of the site serving name
+// (owner/repo/filepath) and the path of name on it, "" if there's none.
+// The canonical domain is the canonicaldomain of its config, or the first
+// domain the site claims, if it points to the site.
+func (c *Client) CanonicalDomain(ctx context.Context, name string) (string, string, error) {
+	if c.domains == nil {
+		return "", "", nil
+	}
+
+	s, err := c.resolve(ctx, name, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	domain, err := c.siteCanonicalDomain(s)
+
+	return domain, s.filepath, err
+}
+
+// SecondaryRedirect returns the canonical domain the custom domain host
+// redirects to, for the site serving name on it, and the path of name
+// there. The domain is "" if host is the canonical domain or the site serves
+// its secondary domains as mirrors.
+func (c *Client) SecondaryRedirect(ctx context.Context, host, name string) (string, string, error) {
+	if c.domains == nil {
+		return "", "", nil
+	}
+
+	s, err := c.resolve(ctx, name, "")
+	if err != nil || !s.redirectSecondary {
+		return "", "", err
+	}
+
+	// the domain of the gitea-pages repo serves the other repos of the owner
+	// below it, which aren't its secondaries
+	if owner, repo, err := c.CustomDomain(host); err != nil || !c.pointsTo(owner, repo, s) {
+		return "", "", nil
+	}
+
+	domain, err := c.siteCanonicalDomain(s)
+	if err != nil || domain == "" || domain == NormalizeHost(host) {
+		return "", "", err
+	}
+
+	return domain, s.filepath, nil
+}
+
+// siteCanonicalDomain returns the canonical domain of the site s, looked up
+// for customDomainTTL.
+func (c *Client) siteCanonicalDomain(s *site) (string, error) {
+	key := strings.ToLower(s.owner + "/" + s.repo)
+
+	c.domains.mu.Lock()
+	e, ok := c.domains.canonical[key]
+	c.domains.mu.Unlock()
+
+	if ok && time.Now().Before(e.expires) {
+		return e.domain, nil
+	}
+
+	domain, err := c.canonicalDomain(s)
+	if err != nil {
+		return "", err
+	}
+
+	c.domains.mu.Lock()
+	c.domains.canonical[key] = canonicalEntry{domain: domain, expires: time.Now().Add(customDomainTTL)}
+	c.domains.mu.Unlock()
+
+	return domain, nil
+}
+
+// canonicalDomain returns the canonical domain of the site s if it claims
+// it, or else the first domain it claims, if it points to the site.
+func (c *Client) canonicalDomain(s *site) (string, error) {
+	domains, err := c.siteDomains(s)
+	if err != nil {
+		return "", err
+	}
+
+	// the canonical domain is only served if the site claims it too
+	if s.canonicalDomain != "" {
+		domains = append([]string{s.canonicalDomain}, domains...)
+	}
+
+	for _, domain := range domains {
+		owner, repo, err := c.CustomDomain(domain)
+		if errors.Is(err, ErrUnclaimedDomain) {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if c.pointsTo(owner, repo, s) {
+			return domain, nil
+		}
+	}
+
+	return "", nil
+}
+
+// pointsTo reports if a custom domain pointing to owner/repo serves the site
+// s, the domain of the gitea-pages repo of the owner points to its sites.
+func (c *Client) pointsTo(owner, repo string, s *site) bool {
+	if !strings.EqualFold(owner, s.owner) {
+		return false
+	}
+
+	return strings.EqualFold(repo, s.repo) || repo == "" && s.repo == c.names(s.owner).repo
+}
+
+// domainTarget returns the owner and repo of the site below the pages domain
+// the CNAME record of host points to, or one of its TXT records for apex
+// domains, which can't have a CNAME record.
+func (c *Client) domainTarget(host string) (string, string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var targets []string
+
+	if cname, err := lookupCNAME(ctx, host); err == nil {
+		targets = append(targets, cname)
+	}
+
+	if txts, err := lookupTXT(ctx, host); err == nil {
+		targets = append(targets, txts...)
+	}
+
+	for _, target := range targets {
+		target = strings.Trim(strings.ToLower(strings.TrimSpace(target)), ".")
+
+		labels, ok := strings.CutSuffix(target, "."+c.domains.pagesDomain)
+		if !ok {
+			continue
+		}
+
+		switch parts := strings.Split(labels, "."); len(parts) {
+		case 1:
+			return parts[0], "", true
+		case 2:
+			return parts[1], parts[0], true
+		}
+	}
+
+	return "", "", false
+}
+
+// claimsDomain reports if the site of owner/repo claims the custom domain
+// host, in its CNAME file, its config, its topics or its website.
+func (c *Client) claimsDomain(owner, repo, host string) (bool, error) {
+	name := owner
+	if repo != "" {
+		name += "/" + repo
+	}
+
+	s, err := c.resolve(context.Background(), name, "")
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	// a repo that isn't a site is a path of the gitea-pages repo, which can't
+	// have its own domain
+	if s.filepath != "" {
+		return false, nil
+	}
+
+	domains, err := c.siteDomains(s)
+	if err != nil {
+		return false, err
+	}
+
+	for _, domain := range domains {
+		if domain == host {
+			return true, nil
+		}
+	}
+
+	c.logger.Debug("custom domain not claimed by its site", zap.String("host", host),
+		zap.String("owner", owner), zap.String("repo", repo))
+
+	return false, nil
+}
+
+// siteDomains returns the custom domains the site s claims.
+func (c *Client) siteDomains(s *site) ([]string, error) {
+	domains := s.domains
+
+	raw, err := c.fetchRaw(s.ctx, s.owner, s.repo, cnameFile, s.ref, nil)
+
+	switch {
+	case err == nil:
+		domains = append(domains, c.customDomains(s.owner, s.repo, parseCNAME(raw.content))...)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	if s.websiteDomain {
+		meta, err := c.repoMeta(s.ctx, s.owner, s.repo)
+		if err != nil {
+			return nil, err
+		}
+
+		if domain := websiteHost(meta.Website); domain != "" {
+			domains = append(domains, c.customDomains(s.owner, s.repo, []string{domain})...)
+		}
+	}
+
+	return domains, nil
+}
+
+// websiteHost returns the host of the website url of a repo, "" if it has
+// none.
+func websiteHost(website string) string {
+	website = strings.TrimSpace(website)
+	if website == "" {
+		return ""
+	}
+
+	// the website may be given without scheme
+	if !strings.Contains(website, "://") {
+		website = "https://" + website
+	}
+
+	u, err := url.Parse(website)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// parseCNAME returns the domains of a CNAME file, one per line; empty lines
+// and comments (#) are skipped.
+func parseCNAME(content []byte) []string {
+	var domains []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// like github pages, the domain may be written as url
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "https://"), "http://")
+
+		domains = append(domains, strings.TrimSuffix(line, "/"))
+	}
+
+	return domains
+}
+
+// configDomains returns the custom domains in the config of the repo.
+func (c *Client) configDomains(owner, repo string, cfg *repoConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+
+	domains := cfg.GetStringSlice("domains")
+	if len(domains) == 0 {
+		return nil
+	}
+
+	return c.customDomains(owner, repo, domains)
+}
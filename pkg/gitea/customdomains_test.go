@@ -0,0 +1,323 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+const testPagesDomain = "pages.example.com"
+
+// siteConfig makes a repo a site serving its gitea-pages branch, where its
+// config is.
+const siteConfig = "defaultref = \"gitea-pages\"\nallowedrefs = [\"gitea-pages\"]\n"
+
+// fakeDNS makes the custom domains resolve to the CNAME or TXT records of
+// records, by host; the records are restored when the test ends.
+func fakeDNS(t *testing.T, cnames map[string]string, txts map[string][]string) {
+	t.Helper()
+
+	oldCNAME, oldTXT := lookupCNAME, lookupTXT
+
+	t.Cleanup(func() {
+		lookupCNAME, lookupTXT = oldCNAME, oldTXT
+	})
+
+	lookupCNAME = func(_ context.Context, host string) (string, error) {
+		if cname, ok := cnames[host]; ok {
+			return cname, nil
+		}
+
+		return "", errors.New("no such host")
+	}
+
+	lookupTXT = func(_ context.Context, host string) ([]string, error) {
+		if txt, ok := txts[host]; ok {
+			return txt, nil
+		}
+
+		return nil, errors.New("no such host")
+	}
+}
+
+// newDomainClient returns a client serving the sites of srv on the custom
+// domains they claim.
+func newDomainClient(t *testing.T, srv *giteatest.Server, options ...ClientOption) *Client {
+	t.Helper()
+
+	c, err := NewClient(srv.URL, "token", "", "", append([]ClientOption{SetCustomDomains(testPagesDomain)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestCustomDomainNormalization(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	// аррӏе is Cyrillic
+	const confusable = "xn--80ak6aa92e.example"
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "blog",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"gitea-pages.toml": siteConfig,
+			"CNAME":            "Bücher.Example\nаррӏе.example\n",
+		}},
+	})
+
+	site := "blog.user." + testPagesDomain
+	fakeDNS(t, map[string]string{"xn--bcher-kva.example": site + ".", confusable: site}, nil)
+
+	for _, policy := range []string{"", DomainPolicyAny} {
+		var options []ClientOption
+		if policy != "" {
+			options = append(options, SetDomainPolicy(policy))
+		}
+
+		c := newDomainClient(t, srv, options...)
+
+		// hosts match the punycode domains whatever their case and form
+		for _, host := range []string{"bücher.example", "BÜCHER.EXAMPLE.", "xn--bcher-kva.example"} {
+			if owner, repo, err := c.CustomDomain(host); err != nil || owner != "user" || repo != "blog" {
+				t.Errorf("policy %q: CustomDomain(%q) = %q, %q, %v", policy, host, owner, repo, err)
+			}
+		}
+
+		_, _, err := c.CustomDomain(confusable)
+
+		switch {
+		case policy == DomainPolicyAny && err != nil:
+			t.Errorf("policy any: confusable domain refused: %v", err)
+		case policy == "" && !errors.Is(err, ErrUnclaimedDomain):
+			t.Errorf("default policy: confusable domain claimed: %v", err)
+		}
+	}
+
+	c := newDomainClient(t, srv, SetDomainPolicy(DomainPolicyASCII))
+
+	if _, _, err := c.CustomDomain("bücher.example"); !errors.Is(err, ErrUnclaimedDomain) {
+		t.Errorf("policy ascii: internationalized domain claimed: %v", err)
+	}
+
+	if _, err := c.NormalizeDomain("not a domain"); err == nil || strings.Contains(err.Error(), "confusable") {
+		t.Errorf("NormalizeDomain of an invalid domain: %v", err)
+	}
+}
+
+func TestCustomDomainClaims(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "blog",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"gitea-pages.toml": siteConfig + "domains = [\"docs.example.org\"]\n",
+			"CNAME":            "# the blog\nhttps://blog.example.org/\n",
+		}},
+	})
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"CNAME": "example.net\n"}},
+	})
+
+	blog := "blog.user." + testPagesDomain
+	fakeDNS(t, map[string]string{
+		"blog.example.org":  blog,
+		"docs.example.org":  blog,
+		"other.example.org": blog,
+		"away.example.org":  "example.com",
+	}, map[string][]string{
+		// apex domains can't have a CNAME record
+		"example.net": {"v=spf1 -all", "user." + testPagesDomain + "."},
+	})
+
+	c := newDomainClient(t, srv)
+
+	for host, want := range map[string][2]string{
+		"blog.example.org": {"user", "blog"},
+		"docs.example.org": {"user", "blog"},
+		"example.net":      {"user", ""},
+	} {
+		if owner, repo, err := c.CustomDomain(host); err != nil || owner != want[0] || repo != want[1] {
+			t.Errorf("CustomDomain(%q) = %q, %q, %v, want %q", host, owner, repo, err, want)
+		}
+	}
+
+	// a domain pointing to a site that doesn't claim it tells which site
+	var unclaimed *UnclaimedDomainError
+
+	_, _, err := c.CustomDomain("other.example.org")
+	if !errors.As(err, &unclaimed) || unclaimed.Owner != "user" || unclaimed.Repo != "blog" {
+		t.Errorf("CustomDomain of an unclaimed domain: %v", err)
+	}
+
+	_, _, err = c.CustomDomain("away.example.org")
+	if !errors.Is(err, ErrUnclaimedDomain) || errors.As(err, &unclaimed) {
+		t.Errorf("CustomDomain of a domain pointing elsewhere: %v", err)
+	}
+
+	// the new claims of a site are known once it's purged
+	srv.AddFile("user", "blog", "gitea-pages", "CNAME", "blog.example.org\nother.example.org\n")
+	c.Purge(NewPurge("user", "blog", PurgeReasonAdmin))
+
+	if owner, repo, err := c.CustomDomain("other.example.org"); err != nil || owner != "user" || repo != "blog" {
+		t.Errorf("CustomDomain of a domain claimed after a purge = %q, %q, %v", owner, repo, err)
+	}
+}
+
+func TestCustomDomainTopics(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	for _, name := range []string{"shop", "docs"} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:    "user",
+			Name:     name,
+			Website:  "www." + name + ".example.net/welcome",
+			Topics:   []string{"gitea-pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {"gitea-pages.toml": siteConfig}},
+		})
+	}
+
+	srv.SetTopics("user", "shop", "gitea-pages", "pages.domain.shop.example.org", "pages:domain=website")
+
+	fakeDNS(t, map[string]string{
+		"shop.example.org":     "shop.user." + testPagesDomain,
+		"www.shop.example.net": "shop.user." + testPagesDomain,
+		"www.docs.example.net": "docs.user." + testPagesDomain,
+	}, nil)
+
+	c := newDomainClient(t, srv)
+
+	for _, host := range []string{"shop.example.org", "www.shop.example.net"} {
+		if owner, repo, err := c.CustomDomain(host); err != nil || owner != "user" || repo != "shop" {
+			t.Errorf("CustomDomain(%q) = %q, %q, %v", host, owner, repo, err)
+		}
+	}
+
+	// the website is only claimed with the topic
+	if _, _, err := c.CustomDomain("www.docs.example.net"); !errors.Is(err, ErrUnclaimedDomain) {
+		t.Errorf("website claimed without topic: %v", err)
+	}
+
+	srv.SetTopics("user", "shop", "gitea-pages")
+	c.Purge(NewPurge("user", "shop", PurgeReasonAdmin))
+
+	if _, _, err := c.CustomDomain("shop.example.org"); !errors.Is(err, ErrUnclaimedDomain) {
+		t.Errorf("domain of a removed topic still claimed: %v", err)
+	}
+}
+
+func TestCanonicalDomain(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "blog",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"gitea-pages.toml": siteConfig,
+			"CNAME":            "unpointed.example.org\nblog.example.org\n",
+		}},
+	})
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "plain",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"gitea-pages.toml": siteConfig}},
+	})
+
+	fakeDNS(t, map[string]string{"blog.example.org": "blog.user." + testPagesDomain}, nil)
+
+	c := newDomainClient(t, srv, SetPagesDomain(testPagesDomain))
+	ctx := context.Background()
+
+	// the first claimed domain that points to the site is canonical
+	if domain, filepath, err := c.CanonicalDomain(ctx, "user/blog/posts/a.html"); err != nil ||
+		domain != "blog.example.org" || filepath != "posts/a.html" {
+		t.Errorf("CanonicalDomain = %q, %q, %v", domain, filepath, err)
+	}
+
+	if domain, _, err := c.CanonicalDomain(ctx, "user/plain/index.html"); err != nil || domain != "" {
+		t.Errorf("CanonicalDomain of a site without custom domain = %q, %v", domain, err)
+	}
+
+	// only the default version is on the custom domain
+	for _, tc := range []struct{ repo, ref, want string }{
+		{"blog", "", "https://blog.example.org/posts/"},
+		{"blog", "dev", "https://dev.blog.user." + testPagesDomain + "/posts/"},
+		{"plain", "", "https://plain.user." + testPagesDomain + "/posts/"},
+	} {
+		if got := c.SiteURL("user", tc.repo, tc.ref, "/posts/"); got != tc.want {
+			t.Errorf("SiteURL(%q, %q) = %q, want %q", tc.repo, tc.ref, got, tc.want)
+		}
+	}
+}
+
+func TestSecondaryDomains(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	for name, mode := range map[string]string{"blog": "redirect", "docs": "mirror"} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:  "user",
+			Name:   name,
+			Topics: []string{"gitea-pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {
+				"gitea-pages.toml": siteConfig + "canonicaldomain = \"www." + name + ".example.org\"\nsecondarydomains = \"" + mode + "\"\n",
+				"CNAME":            name + ".example.org\nwww." + name + ".example.org\n",
+			}},
+		})
+	}
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"CNAME": "user.example.net\n"}},
+	})
+
+	fakeDNS(t, map[string]string{
+		"blog.example.org":     "blog.user." + testPagesDomain,
+		"www.blog.example.org": "blog.user." + testPagesDomain,
+		"docs.example.org":     "docs.user." + testPagesDomain,
+		"www.docs.example.org": "docs.user." + testPagesDomain,
+		"user.example.net":     "user." + testPagesDomain,
+	}, nil)
+
+	c := newDomainClient(t, srv)
+	ctx := context.Background()
+
+	// the canonical domain of the config comes first
+	if domain, _, err := c.CanonicalDomain(ctx, "user/blog/a.html"); err != nil || domain != "www.blog.example.org" {
+		t.Errorf("CanonicalDomain = %q, %v", domain, err)
+	}
+
+	for _, tc := range []struct{ host, name, want string }{
+		{"blog.example.org", "user/blog/a.html", "www.blog.example.org"},
+		{"BLOG.example.org", "user/blog/a.html", "www.blog.example.org"},
+		{"www.blog.example.org", "user/blog/a.html", ""},
+		// secondaries of mirroring sites serve the site themselves
+		{"docs.example.org", "user/docs/a.html", ""},
+		// the domain of the gitea-pages repo serves the other sites below it
+		{"user.example.net", "user/blog/a.html", ""},
+	} {
+		domain, filepath, err := c.SecondaryRedirect(ctx, tc.host, tc.name)
+		if err != nil || domain != tc.want || domain != "" && filepath != "a.html" {
+			t.Errorf("SecondaryRedirect(%q, %q) = %q, %q, %v, want %q", tc.host, tc.name, domain, filepath, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"strings"
+)
+
+// descriptionConfigMarker starts the config of a repo in its description.
+const descriptionConfigMarker = "pages-config:"
+
+// SetDescriptionConfig reads the config of a repo from its description before
+// fetching its config file, e.g. "My docs. pages-config: allowedrefs=["main"]; prpreviews=true".
+func SetDescriptionConfig() ClientOption {
+	return func(c *Client) error {
+		c.descriptionConfig = true
+		return nil
+	}
+}
+
+// descriptionConfig returns the config in the description of the repo as
+// TOML, or nil if it has none.
+func descriptionConfig(description string) []byte {
+	i := strings.Index(description, descriptionConfigMarker)
+	if i < 0 {
+		return nil
+	}
+
+	settings := strings.Split(description[i+len(descriptionConfigMarker):], ";")
+
+	var buf bytes.Buffer
+
+	for _, setting := range settings {
+		if setting = strings.TrimSpace(setting); setting != "" {
+			buf.WriteString(setting)
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// fetchDescriptionConfig returns the config in the description of the repo,
+// or nil if it has none.
+func (c *Client) fetchDescriptionConfig(ctx context.Context, owner, repo string) ([]byte, error) {
+	r, err := c.repoMeta(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return descriptionConfig(r.Description), nil
+}
@@ -0,0 +1,174 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+// The policies for internationalized custom domains, from the least to the
+// most strict.
+const (
+	// DomainPolicyAny accepts every valid internationalized domain.
+	DomainPolicyAny = "any"
+	// DomainPolicySingleScript rejects labels mixing scripts (but the usual
+	// mixes of Latin and CJK scripts) and labels made only of Cyrillic or
+	// Greek letters that look Latin, like "аррӏе".
+	DomainPolicySingleScript = "single_script"
+	// DomainPolicyASCII only accepts ASCII domains.
+	DomainPolicyASCII = "ascii"
+)
+
+// ErrConfusableDomain is returned for custom domains the domain policy rejects.
+var ErrConfusableDomain = errors.New("confusable domain")
+
+// ValidDomainPolicy reports if policy is a known domain policy.
+func ValidDomainPolicy(policy string) bool {
+	switch policy {
+	case DomainPolicyAny, DomainPolicySingleScript, DomainPolicyASCII:
+		return true
+	}
+
+	return false
+}
+
+// SetDomainPolicy sets which internationalized custom domains sites may
+// declare, DomainPolicySingleScript by default.
+func SetDomainPolicy(policy string) ClientOption {
+	return func(c *Client) error {
+		if !ValidDomainPolicy(policy) {
+			return fmt.Errorf("unknown domain policy %q", policy)
+		}
+
+		c.domainPolicy = policy
+
+		return nil
+	}
+}
+
+// NormalizeDomain returns the punycode form of the custom domain, or an
+// error if it isn't a valid domain name or the domain policy rejects it.
+func (c *Client) NormalizeDomain(domain string) (string, error) {
+	ascii, err := idna.Registration.ToASCII(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), ".")))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+
+	policy := c.domainPolicy
+	if policy == "" {
+		policy = DomainPolicySingleScript
+	}
+
+	if policy == DomainPolicyAny || !strings.Contains(ascii, "xn--") {
+		return ascii, nil
+	}
+
+	if policy == DomainPolicyASCII {
+		return "", fmt.Errorf("%w: %s isn't ASCII", ErrConfusableDomain, domain)
+	}
+
+	unicodeForm, err := idna.Registration.ToUnicode(ascii)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+
+	for _, label := range strings.Split(unicodeForm, ".") {
+		if !singleScript(label) || latinLookalike(label) {
+			return "", fmt.Errorf("%w: %s", ErrConfusableDomain, domain)
+		}
+	}
+
+	return ascii, nil
+}
+
+// customDomains returns the punycode form of the custom domains of the
+// repo owner/repo, without the ones that are invalid or rejected.
+func (c *Client) customDomains(owner, repo string, domains []string) []string {
+	var res []string
+
+	for _, domain := range domains {
+		ascii, err := c.NormalizeDomain(domain)
+		if err != nil {
+			// the topics are parsed on every request
+			c.logger.Debug("ignoring custom domain", zap.String("owner", owner), zap.String("repo", repo), zap.Error(err))
+			continue
+		}
+
+		res = append(res, ascii)
+	}
+
+	return res
+}
+
+// scriptMixes are the mixes of scripts allowed in a label, as in the
+// highly restrictive level of Unicode TS #39.
+var scriptMixes = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// singleScript reports if the letters of label are of one script or one of
+// the allowed mixes.
+func singleScript(label string) bool {
+	scripts := map[string]bool{}
+
+	for _, r := range label {
+		if unicode.In(r, unicode.Common, unicode.Inherited) {
+			continue
+		}
+
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				scripts[name] = true
+				break
+			}
+		}
+	}
+
+	if len(scripts) <= 1 {
+		return true
+	}
+
+	for _, mix := range scriptMixes {
+		n := 0
+
+		for _, name := range mix {
+			if scripts[name] {
+				n++
+			}
+		}
+
+		if n == len(scripts) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// latinLookalikes are the Cyrillic and Greek letters that look like Latin ones.
+const latinLookalikes = "аеорсухіјѕԁһӏԛԝοικνρυ"
+
+// latinLookalike reports if label is written only in Cyrillic or Greek
+// letters that look Latin (and digits or dashes), so it can pass for a Latin
+// label.
+func latinLookalike(label string) bool {
+	letters := 0
+
+	for _, r := range label {
+		switch {
+		case r == '-' || '0' <= r && r <= '9':
+		case strings.ContainsRune(latinLookalikes, r):
+			letters++
+		default:
+			return false
+		}
+	}
+
+	return letters > 0
+}
@@ -0,0 +1,64 @@
+package gitea
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// fetches counts the fetches from gitea (or a peer) in flight, including
+// the cache writes of their results.
+type fetches struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (f *fetches) begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.n == 0 {
+		f.idle = make(chan struct{})
+	}
+
+	f.n++
+}
+
+func (f *fetches) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.n--
+
+	if f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// Drain waits until the fetches in flight (e.g. those that outlived the open
+// timeout of their request) finished writing to the cache, but at most
+// timeout, so an unloaded config doesn't leave truncated cache entries.
+func (c *Client) Drain(timeout time.Duration) error {
+	c.fetches.mu.Lock()
+	n, idle := c.fetches.n, c.fetches.idle
+	c.fetches.mu.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		return nil
+	case <-timer.C:
+		c.fetches.mu.Lock()
+		n = c.fetches.n
+		c.fetches.mu.Unlock()
+
+		return fmt.Errorf("%d fetches still in flight after %s", n, timeout)
+	}
+}
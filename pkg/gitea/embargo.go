@@ -0,0 +1,148 @@
+package gitea
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EmbargoError is returned for files under embargo (press releases, exam
+// solutions) until they unlock.
+type EmbargoError struct {
+	// Path is the path of the request in the site.
+	Path string
+	// Until is when the file unlocks.
+	Until time.Time
+}
+
+func (e *EmbargoError) Error() string {
+	return fmt.Sprintf("%s is under embargo until %s", e.Path, e.Until.UTC().Format(time.RFC3339))
+}
+
+// embargoRule is an entry of the embargo list of the config of a repo:
+//
+//	[[embargo]]
+//	path="/solutions/*"
+//	until="2024-06-01T09:00:00Z"
+//	countries=["US", "CA"]
+//
+// The files of path are locked until the UTC time until, for the visitors
+// of countries if given.
+type embargoRule struct {
+	path      []string
+	splat     bool
+	until     time.Time
+	countries []string
+}
+
+// embargoSettings returns the embargo rules of the config of owner/repo,
+// invalid rules are skipped.
+func (c *Client) embargoSettings(owner, repo string, cfg *repoConfig) []embargoRule {
+	if cfg == nil || !cfg.IsSet("embargo") {
+		return nil
+	}
+
+	var entries []struct {
+		Path      string
+		Until     any
+		Countries []string
+	}
+
+	if err := cfg.UnmarshalKey("embargo", &entries); err != nil {
+		c.logger.Warn("invalid embargo list", zap.String("owner", owner), zap.String("repo", repo), zap.Error(err))
+		return nil
+	}
+
+	var rules []embargoRule
+
+	for _, e := range entries {
+		var (
+			until time.Time
+			err   error
+		)
+
+		// toml has datetimes, but they may be quoted
+		switch v := e.Until.(type) {
+		case time.Time:
+			until = v
+		case string:
+			until, err = time.Parse(time.RFC3339, v)
+		default:
+			err = fmt.Errorf("missing or invalid until %v", v)
+		}
+
+		if err == nil && !strings.HasPrefix(e.Path, "/") {
+			err = fmt.Errorf("path %q doesn't start with /", e.Path)
+		}
+
+		if err != nil {
+			c.logger.Warn("invalid embargo rule", zap.String("owner", owner), zap.String("repo", repo),
+				zap.String("path", e.Path), zap.Error(err))
+
+			continue
+		}
+
+		rule := embargoRule{
+			path:      splitPath(unescapePath(e.Path)),
+			until:     until,
+			countries: e.Countries,
+		}
+
+		if n := len(rule.path); n > 0 && rule.path[n-1] == "*" {
+			rule.path = rule.path[:n-1]
+			rule.splat = true
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// match reports if the rule locks the file path p for the visitor v at now.
+// Visitors of unknown countries are locked out of rules for countries.
+func (rule *embargoRule) match(p string, v *Visitor, now time.Time) bool {
+	if !now.Before(rule.until) {
+		return false
+	}
+
+	if len(rule.countries) > 0 && v != nil && v.Country != "" && !containsFold(rule.countries, v.Country) {
+		return false
+	}
+
+	segments := splitPath(p)
+
+	if len(segments) < len(rule.path) || !rule.splat && len(segments) != len(rule.path) {
+		return false
+	}
+
+	for i, seg := range rule.path {
+		if seg != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// embargo returns an *EmbargoError if the file path p of the site s is
+// under embargo for the visitor v, unlocking with the last rule matching.
+func (s *site) embargo(p string, v *Visitor) error {
+	var until time.Time
+
+	now := time.Now()
+
+	for i := range s.embargoes {
+		if rule := &s.embargoes[i]; rule.match(p, v, now) && rule.until.After(until) {
+			until = rule.until
+		}
+	}
+
+	if until.IsZero() {
+		return nil
+	}
+
+	return &EmbargoError{Path: "/" + strings.TrimPrefix(p, "/"), Until: until}
+}
@@ -0,0 +1,81 @@
+package gitea
+
+// The events emitted by the client and the handlers using it.
+const (
+	// EventSitePublished is emitted when a new version of a site is seen.
+	EventSitePublished = "site_published"
+	// EventDeployRollback is emitted when a site goes back to an earlier version.
+	EventDeployRollback = "deploy_rollback"
+	// EventCachePurged is emitted when cached content is purged.
+	EventCachePurged = "cache_purged"
+	// EventAuthDenied is emitted when a visitor isn't allowed to see a site.
+	EventAuthDenied = "auth_denied"
+	// EventSiteLimitExceeded is emitted when a site exceeds a limit of the pages host.
+	EventSiteLimitExceeded = "site_limit_exceeded"
+)
+
+// siteHistorySize is the number of versions remembered per site to detect rollbacks.
+const siteHistorySize = 10
+
+// SetEventHandler calls fn for every event, fn must not block.
+func SetEventHandler(fn func(name string, data map[string]any)) ClientOption {
+	return func(c *Client) error {
+		c.eventHandler = fn
+		return nil
+	}
+}
+
+// Emit emits the event name with data.
+func (c *Client) Emit(name string, data map[string]any) {
+	if c.eventHandler != nil {
+		c.eventHandler(name, data)
+	}
+}
+
+// observeTree remembers the tree sha of owner/repo at ref and emits
+// site_published for new versions and deploy_rollback for earlier versions.
+func (c *Client) observeTree(owner, repo, ref, sha string) {
+	key := owner + "/" + repo + "@" + ref
+
+	c.trees.mu.Lock()
+
+	history := c.trees.history[key]
+	known := -1
+
+	for i, h := range history {
+		if h == sha {
+			known = i
+		}
+	}
+
+	if known < 0 {
+		history = append(history, sha)
+		if len(history) > siteHistorySize {
+			history = history[1:]
+		}
+
+		c.trees.history[key] = history
+	} else {
+		// move it to the end as the current version
+		history = append(append(history[:known:known], history[known+1:]...), sha)
+		c.trees.history[key] = history
+	}
+
+	c.trees.mu.Unlock()
+
+	c.inventory.deployed(owner, repo, ref, sha)
+
+	data := map[string]any{
+		"owner": owner,
+		"repo":  repo,
+		"ref":   ref,
+		"sha":   sha,
+	}
+
+	switch {
+	case known < 0 && len(history) > 1:
+		c.Emit(EventSitePublished, data)
+	case known >= 0 && known < len(history)-1:
+		c.Emit(EventDeployRollback, data)
+	}
+}
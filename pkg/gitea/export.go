@@ -0,0 +1,148 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// EventSiteExported is emitted when a snapshot of a site is exported to
+// object storage.
+const EventSiteExported = "site_exported"
+
+// exports remembers the version of every site exported last.
+type exports struct {
+	mu   sync.Mutex
+	shas map[string]string
+}
+
+// ExportSites exports a snapshot of every site served since it changed
+// (or wasn't exported before) to store, see ExportSite.
+func (c *Client) ExportSites(store *ObjectStore, prefix string) error {
+	var firstErr error
+
+	for _, s := range c.Sites() {
+		key := s.Owner + "/" + s.Repo + "@" + s.Ref
+
+		c.exports.mu.Lock()
+		exported := s.DeploySHA != "" && c.exports.shas[key] == s.DeploySHA
+		c.exports.mu.Unlock()
+
+		if exported {
+			continue
+		}
+
+		if _, err := c.ExportSite(store, prefix, s.Owner, s.Repo, s.Ref); err != nil {
+			c.logger.Error("exporting site failed", zap.String("owner", s.Owner), zap.String("repo", s.Repo),
+				zap.String("ref", s.Ref), zap.Error(err))
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		c.exports.mu.Lock()
+		c.exports.shas[key] = s.DeploySHA
+		c.exports.mu.Unlock()
+	}
+
+	return firstErr
+}
+
+// ExportSite stores the files of owner/repo at ref, rendered like they're
+// served, in store under <prefix><owner>/<repo>/<ref>/<path>; the default
+// ref is stored under the name of the branch. It returns the number of files
+// exported, files that can't be served (e.g. above the site limits) and
+// moved pages and drafts are skipped.
+func (c *Client) ExportSite(store *ObjectStore, prefix, owner, repo, ref string) (int, error) {
+	s, err := c.resolve(context.Background(), owner+"/"+repo, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	// a cached tree may only be a bloom filter, the snapshot needs the paths
+	t, err := c.fetchTree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return 0, err
+	}
+
+	if t.truncated {
+		c.logger.Warn("tree of site is truncated, exporting a partial snapshot",
+			zap.String("owner", s.owner), zap.String("repo", s.repo), zap.String("ref", t.ref))
+	}
+
+	base := prefix + s.owner + "/" + s.repo + "/" + t.ref + "/"
+	exported := 0
+
+	err = fs.WalkDir(c.siteFS(s, t), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		// blocked files are never served, embargoed ones are served from gitea once they unlock
+		if s.embargo(name, nil) != nil || c.checkExtension(s, name) != nil {
+			return nil
+		}
+
+		f, err := c.openSiteFile(s, name, nil)
+
+		// drafts don't exist outside of previews
+		var redirect *RedirectError
+		if errors.Is(err, ErrSiteLimit) || errors.Is(err, fs.ErrNotExist) || errors.As(err, &redirect) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the object storage takes large files as a whole too
+		if f.stream != nil {
+			f.content, err = io.ReadAll(f.stream)
+			f.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// rendered pages keep their extension, their type is sniffed like when they're served
+		contentType := f.contentType
+		if contentType == "" && c.renderer(name) == nil {
+			contentType = mime.TypeByExtension(path.Ext(name))
+		}
+
+		if contentType == "" {
+			contentType = http.DetectContentType(f.content)
+		}
+
+		if err := store.Put(base+name, f.content, contentType, map[string]string{shieldTreeMeta: t.sha}); err != nil {
+			return err
+		}
+
+		exported++
+
+		return nil
+	})
+	if err != nil {
+		return exported, err
+	}
+
+	c.Emit(EventSiteExported, map[string]any{
+		"owner": s.owner,
+		"repo":  s.repo,
+		"ref":   t.ref,
+		"files": exported,
+	})
+
+	return exported, nil
+}
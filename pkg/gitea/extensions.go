@@ -0,0 +1,85 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrBlockedExtension is returned for files with an extension the operator
+// blocks, like executables or server side scripts.
+var ErrBlockedExtension = errors.New("blocked file extension")
+
+// extensionPolicy are the file extensions that aren't served by any site.
+type extensionPolicy struct {
+	blocked map[string]bool
+	// optOuts are the owners and owner/repo that may serve blocked
+	// extensions listed in their config.
+	optOuts map[string]bool
+}
+
+// normalizeExtension returns ext lowercase with a leading dot.
+func normalizeExtension(ext string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+}
+
+// SetBlockedExtensions refuses to serve files with the extensions blocked
+// (".exe", "php") for all sites. The owners and owner/repo of optOuts may
+// serve some of them anyway, with unblockextensions in their repo config:
+//
+//	unblockextensions=[".exe"]
+func SetBlockedExtensions(blocked, optOuts []string) ClientOption {
+	return func(c *Client) error {
+		c.extensions = &extensionPolicy{
+			blocked: make(map[string]bool, len(blocked)),
+			optOuts: ownerSet(optOuts),
+		}
+
+		for _, ext := range blocked {
+			c.extensions.blocked[normalizeExtension(ext)] = true
+		}
+
+		return nil
+	}
+}
+
+// unblockedExtensions returns the blocked extensions the config of
+// owner/repo unblocks, if the operator allows it to.
+func (c *Client) unblockedExtensions(owner, repo string, cfg *repoConfig) map[string]bool {
+	if c.extensions == nil || cfg == nil || !cfg.IsSet("unblockextensions") {
+		return nil
+	}
+
+	optOuts := c.extensions.optOuts
+	if !optOuts[strings.ToLower(owner)] && !optOuts[strings.ToLower(owner+"/"+repo)] {
+		c.logger.Debug("ignoring unblockextensions of repo without opt-out", zap.String("owner", owner),
+			zap.String("repo", repo))
+
+		return nil
+	}
+
+	unblocked := make(map[string]bool)
+	for _, ext := range cfg.GetStringSlice("unblockextensions") {
+		unblocked[normalizeExtension(ext)] = true
+	}
+
+	return unblocked
+}
+
+// checkExtension returns ErrBlockedExtension if the extension of the file
+// filepath of the site s is blocked.
+func (c *Client) checkExtension(s *site, filepath string) error {
+	if c.extensions == nil {
+		return nil
+	}
+
+	ext := strings.ToLower(path.Ext(filepath))
+	if !c.extensions.blocked[ext] || s.unblocked[ext] {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrBlockedExtension, filepath)
+}
@@ -0,0 +1,96 @@
+package gitea
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// notFoundPage is the page of a site shown for files it doesn't have.
+	notFoundPage = "404.html"
+	// spaIndex is the page single-page apps serve for all their routes.
+	spaIndex = "index.html"
+)
+
+// fallbackSettings returns whether the site is a single-page app and its
+// not found page from its config:
+//
+//	spa = true
+//	notfoundpage = "errors/404.html"
+func fallbackSettings(cfg *repoConfig) (bool, string) {
+	if cfg == nil {
+		return false, notFoundPage
+	}
+
+	page := notFoundPage
+	if cfg.IsSet("notfoundpage") {
+		page = strings.TrimPrefix(cfg.GetString("notfoundpage"), "/")
+	}
+
+	return cfg.GetBool("spa"), page
+}
+
+// fallbackPage returns the page the visitor v of the site s sees for a file
+// that doesn't exist, or nil if there's none. Single-page apps serve their
+// index for routes (paths without extension), others their not found page
+// with status 404.
+func (c *Client) fallbackPage(s *site, v *Visitor) (*openFile, error) {
+	if s.spa && path.Ext(s.filepath) == "" {
+		f, err := c.openFallback(s, spaIndex, v)
+		if f != nil || err != nil {
+			return f, err
+		}
+	}
+
+	if s.notFoundPage == "" {
+		return nil, nil
+	}
+
+	f, err := c.openFallback(s, s.notFoundPage, v)
+	if f == nil || err != nil {
+		return nil, err
+	}
+
+	// the page isn't the missing file, its validators would be wrong
+	f.status = http.StatusNotFound
+	f.etag = ""
+	f.modTime = time.Time{}
+
+	return f, nil
+}
+
+// openFallback opens the fallback page filepath of the site s for v, nil if
+// it doesn't exist or may not be served.
+func (c *Client) openFallback(s *site, filepath string, v *Visitor) (*openFile, error) {
+	if exists, known := c.exists(s, filepath); !exists && known {
+		return nil, nil
+	}
+
+	if s.embargo(filepath, v) != nil || c.checkExtension(s, filepath) != nil {
+		return nil, nil
+	}
+
+	f, err := c.openSiteFile(s, filepath, nil)
+
+	var redirect *RedirectError
+	if errors.Is(err, fs.ErrNotExist) || errors.As(err, &redirect) {
+		return nil, nil
+	}
+
+	return f, err
+}
+
+// exists reports if filepath exists in the tree of the site s, known is
+// false if the tree can't tell.
+func (c *Client) exists(s *site, filepath string) (exists, known bool) {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return false, false
+	}
+
+	return t.exists(filepath)
+}
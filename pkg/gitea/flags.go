@@ -0,0 +1,121 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The features that can be rolled out gradually with feature flags.
+const (
+	// FeatureArchives serves sites from their repo archive, see SetArchives.
+	FeatureArchives = "archives"
+	// FeatureAuth protects private sites with the auth provider of the
+	// handler, the sites it's not enabled for refuse private repos.
+	FeatureAuth = "auth"
+)
+
+// features are the features that have flags.
+var features = []string{FeatureArchives, FeatureAuth}
+
+// FeatureFlags limit features to some owners (owner) and sites (owner/repo)
+// while they're rolled out; a feature without flag is enabled for all sites.
+type FeatureFlags map[string][]string
+
+// Validate returns an error for an unknown feature or a site that isn't an
+// owner, owner/repo or * (all sites).
+func (ff FeatureFlags) Validate() error {
+	for feature, sites := range ff {
+		if !ValidFeature(feature) {
+			return fmt.Errorf("unknown feature %q, known are %s", feature, strings.Join(features, ", "))
+		}
+
+		for _, site := range sites {
+			owner, repo, ok := strings.Cut(site, "/")
+			if owner == "" || ok && (repo == "" || strings.Contains(repo, "/")) {
+				return fmt.Errorf("%s: %q must be an owner, owner/repo or *", feature, site)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidFeature reports if feature can be flagged.
+func ValidFeature(feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enabled reports if feature is enabled for owner/repo.
+func (ff FeatureFlags) enabled(feature, owner, repo string) bool {
+	sites, ok := ff[feature]
+	if !ok {
+		return true
+	}
+
+	for _, site := range sites {
+		if site == "*" || strings.EqualFold(site, owner) || strings.EqualFold(site, owner+"/"+repo) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetFeatureFlags limits the flagged features to the owners and sites of
+// their flag.
+func SetFeatureFlags(flags FeatureFlags) ClientOption {
+	return func(c *Client) error {
+		if err := flags.Validate(); err != nil {
+			return err
+		}
+
+		c.flags = flags
+
+		return nil
+	}
+}
+
+// FeatureEnabled reports if feature is enabled for the site of the file
+// name (owner/repo/filepath) at ref. A site that can't be resolved only
+// matches flags of its owner.
+func (c *Client) FeatureEnabled(ctx context.Context, feature, name, ref string) bool {
+	if _, ok := c.flags[feature]; !ok {
+		return true
+	}
+
+	owner, repo, _ := splitName(name)
+	if s, err := c.resolve(ctx, name, ref); err == nil {
+		owner, repo = s.owner, s.repo
+	} else {
+		repo = ""
+	}
+
+	return c.flags.enabled(feature, owner, repo)
+}
+
+// FeatureFlag is a feature enabled for some owners and sites only.
+type FeatureFlag struct {
+	Feature string   `json:"feature"`
+	Sites   []string `json:"sites"`
+}
+
+// FeatureFlags returns the flagged features and the owners and sites they're
+// enabled for, ordered by feature.
+func (c *Client) FeatureFlags() []FeatureFlag {
+	flags := make([]FeatureFlag, 0, len(c.flags))
+	for feature, sites := range c.flags {
+		flags = append(flags, FeatureFlag{Feature: feature, Sites: append([]string(nil), sites...)})
+	}
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Feature < flags[j].Feature })
+
+	return flags
+}
@@ -0,0 +1,265 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	gclient "code.gitea.io/sdk/gitea"
+	"go.uber.org/zap"
+)
+
+// EventFormSubmitted is emitted when a form submission is filed.
+const EventFormSubmitted = "form_submitted"
+
+const (
+	// maxFormFields is the number of fields of a submission filed.
+	maxFormFields = 50
+	// maxFormValue is the length of a field value filed.
+	maxFormValue = 10000
+)
+
+// captchaFields are the fields of the response of the captcha widgets
+// (Turnstile, hCaptcha and reCAPTCHA), they aren't filed.
+var captchaFields = []string{"cf-turnstile-response", "h-captcha-response", "g-recaptcha-response"}
+
+// ErrFormRejected is returned for form submissions that are invalid or fail
+// the captcha.
+var ErrFormRejected = errors.New("form submission rejected")
+
+// formConfig is a form of the config of a repo, filed as issue:
+//
+//	[forms.contact]
+//	title="Contact form"
+//	repo="inbox"
+//	issue=12
+//	fields=["name", "email", "message"]
+//	honeypot="website"
+//	redirect="/thanks/"
+//
+// repo is a repo of the same owner, the repo of the site by default. With
+// issue submissions are comments on that issue. Only fields are filed if
+// set, submissions with the honeypot field filled are dropped.
+type formConfig struct {
+	title    string
+	repo     string
+	issue    int64
+	fields   []string
+	honeypot string
+	redirect string
+}
+
+// formSettings returns the forms of the repo config cfg.
+func formSettings(cfg *repoConfig) map[string]*formConfig {
+	if cfg == nil || !cfg.IsSet("forms") {
+		return nil
+	}
+
+	forms := make(map[string]*formConfig)
+
+	for name := range cfg.GetStringMap("forms") {
+		key := "forms." + name
+
+		forms[name] = &formConfig{
+			title:    cfg.GetString(key + ".title"),
+			repo:     cfg.GetString(key + ".repo"),
+			issue:    cfg.GetInt64(key + ".issue"),
+			fields:   cfg.GetStringSlice(key + ".fields"),
+			honeypot: cfg.GetString(key + ".honeypot"),
+			redirect: cfg.GetString(key + ".redirect"),
+		}
+	}
+
+	return forms
+}
+
+// formCaptcha verifies the captcha of form submissions with a siteverify
+// endpoint.
+type formCaptcha struct {
+	verifyURL string
+	secret    string
+	client    *http.Client
+}
+
+// SetFormCaptcha requires a captcha in every form submission, verified with
+// the secret at verifyURL, the siteverify endpoint of Turnstile, hCaptcha or
+// reCAPTCHA.
+func SetFormCaptcha(verifyURL, secret string) ClientOption {
+	return func(c *Client) error {
+		c.formCaptcha = &formCaptcha{
+			verifyURL: verifyURL,
+			secret:    secret,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}
+
+		return nil
+	}
+}
+
+// SubmitForm files the values of the form submitted to the site name at ref
+// as issue, or as comment on its issue, in the repo of the form. remoteIP
+// is the address of the visitor, for the captcha. It returns where the
+// visitor goes next, empty if the form doesn't say.
+func (c *Client) SubmitForm(ctx context.Context, name, ref, form string, values url.Values, remoteIP string) (string, error) {
+	if c.scopes != nil && !c.scopes.Issues {
+		return "", ErrReadOnlyToken
+	}
+
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return "", err
+	}
+
+	f, ok := s.forms[strings.ToLower(form)]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+
+	// bots filling the honeypot think they succeeded
+	if f.honeypot != "" && values.Get(f.honeypot) != "" {
+		c.logger.Debug("dropping form submission filling the honeypot", zap.String("owner", s.owner),
+			zap.String("repo", s.repo), zap.String("form", form))
+
+		return f.redirect, nil
+	}
+
+	if err := c.verifyCaptcha(values, remoteIP); err != nil {
+		return "", err
+	}
+
+	body, err := formBody(f, values)
+	if err != nil {
+		return "", err
+	}
+
+	// the token may write to any repo, sites only file to their owner's
+	repo := s.repo
+	if f.repo != "" {
+		if strings.Contains(f.repo, "/") {
+			return "", fmt.Errorf("%w: repo %q isn't a repo of %s", ErrFormRejected, f.repo, s.owner)
+		}
+
+		repo = f.repo
+	}
+
+	if f.issue > 0 {
+		_, _, err = c.gc.CreateIssueComment(s.owner, repo, f.issue, gclient.CreateIssueCommentOption{Body: body})
+	} else {
+		title := f.title
+		if title == "" {
+			title = "Submission of form " + form
+		}
+
+		_, _, err = c.gc.CreateIssue(s.owner, repo, gclient.CreateIssueOption{Title: title, Body: body})
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("filing form %s of %s/%s: %w", form, s.owner, s.repo, err)
+	}
+
+	c.Emit(EventFormSubmitted, map[string]any{"owner": s.owner, "repo": s.repo, "form": form})
+
+	return f.redirect, nil
+}
+
+// formBody returns the markdown of the values of a submission. The fields
+// and values are code, so they can't mention users or inject markdown.
+func formBody(f *formConfig, values url.Values) (string, error) {
+	fields := f.fields
+	if len(fields) == 0 {
+		for field := range values {
+			if field != f.honeypot && !containsFold(captchaFields, field) {
+				fields = append(fields, field)
+			}
+		}
+
+		sort.Strings(fields)
+	}
+
+	if len(fields) > maxFormFields {
+		fields = fields[:maxFormFields]
+	}
+
+	var b strings.Builder
+
+	for _, field := range fields {
+		value := strings.TrimSpace(values.Get(field))
+		if value == "" {
+			continue
+		}
+
+		if len(value) > maxFormValue {
+			value = value[:maxFormValue]
+		}
+
+		fmt.Fprintf(&b, "`%s`\n\n", strings.NewReplacer("`", "", "\n", " ", "\r", "").Replace(field))
+
+		for _, line := range strings.Split(value, "\n") {
+			b.WriteString("    " + strings.TrimRight(line, "\r") + "\n")
+		}
+
+		b.WriteString("\n")
+	}
+
+	if b.Len() == 0 {
+		return "", fmt.Errorf("%w: no values", ErrFormRejected)
+	}
+
+	return b.String(), nil
+}
+
+// verifyCaptcha checks the captcha response of the values with the
+// siteverify endpoint, if captchas are required.
+func (c *Client) verifyCaptcha(values url.Values, remoteIP string) error {
+	if c.formCaptcha == nil {
+		return nil
+	}
+
+	var response string
+	for _, field := range captchaFields {
+		if response = values.Get(field); response != "" {
+			break
+		}
+	}
+
+	if response == "" {
+		return fmt.Errorf("%w: missing captcha", ErrFormRejected)
+	}
+
+	form := url.Values{"secret": {c.formCaptcha.secret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	resp, err := c.formCaptcha.client.PostForm(c.formCaptcha.verifyURL, form)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifying captcha: unexpected status code '%d'", resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("verifying captcha: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: captcha failed", ErrFormRejected)
+	}
+
+	return nil
+}
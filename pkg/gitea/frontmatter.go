@@ -171,11 +171,19 @@ func markdown(input []byte) ([]byte, error) {
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 
-	defer bufPool.Put(buf)
+	defer putBuffer(buf)
 
-	if err := md.Convert(input, buf); err != nil {
+	ctx := parser.NewContext(parser.WithIDs(newHeadingIDs()))
+
+	if err := md.Convert(input, buf, parser.WithContext(ctx)); err != nil {
 		return input, err
 	}
 
-	return buf.Bytes(), nil
+	held := float64(buf.Cap())
+	memoryMetrics.held.WithLabelValues("render").Add(held)
+
+	defer memoryMetrics.held.WithLabelValues("render").Sub(held)
+
+	// buf is reused once it's back in the pool
+	return append([]byte(nil), buf.Bytes()...), nil
 }
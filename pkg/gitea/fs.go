@@ -3,20 +3,39 @@ package gitea
 import (
 	"io"
 	"io/fs"
+	"net/http"
 	"time"
 )
 
 type fileInfo struct {
-	size  int64
-	isdir bool
-	name  string
+	size    int64
+	isdir   bool
+	name    string
+	modTime time.Time
 }
 
 type openFile struct {
-	content []byte
-	offset  int64
-	name    string
-	isdir   bool
+	content     []byte
+	offset      int64
+	name        string
+	isdir       bool
+	etag        string
+	modTime     time.Time
+	stale       bool
+	contentType string
+	variant     string
+	headers     http.Header
+	// generated files (blog listings) have no source to take ranges of
+	generated bool
+	// restricted files are of private sites or sites with auth, they
+	// aren't kept as stale copies
+	restricted bool
+
+	// streamed files are read from stream instead of content, a partial
+	// response if their status is 206; not found pages have status 404
+	stream     io.ReadCloser
+	streamSize int64
+	status     int
 }
 
 func (g fileInfo) Name() string {
@@ -28,11 +47,15 @@ func (g fileInfo) Size() int64 {
 }
 
 func (g fileInfo) Mode() fs.FileMode {
+	if g.isdir {
+		return fs.ModeDir | 0o555
+	}
+
 	return 0o444
 }
 
 func (g fileInfo) ModTime() time.Time {
-	return time.Time{}
+	return g.modTime
 }
 
 func (g fileInfo) Sys() any {
@@ -43,21 +66,96 @@ func (g fileInfo) IsDir() bool {
 	return g.isdir
 }
 
+// Type implements fs.DirEntry, a fileInfo is the entry of a directory listing.
+func (g fileInfo) Type() fs.FileMode {
+	return g.Mode().Type()
+}
+
+// Info implements fs.DirEntry.
+func (g fileInfo) Info() (fs.FileInfo, error) {
+	return g, nil
+}
+
 var _ io.Seeker = (*openFile)(nil)
 
 func (o *openFile) Close() error {
+	if o.stream != nil {
+		return o.stream.Close()
+	}
+
 	return nil
 }
 
+// size returns the size of the file, -1 for streams of unknown length.
+func (o *openFile) size() int64 {
+	if o.stream != nil {
+		return o.streamSize
+	}
+
+	return int64(len(o.content))
+}
+
 func (o *openFile) Stat() (fs.FileInfo, error) {
 	return fileInfo{
-		size:  int64(len(o.content)),
-		isdir: o.isdir,
-		name:  o.name,
+		size:    o.size(),
+		isdir:   o.isdir,
+		name:    o.name,
+		modTime: o.modTime,
 	}, nil
 }
 
+// Stale reports if the file is a stale copy served while gitea is in maintenance.
+func (o *openFile) Stale() bool {
+	return o.stale
+}
+
+// ContentType returns the content type of the file, if it's known.
+func (o *openFile) ContentType() string {
+	return o.contentType
+}
+
+// Header returns the response headers of the file set by its site.
+func (o *openFile) Header() http.Header {
+	return o.headers
+}
+
+// Variant returns the variant (stable or canary) of a site with a canary that
+// served the file, empty for other sites.
+func (o *openFile) Variant() string {
+	return o.variant
+}
+
+// ETag returns the entity tag gitea sent for the file (if any).
+func (o *openFile) ETag() string {
+	return o.etag
+}
+
+// Generated reports if the file is generated, ranges of it aren't served.
+func (o *openFile) Generated() bool {
+	return o.generated
+}
+
+// Streamed reports if the file is streamed from gitea, it can't seek then.
+func (o *openFile) Streamed() bool {
+	return o.stream != nil
+}
+
+// Status returns the status the file is served with: the status gitea
+// answered a streamed file with (200 or 206 for a range), 404 for the not
+// found page of a site and 200 for other files.
+func (o *openFile) Status() int {
+	if o.status == 0 {
+		return http.StatusOK
+	}
+
+	return o.status
+}
+
 func (o *openFile) Read(b []byte) (int, error) {
+	if o.stream != nil {
+		return o.stream.Read(b)
+	}
+
 	if o.offset >= int64(len(o.content)) {
 		return 0, io.EOF
 	}
@@ -74,6 +172,10 @@ func (o *openFile) Read(b []byte) (int, error) {
 }
 
 func (o *openFile) Seek(offset int64, whence int) (int64, error) {
+	if o.stream != nil {
+		return 0, &fs.PathError{Op: "seek", Path: o.name, Err: fs.ErrInvalid}
+	}
+
 	switch whence {
 	case 0:
 		offset += 0
@@ -2,16 +2,22 @@ package gitea
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
 	gclient "code.gitea.io/sdk/gitea"
-	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 type Client struct {
@@ -20,9 +26,61 @@ type Client struct {
 	giteapages         string
 	giteapagesAllowAll string
 	gc                 *gclient.Client
+	httpClient         *http.Client
+	trees              *treeCache
+	notFound           *notFoundCache
+	stale              *staleStore
+	logger             *zap.Logger
+	renderers          map[string]Renderer
+	cache              Cache
+	cacheTTL           time.Duration
+	eventHandler       func(name string, data map[string]any)
+	previews           *previews
+	inventory          *inventory
+	renderMarkdown     bool
+	sanitize           string
+	owners             *ownerPolicy
+	takedowns          *takedownList
+	limits             siteLimits
+	ownerNames         map[string]OwnerNames
+	descriptionConfig  bool
+	topicsMode         int32
+	defaultBranches    sync.Map
+	openTimeout        time.Duration
+	memory             *memoryGuard
+	exports            *exports
+	shield             *shield
+	peers              *peerRing
+	fetches            fetches
+	defaultFallback    bool
+	gitHeaders         bool
+	domainPolicy       string
+	extensions         *extensionPolicy
+	formCaptcha        *formCaptcha
+	comments           *commentCache
+	lookups            *lookupCache
+	limitPages         limitPages
+	streamThreshold    int64
+	domains            *domainMap
+	archives           *archiveStore
+	defaultLayout      *defaultLayout
+	access             *accessCache
+	store              Store
+	purges             *purgeLog
+	configs            *configCache
+	maxUpstreamBody    int64
+	scopes             *TokenScopes
+	flags              FeatureFlags
+	shas               *shaCache
+	cacheCipher        *cacheCipher
+	tiers              map[string]TopicTier
+	pagesDomain        string
 }
 
-func NewClient(serverURL, token, giteapages, giteapagesAllowAll string) (*Client, error) {
+// ClientOption configures optional behavior of a Client.
+type ClientOption func(*Client) error
+
+func NewClient(serverURL, token, giteapages, giteapagesAllowAll string, options ...ClientOption) (*Client, error) {
 	if giteapages == "" {
 		giteapages = "gitea-pages"
 	}
@@ -31,211 +89,1058 @@ func NewClient(serverURL, token, giteapages, giteapagesAllowAll string) (*Client
 		giteapagesAllowAll = "gitea-pages-allowall"
 	}
 
-	gc, err := gclient.NewClient(serverURL, gclient.SetToken(token), gclient.SetGiteaVersion(""))
-	if err != nil {
-		return nil, err
-	}
-
-	return &Client{
+	c := &Client{
 		serverURL:          serverURL,
 		token:              token,
-		gc:                 gc,
 		giteapages:         giteapages,
 		giteapagesAllowAll: giteapagesAllowAll,
-	}, nil
+		httpClient:         &http.Client{Transport: http.DefaultTransport},
+		trees:              newTreeCache(defaultTreeTTL),
+		logger:             zap.NewNop(),
+		renderers:          defaultRenderers(),
+		inventory:          &inventory{sites: make(map[string]*SiteStats), usage: newUsagePeriod()},
+		renderMarkdown:     true,
+		takedowns:          newTakedownList(),
+		openTimeout:        defaultOpenTimeout,
+		exports:            &exports{shas: make(map[string]string)},
+		comments:           &commentCache{entries: make(map[string]commentEntry)},
+		access:             &accessCache{entries: make(map[string]accessEntry)},
+		purges:             &purgeLog{seen: make(map[string]time.Time)},
+		configs:            &configCache{entries: make(map[string]configEntry)},
+		shas:               &shaCache{entries: make(map[string]string)},
+	}
+
+	for _, opt := range options {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.loadState(); err != nil {
+		return nil, err
+	}
+
+	c.httpClient.Transport = &maintenanceTransport{
+		logger: c.logger,
+		next:   c.httpClient.Transport,
+	}
+
+	gc, err := gclient.NewClient(serverURL, gclient.SetToken(c.token), gclient.SetGiteaVersion(""),
+		gclient.SetHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, err
+	}
+
+	c.gc = gc
+
+	return c, nil
+}
+
+// api returns the gitea API client making its requests with ctx.
+func (c *Client) api(ctx context.Context) *gclient.Client {
+	gc, err := gclient.NewClient(c.serverURL, gclient.SetToken(c.token), gclient.SetGiteaVersion(""),
+		gclient.SetHTTPClient(c.httpClient), gclient.SetContext(ctx))
+	if err != nil {
+		return c.gc
+	}
+
+	return gc
+}
+
+// PagesRepo returns the name of the gitea-pages repo (and branch) of owner.
+func (c *Client) PagesRepo(owner string) string {
+	return c.names(owner).repo
+}
+
+// SiteExists reports if name resolves to a published site at ref. Errors
+// looking it up don't tell it doesn't exist, they're reported by Open.
+func (c *Client) SiteExists(ctx context.Context, name, ref string) bool {
+	_, err := c.resolve(ctx, name, ref)
+	return !errors.Is(err, fs.ErrNotExist)
 }
 
 func (c *Client) Open(name, ref string) (fs.File, error) {
+	return c.OpenConditional(name, ref, nil)
+}
+
+// OpenConditional opens name like Open but forwards the conditional headers
+// (If-None-Match, If-Modified-Since) of header to gitea.
+// It returns a *NotModifiedError when gitea answers with 304 Not Modified.
+func (c *Client) OpenConditional(name, ref string, header http.Header) (fs.File, error) {
+	return c.openStale(context.Background(), name, ref, header, nil)
+}
+
+// OpenVisitor opens name like OpenConditional for the visitor v, applying
+// the redirect rules of the site. It returns a *RedirectError when a rule
+// redirects the visitor. The requests to gitea are cancelled with ctx, the
+// context of the request of the visitor.
+func (c *Client) OpenVisitor(ctx context.Context, name, ref string, v *Visitor) (fs.File, error) {
+	return c.openStale(ctx, name, ref, v.Header, v)
+}
+
+// openStale opens name and keeps a copy to serve while gitea is in maintenance.
+func (c *Client) openStale(ctx context.Context, name, ref string, header http.Header, v *Visitor) (fs.File, error) {
+	f, err := c.openWithin(ctx, name, ref, header, v)
+
+	staleKey := name + "@" + ref
+	if prefersMarkdown(header) || header.Get("Range") != "" {
+		staleKey += "#source"
+	}
+
+	switch {
+	case err == nil:
+		// 404 pages aren't the copy of the file, restricted sites aren't
+		// kept as their copy would be served without authorizing anyone
+		switch {
+		case c.stale == nil:
+		case f.restricted:
+			c.stale.remove(staleKey)
+		case f.stream == nil && f.status == 0 && !c.relieveMemory():
+			c.stale.put(staleKey, f)
+		}
+	case errors.Is(err, ErrMaintenance), errors.Is(err, ErrTimeout):
+		if sf := c.stale.get(staleKey); sf != nil {
+			return &openFile{
+				content:     sf.content,
+				name:        sf.name,
+				etag:        sf.etag,
+				modTime:     sf.modTime,
+				stale:       true,
+				contentType: sf.contentType,
+				variant:     sf.variant,
+				headers:     sf.headers,
+			}, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// restricted reports if the site s is private or restricted by auth. A site
+// that can't be looked up is taken as restricted.
+func (c *Client) restricted(s *site) bool {
+	if s.auth {
+		return true
+	}
+
+	private, err := c.repoPrivate(s.ctx, s.owner, s.repo)
+
+	return err != nil || private
+}
+
+// visit resolves name for the visitor v, picking the variant of a site with
+// a canary and applying the redirect rules of the site.
+func (c *Client) visit(ctx context.Context, name, ref string, header http.Header, v *Visitor) (*site, error) {
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkFileCount(s); err != nil {
+		return nil, err
+	}
+
+	// visitors of a site with a canary may see another ref
+	if s.canary != nil {
+		s.variant = s.canary.variant(header)
+		if s.variant == VariantCanary {
+			s.ref = s.canary.ref
+		}
+	}
+
+	if v != nil {
+		if err := c.redirect(s, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (c *Client) open(ctx context.Context, name, ref string, header http.Header, v *Visitor) (*openFile, error) {
+	s, err := c.visit(ctx, name, ref, header, v)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.openSite(s, header, v)
+	if err != nil {
+		return nil, err
+	}
+
+	// only stale copies need to know, see openStale
+	if c.stale != nil {
+		f.restricted = c.restricted(s)
+	}
+
+	return f, nil
+}
+
+// openSite opens the file of the site s the visitor v asks for.
+func (c *Client) openSite(s *site, header http.Header, v *Visitor) (*openFile, error) {
+	if err := s.embargo(s.filepath, v); err != nil {
+		return nil, err
+	}
+
+	if err := c.shortLink(s); err != nil {
+		return nil, err
+	}
+
+	// resolve pretty urls (file, file.html, file/index.html) using the git tree
+	filepath, err := c.prettyPath(s, s.filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// blogs get generated listing pages
+		if f, berr := c.blogPage(s); f != nil || berr != nil {
+			if berr != nil {
+				return nil, berr
+			}
+
+			c.inventory.served(s.owner, s.repo, s.ref, s.filepath, len(f.content))
+
+			return f, nil
+		}
+
+		// the page may have moved, listing the path as alias
+		if aerr := c.aliasRedirect(s); aerr != nil {
+			return nil, aerr
+		}
+
+		// visitors see the index of single-page apps or the 404 page
+		if v != nil {
+			if f, ferr := c.fallbackPage(s, v); f != nil || ferr != nil {
+				if ferr != nil {
+					return nil, ferr
+				}
+
+				c.inventory.served(s.owner, s.repo, s.ref, s.filepath, len(f.content))
+
+				return f, nil
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the file may be embargoed under another name (foo.html for /foo)
+	if err := s.embargo(filepath, v); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkExtension(s, filepath); err != nil {
+		return nil, err
+	}
+
+	// exported snapshots of the site spare gitea, they only have rendered pages
+	var f *openFile
+	if !c.wantsSource(filepath, header) {
+		f, err = c.shielded(s, filepath, header)
+	}
+
+	if f == nil && err == nil {
+		f, err = c.openSiteFile(s, filepath, header)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.inventory.served(s.owner, s.repo, s.ref, filepath, int(f.size()))
+
+	return f, nil
+}
+
+// openSiteFile fetches filepath of the site s and renders it.
+func (c *Client) openSiteFile(s *site, filepath string, header http.Header) (*openFile, error) {
+	var (
+		raw *rawFile
+		err error
+	)
+
+	// large files served as is go straight from gitea to the client
+	if c.streamable(filepath) {
+		var f *openFile
+		if f, raw, err = c.openStream(s, filepath, header); f != nil || err != nil {
+			return f, err
+		}
+	}
+
+	if raw == nil {
+		raw, err = c.fetchRaw(s.ctx, s.owner, s.repo, filepath, s.ref, header)
+	}
+
+	// the 304 has the validators the file is served with
+	var nm *NotModifiedError
+	if errors.As(err, &nm) {
+		if etag := nm.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") && c.renders(s, filepath, header) {
+			nm.Header.Set("ETag", "W/"+etag)
+		}
+
+		if cc := siteHeaders(s, filepath).Get("Cache-Control"); cc != "" {
+			nm.Header.Set("Cache-Control", cc)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// moved pages redirect instead of being rendered, drafts only show in previews
+	if meta := pageMeta(filepath, raw.content); meta != nil {
+		if err := pageRedirect(s, meta); err != nil {
+			return nil, err
+		}
+
+		if isDraft(meta) && !s.preview {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	res := raw.content
+	etag := raw.etag
+	contentType := webAppType(filepath)
+
+	headers := siteHeaders(s, filepath)
+
+	// rendered markdown pages are negotiated
+	if isMarkdown(filepath) && s.markdown {
+		headers.Add("Vary", "Accept")
+	}
+
+	// serve markdown as is if rendering is disabled or the source (or a
+	// range of it) is asked for
+	if isMarkdown(filepath) && (!s.markdown || c.wantsSource(filepath, header)) {
+		contentType = "text/markdown; charset=utf-8"
+	} else if r := c.renderer(filepath); r != nil && !c.wantsSource(filepath, header) {
+		var rendered []byte
+
+		// markdown pages may have a layout, other renderers, pages without
+		// layout and broken layouts get the default page
+		if isMarkdown(filepath) {
+			page, layoutETag, lerr := c.renderLayout(s, filepath, res, etag)
+
+			switch {
+			case lerr == nil:
+				rendered, etag = page, layoutETag
+			case !errors.Is(lerr, errLayoutUnusable):
+				c.logger.Warn("rendering layout failed", zap.String("owner", s.owner),
+					zap.String("repo", s.repo), zap.String("file", filepath), zap.Error(lerr))
+			}
+		}
+
+		if rendered == nil {
+			rendered, err = c.renderPage(s, r, filepath, res)
+		} else {
+			rendered = sanitizePage(rendered, s.sanitize)
+		}
+
+		var warning *RenderWarning
+		if errors.As(err, &warning) && rendered != nil {
+			c.logger.Warn("rendering with problems", zap.String("owner", s.owner), zap.String("repo", s.repo),
+				zap.String("file", filepath), zap.Error(warning.Err))
+		} else if err != nil {
+			return nil, err
+		}
+
+		res = rendered
+
+		// the rendered page is only semantically equivalent to the source
+		if etag != "" && !strings.HasPrefix(etag, "W/") {
+			etag = "W/" + etag
+		}
+	}
+
+	if c.gitHeaders {
+		setGitHeaders(headers, c.gitInfo(s, filepath))
+	}
+
+	return &openFile{
+		content:     res,
+		name:        filepath,
+		etag:        etag,
+		modTime:     raw.modTime,
+		contentType: contentType,
+		variant:     s.variant,
+		headers:     headers,
+	}, nil
+}
+
+// site is a request resolved to the repo and ref that will serve it.
+type site struct {
+	// ctx is the context of the request, the requests to gitea for the site
+	// are cancelled with it
+	ctx context.Context
+
+	owner    string
+	repo     string
+	filepath string
+	ref      string
+	// urlRef is the ref in the url of the site, empty for its default version
+	urlRef   string
+	allowall bool
+	markdown bool
+	sanitize string
+	canary   *canary
+	variant  string
+	preview  bool
+	blog     *blogConfig
+	layout   string
+
+	// embargoes lock files until a point in time
+	embargoes []embargoRule
+	// unblocked are the blocked extensions the site may serve
+	unblocked map[string]bool
+	forms     map[string]*formConfig
+	comments  *commentsConfig
+	// domains are the custom domains of its topics and config
+	domains []string
+	// websiteDomain claims the host of the website of the repo too
+	websiteDomain bool
+	// canonicalDomain is the primary custom domain of its config, its
+	// secondaries redirect to it if redirectSecondary is set
+	canonicalDomain   string
+	redirectSecondary bool
+	// single-page apps serve their index for missing routes
+	spa          bool
+	notFoundPage string
+	// auth restricts a public site to visitors that can read the repo
+	auth bool
+	// tier is the tier of its topics, empty for the default tier
+	tier string
+	// indexFiles are the files served for a directory, headers are set on
+	// every response, both from the config
+	indexFiles []string
+	headers    http.Header
+
+	crossOriginIsolated bool
+}
+
+// resolve resolves the site that serves name at ref, unless it's taken down.
+func (c *Client) resolve(ctx context.Context, name, ref string) (*site, error) {
+	owner, repo, filepath := splitName(name)
+
+	// name and ref come from the request and end up in gitea API urls
+	if !validName(owner, repo, filepath, ref) {
+		return nil, fs.ErrNotExist
+	}
+
+	// taken down sites aren't even looked up
+	if err := c.takedowns.check(owner, repo); err != nil {
+		return nil, err
+	}
+
+	s, err := c.resolveSite(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ctx = ctx
+
+	// the site may be served from the gitea-pages repo
+	if err := c.takedowns.check(s.owner, s.repo); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// resolveSite splits name into owner, repo and filepath, checks that the repo
+// allows pages and validates ref against the repo config.
+func (c *Client) resolveSite(ctx context.Context, name, ref string) (*site, error) {
 	owner, repo, filepath := splitName(name)
 
+	if !c.owners.allowed(owner) {
+		return nil, fs.ErrNotExist
+	}
+
+	names := c.names(owner)
+
 	// if repo is empty they want to have the gitea-pages repo
 	if repo == "" {
-		repo = c.giteapages
-		filepath = "index.html"
+		repo = names.repo
+	}
+
+	notFoundKey := owner + "/" + repo
+	if c.notFound.has(notFoundKey) {
+		return nil, fs.ErrNotExist
 	}
 
-	// if filepath is empty they want to have the index.html
-	if filepath == "" {
-		filepath = "index.html"
+	// we need to check if the repo exists (and allows access), the config is
+	// fetched at the same time but only used if it does
+	var (
+		tc     topicConfig
+		cfg    []byte
+		cfgErr error
+	)
+
+	g := new(errgroup.Group)
+	g.Go(func() (err error) {
+		tc, err = c.allowsPages(ctx, owner, repo, names)
+		return err
+	})
+	g.Go(func() error {
+		cfg, cfgErr = c.fetchConfig(ctx, owner, repo, names)
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 
-	// we need to check if the repo exists (and allows access)
-	limited, allowall := c.allowsPages(owner, repo)
-	if !limited && !allowall {
+	var defaultBranch bool
+
+	if !tc.limited {
 		// if we're checking the gitea-pages and it doesn't exist, return 404
-		if repo == c.giteapages && !c.hasRepoBranch(owner, repo, c.giteapages) {
+		if repo == names.repo && !c.hasRepoBranch(ctx, owner, repo, names.repo) {
+			// a cancelled request didn't find out
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			c.notFound.add(notFoundKey)
+
 			return nil, fs.ErrNotExist
 		}
 
 		// the repo didn't exist but maybe it's a filepath in the gitea-pages repo
-		// so we need to check if the gitea-pages repo exists
-		filepath = repo
-		repo = c.giteapages
+		// so we need to check if the gitea-pages repo exists; the whole path is
+		// kept, taking only its first element served /dir/page.html as /dir
+		filepath = path.Join(repo, filepath)
+		repo = names.repo
 
-		if ref == "" {
-			ref = c.giteapages
+		var hasBranch bool
+
+		g := new(errgroup.Group)
+		g.Go(func() (err error) {
+			tc, err = c.allowsPages(ctx, owner, repo, names)
+			return err
+		})
+		g.Go(func() error {
+			hasBranch = c.hasRepoBranch(ctx, owner, repo, names.repo)
+			return nil
+		})
+		g.Go(func() error {
+			cfg, cfgErr = c.fetchConfig(ctx, owner, repo, names)
+			return nil
+		})
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+
+		// without a gitea-pages branch an allowall repo serves its default branch
+		defaultBranch = c.defaultFallback && tc.allowAll && tc.branch == "" && !hasBranch
+
+		if ref == "" && !defaultBranch {
+			ref = names.repo
+			if tc.branch != "" {
+				ref = tc.branch
+			}
 		}
 
-		limited, allowall = c.allowsPages(owner, repo)
-		if !limited && !allowall || !c.hasRepoBranch(owner, repo, c.giteapages) {
+		if !tc.limited || tc.branch == "" && !hasBranch && !defaultBranch {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			c.notFound.add(notFoundKey)
+
 			return nil, fs.ErrNotExist
 		}
 	}
 
-	hasConfig := true
+	allowall := tc.allowAll
+
+	var rc *repoConfig
+	if cfgErr == nil {
+		rc, cfgErr = c.repoConfig(owner, repo, cfg)
+	}
+
+	if tc.branch != "" {
+		// the topics configure the site, it has no config file
+		rc = nil
 
-	if err := c.readConfig(owner, repo); err != nil {
+		if ref == "" {
+			ref = tc.branch
+		}
+	} else if cfgErr != nil {
 		// we don't need a config for gitea-pages
 		// no config is only exposing the gitea-pages branch
-		if repo != c.giteapages && !allowall {
+		if repo != names.repo && !allowall {
+			return nil, cfgErr
+		}
+
+		rc = nil
+	}
+
+	// the repo config can override whether markdown is rendered
+	markdown := c.renderMarkdown
+	if rc != nil && rc.IsSet("rendermarkdown") {
+		markdown = rc.GetBool("rendermarkdown")
+	}
+
+	sanitize := c.sanitizePolicy("")
+	if rc != nil {
+		sanitize = c.sanitizePolicy(rc.GetString("sanitize"))
+	}
+
+	blog := blogSettings(rc)
+
+	// markdown pages can have a layout template in the repo
+	var layout string
+	if rc != nil {
+		layout = strings.TrimPrefix(rc.GetString("layout"), "/")
+	}
+
+	indexFiles := defaultIndexFiles
+
+	var (
+		headers           http.Header
+		canonicalDomain   string
+		redirectSecondary bool
+	)
+
+	if rc != nil {
+		indexFiles, headers = rc.indexFiles, rc.headers
+		canonicalDomain, redirectSecondary = rc.canonicalDomain, rc.redirectSecondary
+	}
+
+	embargoes := c.embargoSettings(owner, repo, rc)
+	unblocked := c.unblockedExtensions(owner, repo, rc)
+	forms := formSettings(rc)
+	comments := commentsSettings(rc)
+	domains := append(tc.domains, c.configDomains(owner, repo, rc)...)
+	spa, notFoundPage := fallbackSettings(rc)
+	auth := tc.auth || rc != nil && rc.GetBool("auth")
+
+	// pull request previews are served from the head of the pull request
+	if index, ok := c.previewIndex(ref); ok && (allowall || rc != nil && rc.GetBool("prpreviews")) {
+		sha, err := c.previewSHA(ctx, owner, repo, index)
+		if err != nil {
 			return nil, err
 		}
 
-		hasConfig = false
+		return &site{
+			owner:    owner,
+			repo:     repo,
+			filepath: filepath,
+			ref:      sha,
+			urlRef:   ref,
+			allowall: allowall,
+			markdown: markdown,
+			sanitize: sanitize,
+			preview:  true,
+			blog:     blog,
+			layout:   layout,
+
+			embargoes: embargoes,
+			unblocked: unblocked,
+			forms:     forms,
+			comments:  comments,
+			domains:   domains,
+
+			spa:          spa,
+			notFoundPage: notFoundPage,
+			auth:         auth,
+			tier:         tc.tier,
+			indexFiles:   indexFiles,
+			headers:      headers,
+
+			websiteDomain:     tc.websiteDomain,
+			canonicalDomain:   canonicalDomain,
+			redirectSecondary: redirectSecondary,
+		}, nil
+	}
+
+	// the config may serve another ref than the default branch by default
+	defaultVersion, urlRef := ref == "", ref
+	if defaultVersion && rc != nil && rc.defaultRef != "" {
+		ref = rc.defaultRef
 	}
 
-	// if we don't have a config and the repo is the gitea-pages
-	// always overwrite the ref to the gitea-pages branch
-	if !hasConfig && (repo == c.giteapages || ref == c.giteapages) {
-		ref = c.giteapages
-	} else if !validRefs(ref, allowall) {
+	switch {
+	case tc.branch != "":
+		// only the branch of the topic is exposed
+		if ref != tc.branch && !allowall {
+			return nil, fs.ErrNotExist
+		}
+	case rc == nil && (repo == names.repo || ref == names.repo):
+		// if we don't have a config and the repo is the gitea-pages
+		// always overwrite the ref to the gitea-pages branch, or to the
+		// default branch if an allowall repo has none
+		ref = names.repo
+
+		if c.defaultFallback && allowall &&
+			(defaultBranch || !c.hasRepoBranch(ctx, owner, repo, names.repo)) {
+			ref = ""
+		}
+	case !allowall && !rc.allowsRef(ref):
 		return nil, fs.ErrNotExist
 	}
 
-	res, err := c.getRawFileOrLFS(owner, repo, filepath, ref)
+	s := &site{
+		owner:    owner,
+		repo:     repo,
+		filepath: filepath,
+		ref:      ref,
+		urlRef:   urlRef,
+		allowall: allowall,
+		markdown: markdown,
+		sanitize: sanitize,
+		blog:     blog,
+		layout:   layout,
+
+		embargoes: embargoes,
+		unblocked: unblocked,
+		forms:     forms,
+		comments:  comments,
+		domains:   domains,
+
+		spa:          spa,
+		notFoundPage: notFoundPage,
+		auth:         auth,
+		tier:         tc.tier,
+		indexFiles:   indexFiles,
+		headers:      headers,
+
+		websiteDomain:     tc.websiteDomain,
+		canonicalDomain:   canonicalDomain,
+		redirectSecondary: redirectSecondary,
+	}
+
+	s.crossOriginIsolated = rc != nil && rc.GetBool("crossoriginisolated")
+
+	// a canary only applies to the default version of the site
+	if rc != nil && defaultVersion && rc.GetString("canary.ref") != "" && rc.GetInt("canary.percent") > 0 {
+		s.canary = &canary{
+			ref:     rc.GetString("canary.ref"),
+			percent: rc.GetInt("canary.percent"),
+		}
+	}
+
+	return s, nil
+}
+
+// ErrNotModified is returned when gitea answers a conditional request
+// with 304 Not Modified, as *NotModifiedError.
+var ErrNotModified = errors.New("not modified")
+
+// NotModifiedError is returned when the file a conditional request asks for
+// didn't change. Header has the validators to send with the 304.
+type NotModifiedError struct {
+	Header http.Header
+}
+
+func (e *NotModifiedError) Error() string {
+	return ErrNotModified.Error()
+}
+
+// Is makes a *NotModifiedError match ErrNotModified.
+func (e *NotModifiedError) Is(target error) bool {
+	return target == ErrNotModified
+}
+
+// notModifiedHeaders are the headers of gitea's 304 sent with ours.
+var notModifiedHeaders = []string{"ETag", "Last-Modified", "Cache-Control"}
+
+// notModified returns the error of a 304 with the validators of header.
+func notModified(header http.Header) *NotModifiedError {
+	h := make(http.Header)
+
+	for _, k := range notModifiedHeaders {
+		if v := header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+
+	return &NotModifiedError{Header: h}
+}
+
+// conditionalHeaders are the request headers forwarded to gitea.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// rawFile is a file fetched from gitea together with its validators.
+type rawFile struct {
+	content []byte
+	etag    string
+	modTime time.Time
+}
+
+// notModified returns the error of a 304 to a conditional request of f.
+func (f *rawFile) notModified() *NotModifiedError {
+	h := make(http.Header)
+	h.Set("ETag", f.etag)
+
+	if !f.modTime.IsZero() {
+		h.Set("Last-Modified", f.modTime.UTC().Format(http.TimeFormat))
+	}
+
+	return &NotModifiedError{Header: h}
+}
+
+func (c *Client) getRawFileOrLFS(ctx context.Context, owner, repo, filepath, ref string) ([]byte, error) {
+	f, err := c.fetchRaw(ctx, owner, repo, filepath, ref, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	if strings.HasSuffix(filepath, ".md") {
-		res, err = handleMD(res)
-		if err != nil {
-			return nil, err
+	return f.content, nil
+}
+
+// fetchRaw fetches filepath from gitea, forwarding the conditional headers
+// of header (if any).
+func (c *Client) fetchRaw(ctx context.Context, owner, repo, filepath, ref string, header http.Header) (*rawFile, error) {
+	return c.fetchRawVia(ctx, owner, repo, filepath, ref, header, c.peers.home(owner, repo))
+}
+
+// fetchRawVia fetches filepath like fetchRaw, through the peer if it's not
+// empty; gitea is asked if the peer fails.
+func (c *Client) fetchRawVia(ctx context.Context, owner, repo, filepath, ref string, header http.Header, peer string) (*rawFile, error) {
+	cacheKey := fileCacheKey(owner, repo, filepath, ref)
+
+	f, ok := c.cachedRaw(owner, repo, cacheKey)
+	if !ok {
+		f, ok = c.archived(owner, repo, filepath, ref)
+	}
+
+	if ok {
+		if inm := header.Get("If-None-Match"); inm != "" && f.etag != "" &&
+			strings.Contains(strings.ReplaceAll(inm, "W/", ""), strings.TrimPrefix(f.etag, "W/")) {
+			return nil, f.notModified()
 		}
+
+		return f, nil
 	}
 
-	return &openFile{
-		content: res,
-		name:    filepath,
-	}, nil
+	c.fetches.begin()
+	defer c.fetches.end()
+
+	var err error
+
+	if peer != "" {
+		f, err = c.fetchMedia(ctx, c.peers.httpClient, peer+PeerPath, "peer "+c.peers.secret, owner, repo, filepath, ref, header)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, ErrNotModified) && !errors.Is(err, ErrSiteLimit) {
+			c.logger.Debug("fetching from peer failed, asking gitea", zap.String("peer", peer), zap.Error(err))
+			f, err = nil, nil
+		}
+	}
+
+	if f == nil && err == nil {
+		f, err = c.fetchMedia(ctx, c.httpClient, c.serverURL, "token "+c.token, owner, repo, filepath, ref, header)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.cacheFull(owner, repo, len(f.content)) && !c.relieveMemory() {
+		c.cacheRaw(ctx, owner, repo, cacheKey, f)
+	}
+
+	return f, nil
 }
 
-func (c *Client) getRawFileOrLFS(owner, repo, filepath, ref string) ([]byte, error) {
-	var (
-		giteaURL string
-		err      error
-	)
+// fetchMedia fetches filepath from the gitea media api at serverURL (gitea
+// or a peer) with the authorization auth.
+func (c *Client) fetchMedia(ctx context.Context, client *http.Client, serverURL, auth, owner, repo, filepath, ref string, header http.Header) (*rawFile, error) {
+	resp, err := c.mediaResponse(ctx, client, serverURL, auth, owner, repo, filepath, ref, header, false)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return c.readMedia(resp, owner, repo, filepath)
+}
 
+// mediaResponse requests filepath from the gitea media api at serverURL,
+// forwarding the conditional headers of header. Streamed requests forward
+// its range too and aren't compressed.
+func (c *Client) mediaResponse(ctx context.Context, client *http.Client, serverURL, auth, owner, repo, filepath, ref string, header http.Header, stream bool) (*http.Response, error) {
 	// TODO: make pr for go-sdk
 	// gitea sdk doesn't support "media" type for lfs/non-lfs
-	giteaURL, err = url.JoinPath(c.serverURL+"/api/v1/repos/", owner, repo, "media", filepath)
+	giteaURL, err := url.JoinPath(serverURL+"/api/v1/repos/", owner, repo, "media", filepath)
 	if err != nil {
 		return nil, err
 	}
 
 	giteaURL += "?ref=" + url.QueryEscape(ref)
 
-	req, err := http.NewRequest(http.MethodGet, giteaURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, giteaURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Authorization", "token "+c.token)
+	req.Header.Add("Authorization", auth)
+
+	if stream {
+		// ranges are of the identity, which has a known length
+		req.Header.Set("Accept-Encoding", "identity")
+
+		for _, h := range streamedHeaders {
+			if v := header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+	} else {
+		// ask for a compressed body explicitly, we decompress it ourselves below
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	for _, h := range conditionalHeaders {
+		// our weak etags of rendered files are strong etags upstream
+		if v := strings.ReplaceAll(header.Get(h), "W/", ""); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return resp, nil
+	case resp.StatusCode == http.StatusPartialContent && stream:
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
 	switch resp.StatusCode {
 	case http.StatusNotFound:
 		return nil, fs.ErrNotExist
-	case http.StatusOK:
-	default:
-		return nil, fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	case http.StatusNotModified:
+		return nil, notModified(resp.Header)
 	}
 
-	res, err := io.ReadAll(resp.Body)
-	if err != nil {
+	return nil, fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+}
+
+// readMedia reads the body of the response resp for filepath, within the
+// file size limits.
+func (c *Client) readMedia(resp *http.Response, owner, repo, filepath string) (*rawFile, error) {
+	if err := c.checkFileSize(owner, repo, filepath, resp.ContentLength); err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	body := io.Reader(resp.Body)
 
-	return res, nil
-}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
 
-var bufPool = sync.Pool{
-	New: func() any {
-		return new(bytes.Buffer)
-	},
-}
+		defer gz.Close()
+
+		body = gz
+
+		// a small compressed body may be large
+		if c.maxUpstreamBody > 0 {
+			body = &guardedBody{ReadCloser: gz, left: c.maxUpstreamBody + 1}
+		}
+	}
+
+	// the content length is unknown (or compressed), don't read more than allowed
+	if c.limits.maxFileSize > 0 {
+		body = io.LimitReader(body, c.limits.maxFileSize+1)
+	}
 
-func handleMD(res []byte) ([]byte, error) {
-	meta, resbody, err := extractFrontMatter(string(res))
+	res, err := io.ReadAll(body)
 	if err != nil {
 		return nil, err
 	}
 
-	resmd, err := markdown([]byte(resbody))
-	if err != nil {
+	if err := c.checkFileSize(owner, repo, filepath, int64(len(res))); err != nil {
 		return nil, err
 	}
 
-	title, _ := meta["title"].(string)
+	f := &rawFile{
+		content: res,
+		etag:    resp.Header.Get("ETag"),
+	}
+
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		f.modTime, _ = http.ParseTime(lm)
+	}
 
-	res = append([]byte("<!DOCTYPE html>\n<html>\n<body>\n<h1>"), []byte(title)...)
-	res = append(res, []byte("</h1>")...)
-	res = append(res, resmd...)
-	res = append(res, []byte("</body></html>")...)
+	return f, nil
+}
 
-	return res, nil
+var bufPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
 }
 
-func (c *Client) repoTopics(owner, repo string) ([]string, error) {
-	repos, _, err := c.gc.ListRepoTopics(owner, repo, gclient.ListRepoTopicsOptions{})
-	return repos, err
+// maxPooledBuffer is the largest buffer put back in bufPool, the buffers of
+// huge pages are left to the garbage collector instead of being kept around.
+const maxPooledBuffer = 1 << 20
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufPool.Put(buf)
+	}
 }
 
-func (c *Client) hasRepoBranch(owner, repo, branch string) bool {
-	b, _, err := c.gc.GetRepoBranch(owner, repo, branch)
+func (c *Client) hasRepoBranch(ctx context.Context, owner, repo, branch string) bool {
+	if exists, ok := c.lookups.hasBranch(owner, repo, branch); ok {
+		return exists
+	}
+
+	b, resp, err := c.api(ctx).GetRepoBranch(owner, repo, branch)
 	if err != nil {
+		// only a missing branch is known not to exist
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			c.lookups.setBranch(owner, repo, branch, false)
+		}
+
 		return false
 	}
 
+	c.lookups.setBranch(owner, repo, branch, b.Name == branch)
+
 	return b.Name == branch
 }
 
-func (c *Client) allowsPages(owner, repo string) (bool, bool) {
-	topics, err := c.repoTopics(owner, repo)
-	if err != nil {
-		return false, false
+// allowsPages returns the pages configuration of the topics of the repo, if
+// it has the gitea-pages (limited), the gitea-pages-allowall or structured
+// topics. A repo that doesn't exist allows nothing, other errors of the gitea
+// API are returned.
+func (c *Client) allowsPages(ctx context.Context, owner, repo string, names ownerNames) (topicConfig, error) {
+	topics, err := c.repoTopics(ctx, owner, repo)
+	if errors.Is(err, fs.ErrNotExist) {
+		return topicConfig{}, nil
 	}
 
-	for _, topic := range topics {
-		if topic == c.giteapagesAllowAll {
-			return true, true
-		}
+	if err != nil {
+		return topicConfig{}, err
 	}
 
-	for _, topic := range topics {
-		if topic == c.giteapages {
-			return true, false
-		}
-	}
+	tc := parseTopics(topics, names)
+	tc.domains = c.customDomains(owner, repo, tc.domains)
 
-	return false, false
+	return tc, nil
 }
 
-func (c *Client) readConfig(owner, repo string) error {
-	cfg, err := c.getRawFileOrLFS(owner, repo, c.giteapages+".toml", c.giteapages)
-	if err != nil {
-		return err
+// fetchConfig fetches the config of the repo from its description (if
+// enabled) or its config file.
+func (c *Client) fetchConfig(ctx context.Context, owner, repo string, names ownerNames) ([]byte, error) {
+	if c.descriptionConfig {
+		cfg, err := c.fetchDescriptionConfig(ctx, owner, repo)
+		if err != nil || cfg != nil {
+			return cfg, err
+		}
 	}
 
-	viper.SetConfigType("toml")
-
-	return viper.ReadConfig(bytes.NewBuffer(cfg))
+	return c.getRawFileOrLFS(ctx, owner, repo, names.pages+".toml", names.pages)
 }
 
 func splitName(name string) (string, string, string) {
@@ -251,22 +1156,3 @@ func splitName(name string) (string, string, string) {
 		return parts[0], parts[1], strings.Join(parts[2:], "/")
 	}
 }
-
-func validRefs(ref string, allowall bool) bool {
-	if allowall {
-		return true
-	}
-
-	validrefs := viper.GetStringSlice("allowedrefs")
-	for _, r := range validrefs {
-		if r == ref {
-			return true
-		}
-
-		if r == "*" {
-			return true
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,11 @@
+// Package giteabench benchmarks the gitea client against the fake gitea
+// server of giteatest: opening a file without cache (the cold path), opening
+// it from the cache (the warm path) and rendering markdown. Every benchmark
+// has a budget, so features added to these paths (auth, redirects, headers)
+// that slow them down are noticed.
+//
+// The benchmarks run with go test -bench, the budgets are checked with
+// go test -budget. They're for a current x86-64 server core and leave room
+// for noisy machines, only regressions of about twice the cost exceed them.
+// Raise a budget in the change that needs it, with the reason.
+package giteabench
@@ -0,0 +1,179 @@
+package giteabench
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+var budget = flag.Bool("budget", false, "run the benchmarks and check them against their budgets")
+
+// Budget is the most a benchmark may cost per operation.
+type Budget struct {
+	NsPerOp     int64
+	AllocsPerOp int64
+}
+
+// budgets are the budgets of the benchmarks.
+var budgets = []struct {
+	Name   string
+	Budget Budget
+	Run    func(b *testing.B)
+}{
+	{Name: "cold-open", Budget: Budget{NsPerOp: 2 * int64(time.Millisecond), AllocsPerOp: 1500}, Run: BenchmarkColdOpen},
+	{Name: "warm-open", Budget: Budget{NsPerOp: 200 * int64(time.Microsecond), AllocsPerOp: 600}, Run: BenchmarkWarmOpen},
+	// rendered pages are cached by their source, like the files they're
+	// rendered from
+	{Name: "warm-open-markdown", Budget: Budget{NsPerOp: 300 * int64(time.Microsecond), AllocsPerOp: 1200}, Run: BenchmarkWarmOpenMarkdown},
+	{Name: "render-markdown", Budget: Budget{NsPerOp: 6 * int64(time.Millisecond), AllocsPerOp: 30000}, Run: BenchmarkRenderMarkdown},
+}
+
+// TestBudgets runs the benchmarks with -budget and fails for those over
+// their budget.
+func TestBudgets(t *testing.T) {
+	if !*budget {
+		t.Skip("checked with -budget")
+	}
+
+	for _, bm := range budgets {
+		r := testing.Benchmark(bm.Run)
+
+		status := "ok"
+		if r.NsPerOp() > bm.Budget.NsPerOp || r.AllocsPerOp() > bm.Budget.AllocsPerOp {
+			status = "OVER BUDGET"
+			t.Errorf("%s is over budget", bm.Name)
+		}
+
+		t.Logf("%-20s %12d ns/op (budget %d) %8d allocs/op (budget %d) %s", bm.Name,
+			r.NsPerOp(), bm.Budget.NsPerOp, r.AllocsPerOp(), bm.Budget.AllocsPerOp, status)
+	}
+}
+
+// page is the markdown page rendered by the benchmarks, a few screens of
+// the usual documentation: headings, lists, tables and code.
+var page = func() string {
+	var sb strings.Builder
+
+	sb.WriteString("---\ntitle: Benchmark\n---\n")
+
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&sb, "## Section %d\n\nSome *emphasis*, **strong** and `code` with a [link](/page%d).\n\n", i, i)
+		sb.WriteString("- one\n- two\n- [x] three\n\n| a | b |\n| - | - |\n| 1 | 2 |\n\n")
+		sb.WriteString("```go\nfunc main() {\n\tprintln(\"hello\")\n}\n```\n\n")
+	}
+
+	return sb.String()
+}()
+
+// newServer returns a fake gitea with the site of user: an index.html and
+// the markdown page.
+func newServer() *giteatest.Server {
+	srv := giteatest.NewServer()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {
+				"index.html": "<!DOCTYPE html>\n<p>" + strings.Repeat("hello ", 1000) + "</p>\n",
+				"page.md":    page,
+			},
+		},
+	})
+
+	return srv
+}
+
+// newClient returns a client of srv caching in memory, like the handler
+// does by default.
+func newClient(b *testing.B, srv *giteatest.Server) *gitea.Client {
+	c, err := gitea.NewClient(srv.URL, "token", "", "", gitea.SetCache(gitea.NewMemoryCache(64<<20), time.Hour))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return c
+}
+
+// open reads the file name of c.
+func open(b *testing.B, c *gitea.Client, name string) {
+	f, err := c.Open(name, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		b.Fatal(err)
+	}
+
+	f.Close()
+}
+
+// BenchmarkColdOpen opens a file with a new client every time, so the repo,
+// its topics, tree and the file are fetched from gitea.
+func BenchmarkColdOpen(b *testing.B) {
+	srv := newServer()
+	defer srv.Close()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		c := newClient(b, srv)
+		b.StartTimer()
+
+		open(b, c, "user/gitea-pages/index.html")
+	}
+}
+
+// BenchmarkWarmOpen opens a file from the cache.
+func BenchmarkWarmOpen(b *testing.B) {
+	warm(b, "user/gitea-pages/index.html")
+}
+
+// BenchmarkWarmOpenMarkdown opens a rendered markdown page from the cache.
+func BenchmarkWarmOpenMarkdown(b *testing.B) {
+	warm(b, "user/gitea-pages/page.md")
+}
+
+func warm(b *testing.B, name string) {
+	srv := newServer()
+	defer srv.Close()
+
+	c := newClient(b, srv)
+	open(b, c, name)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		open(b, c, name)
+	}
+}
+
+// BenchmarkRenderMarkdown renders the markdown page without fetching it.
+func BenchmarkRenderMarkdown(b *testing.B) {
+	content := []byte(page)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+
+	for i := 0; i < b.N; i++ {
+		res, err := gitea.MarkdownRenderer.Render("page.md", content)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if !bytes.Contains(res, []byte("<h2")) {
+			b.Fatal("page not rendered")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package giteatest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Behavior is a behavior of sites served by the gitea client (through the
+// gitea handler), for a request of Path on the site of GitHubPagesRepo. They
+// document what sites moving from GitHub Pages can count on, see GitHubPages.
+type Behavior struct {
+	// Name is what is checked, e.g. "clean urls".
+	Name string
+	// Path is the path requested on the host of the site.
+	Path string
+	// Status is the status of the response.
+	Status int
+	// Location is where the response redirects to, if it does.
+	Location string
+	// Body is contained in the body of the response.
+	Body string
+	// GitHub is whether GitHub Pages behaves the same, Note how it differs.
+	GitHub bool
+	Note   string
+}
+
+// GitHubPages are the behaviors of GitHub Pages sites: how paths resolve to
+// files, the 404 page, the CNAME file and redirects. Those GitHub Pages
+// doesn't share tell how it differs.
+var GitHubPages = []Behavior{
+	{Name: "index of the site", Path: "/", Status: http.StatusOK, Body: "home", GitHub: true},
+	{Name: "files", Path: "/about.html", Status: http.StatusOK, Body: "about", GitHub: true},
+	{Name: "clean urls", Path: "/about", Status: http.StatusOK, Body: "about", GitHub: true},
+	{Name: "index of a directory", Path: "/docs/", Status: http.StatusOK, Body: "docs", GitHub: true},
+	{
+		Name: "directory without trailing slash", Path: "/docs", Status: http.StatusOK, Body: "docs",
+		Note: "GitHub Pages redirects to /docs/ with 301",
+	},
+	{Name: "names with spaces", Path: "/with%20space.html", Status: http.StatusOK, Body: "space", GitHub: true},
+	{Name: "paths are case sensitive", Path: "/DOCS/", Status: http.StatusNotFound, Body: "custom not found", GitHub: true},
+	{Name: "404.html of the site", Path: "/missing", Status: http.StatusNotFound, Body: "custom not found", GitHub: true},
+	{Name: "404.html for assets", Path: "/missing.css", Status: http.StatusNotFound, Body: "custom not found", GitHub: true},
+	{Name: "CNAME file", Path: "/CNAME", Status: http.StatusOK, Body: "www.example.org", GitHub: true},
+	{
+		Name: "redirect_to front matter", Path: "/moved.md", Status: http.StatusMovedPermanently, Location: "/about",
+		Note: "GitHub Pages serves a page redirecting with a meta refresh on /moved.html",
+	},
+	{
+		Name: "_redirects rules", Path: "/old", Status: http.StatusMovedPermanently, Location: "/new",
+		Note: "GitHub Pages has no redirect rules",
+	},
+	{
+		Name: "markdown pages", Path: "/page.md", Status: http.StatusOK, Body: "<h1",
+		Note: "GitHub Pages renders page.md to /page.html and /page",
+	},
+	{
+		Name: "dotfiles", Path: "/.nojekyll", Status: http.StatusOK,
+		Note: "GitHub Pages doesn't serve dotfiles and files starting with _ unless the site has a .nojekyll",
+	},
+}
+
+// GitHubPagesRepo returns the gitea-pages repo of owner with the site the
+// GitHubPages behaviors are checked on, to add to a Server or an Instance.
+// The site is served on the host of owner.
+func GitHubPagesRepo(owner string) *Repo {
+	return &Repo{
+		Owner:  owner,
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{
+			"gitea-pages": {
+				"index.html":      "<p>home</p>",
+				"about.html":      "<p>about</p>",
+				"docs/index.html": "<p>docs</p>",
+				"with space.html": "<p>space</p>",
+				"404.html":        "<p>custom not found</p>",
+				"CNAME":           "www.example.org\n",
+				"moved.md":        "---\nredirect_to: /about\n---\n",
+				"_redirects":      "/old /new 301\n",
+				"new.html":        "<p>new</p>",
+				"page.md":         "# Page\n",
+				".nojekyll":       "",
+			},
+		},
+	}
+}
+
+// Check returns an error if resp doesn't behave like b.
+func (b Behavior) Check(resp *http.Response) error {
+	if resp.StatusCode != b.Status {
+		return fmt.Errorf("%s: status %d, want %d", b.Name, resp.StatusCode, b.Status)
+	}
+
+	if loc := resp.Header.Get("Location"); loc != b.Location {
+		return fmt.Errorf("%s: location %q, want %q", b.Name, loc, b.Location)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.Name, err)
+	}
+
+	if !strings.Contains(string(body), b.Body) {
+		return fmt.Errorf("%s: body %q doesn't contain %q", b.Name, body, b.Body)
+	}
+
+	return nil
+}
@@ -0,0 +1,437 @@
+package giteatest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The types of git objects, as in packs.
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var objTypeNames = map[byte]string{objCommit: "commit", objTree: "tree", objBlob: "blob"}
+
+// object is a git object of the server, the objects of all versions of the
+// repos are kept so clients can fetch against older versions.
+type object struct {
+	kind byte
+	data []byte
+}
+
+// addObject stores the object of kind with data and returns its id.
+func (s *Server) addObject(kind byte, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objTypeNames[kind], len(data))
+	h.Write(data)
+
+	oid := hex.EncodeToString(h.Sum(nil))
+
+	if s.objects == nil {
+		s.objects = make(map[string]object)
+	}
+
+	s.objects[oid] = object{kind: kind, data: data}
+
+	return oid
+}
+
+// gitCommit returns the id of the (real) git commit of the files of branch,
+// without parents, committed at Modified. It differs from the sha of the
+// api, which doesn't build git objects.
+func (s *Server) gitCommit(repo *Repo, branch string) string {
+	tree := s.gitTree(repo.Branches[branch], "")
+
+	date := fmt.Sprintf("%d +0000", s.Modified.Unix())
+	commit := fmt.Sprintf("tree %s\nauthor %s <%[2]s@example.com> %s\ncommitter %[2]s <%[2]s@example.com> %[3]s\n\nUpdate %s\n",
+		tree, repo.Owner, date, branch)
+
+	return s.addObject(objCommit, []byte(commit))
+}
+
+// gitTree stores the tree of the files below dir and returns its id.
+func (s *Server) gitTree(files map[string]string, dir string) string {
+	type entry struct {
+		mode, name, oid string
+	}
+
+	var entries []entry
+
+	dirs := make(map[string]bool)
+
+	for name, content := range files {
+		if !strings.HasPrefix(name, dir) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(name, dir)
+
+		if sub, _, ok := strings.Cut(rest, "/"); ok {
+			if !dirs[sub] {
+				dirs[sub] = true
+				entries = append(entries, entry{"40000", sub, s.gitTree(files, dir+sub+"/")})
+			}
+
+			continue
+		}
+
+		entries = append(entries, entry{"100644", rest, s.addObject(objBlob, []byte(content))})
+	}
+
+	// git sorts directories as if their name ended with a slash
+	key := func(e entry) string {
+		if e.mode == "40000" {
+			return e.name + "/"
+		}
+
+		return e.name
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+
+	var tree bytes.Buffer
+
+	for _, e := range entries {
+		oid, _ := hex.DecodeString(e.oid)
+		fmt.Fprintf(&tree, "%s %s\x00%s", e.mode, e.name, oid)
+	}
+
+	return s.addObject(objTree, tree.Bytes())
+}
+
+// walk calls fn with the objects reachable from the commit or tree oid, and
+// the paths of the blobs.
+func (s *Server) walk(oid, dir string, fn func(oid, path string, o object)) {
+	o, ok := s.objects[oid]
+	if !ok {
+		return
+	}
+
+	fn(oid, strings.TrimSuffix(dir, "/"), o)
+
+	switch o.kind {
+	case objCommit:
+		tree, _, _ := strings.Cut(strings.TrimPrefix(string(o.data), "tree "), "\n")
+		s.walk(tree, "", fn)
+	case objTree:
+		for data := o.data; len(data) > 0; {
+			head, rest, _ := bytes.Cut(data, []byte{0})
+			_, name, _ := strings.Cut(string(head), " ")
+
+			s.walk(hex.EncodeToString(rest[:sha1.Size]), dir+name+"/", fn)
+			data = rest[sha1.Size:]
+		}
+	}
+}
+
+// serveUploadPack serves the git-upload-pack endpoint of the smart http git
+// protocol, version 2 only: ls-refs and shallow fetches of thin packs.
+func (s *Server) serveUploadPack(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	if r.Method != http.MethodPost || r.Header.Get("Git-Protocol") != "version=2" {
+		http.Error(w, "only the git protocol v2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		command string
+		args    []string
+	)
+
+	br := bufio.NewReader(r.Body)
+
+	for {
+		line, ok := readPkt(br)
+		if !ok {
+			break
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "command=") {
+			command = strings.TrimPrefix(line, "command=")
+		} else {
+			args = append(args, line)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+
+	switch command {
+	case "ls-refs":
+		s.lsRefs(w, repo, args)
+	case "fetch":
+		s.fetch(w, repo, args)
+	default:
+		fmt.Fprint(w, pktLine("ERR unknown command "+command+"\n"))
+	}
+}
+
+func (s *Server) lsRefs(w io.Writer, repo *Repo, args []string) {
+	var prefixes []string
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "ref-prefix ") {
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+
+	names := make([]string, 0, len(repo.Branches))
+	for branch := range repo.Branches {
+		names = append(names, branch)
+	}
+
+	sort.Strings(names)
+
+	for _, branch := range names {
+		ref := "refs/heads/" + branch
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(ref, prefix) {
+				fmt.Fprint(w, pktLine(s.gitCommit(repo, branch)+" "+ref+"\n"))
+				break
+			}
+		}
+	}
+
+	fmt.Fprint(w, "0000")
+}
+
+// fetch sends the objects reachable from the wants and not from the haves.
+// Blobs changed since a have are deltified against their old version (which
+// isn't sent, the pack is thin), blobs sharing a start with the blob before
+// them in the pack against that one.
+func (s *Server) fetch(w io.Writer, repo *Repo, args []string) {
+	var wants, haves, shallow []string
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			wants = append(wants, strings.TrimPrefix(arg, "want "))
+		case strings.HasPrefix(arg, "have "):
+			haves = append(haves, strings.TrimPrefix(arg, "have "))
+		}
+	}
+
+	// the current commits of the branches are built, so they can be wanted
+	for branch := range repo.Branches {
+		s.gitCommit(repo, branch)
+	}
+
+	old := make(map[string]bool)
+	oldPaths := make(map[string]string)
+
+	for _, have := range haves {
+		s.walk(have, "", func(oid, path string, o object) {
+			old[oid] = true
+
+			if o.kind == objBlob {
+				oldPaths[path] = oid
+			}
+		})
+	}
+
+	type entry struct {
+		oid, path string
+		o         object
+	}
+
+	var entries []entry
+
+	sent := make(map[string]bool)
+
+	for _, want := range wants {
+		if _, ok := s.objects[want]; !ok {
+			fmt.Fprint(w, pktLine("ERR not our ref "+want+"\n"))
+			return
+		}
+
+		shallow = append(shallow, want)
+
+		s.walk(want, "", func(oid, path string, o object) {
+			if !old[oid] && !sent[oid] {
+				sent[oid] = true
+				entries = append(entries, entry{oid, path, o})
+			}
+		})
+	}
+
+	fmt.Fprint(w, pktLine("shallow-info\n"))
+
+	for _, oid := range shallow {
+		fmt.Fprint(w, pktLine("shallow "+oid+"\n"))
+	}
+
+	fmt.Fprint(w, "0001")
+	fmt.Fprint(w, pktLine("packfile\n"))
+
+	var pack bytes.Buffer
+
+	pack.WriteString("PACK")
+	_ = binary.Write(&pack, binary.BigEndian, uint32(2))
+	_ = binary.Write(&pack, binary.BigEndian, uint32(len(entries)))
+
+	var prev struct {
+		off  int
+		data []byte
+	}
+
+	for _, e := range entries {
+		off := pack.Len()
+		kind, data := e.o.kind, e.o.data
+
+		var header []byte
+
+		switch base, ok := oldPaths[e.path]; {
+		case e.o.kind == objBlob && ok:
+			kind, data = objRefDelta, encodeDelta(s.objects[base].data, e.o.data)
+
+			header, _ = hex.DecodeString(base)
+		case e.o.kind == objBlob && prev.data != nil && commonPrefix(prev.data, e.o.data) >= 16:
+			kind, data = objOfsDelta, encodeDelta(prev.data, e.o.data)
+
+			header = ofsDeltaOffset(off - prev.off)
+		}
+
+		if e.o.kind == objBlob {
+			prev.off, prev.data = off, e.o.data
+		}
+
+		// type and size, then the base of deltas
+		size := len(data)
+		b := kind<<4 | byte(size&15)
+
+		for size >>= 4; size > 0; size >>= 7 {
+			pack.WriteByte(b | 0x80)
+			b = byte(size & 0x7f)
+		}
+
+		pack.WriteByte(b)
+		pack.Write(header)
+
+		zw := zlib.NewWriter(&pack)
+		zw.Write(data)
+		zw.Close()
+	}
+
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	// sideband 1 carries the pack
+	for data := pack.Bytes(); len(data) > 0; {
+		n := len(data)
+		if n > 65515 {
+			n = 65515
+		}
+
+		fmt.Fprint(w, pktLine("\x01"+string(data[:n])))
+		data = data[n:]
+	}
+
+	fmt.Fprint(w, "0000")
+}
+
+// encodeDelta returns a delta making target of base: the start they share
+// is copied, the rest inserted.
+func encodeDelta(base, target []byte) []byte {
+	var delta []byte
+
+	for _, size := range []int{len(base), len(target)} {
+		for ; size >= 0x80; size >>= 7 {
+			delta = append(delta, byte(size&0x7f)|0x80)
+		}
+
+		delta = append(delta, byte(size))
+	}
+
+	common := commonPrefix(base, target)
+
+	for off := 0; off < common; {
+		n := common - off
+		if n > 0xffff {
+			n = 0xffff
+		}
+
+		// offset in 4 bytes and size in 2
+		delta = append(delta, 0x80|0x0f|0x30,
+			byte(off), byte(off>>8), byte(off>>16), byte(off>>24), byte(n), byte(n>>8))
+		off += n
+	}
+
+	for rest := target[common:]; len(rest) > 0; {
+		n := len(rest)
+		if n > 0x7f {
+			n = 0x7f
+		}
+
+		delta = append(delta, byte(n))
+		delta = append(delta, rest[:n]...)
+		rest = rest[n:]
+	}
+
+	return delta
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// ofsDeltaOffset encodes the distance to the base of an ofs delta.
+func ofsDeltaOffset(rel int) []byte {
+	buf := []byte{byte(rel & 0x7f)}
+
+	for rel >>= 7; rel > 0; rel >>= 7 {
+		rel--
+		buf = append([]byte{byte(rel&0x7f) | 0x80}, buf...)
+	}
+
+	return buf
+}
+
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+// readPkt reads a pkt-line without its newline, special pkt-lines are
+// empty; it returns false at the end of the request.
+func readPkt(r io.Reader) (string, bool) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", false
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(string(size[:]), "%04x", &n); err != nil {
+		return "", false
+	}
+
+	if n < 4 {
+		return "", true
+	}
+
+	line := make([]byte, n-4)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return "", false
+	}
+
+	return strings.TrimSuffix(string(line), "\n"), true
+}
@@ -0,0 +1,150 @@
+package giteatest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateGolden is set with the UPDATE_GOLDEN environment variable, e.g.
+// `UPDATE_GOLDEN=1 go test ./...`, to write the output checked by Golden into
+// its golden files instead of comparing it. Review the changes of the golden
+// files like those of the code.
+var UpdateGolden = os.Getenv("UPDATE_GOLDEN") != ""
+
+// RenderingPages are the markdown pages of the RenderingRepos, as names
+// below their owner (repo/filepath). Rendered in order and compared with
+// their golden files, they show how the rendering pipeline changes.
+var RenderingPages = []string{
+	"gitea-pages/index.md",
+	"gitea-pages/code.md",
+	"gitea-pages/raw.md",
+	"gitea-pages/broken-front-matter.md",
+	"gitea-pages/other-layout.md",
+	"docs/index.md",
+	"docs/raw.md",
+}
+
+// renderingRaw is a page with raw html, which the sanitization policies of
+// the sites strip in part.
+const renderingRaw = `# Raw html
+
+<script>alert("script")</script>
+<p onclick="alert('handler')" class="note" id="note">A paragraph with a handler.</p>
+
+<details><summary>Collapsed</summary>
+
+Hidden content.
+
+</details>
+
+[a javascript link](javascript:alert('link'))
+`
+
+// RenderingRepos returns the repos of owner with the pages of RenderingPages,
+// to add to a Server or an Instance. The pages cover the markdown extensions
+// (tables, task lists, footnotes, heading ids), syntax highlighting, front
+// matter, layouts and the sanitization policies. The gitea-pages repo has a
+// layout and no sanitization; the docs repo is sanitized strictly and has no
+// layout, so its pages are rendered into the default html.
+//
+// The pages don't use anything varying between runs (commit dates, urls of
+// the server), so they render the same every time.
+func RenderingRepos(owner string) []*Repo {
+	return []*Repo{
+		{
+			Owner:  owner,
+			Name:   "gitea-pages",
+			Topics: []string{"gitea-pages"},
+			Branches: map[string]map[string]string{
+				"gitea-pages": {
+					"_layout.html": "<!DOCTYPE html>\n<html>\n<head><title>{{ .Title }}</title></head>\n" +
+						"<body>\n<main>\n{{ .Content }}</main>\n</body>\n</html>\n",
+					"_other.html": "<article data-tags=\"{{ .Meta.tags }}\">\n{{ .Content }}</article>\n",
+					"index.md": "---\ntitle: Home\n---\n# Home\n\nSome *emphasis*, **strong** and `code`.\n\n" +
+						"## Section\n\n## Section\n\n" +
+						"| Name | Value |\n| ---- | ----: |\n| a    | 1     |\n| b    | 2     |\n\n" +
+						"- [x] done\n- [ ] todo\n\n" +
+						"A footnote.[^1]\n\n[^1]: The footnote.\n\n" +
+						"https://example.org is linked, ~~struck~~ isn't.\n",
+					"code.md": "---\ntitle: Code\n---\n# Code\n\n" +
+						"```go\npackage main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n```\n\n" +
+						"```\nno language\n```\n\n    indented\n",
+					"raw.md":                 renderingRaw,
+					"broken-front-matter.md": "---\ntitle: [unclosed\n---\n# Broken\n\nThe front matter is rendered too.\n",
+					"other-layout.md":        "---\ntitle: Other\nlayout: _other.html\ntags: [a, b]\n---\n# Other layout\n",
+				},
+			},
+		},
+		{
+			Owner:  owner,
+			Name:   "docs",
+			Topics: []string{"gitea-pages"},
+			Branches: map[string]map[string]string{
+				"gitea-pages": {
+					"gitea-pages.toml": "allowedrefs = [\"gitea-pages\"]\ndefaultref = \"gitea-pages\"\n" +
+						"sanitize = \"strict\"\n",
+					"index.md": "+++\ntitle = \"Docs\"\n+++\n# Docs\n\n" +
+						"```go\nfunc docs() {}\n```\n",
+					"raw.md": renderingRaw,
+				},
+			},
+		},
+	}
+}
+
+// Golden compares output with the golden files in Dir (usually below
+// testdata), or writes them if Update is set (see UpdateGolden).
+type Golden struct {
+	Dir    string
+	Update bool
+}
+
+// Check returns an error if got differs from the golden file of name, the
+// path of name below Dir with a .golden extension. It names the first line
+// that differs. With Update the golden file is written instead.
+func (g Golden) Check(name string, got []byte) error {
+	file := filepath.Join(g.Dir, filepath.FromSlash(name)+".golden")
+
+	if g.Update {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+
+		return os.WriteFile(file, got, 0o644)
+	}
+
+	want, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: no golden file %s, write it with -update or UPDATE_GOLDEN=1", name, file)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(got, want) {
+		return nil
+	}
+
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+
+	for i := 0; ; i++ {
+		var gotLine, wantLine string
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+
+		if gotLine != wantLine || i >= len(gotLines) || i >= len(wantLines) {
+			return fmt.Errorf("%s differs from %s at line %d:\n got: %q\nwant: %q", name, file, i+1, gotLine, wantLine)
+		}
+	}
+}
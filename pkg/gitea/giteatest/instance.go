@@ -0,0 +1,425 @@
+//go:build integration
+
+package giteatest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultImage is the gitea image instances run by default.
+const DefaultImage = "gitea/gitea:1.21"
+
+// instanceStartTimeout is how long StartInstance waits for gitea to answer.
+const instanceStartTimeout = 2 * time.Minute
+
+// The admin user instances are seeded by, its token is Instance.Token.
+const (
+	instanceAdmin    = "pages"
+	instancePassword = "gitea-pages-integration"
+)
+
+// instanceScopes are the scopes of the token of the admin user, all of them.
+var instanceScopes = []string{
+	"write:activitypub", "write:admin", "write:issue", "write:misc", "write:notification",
+	"write:organization", "write:package", "write:repository", "write:user",
+}
+
+// Instance is a throwaway gitea running in docker, to test against the real
+// gitea API. It's seeded like the fake Server, with repos, files, branches
+// and topics, and removed with Close.
+//
+// Instances are only built with the integration build tag and need a docker
+// that can run DefaultImage (or the image passed to StartInstance).
+type Instance struct {
+	// URL is the base url of the gitea server.
+	URL string
+	// Token is the access token of the admin user that seeded the instance.
+	Token string
+
+	container  string
+	httpClient *http.Client
+}
+
+// StartInstance starts a gitea of image (DefaultImage if empty) in docker and
+// waits until it answers. Webhooks of the instance may post to any host, the
+// docker host is host.docker.internal.
+func StartInstance(image string) (*Instance, error) {
+	if image == "" {
+		image = DefaultImage
+	}
+
+	out, err := docker("run", "--detach", "--rm",
+		"--publish", "127.0.0.1::3000",
+		"--add-host", "host.docker.internal:host-gateway",
+		"--env", "GITEA__security__INSTALL_LOCK=true",
+		"--env", "GITEA__database__DB_TYPE=sqlite3",
+		"--env", "GITEA__webhook__ALLOWED_HOST_LIST=*",
+		"--env", "GITEA__webhook__DELIVER_TIMEOUT=5",
+		"--env", "GITEA__repository__DEFAULT_BRANCH=main",
+		image)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Instance{
+		container:  out,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if err := i.start(); err != nil {
+		i.Close()
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// start waits for the instance to answer and creates the admin user.
+func (i *Instance) start() error {
+	addr, err := docker("port", i.container, "3000/tcp")
+	if err != nil {
+		return err
+	}
+
+	// docker lists an address per line, the first one is enough
+	addr, _, _ = strings.Cut(addr, "\n")
+	i.URL = "http://" + addr
+
+	ctx, cancel := context.WithTimeout(context.Background(), instanceStartTimeout)
+	defer cancel()
+
+	for {
+		resp, err := i.httpClient.Get(i.URL + "/api/v1/version")
+		if err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gitea didn't start: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	if _, err := docker("exec", "--user", "git", i.container, "gitea", "admin", "user", "create",
+		"--admin", "--username", instanceAdmin, "--password", instancePassword,
+		"--email", instanceAdmin+"@example.com", "--must-change-password=false"); err != nil {
+		return err
+	}
+
+	var token struct {
+		SHA1 string `json:"sha1"`
+	}
+
+	req, err := i.request(http.MethodPost, "/api/v1/users/"+instanceAdmin+"/tokens", map[string]any{
+		"name":   "gitea-pages",
+		"scopes": instanceScopes,
+	})
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(instanceAdmin, instancePassword)
+
+	if err := i.do(req, &token); err != nil {
+		return fmt.Errorf("creating token: %w", err)
+	}
+
+	i.Token = token.SHA1
+
+	return nil
+}
+
+// Close removes the instance and everything seeded.
+func (i *Instance) Close() error {
+	_, err := docker("rm", "--force", i.container)
+	return err
+}
+
+// AddUser adds a user authenticating with password and returns its token,
+// with the read scopes.
+func (i *Instance) AddUser(name, password string) (string, error) {
+	if err := i.api(http.MethodPost, "/api/v1/admin/users", map[string]any{
+		"username":             name,
+		"password":             password,
+		"email":                name + "@example.com",
+		"must_change_password": false,
+	}, nil); err != nil {
+		return "", fmt.Errorf("creating user %s: %w", name, err)
+	}
+
+	var token struct {
+		SHA1 string `json:"sha1"`
+	}
+
+	req, err := i.request(http.MethodPost, "/api/v1/users/"+url.PathEscape(name)+"/tokens", map[string]any{
+		"name":   "gitea-pages",
+		"scopes": []string{"read:repository", "read:user", "read:organization"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(name, password)
+
+	if err := i.do(req, &token); err != nil {
+		return "", fmt.Errorf("creating token of %s: %w", name, err)
+	}
+
+	return token.SHA1, nil
+}
+
+// AddRepo creates r with its description, website, branches, files and
+// topics; its pull requests and readers aren't seeded. Its owner is created
+// as a user if it doesn't exist, its default branch defaults to main and is
+// created with a README.md.
+func (i *Instance) AddRepo(r *Repo) error {
+	if r.DefaultBranch == "" {
+		r.DefaultBranch = "main"
+	}
+
+	if err := i.ensureUser(r.Owner); err != nil {
+		return err
+	}
+
+	if err := i.api(http.MethodPost, "/api/v1/admin/users/"+url.PathEscape(r.Owner)+"/repos", map[string]any{
+		"name":           r.Name,
+		"private":        r.Private,
+		"description":    r.Description,
+		"default_branch": r.DefaultBranch,
+		"auto_init":      true,
+		"readme":         "Default",
+	}, nil); err != nil {
+		return fmt.Errorf("creating repo %s/%s: %w", r.Owner, r.Name, err)
+	}
+
+	if r.Website != "" {
+		if err := i.api(http.MethodPatch, repoPath(r.Owner, r.Name), map[string]any{
+			"website": r.Website,
+		}, nil); err != nil {
+			return fmt.Errorf("setting website of %s/%s: %w", r.Owner, r.Name, err)
+		}
+	}
+
+	for branch, files := range r.Branches {
+		for name, content := range files {
+			if err := i.AddFile(r.Owner, r.Name, branch, name, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(r.Topics) > 0 {
+		return i.SetTopics(r.Owner, r.Name, r.Topics...)
+	}
+
+	return nil
+}
+
+// AddFile commits a file with content to branch of owner/repo, replacing the
+// file if it exists. The branch is created from the default branch if it
+// doesn't exist, which is used if branch is empty.
+func (i *Instance) AddFile(owner, repo, branch, name, content string) error {
+	name = strings.TrimPrefix(name, "/")
+	body := map[string]any{
+		"content": base64.StdEncoding.EncodeToString([]byte(content)),
+		"message": "Add " + name,
+	}
+
+	if branch != "" {
+		err := i.api(http.MethodGet, repoPath(owner, repo)+"/branches/"+url.PathEscape(branch), nil, nil)
+
+		var se *statusError
+
+		switch {
+		case errors.As(err, &se) && se.status == http.StatusNotFound:
+			body["new_branch"] = branch
+		case err != nil:
+			return err
+		default:
+			body["branch"] = branch
+		}
+	}
+
+	var existing struct {
+		SHA string `json:"sha"`
+	}
+
+	p := repoPath(owner, repo) + "/contents/" + escapePath(name)
+
+	ref := ""
+	if branch != "" && body["new_branch"] == nil {
+		ref = "?ref=" + url.QueryEscape(branch)
+	}
+
+	method := http.MethodPost
+	if err := i.api(http.MethodGet, p+ref, nil, &existing); err == nil && existing.SHA != "" {
+		method = http.MethodPut
+		body["sha"] = existing.SHA
+	}
+
+	if err := i.api(method, p, body, nil); err != nil {
+		return fmt.Errorf("adding %s to %s/%s: %w", name, owner, repo, err)
+	}
+
+	return nil
+}
+
+// SetTopics replaces the topics of owner/repo.
+func (i *Instance) SetTopics(owner, repo string, topics ...string) error {
+	if err := i.api(http.MethodPut, repoPath(owner, repo)+"/topics", map[string]any{
+		"topics": topics,
+	}, nil); err != nil {
+		return fmt.Errorf("setting topics of %s/%s: %w", owner, repo, err)
+	}
+
+	return nil
+}
+
+// AddWebhook adds a gitea webhook of owner/repo posting push, create, delete
+// and repository events to target, signed with secret. The instance reaches
+// the docker host as host.docker.internal.
+func (i *Instance) AddWebhook(owner, repo, target, secret string) error {
+	if err := i.api(http.MethodPost, repoPath(owner, repo)+"/hooks", map[string]any{
+		"type":   "gitea",
+		"active": true,
+		"events": []string{"push", "create", "delete", "repository"},
+		"config": map[string]string{
+			"url":          target,
+			"content_type": "json",
+			"secret":       secret,
+		},
+	}, nil); err != nil {
+		return fmt.Errorf("adding webhook to %s/%s: %w", owner, repo, err)
+	}
+
+	return nil
+}
+
+// ensureUser creates the user name if it doesn't exist.
+func (i *Instance) ensureUser(name string) error {
+	err := i.api(http.MethodGet, "/api/v1/users/"+url.PathEscape(name), nil, nil)
+
+	var se *statusError
+	if !errors.As(err, &se) || se.status != http.StatusNotFound {
+		return err
+	}
+
+	_, err = i.AddUser(name, instancePassword)
+
+	return err
+}
+
+// api sends a request with body as JSON to the instance as the admin user
+// and decodes the response into v, if not nil.
+func (i *Instance) api(method, p string, body, v any) error {
+	req, err := i.request(method, p, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "token "+i.Token)
+
+	return i.do(req, v)
+}
+
+// request returns a request for the path p of the instance with body as JSON.
+func (i *Instance) request(method, p string, body any) (*http.Request, error) {
+	var r io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, i.URL+p, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// statusError is the error of a request gitea didn't answer successfully.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code '%d': %s", e.status, e.body)
+}
+
+// do sends req and decodes the response into v, if not nil.
+func (i *Instance) do(req *http.Request, v any) error {
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return &statusError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// repoPath returns the API path of owner/repo.
+func repoPath(owner, repo string) string {
+	return "/api/v1/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+// escapePath escapes the segments of the file path p.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// docker runs the docker command with args and returns its trimmed output.
+func docker(args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,737 @@
+// Package giteatest provides a fake gitea server implementing the parts of
+// the gitea API the gitea client uses, to test it without a gitea instance.
+package giteatest
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the gitea version the server reports.
+const Version = "1.19.0"
+
+// Repo is a repository served by the fake gitea server.
+type Repo struct {
+	Owner         string
+	Name          string
+	Private       bool
+	Description   string
+	Website       string
+	DefaultBranch string
+	Topics        []string
+
+	// Branches maps the branch names to their files (path to content).
+	Branches map[string]map[string]string
+
+	// Pulls maps the open pull requests to the branch of their head.
+	Pulls map[int64]string
+
+	// Readers are the users that can read the repo if it's private.
+	Readers []string
+}
+
+// Status is a commit status posted to the fake gitea server.
+type Status struct {
+	Owner       string
+	Repo        string
+	SHA         string
+	State       string
+	TargetURL   string
+	Description string
+	Context     string
+}
+
+// Comment is an issue comment posted to the fake gitea server.
+type Comment struct {
+	ID    int64
+	Owner string
+	Repo  string
+	Index int64
+	Body  string
+}
+
+// Issue is an issue opened on the fake gitea server.
+type Issue struct {
+	Index int64
+	Owner string
+	Repo  string
+	Title string
+	Body  string
+	// State is open or closed.
+	State string
+}
+
+// Server is a fake gitea server.
+type Server struct {
+	*httptest.Server
+
+	// Modified is sent as Last-Modified of all files.
+	Modified time.Time
+	// Chunked sends the files of the media endpoint chunked, without their
+	// length and ranges, like gitea behind some proxies.
+	Chunked bool
+	// DisableGit refuses git over http, like gitea with DISABLE_HTTP_GIT.
+	DisableGit bool
+
+	mu       sync.Mutex
+	repos    map[string]*Repo
+	users    map[string]string
+	statuses []Status
+	comments []*Comment
+	issues   []Issue
+	requests map[string]int
+	down     bool
+	objects  map[string]object
+}
+
+// NewServer starts a fake gitea server, it's closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		Modified: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		repos:    make(map[string]*Repo),
+		users:    make(map[string]string),
+		requests: make(map[string]int),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// AddRepo adds (or replaces) a repo, its default branch defaults to main.
+func (s *Server) AddRepo(r *Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.DefaultBranch == "" {
+		r.DefaultBranch = "main"
+	}
+
+	if r.Branches == nil {
+		r.Branches = make(map[string]map[string]string)
+	}
+
+	s.repos[r.Owner+"/"+r.Name] = r
+}
+
+// AddFile adds a file with content to branch of owner/repo, the repo is
+// created if it doesn't exist.
+func (s *Server) AddFile(owner, repo, branch, name, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		r = &Repo{Owner: owner, Name: repo, DefaultBranch: "main", Branches: make(map[string]map[string]string)}
+		s.repos[owner+"/"+repo] = r
+	}
+
+	if branch == "" {
+		branch = r.DefaultBranch
+	}
+
+	if r.Branches[branch] == nil {
+		r.Branches[branch] = make(map[string]string)
+	}
+
+	r.Branches[branch][strings.TrimPrefix(name, "/")] = content
+}
+
+// SetTopics replaces the topics of owner/repo.
+func (s *Server) SetTopics(owner, repo string, topics ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.repos[owner+"/"+repo]; ok {
+		r.Topics = topics
+	}
+}
+
+// AddUser adds a user authenticating with token.
+func (s *Server) AddUser(name, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[token] = name
+}
+
+// SetDown makes the server answer all requests with 503 Service Unavailable
+// (and Retry-After), like gitea during maintenance.
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = down
+}
+
+// Requests returns how many requests were made for path (without query),
+// e.g. "/api/v1/repos/owner/repo".
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// Statuses returns the commit statuses posted so far.
+func (s *Server) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Status(nil), s.statuses...)
+}
+
+// Comments returns the issue comments posted (and edited) so far.
+func (s *Server) Comments() []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments := make([]Comment, 0, len(s.comments))
+	for _, c := range s.comments {
+		comments = append(comments, *c)
+	}
+
+	return comments
+}
+
+// Issues returns the issues opened so far.
+func (s *Server) Issues() []Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Issue(nil), s.issues...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.URL.Path]++
+
+	if s.down {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	user := s.users[strings.TrimPrefix(r.Header.Get("Authorization"), "token ")]
+
+	// git over http: /{owner}/{repo}.git/git-upload-pack
+	if p := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(p) == 3 && p[2] == "git-upload-pack" {
+		repo, ok := s.repos[p[0]+"/"+strings.TrimSuffix(p[1], ".git")]
+		if !ok || !repo.readableBy(user) || s.DisableGit {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.serveUploadPack(w, r, repo)
+
+		return
+	}
+
+	p := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/"), "/")
+
+	switch {
+	case len(p) == 1 && p[0] == "version":
+		writeJSON(w, map[string]string{"version": Version})
+	case len(p) == 1 && p[0] == "user":
+		if user == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(w, map[string]any{"id": 1, "login": user, "username": user})
+	case len(p) >= 3 && p[0] == "repos":
+		repo, ok := s.repos[p[1]+"/"+p[2]]
+		if !ok || !repo.readableBy(user) {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.serveRepo(w, r, repo, p[3:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveRepo(w http.ResponseWriter, r *http.Request, repo *Repo, p []string) {
+	switch {
+	case len(p) == 0:
+		writeJSON(w, map[string]any{
+			"name":           repo.Name,
+			"full_name":      repo.Owner + "/" + repo.Name,
+			"owner":          map[string]any{"login": repo.Owner, "username": repo.Owner},
+			"private":        repo.Private,
+			"description":    repo.Description,
+			"website":        repo.Website,
+			"default_branch": repo.DefaultBranch,
+			"topics":         nonNil(repo.Topics),
+		})
+	case len(p) == 1 && p[0] == "topics":
+		writeJSON(w, map[string]any{"topics": nonNil(repo.Topics)})
+	case len(p) == 2 && p[0] == "branches":
+		if _, ok := repo.Branches[p[1]]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		sha := repo.commit(p[1])
+		writeJSON(w, map[string]any{"name": p[1], "commit": map[string]any{"id": sha}})
+	case len(p) == 2 && p[0] == "archive" && strings.HasSuffix(p[1], ".tar.gz"):
+		s.serveArchive(w, r, repo, strings.TrimSuffix(p[1], ".tar.gz"))
+	case len(p) >= 2 && p[0] == "media":
+		s.serveMedia(w, r, repo, strings.Join(p[1:], "/"))
+	case len(p) == 3 && p[0] == "git" && p[1] == "trees":
+		s.serveTree(w, r, repo, p[2])
+	case len(p) == 1 && p[0] == "commits":
+		s.serveCommits(w, r, repo)
+	case len(p) == 3 && p[0] == "git" && p[1] == "commits":
+		branch, ok := repo.branch(p[2])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, map[string]any{"sha": repo.commit(branch)})
+	case len(p) == 2 && p[0] == "statuses" && r.Method == http.MethodPost:
+		var st Status
+		if err := json.NewDecoder(r.Body).Decode(&struct {
+			State       *string `json:"state"`
+			TargetURL   *string `json:"target_url"`
+			Description *string `json:"description"`
+			Context     *string `json:"context"`
+		}{&st.State, &st.TargetURL, &st.Description, &st.Context}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		st.Owner, st.Repo, st.SHA = repo.Owner, repo.Name, p[1]
+		s.statuses = append(s.statuses, st)
+
+		writeJSONStatus(w, http.StatusCreated, map[string]any{"state": st.State, "context": st.Context})
+	case len(p) == 2 && p[0] == "pulls":
+		index, _ := strconv.ParseInt(p[1], 10, 64)
+
+		branch, ok := repo.Pulls[index]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, map[string]any{
+			"number": index,
+			"state":  "open",
+			"head":   map[string]any{"ref": branch, "sha": repo.commit(branch)},
+		})
+	case len(p) == 1 && p[0] == "issues" && r.Method == http.MethodPost:
+		var opt struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		issue := Issue{
+			Index: int64(len(s.issues) + 1), Owner: repo.Owner, Repo: repo.Name,
+			Title: opt.Title, Body: opt.Body, State: "open",
+		}
+		s.issues = append(s.issues, issue)
+
+		writeJSONStatus(w, http.StatusCreated, issueJSON(issue))
+	case len(p) == 1 && p[0] == "issues":
+		s.listIssues(w, r, repo)
+	case len(p) == 2 && p[0] == "issues" && r.Method == http.MethodPatch:
+		index, _ := strconv.ParseInt(p[1], 10, 64)
+		s.editIssue(w, r, repo, index)
+	case len(p) == 3 && p[0] == "issues" && p[2] == "comments":
+		index, _ := strconv.ParseInt(p[1], 10, 64)
+		s.serveComments(w, r, repo, index)
+	case len(p) == 3 && p[0] == "issues" && p[1] == "comments" && r.Method == http.MethodPatch:
+		id, _ := strconv.ParseInt(p[2], 10, 64)
+		s.editComment(w, r, repo, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listIssues lists the issues of the repo in the state param (open by
+// default, or all), on a single page.
+func (s *Server) listIssues(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+
+	issues := []map[string]any{}
+
+	for _, issue := range s.issues {
+		if issue.Owner == repo.Owner && issue.Repo == repo.Name && (state == "all" || issue.State == state) {
+			issues = append(issues, issueJSON(issue))
+		}
+	}
+
+	writeJSON(w, issues)
+}
+
+// editIssue edits the title, body and state of issue index of the repo.
+func (s *Server) editIssue(w http.ResponseWriter, r *http.Request, repo *Repo, index int64) {
+	var opt struct {
+		Title string  `json:"title"`
+		Body  *string `json:"body"`
+		State *string `json:"state"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i := range s.issues {
+		issue := &s.issues[i]
+		if issue.Index != index || issue.Owner != repo.Owner || issue.Repo != repo.Name {
+			continue
+		}
+
+		if opt.Title != "" {
+			issue.Title = opt.Title
+		}
+
+		if opt.Body != nil {
+			issue.Body = *opt.Body
+		}
+
+		if opt.State != nil {
+			issue.State = *opt.State
+		}
+
+		writeJSONStatus(w, http.StatusCreated, issueJSON(*issue))
+
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// issueJSON is issue as the gitea API returns it.
+func issueJSON(issue Issue) map[string]any {
+	return map[string]any{"number": issue.Index, "title": issue.Title, "body": issue.Body, "state": issue.State}
+}
+
+// serveCommits lists the commit of the branch (the sha param) that changed
+// the path param, every branch is a single commit by the owner of the repo.
+func (s *Server) serveCommits(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	ref := r.URL.Query().Get("sha")
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+
+	branch, ok := repo.branch(ref)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	commits := []map[string]any{}
+
+	if _, ok := repo.Branches[branch][r.URL.Query().Get("path")]; ok {
+		sha := repo.commit(branch)
+		commits = append(commits, map[string]any{
+			"sha":      sha,
+			"html_url": s.URL + "/" + repo.Owner + "/" + repo.Name + "/commit/" + sha,
+			"commit": map[string]any{
+				"message": "Update " + branch,
+				"author":  map[string]any{"name": repo.Owner, "date": s.Modified.Format(time.RFC3339)},
+			},
+		})
+	}
+
+	writeJSON(w, commits)
+}
+
+// serveMedia serves a file like gitea's media endpoint: with an etag (the
+// blob sha), Last-Modified, ranges and gzip compression if the client
+// accepts it.
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, repo *Repo, name string) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+
+	branch, ok := repo.branch(ref)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok := repo.Branches[branch][name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := `"` + blobSHA(content) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", s.Modified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+
+	// like gitea, text files (html too) are served as plain text
+	ct := http.DetectContentType([]byte(content))
+	if strings.HasPrefix(ct, "text/") {
+		ct = "text/plain; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", ct)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if s.Chunked {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, content)
+
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		http.ServeContent(w, r, name, s.Modified, strings.NewReader(content))
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+
+	fmt.Fprint(gz, content)
+}
+
+// serveArchive serves the files of ref as tar.gz archive, below a directory
+// named after the repo like gitea does.
+func (s *Server) serveArchive(w http.ResponseWriter, r *http.Request, repo *Repo, ref string) {
+	branch, ok := repo.branch(ref)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	files := repo.Branches[branch]
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/gzip")
+
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	for _, name := range names {
+		content := files[name]
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    repo.Name + "/" + name,
+			Mode:    0o644,
+			Size:    int64(len(content)),
+			ModTime: s.Modified,
+		}); err != nil {
+			return
+		}
+
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) serveTree(w http.ResponseWriter, r *http.Request, repo *Repo, ref string) {
+	branch, ok := repo.branch(ref)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	files := repo.Branches[branch]
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	entries := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, map[string]any{
+			"path": name,
+			"mode": "100644",
+			"type": "blob",
+			"size": len(files[name]),
+			"sha":  blobSHA(files[name]),
+		})
+	}
+
+	writeJSON(w, map[string]any{
+		"sha":         repo.commit(branch),
+		"tree":        entries,
+		"truncated":   false,
+		"page":        1,
+		"total_count": len(entries),
+	})
+}
+
+func (s *Server) serveComments(w http.ResponseWriter, r *http.Request, repo *Repo, index int64) {
+	switch r.Method {
+	case http.MethodGet:
+		comments := []map[string]any{}
+
+		for _, c := range s.comments {
+			if c.Owner == repo.Owner && c.Repo == repo.Name && c.Index == index {
+				comments = append(comments, map[string]any{"id": c.ID, "body": c.Body})
+			}
+		}
+
+		writeJSON(w, comments)
+	case http.MethodPost:
+		var opt struct {
+			Body string `json:"body"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c := &Comment{ID: int64(len(s.comments) + 1), Owner: repo.Owner, Repo: repo.Name, Index: index, Body: opt.Body}
+		s.comments = append(s.comments, c)
+
+		writeJSONStatus(w, http.StatusCreated, map[string]any{"id": c.ID, "body": c.Body})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) editComment(w http.ResponseWriter, r *http.Request, repo *Repo, id int64) {
+	var opt struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, c := range s.comments {
+		if c.ID == id && c.Owner == repo.Owner && c.Repo == repo.Name {
+			c.Body = opt.Body
+			writeJSON(w, map[string]any{"id": c.ID, "body": c.Body})
+
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// readableBy reports if user (empty if anonymous) can read the repo.
+func (r *Repo) readableBy(user string) bool {
+	if !r.Private || user == r.Owner {
+		return true
+	}
+
+	for _, u := range r.Readers {
+		if u == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// branch returns the branch ref names, ref is a branch or its commit sha,
+// which may be abbreviated to 7 characters like in gitea.
+func (r *Repo) branch(ref string) (string, bool) {
+	if _, ok := r.Branches[ref]; ok {
+		return ref, true
+	}
+
+	for name := range r.Branches {
+		if len(ref) >= 7 && strings.HasPrefix(r.commit(name), ref) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// commit returns the (fake) commit sha of branch, it changes with its files.
+func (r *Repo) commit(branch string) string {
+	files := r.Branches[branch]
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s/%s@%s\n", r.Owner, r.Name, branch)
+
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", name, blobSHA(files[name]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// blobSHA returns the git blob sha of content.
+func blobSHA(content string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00%s", len(content), content)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
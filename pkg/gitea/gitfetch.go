@@ -0,0 +1,682 @@
+package gitea
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The archives of sites are kept as a mirror of the git objects of their
+// version, so the next version is fetched with the smart git protocol (v2)
+// as a thin pack: only the objects that changed, deltified against those of
+// the mirror. The first version is fetched the same way, shallow.
+
+// errNoGit is returned when gitea doesn't answer with the git protocol v2,
+// the archive is downloaded instead.
+var errNoGit = errors.New("gitea doesn't speak the git protocol v2")
+
+// The types of git objects, as in packs.
+const (
+	gitCommit   = 1
+	gitTree     = 2
+	gitBlob     = 3
+	gitTag      = 4
+	gitOfsDelta = 6
+	gitRefDelta = 7
+)
+
+var gitTypeNames = map[byte]string{
+	gitCommit: "commit",
+	gitTree:   "tree",
+	gitBlob:   "blob",
+	gitTag:    "tag",
+}
+
+// gitObject is an object of a git repo.
+type gitObject struct {
+	kind byte
+	data []byte
+}
+
+// gitOID returns the id of the object of kind with data.
+func gitOID(kind byte, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", gitTypeNames[kind], len(data))
+	h.Write(data)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// gitMirror has the objects of a commit, its trees and blobs, to fetch the
+// next version of a site against.
+type gitMirror struct {
+	commit  string
+	objects map[string]gitObject
+}
+
+// pktLine encodes s as pkt-line.
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+// The special pkt-lines.
+const (
+	pktFlush = "0000"
+	pktDelim = "0001"
+)
+
+// readPkt reads a pkt-line, it returns nil for the special pkt-lines (flush,
+// delim, response end) with their length as special.
+func readPkt(r io.Reader) (data []byte, special int, err error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, 0, err
+	}
+
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid pkt-line length %q", size)
+	}
+
+	if n < 4 {
+		return nil, int(n), nil
+	}
+
+	data = make([]byte, n-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+
+	if bytes.HasPrefix(data, []byte("ERR ")) {
+		return nil, 0, fmt.Errorf("git: %s", strings.TrimSpace(string(data[4:])))
+	}
+
+	return data, 0, nil
+}
+
+// uploadPack runs the upload-pack command with args of owner/repo and
+// returns the response body.
+func (c *Client) uploadPack(ctx context.Context, owner, repo, command string, args []string) (io.ReadCloser, error) {
+	u, err := url.JoinPath(c.serverURL, owner, repo+".git", "git-upload-pack")
+	if err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+
+	body.WriteString(pktLine("command=" + command + "\n"))
+	body.WriteString(pktDelim)
+
+	for _, arg := range args {
+		body.WriteString(pktLine(arg + "\n"))
+	}
+
+	body.WriteString(pktFlush)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Accept", "application/x-git-upload-pack-result")
+	req.Header.Set("Git-Protocol", "version=2")
+
+	req.Header.Add("Authorization", "token "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-upload-pack-result" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", errNoGit, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// resolveCommit returns the commit of ref (a branch, tag or commit sha) of
+// owner/repo, branches first like gitea.
+func (c *Client) resolveCommit(ctx context.Context, owner, repo, ref string) (string, error) {
+	body, err := c.uploadPack(ctx, owner, repo, "ls-refs", []string{
+		"peel",
+		"ref-prefix refs/heads/" + ref,
+		"ref-prefix refs/tags/" + ref,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	refs := make(map[string]string)
+
+	for {
+		line, special, err := readPkt(body)
+		if err != nil {
+			return "", err
+		}
+
+		if line == nil {
+			if special == 0 {
+				break
+			}
+
+			continue
+		}
+
+		// <oid> <name>[ peeled:<oid>]
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 {
+			continue
+		}
+
+		oid := fields[0]
+
+		for _, attr := range fields[2:] {
+			if strings.HasPrefix(attr, "peeled:") {
+				oid = strings.TrimPrefix(attr, "peeled:")
+			}
+		}
+
+		refs[fields[1]] = oid
+	}
+
+	for _, name := range []string{"refs/heads/" + ref, "refs/tags/" + ref} {
+		if oid, ok := refs[name]; ok {
+			return oid, nil
+		}
+	}
+
+	if isCommitSHA(ref) {
+		return ref, nil
+	}
+
+	return "", fmt.Errorf("ref %s of %s/%s not found", ref, owner, repo)
+}
+
+// fetchPack fetches the commit want of owner/repo, shallow, as a thin pack
+// against the commit of mirror (if any), and returns its objects. The
+// objects of the mirror are the bases of the deltas of the pack.
+func (c *Client) fetchPack(ctx context.Context, owner, repo, want string, mirror *gitMirror, maxSize int64) (map[string]gitObject, error) {
+	args := []string{"thin-pack", "ofs-delta", "no-progress", "deepen 1", "want " + want}
+	if mirror != nil {
+		args = append(args, "shallow "+mirror.commit, "have "+mirror.commit)
+	}
+
+	args = append(args, "done")
+
+	body, err := c.uploadPack(ctx, owner, repo, "fetch", args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	// the sections before the pack, like shallow-info, aren't needed
+	for {
+		line, special, err := readPkt(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if special == 0 && line == nil {
+			return nil, errors.New("git: no packfile in the response")
+		}
+
+		if string(line) == "packfile\n" {
+			break
+		}
+	}
+
+	var have func(oid string) (gitObject, bool)
+	if mirror != nil {
+		have = func(oid string) (gitObject, bool) {
+			o, ok := mirror.objects[oid]
+			return o, ok
+		}
+	}
+
+	return parsePack(&sidebandReader{r: body}, have, maxSize)
+}
+
+// sidebandReader reads the data of the packfile section, sent in band 1.
+type sidebandReader struct {
+	r   io.Reader
+	buf []byte
+	eof bool
+}
+
+func (sr *sidebandReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.eof {
+			return 0, io.EOF
+		}
+
+		data, special, err := readPkt(sr.r)
+		if err != nil {
+			return 0, err
+		}
+
+		if data == nil {
+			if special == 0 {
+				sr.eof = true
+			}
+
+			continue
+		}
+
+		switch data[0] {
+		case 1:
+			sr.buf = data[1:]
+		case 3:
+			return 0, fmt.Errorf("git: %s", strings.TrimSpace(string(data[1:])))
+		}
+	}
+
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+
+	return n, nil
+}
+
+// packReader reads a pack, hashing and counting the bytes read.
+type packReader struct {
+	r   *bufio.Reader
+	h   hash.Hash
+	off int64
+}
+
+func (pr *packReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.h.Write(p[:n])
+	pr.off += int64(n)
+
+	return n, err
+}
+
+func (pr *packReader) ReadByte() (byte, error) {
+	b, err := pr.r.ReadByte()
+	if err == nil {
+		pr.h.Write([]byte{b})
+		pr.off++
+	}
+
+	return b, err
+}
+
+// packEntry is an object of a pack as read, deltas aren't applied yet.
+type packEntry struct {
+	kind    byte
+	data    []byte
+	baseOff int64
+	baseOID string
+}
+
+// parsePack reads the objects of the pack r, with their deltas applied.
+// Deltas against objects that aren't in the pack (of thin packs) are applied
+// to the objects of have. Packs with more than maxSize bytes of objects
+// (0 is unlimited) are refused.
+func parsePack(r io.Reader, have func(oid string) (gitObject, bool), maxSize int64) (map[string]gitObject, error) {
+	pr := &packReader{r: bufio.NewReader(r), h: sha1.New()}
+
+	var header [12]byte
+	if _, err := io.ReadFull(pr, header[:]); err != nil {
+		return nil, err
+	}
+
+	if string(header[:4]) != "PACK" {
+		return nil, errors.New("git: not a pack")
+	}
+
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return nil, fmt.Errorf("git: pack version %d", v)
+	}
+
+	count := binary.BigEndian.Uint32(header[8:])
+	entries := make(map[int64]*packEntry, count)
+	order := make([]int64, 0, count)
+
+	var size int64
+
+	for i := uint32(0); i < count; i++ {
+		off := pr.off
+
+		e, err := readPackEntry(pr, off)
+		if err != nil {
+			return nil, err
+		}
+
+		if size += int64(len(e.data)); maxSize > 0 && size > maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, maxSize)
+		}
+
+		entries[off] = e
+		order = append(order, off)
+	}
+
+	sum := pr.h.Sum(nil)
+
+	var trailer [sha1.Size]byte
+	if _, err := io.ReadFull(pr.r, trailer[:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(sum, trailer[:]) {
+		return nil, errors.New("git: pack checksum mismatch")
+	}
+
+	objects := make(map[string]gitObject, count)
+	resolved := make(map[int64]gitObject, count)
+
+	// deltas may have bases later in the pack, they're applied until no
+	// more can be
+	for len(order) > 0 {
+		var pending []int64
+
+		for _, off := range order {
+			e := entries[off]
+
+			var (
+				base gitObject
+				ok   bool
+			)
+
+			switch e.kind {
+			case gitOfsDelta:
+				base, ok = resolved[e.baseOff]
+			case gitRefDelta:
+				if base, ok = objects[e.baseOID]; !ok && have != nil {
+					base, ok = have(e.baseOID)
+				}
+			default:
+				base, ok = gitObject{kind: e.kind}, true
+			}
+
+			if !ok {
+				pending = append(pending, off)
+				continue
+			}
+
+			o := gitObject{kind: base.kind, data: e.data}
+			if e.kind == gitOfsDelta || e.kind == gitRefDelta {
+				data, err := applyDelta(base.data, e.data)
+				if err != nil {
+					return nil, err
+				}
+
+				o.data = data
+			}
+
+			resolved[off] = o
+			objects[gitOID(o.kind, o.data)] = o
+		}
+
+		if len(pending) == len(order) {
+			return nil, fmt.Errorf("git: %d objects without delta base", len(pending))
+		}
+
+		order = pending
+	}
+
+	return objects, nil
+}
+
+// readPackEntry reads the object at off of the pack.
+func readPackEntry(pr *packReader, off int64) (*packEntry, error) {
+	b, err := pr.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &packEntry{kind: (b >> 4) & 7}
+	size := uint64(b & 15)
+
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = pr.ReadByte(); err != nil {
+			return nil, err
+		}
+
+		size |= uint64(b&0x7f) << shift
+	}
+
+	switch e.kind {
+	case gitCommit, gitTree, gitBlob, gitTag:
+	case gitOfsDelta:
+		if b, err = pr.ReadByte(); err != nil {
+			return nil, err
+		}
+
+		rel := int64(b & 0x7f)
+
+		for b&0x80 != 0 {
+			if b, err = pr.ReadByte(); err != nil {
+				return nil, err
+			}
+
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+
+		if rel <= 0 || rel > off {
+			return nil, errors.New("git: invalid delta offset")
+		}
+
+		e.baseOff = off - rel
+	case gitRefDelta:
+		oid := make([]byte, sha1.Size)
+		if _, err := io.ReadFull(pr, oid); err != nil {
+			return nil, err
+		}
+
+		e.baseOID = hex.EncodeToString(oid)
+	default:
+		return nil, fmt.Errorf("git: invalid object type %d", e.kind)
+	}
+
+	zr, err := zlib.NewReader(pr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the size is known, the zlib stream is read to its end still
+	e.data = make([]byte, 0, size)
+
+	buf := bytes.NewBuffer(e.data)
+	if _, err := io.Copy(buf, zr); err != nil {
+		return nil, err
+	}
+
+	if e.data = buf.Bytes(); uint64(len(e.data)) != size {
+		return nil, errors.New("git: object size mismatch")
+	}
+
+	return e, zr.Close()
+}
+
+// deltaSize reads a size of the header of a delta.
+func deltaSize(delta []byte) (uint64, []byte, error) {
+	var size uint64
+
+	for shift := 0; len(delta) > 0; shift += 7 {
+		b := delta[0]
+		delta = delta[1:]
+		size |= uint64(b&0x7f) << shift
+
+		if b&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+
+	return 0, nil, errors.New("git: truncated delta")
+}
+
+// applyDelta returns the object delta makes of base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	if srcSize != uint64(len(base)) {
+		return nil, errors.New("git: delta of another base")
+	}
+
+	dstSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case op&0x80 != 0:
+			// copy from the base, the bits say which offset and size
+			// bytes follow
+			var off, size uint64
+
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+
+				if len(delta) == 0 {
+					return nil, errors.New("git: truncated delta")
+				}
+
+				if i < 4 {
+					off |= uint64(delta[0]) << (8 * i)
+				} else {
+					size |= uint64(delta[0]) << (8 * (i - 4))
+				}
+
+				delta = delta[1:]
+			}
+
+			if size == 0 {
+				size = 0x10000
+			}
+
+			if off+size > uint64(len(base)) {
+				return nil, errors.New("git: delta copies outside its base")
+			}
+
+			out = append(out, base[off:off+size]...)
+		case op != 0:
+			// insert the next op bytes
+			if int(op) > len(delta) {
+				return nil, errors.New("git: truncated delta")
+			}
+
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("git: invalid delta")
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("git: delta of another size")
+	}
+
+	return out, nil
+}
+
+// checkout returns the mirror of the commit with the objects reachable from
+// it, and the blobs of its files by path. Submodules and symlinks aren't
+// files of the site.
+func checkout(commit string, objects map[string]gitObject) (*gitMirror, map[string]gitObject, time.Time, error) {
+	c, ok := objects[commit]
+	if !ok || c.kind != gitCommit {
+		return nil, nil, time.Time{}, fmt.Errorf("git: commit %s missing", commit)
+	}
+
+	m := &gitMirror{commit: commit, objects: map[string]gitObject{commit: c}}
+
+	var (
+		tree    string
+		modTime time.Time
+	)
+
+	// the header of the commit ends at the first empty line
+	header, _, _ := bytes.Cut(c.data, []byte("\n\n"))
+
+	for _, line := range strings.Split(string(header), "\n") {
+		if strings.HasPrefix(line, "tree ") {
+			tree = strings.TrimPrefix(line, "tree ")
+		}
+
+		// committer <name> <email> <unix time> <zone>
+		if strings.HasPrefix(line, "committer ") {
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				if ts, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+					modTime = time.Unix(ts, 0)
+				}
+			}
+		}
+	}
+
+	files := make(map[string]gitObject)
+
+	if err := m.walk(tree, "", objects, files); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	return m, files, modTime, nil
+}
+
+// walk adds the tree with oid below dir and its objects to the mirror, and
+// its blobs to files.
+func (m *gitMirror) walk(oid, dir string, objects map[string]gitObject, files map[string]gitObject) error {
+	t, ok := objects[oid]
+	if !ok || t.kind != gitTree {
+		return fmt.Errorf("git: tree %s missing", oid)
+	}
+
+	m.objects[oid] = t
+
+	// <mode> <name>\0<20 byte oid>
+	for data := t.data; len(data) > 0; {
+		entry, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < sha1.Size {
+			return fmt.Errorf("git: invalid tree %s", oid)
+		}
+
+		mode, name, _ := strings.Cut(string(entry), " ")
+		child := hex.EncodeToString(rest[:sha1.Size])
+		data = rest[sha1.Size:]
+
+		switch mode {
+		case "40000":
+			if err := m.walk(child, dir+name+"/", objects, files); err != nil {
+				return err
+			}
+		case "100644", "100755":
+			b, ok := objects[child]
+			if !ok || b.kind != gitBlob {
+				return fmt.Errorf("git: blob %s missing", child)
+			}
+
+			m.objects[child] = b
+			files[dir+name] = b
+		}
+	}
+
+	return nil
+}
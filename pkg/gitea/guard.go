@@ -0,0 +1,249 @@
+package gitea
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrUpstreamInvalid is returned when gitea, or a proxy in front of it,
+	// answers with a response that can't be the answer to the request, like
+	// an html error page sent with 200 OK.
+	ErrUpstreamInvalid = errors.New("invalid response from gitea")
+	// ErrUpstreamTooLarge is returned when the body of a response of gitea is
+	// larger than the upstream body limit.
+	ErrUpstreamTooLarge = errors.New("response from gitea too large")
+)
+
+// SetUpstreamGuard limits the bodies of the responses of gitea to maxBody
+// bytes (0 is unlimited), as they're sent and decompressed, whatever the size
+// of the files served. Files streamed to the client and repo archives, which are read a
+// file at a time, aren't limited. With validate set, responses that can't be
+// from gitea are refused: API responses that aren't JSON and files sent as
+// html, which gitea never does.
+func SetUpstreamGuard(maxBody int64, validate bool) ClientOption {
+	return func(c *Client) error {
+		c.maxUpstreamBody = maxBody
+		c.httpClient.Transport = &guardTransport{
+			maxBody:  maxBody,
+			validate: validate,
+			next:     c.httpClient.Transport,
+		}
+
+		return nil
+	}
+}
+
+// guardTransport is a http.RoundTripper that validates the responses of
+// gitea and limits their size.
+type guardTransport struct {
+	maxBody  int64
+	validate bool
+	next     http.RoundTripper
+}
+
+func (t *guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	endpoint := upstreamEndpoint(req.URL.Path)
+
+	if t.validate && !validResponse(endpoint, resp) {
+		resp.Body.Close()
+		guardMetrics.refused.WithLabelValues("invalid").Inc()
+
+		return nil, ErrUpstreamInvalid
+	}
+
+	if t.maxBody <= 0 || endpoint == "archive" || endpoint == "media" && isStream(req) {
+		return resp, nil
+	}
+
+	if resp.ContentLength > t.maxBody {
+		resp.Body.Close()
+		guardMetrics.refused.WithLabelValues("too_large").Inc()
+
+		return nil, ErrUpstreamTooLarge
+	}
+
+	resp.Body = &guardedBody{ReadCloser: resp.Body, left: t.maxBody + 1}
+
+	return resp, nil
+}
+
+// upstreamEndpoint returns the endpoint of a repo (e.g. media, raw, archive
+// or topics) the API path p is for, "" for other paths.
+func upstreamEndpoint(p string) string {
+	i := strings.Index(p, "/api/v1/repos/")
+	if i < 0 {
+		return ""
+	}
+
+	parts := strings.SplitN(p[i+len("/api/v1/repos/"):], "/", 4)
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[2]
+}
+
+// validResponse reports if the successful response resp can be the answer
+// of gitea to a request for endpoint.
+func validResponse(endpoint string, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return true
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(ct)
+
+	switch endpoint {
+	case "media", "raw", "archive":
+		// gitea serves html files as text, html is the page of a proxy
+		return mediaType != "text/html"
+	}
+
+	if ct == "" && resp.ContentLength == 0 {
+		return true
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isStream reports if req fetches a file that is streamed to the client, see
+// mediaResponse.
+func isStream(req *http.Request) bool {
+	return req.Header.Get("Accept-Encoding") == "identity"
+}
+
+// guardedBody is the body of a response that fails with ErrUpstreamTooLarge
+// once more than the limit is read.
+type guardedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, ErrUpstreamTooLarge
+	}
+
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+
+	if b.left <= 0 {
+		guardMetrics.refused.WithLabelValues("too_large").Inc()
+		return n, ErrUpstreamTooLarge
+	}
+
+	return n, err
+}
+
+// softNotFoundPeek is the size of the start of the files of gitea searched
+// for soft 404 markers, error and login pages are small.
+const softNotFoundPeek = 8 << 10
+
+// SetSoftNotFound turns files sent by gitea with 200 OK into 404 Not Found if
+// their start (8KB) contains any of markers: the login or error page a
+// misconfigured proxy in front of gitea sends for missing files, e.g.
+// "<title>Sign In". They're neither served nor cached.
+func SetSoftNotFound(markers []string) ClientOption {
+	return func(c *Client) error {
+		if len(markers) == 0 {
+			return errors.New("soft not found needs markers")
+		}
+
+		m := make([][]byte, 0, len(markers))
+		for _, marker := range markers {
+			if marker == "" {
+				return errors.New("empty soft not found marker")
+			}
+
+			m = append(m, []byte(marker))
+		}
+
+		c.httpClient.Transport = &softNotFoundTransport{markers: m, next: c.httpClient.Transport}
+
+		return nil
+	}
+}
+
+// softNotFoundTransport is a http.RoundTripper answering requests of files
+// with 404 when gitea sends a page with a soft 404 marker.
+type softNotFoundTransport struct {
+	markers [][]byte
+	next    http.RoundTripper
+}
+
+func (t *softNotFoundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	if endpoint := upstreamEndpoint(req.URL.Path); endpoint != "media" && endpoint != "raw" {
+		return resp, nil
+	}
+
+	peek := make([]byte, softNotFoundPeek)
+
+	n, err := io.ReadFull(resp.Body, peek)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	peek = peek[:n]
+
+	start := peek
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		start = gunzipStart(peek)
+	}
+
+	for _, marker := range t.markers {
+		if bytes.Contains(start, marker) {
+			resp.Body.Close()
+			guardMetrics.refused.WithLabelValues("soft_not_found").Inc()
+
+			resp.StatusCode, resp.Status = http.StatusNotFound, "404 Not Found"
+			resp.Body, resp.ContentLength = http.NoBody, 0
+			resp.Header.Del("Content-Length")
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("ETag")
+
+			return resp, nil
+		}
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+
+	return resp, nil
+}
+
+// gunzipStart returns the start (at most softNotFoundPeek bytes) of the
+// content of the start of a gzip stream.
+func gunzipStart(compressed []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil
+	}
+
+	start := make([]byte, softNotFoundPeek)
+	n, _ := io.ReadFull(gz, start)
+
+	return start[:n]
+}
@@ -0,0 +1,187 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	gclient "code.gitea.io/sdk/gitea"
+	"github.com/spf13/viper"
+)
+
+// ErrSiteUnpublished is returned by CheckSiteHealth for repos that don't
+// publish a site (anymore), their health isn't checked.
+var ErrSiteUnpublished = errors.New("site isn't published")
+
+// SiteFailure is returned by CheckSiteHealth for a site failing because of
+// its repo, which its owner can fix: a missing branch or index, or a config
+// that can't be parsed. Other errors are of checking the site, e.g. gitea
+// being unavailable.
+type SiteFailure struct {
+	Err error
+}
+
+func (f *SiteFailure) Error() string {
+	return f.Err.Error()
+}
+
+func (f *SiteFailure) Unwrap() error {
+	return f.Err
+}
+
+// healthIssueTitle is the title of the issues about failing sites.
+const healthIssueTitle = "The pages site fails to be served"
+
+// CheckSiteHealth fetches the index of the site of owner/repo at ref, as
+// it's served to visitors but without counting it as a request. It returns a
+// SiteFailure if the repo keeps it from being served, ErrSiteUnpublished if
+// the repo has no pages topic (anymore).
+func (c *Client) CheckSiteHealth(owner, repo, ref string) error {
+	err := c.checkSiteHealth(owner, repo, ref)
+
+	var parseErr viper.ConfigParseError
+	if errors.Is(err, fs.ErrNotExist) || errors.As(err, &parseErr) {
+		return &SiteFailure{Err: err}
+	}
+
+	return err
+}
+
+func (c *Client) checkSiteHealth(owner, repo, ref string) error {
+	tc, err := c.allowsPages(context.Background(), owner, repo, c.names(owner))
+	if err != nil {
+		return err
+	}
+
+	if !tc.limited {
+		return ErrSiteUnpublished
+	}
+
+	s, err := c.resolve(context.Background(), owner+"/"+repo+"/", ref)
+	if err != nil {
+		return err
+	}
+
+	filepath, err := c.prettyPath(s, s.filepath)
+	if err != nil {
+		return err
+	}
+
+	f, err := c.openSiteFile(s, filepath, nil)
+
+	// redirects and embargoes are served as meant
+	var (
+		redirect *RedirectError
+		embargo  *EmbargoError
+	)
+
+	if errors.As(err, &redirect) || errors.As(err, &embargo) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// NotifySiteHealth opens an issue in owner/repo telling its site at ref fails
+// with failure, or updates the issue opened before. With failure nil the site
+// is served again and the issue is closed. Repos with the
+// pages:no-health-issues topic opted out, they get no issues.
+func (c *Client) NotifySiteHealth(owner, repo, ref string, failure error) error {
+	if c.scopes != nil && !c.scopes.Issues {
+		return ErrReadOnlyToken
+	}
+
+	tc, err := c.allowsPages(context.Background(), owner, repo, c.names(owner))
+	if err != nil || tc.noHealthIssues {
+		return err
+	}
+
+	issue, err := c.healthIssue(owner, repo, ref)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if failure == nil {
+		if issue == nil {
+			return nil
+		}
+
+		body := issue.Body + "\n\nThe site is served again since " + now + "."
+		closed := gclient.StateClosed
+
+		_, _, err := c.gc.EditIssue(owner, repo, issue.Index, gclient.EditIssueOption{
+			Title: issue.Title, Body: &body, State: &closed,
+		})
+
+		return err
+	}
+
+	what := "The pages site of this repo"
+	if ref != "" {
+		what += " at `" + ref + "`"
+	}
+
+	body := healthIssueMarker(ref) + "\n" + what + " fails to be served:\n\n```\n" +
+		strings.ReplaceAll(failure.Error(), "```", "'''") + "\n```\n\n" +
+		"Last checked at " + now + ". This issue is updated while the site fails and closed once it's served again. " +
+		"Add the `pages:no-health-issues` topic to the repo to get no more issues about it."
+
+	if issue != nil {
+		_, _, err = c.gc.EditIssue(owner, repo, issue.Index, gclient.EditIssueOption{Title: issue.Title, Body: &body})
+	} else {
+		_, _, err = c.gc.CreateIssue(owner, repo, gclient.CreateIssueOption{Title: healthIssueTitle, Body: body})
+	}
+
+	if err != nil {
+		return fmt.Errorf("notifying %s/%s of its failing site: %w", owner, repo, err)
+	}
+
+	return nil
+}
+
+// healthIssueMarker marks the issue about the site of a repo at ref, so it's
+// found again to be updated.
+func healthIssueMarker(ref string) string {
+	return "<!-- gitea-pages-health " + ref + " -->"
+}
+
+// healthIssue returns the open issue about the site of owner/repo at ref, nil
+// if there's none.
+func (c *Client) healthIssue(owner, repo, ref string) (*gclient.Issue, error) {
+	marker := healthIssueMarker(ref)
+
+	for page := 1; ; page++ {
+		issues, resp, err := c.gc.ListRepoIssues(owner, repo, gclient.ListIssueOption{
+			ListOptions: gclient.ListOptions{Page: page, PageSize: 50},
+			State:       gclient.StateOpen,
+			Type:        gclient.IssueTypeIssue,
+		})
+		if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fs.ErrNotExist
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, issue := range issues {
+			if strings.HasPrefix(issue.Body, marker) {
+				return issue, nil
+			}
+		}
+
+		if len(issues) < 50 {
+			return nil, nil
+		}
+	}
+}
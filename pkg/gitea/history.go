@@ -0,0 +1,167 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxFileCommits is the number of commits of a file fetched for its history.
+const maxFileCommits = 50
+
+// Commit is a commit that changed a file of a site.
+type Commit struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	// URL is the page of the commit on gitea.
+	URL string `json:"url,omitempty"`
+}
+
+// Contributor is an author of commits that changed a file.
+type Contributor struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+}
+
+// historyIndex are the commits of the files of a site, per tree.
+type historyIndex struct {
+	mu      sync.Mutex
+	commits map[string][]Commit
+}
+
+// fileHistory returns the commits (newest first) that changed the file
+// filepath of the site s in the tree t, fetched once per tree.
+func (c *Client) fileHistory(s *site, t *siteTree, filepath string) ([]Commit, error) {
+	t.history.mu.Lock()
+	commits, ok := t.history.commits[filepath]
+	t.history.mu.Unlock()
+
+	if ok {
+		return commits, nil
+	}
+
+	commits, err := c.fetchCommits(s.ctx, s.owner, s.repo, filepath, s.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	t.history.mu.Lock()
+	if t.history.commits == nil {
+		t.history.commits = make(map[string][]Commit)
+	}
+
+	t.history.commits[filepath] = commits
+	t.history.mu.Unlock()
+
+	return commits, nil
+}
+
+// fetchCommits fetches the commits of owner/repo at ref that changed
+// filepath from gitea, the sdk can't filter them by path.
+func (c *Client) fetchCommits(ctx context.Context, owner, repo, filepath, ref string) ([]Commit, error) {
+	giteaURL, err := url.JoinPath(c.serverURL+"/api/v1/repos/", owner, repo, "commits")
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("path", filepath)
+	query.Set("limit", strconv.Itoa(maxFileCommits))
+	// the changed files, stats and signatures aren't needed
+	query.Set("files", "false")
+	query.Set("stat", "false")
+	query.Set("verification", "false")
+
+	if ref != "" {
+		query.Set("sha", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, giteaURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "token "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	}
+
+	var list []struct {
+		SHA     string `json:"sha"`
+		HTMLURL string `json:"html_url"`
+		Commit  struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name string    `json:"name"`
+				Date time.Time `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding commits of %s/%s: %w", owner, repo, err)
+	}
+
+	commits := make([]Commit, 0, len(list))
+
+	for _, l := range list {
+		commits = append(commits, Commit{
+			SHA:     l.SHA,
+			Message: l.Commit.Message,
+			Author:  l.Commit.Author.Name,
+			Date:    l.Commit.Author.Date,
+			URL:     l.HTMLURL,
+		})
+	}
+
+	return commits, nil
+}
+
+// contributors returns the authors of commits, the most commits first and
+// otherwise the most recent first.
+func contributors(commits []Commit) []Contributor {
+	var list []Contributor
+
+	index := make(map[string]int)
+
+	for _, commit := range commits {
+		if commit.Author == "" {
+			continue
+		}
+
+		i, ok := index[commit.Author]
+		if !ok {
+			i = len(list)
+			index[commit.Author] = i
+			list = append(list, Contributor{Name: commit.Author})
+		}
+
+		list[i].Commits++
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Commits > list[j].Commits })
+
+	return list
+}
@@ -0,0 +1,52 @@
+package gitea
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeHost returns the ASCII form of the hostname host: lowercase,
+// without trailing dots and with internationalized labels in punycode
+// (xn--), the form gitea owner names and DNS use. Hosts that aren't valid
+// IDNs are only lowercased.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimRight(host, "."))
+
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+
+	return host
+}
+
+// escapePath returns the url path of the file path p, with non-ASCII and
+// reserved characters percent-encoded.
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// unescapePath returns the file path of the url path p, or p itself if it
+// isn't percent-encoded properly.
+func unescapePath(p string) string {
+	if u, err := url.PathUnescape(p); err == nil {
+		return u
+	}
+
+	return p
+}
+
+// otherForm returns the other unicode normalization of the path name, or ""
+// if it has none: files committed on macOS are often decomposed (NFD),
+// while browsers request the composed form (NFC).
+func otherForm(name string) string {
+	for _, form := range []norm.Form{norm.NFC, norm.NFD} {
+		if alt := form.String(name); alt != name {
+			return alt
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,74 @@
+package gitea
+
+import (
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com", "example.com"},
+		{"Owner.Pages.Example.COM.", "owner.pages.example.com"},
+		{"example.com..", "example.com"},
+		{"bücher.pages.example.com", "xn--bcher-kva.pages.example.com"},
+		{"BÜCHER.pages.example.com", "xn--bcher-kva.pages.example.com"},
+		{"xn--bcher-kva.pages.example.com", "xn--bcher-kva.pages.example.com"},
+		{"XN--BCHER-KVA.pages.example.com", "xn--bcher-kva.pages.example.com"},
+		{"127.0.0.1", "127.0.0.1"},
+		// not an IDN, only lowercased
+		{"Under_Score.example.com", "under_score.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeHost(tt.host); got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		p, want string
+	}{
+		{"/docs/index.html", "/docs/index.html"},
+		{"/café.html", "/caf%C3%A9.html"},
+		{"/with space/", "/with%20space/"},
+		{"/日本語/ページ", "/%E6%97%A5%E6%9C%AC%E8%AA%9E/%E3%83%9A%E3%83%BC%E3%82%B8"},
+		{"/a?b#c", "/a%3Fb%23c"},
+	}
+
+	for _, tt := range tests {
+		got := escapePath(tt.p)
+		if got != tt.want {
+			t.Errorf("escapePath(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+
+		if back := unescapePath(got); back != tt.p {
+			t.Errorf("unescapePath(%q) = %q, want %q", got, back, tt.p)
+		}
+	}
+
+	// paths that aren't escaped properly are kept
+	if got := unescapePath("/100%.html"); got != "/100%.html" {
+		t.Errorf("unescapePath(%q) = %q", "/100%.html", got)
+	}
+}
+
+func TestOtherForm(t *testing.T) {
+	nfc, nfd := norm.NFC.String("café.html"), norm.NFD.String("café.html")
+
+	if got := otherForm(nfc); got != nfd {
+		t.Errorf("otherForm(NFC) = %q, want %q", got, nfd)
+	}
+
+	if got := otherForm(nfd); got != nfc {
+		t.Errorf("otherForm(NFD) = %q, want %q", got, nfc)
+	}
+
+	if got := otherForm("index.html"); got != "" {
+		t.Errorf("otherForm(%q) = %q, want none", "index.html", got)
+	}
+}
@@ -0,0 +1,125 @@
+package gitea
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SiteStats describes a site served by the client.
+type SiteStats struct {
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	Ref         string    `json:"ref"`
+	Domains     []string  `json:"domains,omitempty"`
+	DeploySHA   string    `json:"deploy_sha,omitempty"`
+	DeployedAt  time.Time `json:"deployed_at,omitempty"`
+	CacheSize   int64     `json:"cache_size"`
+	Requests    int64     `json:"requests"`
+	Bytes       int64     `json:"bytes"`
+	LastRequest time.Time `json:"last_request"`
+	// Links are the clicks on the short links of the site.
+	Links map[string]int64 `json:"links,omitempty"`
+}
+
+// PrefixSizer is implemented by caches that can tell the size of the
+// entries of a site, with keys starting with prefix (owner/repo/). It's asked
+// for every file cached, so it mustn't scan the cache.
+type PrefixSizer interface {
+	PrefixSize(prefix string) int64
+}
+
+// inventory keeps the statistics of all sites served, and the usage of the
+// owners since the last usage report.
+type inventory struct {
+	mu    sync.Mutex
+	sites map[string]*SiteStats
+	usage *usagePeriod
+}
+
+func (inv *inventory) site(owner, repo, ref string) *SiteStats {
+	key := owner + "/" + repo + "@" + ref
+
+	s, ok := inv.sites[key]
+	if !ok {
+		s = &SiteStats{Owner: owner, Repo: repo, Ref: ref}
+		inv.sites[key] = s
+	}
+
+	return s
+}
+
+// served records a request for the file path of the site.
+func (inv *inventory) served(owner, repo, ref, path string, size int) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	s := inv.site(owner, repo, ref)
+	s.Requests++
+	s.Bytes += int64(size)
+	s.LastRequest = time.Now()
+
+	inv.usage.served(owner, repo, path, size)
+}
+
+// clicked records a click on the short link of the site.
+func (inv *inventory) clicked(owner, repo, ref, link string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	s := inv.site(owner, repo, ref)
+	if s.Links == nil {
+		s.Links = make(map[string]int64)
+	}
+
+	s.Links[link]++
+}
+
+// deployed records the version of the site that is served.
+func (inv *inventory) deployed(owner, repo, ref, sha string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	s := inv.site(owner, repo, ref)
+	if s.DeploySHA != sha {
+		s.DeploySHA = sha
+		s.DeployedAt = time.Now()
+	}
+}
+
+// Sites returns the statistics of all sites served, ordered by owner, repo and ref.
+func (c *Client) Sites() []SiteStats {
+	c.inventory.mu.Lock()
+
+	sites := make([]SiteStats, 0, len(c.inventory.sites))
+	for _, s := range c.inventory.sites {
+		stats := *s
+
+		if s.Links != nil {
+			stats.Links = make(map[string]int64, len(s.Links))
+			for link, clicks := range s.Links {
+				stats.Links[link] = clicks
+			}
+		}
+
+		sites = append(sites, stats)
+	}
+
+	c.inventory.mu.Unlock()
+
+	sizer, _ := c.cache.(PrefixSizer)
+
+	for i := range sites {
+		if sizer != nil {
+			sites[i].CacheSize = sizer.PrefixSize(sites[i].Owner + "/" + sites[i].Repo + "/")
+		}
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		a, b := sites[i], sites[j]
+		return strings.Join([]string{a.Owner, a.Repo, a.Ref}, "\x00") < strings.Join([]string{b.Owner, b.Repo, b.Ref}, "\x00")
+	})
+
+	return sites
+}
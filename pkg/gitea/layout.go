@@ -0,0 +1,346 @@
+package gitea
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// GitInfo is the git metadata of a served file.
+type GitInfo struct {
+	// Commit is the last commit that changed the file, nil if unknown.
+	Commit *Commit
+	// EditURL is the page on gitea to edit the file ("Edit this page on
+	// Gitea"), empty if the site isn't served from a branch.
+	EditURL string
+	// Contributors are the authors of the last commits that changed the
+	// file, the most commits first.
+	Contributors []Contributor
+}
+
+// LastModified returns the date of the last commit that changed the file.
+func (g GitInfo) LastModified() time.Time {
+	if g.Commit == nil {
+		return time.Time{}
+	}
+
+	return g.Commit.Date
+}
+
+// Author returns the author of the last commit that changed the file.
+func (g GitInfo) Author() string {
+	if g.Commit == nil {
+		return ""
+	}
+
+	return g.Commit.Author
+}
+
+// layoutPage is the data of the layout template of a markdown page.
+type layoutPage struct {
+	GitInfo
+
+	Title string
+	// Meta is the front matter of the page.
+	Meta map[string]any
+	// Content is the rendered markdown.
+	Content template.HTML
+	// URL is the public url of the page, e.g. for its canonical link.
+	URL string
+}
+
+// layoutIndex are the layout templates of a site, per tree.
+type layoutIndex struct {
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+// SetGitHeaders adds the git metadata of files to their responses: the
+// X-Git-Commit, X-Git-Author and X-Git-Date headers and a Link to edit
+// the file on gitea. It costs a request to gitea per file and version of
+// a site.
+func SetGitHeaders() ClientOption {
+	return func(c *Client) error {
+		c.gitHeaders = true
+		return nil
+	}
+}
+
+// gitInfo returns the git metadata of the file filepath of the site s.
+func (c *Client) gitInfo(s *site, filepath string) GitInfo {
+	var info GitInfo
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err == nil {
+		var commits []Commit
+		if commits, err = c.fileHistory(s, t, filepath); len(commits) > 0 {
+			info.Commit = &commits[0]
+			info.Contributors = contributors(commits)
+		}
+	}
+
+	if err != nil {
+		c.logger.Warn("fetching commits failed", zap.String("owner", s.owner), zap.String("repo", s.repo),
+			zap.String("file", filepath), zap.Error(err))
+	}
+
+	info.EditURL = c.editURL(s, filepath, info.Commit)
+
+	return info
+}
+
+// editURL returns the page on gitea to edit the file filepath of the site
+// s, commit is the last commit of the file (which knows the public url of
+// gitea) if known.
+func (c *Client) editURL(s *site, filepath string, commit *Commit) string {
+	branch := s.ref
+
+	// previews and commits can't be edited
+	if s.preview || isCommitSHA(branch) {
+		return ""
+	}
+
+	if branch == "" {
+		var err error
+		if branch, err = c.defaultBranch(s.ctx, s.owner, s.repo); err != nil || branch == "" {
+			return ""
+		}
+	}
+
+	base := c.serverURL
+
+	// gitea may be reached on an internal url
+	if commit != nil {
+		if i := strings.Index(commit.URL, "/"+s.owner+"/"+s.repo+"/commit/"); i > 0 {
+			base = commit.URL[:i]
+		}
+	}
+
+	return base + "/" + s.owner + "/" + s.repo + "/_edit/" + branch + "/" + filepath
+}
+
+// isCommitSHA reports if ref is a full commit sha.
+func isCommitSHA(ref string) bool {
+	if len(ref) != 40 {
+		return false
+	}
+
+	for _, r := range ref {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setGitHeaders sets the git metadata info of a file in the headers h.
+func setGitHeaders(h http.Header, info GitInfo) {
+	if info.Commit != nil {
+		h.Set("X-Git-Commit", info.Commit.SHA)
+		h.Set("X-Git-Author", info.Commit.Author)
+		h.Set("X-Git-Date", info.Commit.Date.UTC().Format(time.RFC3339))
+	}
+
+	if info.EditURL != "" {
+		h.Add("Link", "<"+info.EditURL+`>; rel="edit"`)
+	}
+}
+
+// layoutFile is the layout of the markdown pages of a site that doesn't set
+// one in its config.
+const layoutFile = "_layout.html"
+
+// layoutIncludes are the templates the layouts of a site can include.
+const layoutIncludes = "_includes/*.html"
+
+// errLayoutUnusable is returned for layouts that don't exist or don't parse,
+// and for pages without layout.
+var errLayoutUnusable = errors.New("layout unusable")
+
+// defaultLayout is the layout of the markdown pages of sites without one.
+type defaultLayout struct {
+	tmpl *template.Template
+	// sum identifies the version of the layout in etags
+	sum string
+}
+
+// SetDefaultLayout renders the markdown pages of sites without a layout into
+// the Go template in file, instead of the default html.
+func SetDefaultLayout(file string) ClientOption {
+	return func(c *Client) error {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := template.New("layout").Funcs(blogFuncs).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("default layout %s: %w", file, err)
+		}
+
+		sum := sha256.Sum256(content)
+		c.defaultLayout = &defaultLayout{tmpl: tmpl, sum: hex.EncodeToString(sum[:4])}
+
+		return nil
+	}
+}
+
+// pageLayout returns the layout of the markdown page with the front matter
+// meta of the site s in the tree t, and the version of a server-wide layout
+// ("" for layouts of the site). The layout key of the front matter picks the
+// layout of a page, the layout of the config or the _layout.html file of the
+// site that of all pages, else the default layout of the server is used.
+func (c *Client) pageLayout(s *site, t *siteTree, meta map[string]any) (*template.Template, string, error) {
+	name := s.layout
+	if l, ok := meta["layout"].(string); ok && l != "" {
+		name = strings.TrimPrefix(l, "/")
+	}
+
+	if name == "" {
+		if exists, known := t.exists(layoutFile); exists && known {
+			name = layoutFile
+		}
+	}
+
+	if name != "" {
+		tmpl, err := c.layoutTemplate(s, t, name)
+		return tmpl, "", err
+	}
+
+	if c.defaultLayout != nil {
+		return c.defaultLayout.tmpl, c.defaultLayout.sum, nil
+	}
+
+	return nil, "", errLayoutUnusable
+}
+
+// layoutTemplate returns the layout template name of the site s in the
+// tree t, read once per tree. Missing and broken layouts are remembered too.
+func (c *Client) layoutTemplate(s *site, t *siteTree, name string) (*template.Template, error) {
+	t.layouts.mu.Lock()
+	defer t.layouts.mu.Unlock()
+
+	if tmpl, ok := t.layouts.templates[name]; ok {
+		if tmpl == nil {
+			return nil, errLayoutUnusable
+		}
+
+		return tmpl, nil
+	}
+
+	raw, err := c.fetchRaw(s.ctx, s.owner, s.repo, name, s.ref, nil)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var tmpl *template.Template
+	if err == nil {
+		tmpl, err = template.New("layout").Funcs(blogFuncs).Parse(string(raw.content))
+	}
+
+	if err == nil {
+		tmpl, err = c.parseIncludes(s, t, tmpl)
+	}
+
+	if err != nil {
+		c.logger.Warn("layout unusable, using the default", zap.String("owner", s.owner),
+			zap.String("repo", s.repo), zap.String("layout", name), zap.Error(err))
+	}
+
+	if t.layouts.templates == nil {
+		t.layouts.templates = make(map[string]*template.Template)
+	}
+
+	t.layouts.templates[name] = tmpl
+
+	if tmpl == nil {
+		return nil, errLayoutUnusable
+	}
+
+	return tmpl, nil
+}
+
+// parseIncludes adds the templates in _includes of the site s in the tree t
+// to the layout tmpl, which includes them by their file name
+// ({{template "nav.html" .}}).
+func (c *Client) parseIncludes(s *site, t *siteTree, tmpl *template.Template) (*template.Template, error) {
+	// only the full tree has the paths of the includes
+	if t.filter != nil {
+		var err error
+
+		if t, err = c.fetchTree(s.ctx, s.owner, s.repo, s.ref); err != nil {
+			return nil, err
+		}
+	}
+
+	fsys := c.siteFS(s, t)
+
+	if matches, _ := fs.Glob(fsys, layoutIncludes); len(matches) == 0 {
+		return tmpl, nil
+	}
+
+	return tmpl.ParseFS(fsys, layoutIncludes)
+}
+
+// renderLayout renders the markdown page filepath of the site s with content
+// and etag into its layout template, see pageLayout. The page changes with
+// the layout and git metadata, its etag returned is that of the version of
+// the site (and the server-wide layout).
+func (c *Client) renderLayout(s *site, filepath string, content []byte, etag string) ([]byte, string, error) {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// invalid front matter doesn't fail the page, it's rendered as markdown
+	meta, body, err := extractFrontMatter(string(content))
+	if err != nil {
+		meta, body = nil, string(content)
+	}
+
+	tmpl, version, err := c.pageLayout(s, t, meta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rendered, err := markdown([]byte(body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	p := layoutPage{
+		GitInfo: c.gitInfo(s, filepath),
+		Meta:    meta,
+		Content: template.HTML(rendered),
+		URL:     c.siteURL(s, pagePath(filepath)),
+	}
+
+	p.Title, _ = meta["title"].(string)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return nil, "", err
+	}
+
+	if etag != "" {
+		if version != "" {
+			version = "-" + version
+		}
+
+		etag = `W/"` + strings.Trim(strings.TrimPrefix(etag, "W/"), `"`) + "-" + t.sha + version + `"`
+	}
+
+	return buf.Bytes(), etag, nil
+}
@@ -0,0 +1,136 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// rateLimitPage is the page of a site shown when requests to gitea are
+	// shed by the upstream rate limit.
+	rateLimitPage = "429.html"
+	// quotaPage is the page of a site shown when it exceeds a site limit.
+	quotaPage = "quota.html"
+	// maxLimitPageSize is the size of the largest limit page kept.
+	maxLimitPageSize = 256 << 10
+)
+
+// limitPages are the limit pages of sites, kept in memory since gitea may be
+// too busy to fetch them when they're needed.
+type limitPages struct {
+	mu    sync.Mutex
+	pages map[string]limitPage
+}
+
+type limitPage struct {
+	sha     string
+	content []byte
+}
+
+// limitPageName returns the limit page for err, or "" if it isn't a limit.
+func limitPageName(err error) string {
+	switch {
+	case errors.Is(err, ErrUpstreamBusy):
+		return rateLimitPage
+	case errors.Is(err, ErrSiteLimit):
+		return quotaPage
+	}
+
+	return ""
+}
+
+// LimitPage returns the page of the site name at ref to show for err, the
+// 429.html of the site when gitea is too busy and its quota.html when it
+// exceeds a site limit, if it has one.
+func (c *Client) LimitPage(ctx context.Context, name, ref string, err error) ([]byte, bool) {
+	page := limitPageName(err)
+	if page == "" {
+		return nil, false
+	}
+
+	// the lookups of the site are usually cached
+	s, rerr := c.resolve(ctx, name, ref)
+	if rerr != nil {
+		return nil, false
+	}
+
+	key := s.owner + "/" + s.repo + "@" + s.ref + ":" + page
+
+	c.limitPages.mu.Lock()
+	lp, ok := c.limitPages.pages[key]
+	c.limitPages.mu.Unlock()
+
+	if !ok {
+		raw, ferr := c.fetchRaw(s.ctx, s.owner, s.repo, page, s.ref, nil)
+
+		switch {
+		case errors.Is(ferr, fs.ErrNotExist):
+			// sites without the page don't ask gitea again
+			c.storeLimitPage(key, limitPage{})
+			return nil, false
+		case ferr != nil || len(raw.content) > maxLimitPageSize:
+			return nil, false
+		}
+
+		lp = limitPage{content: raw.content}
+		c.storeLimitPage(key, lp)
+	}
+
+	if lp.content == nil {
+		return nil, false
+	}
+
+	return sanitizePage(lp.content, s.sanitize), true
+}
+
+func (c *Client) storeLimitPage(key string, lp limitPage) {
+	c.limitPages.mu.Lock()
+	defer c.limitPages.mu.Unlock()
+
+	if c.limitPages.pages == nil {
+		c.limitPages.pages = make(map[string]limitPage)
+	}
+
+	c.limitPages.pages[key] = lp
+}
+
+// prefetchLimitPages fetches the limit pages of the new tree t of owner/repo
+// at ref in the background, so they're at hand when gitea is busy.
+func (c *Client) prefetchLimitPages(owner, repo, ref string, t *siteTree) {
+	for _, page := range []string{rateLimitPage, quotaPage} {
+		key := owner + "/" + repo + "@" + ref + ":" + page
+
+		c.limitPages.mu.Lock()
+		lp, ok := c.limitPages.pages[key]
+		c.limitPages.mu.Unlock()
+
+		if ok && lp.sha == t.sha {
+			continue
+		}
+
+		if exists, known := t.exists(page); !exists && known {
+			c.storeLimitPage(key, limitPage{sha: t.sha})
+			continue
+		}
+
+		page := page
+
+		go func() {
+			raw, err := c.fetchRaw(context.Background(), owner, repo, page, ref, nil)
+			if err != nil {
+				c.logger.Debug("prefetching limit page failed", zap.String("owner", owner), zap.String("repo", repo),
+					zap.String("page", page), zap.Error(err))
+
+				return
+			}
+
+			if len(raw.content) <= maxLimitPageSize {
+				c.storeLimitPage(key, limitPage{sha: t.sha, content: raw.content})
+			}
+		}()
+	}
+}
@@ -0,0 +1,99 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrSiteLimit is returned when a site exceeds a limit of the pages host.
+var ErrSiteLimit = errors.New("site exceeds a limit")
+
+// siteLimits restrict the resources a single site may use.
+type siteLimits struct {
+	// maxCacheSize is the total size of the cached files of a site.
+	maxCacheSize int64
+	// maxFiles is the number of files of a site.
+	maxFiles int
+	// maxFileSize is the size of a single file.
+	maxFileSize int64
+}
+
+// SetSiteLimits limits the total size of the cached files of a site, its
+// number of files and the size of a single file (0 is unlimited).
+// Sites with too many files and files that are too large aren't served,
+// files of sites over their cache size are served without caching them.
+func SetSiteLimits(maxCacheSize int64, maxFiles int, maxFileSize int64) ClientOption {
+	return func(c *Client) error {
+		c.limits = siteLimits{
+			maxCacheSize: maxCacheSize,
+			maxFiles:     maxFiles,
+			maxFileSize:  maxFileSize,
+		}
+
+		return nil
+	}
+}
+
+// limitExceeded logs and emits that owner/repo exceeds limit and returns the
+// error shown to its visitors.
+func (c *Client) limitExceeded(owner, repo, limit string, format string, args ...any) error {
+	err := fmt.Errorf("%w: "+format, append([]any{ErrSiteLimit}, args...)...)
+
+	c.logger.Warn("site exceeds limit", zap.String("owner", owner), zap.String("repo", repo),
+		zap.String("limit", limit), zap.Error(err))
+
+	c.Emit(EventSiteLimitExceeded, map[string]any{
+		"owner": owner,
+		"repo":  repo,
+		"limit": limit,
+		"error": err.Error(),
+	})
+
+	return err
+}
+
+// checkFileCount returns ErrSiteLimit if the site has more files than allowed.
+func (c *Client) checkFileCount(s *site) error {
+	if c.limits.maxFiles == 0 {
+		return nil
+	}
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		// we can't tell, the file itself will fail if gitea does
+		return nil
+	}
+
+	if t.count > c.limits.maxFiles {
+		return c.limitExceeded(s.owner, s.repo, "max_files",
+			"%d files, at most %d are allowed", t.count, c.limits.maxFiles)
+	}
+
+	return nil
+}
+
+// checkFileSize returns ErrSiteLimit if a file of size bytes is larger than allowed.
+func (c *Client) checkFileSize(owner, repo, filepath string, size int64) error {
+	if c.limits.maxFileSize == 0 || size <= c.limits.maxFileSize {
+		return nil
+	}
+
+	return c.limitExceeded(owner, repo, "max_file_size",
+		"%s is larger than %d bytes", filepath, c.limits.maxFileSize)
+}
+
+// cacheFull reports if the cached files of owner/repo can't grow by size bytes.
+func (c *Client) cacheFull(owner, repo string, size int) bool {
+	if c.limits.maxCacheSize == 0 {
+		return false
+	}
+
+	sizer, ok := c.cache.(PrefixSizer)
+	if !ok {
+		return false
+	}
+
+	return sizer.PrefixSize(owner+"/"+repo+"/")+int64(size) > c.limits.maxCacheSize
+}
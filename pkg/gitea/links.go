@@ -0,0 +1,92 @@
+package gitea
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+)
+
+// linksFile is the file in the root of a site with its short links.
+const linksFile = "_links.toml"
+
+// maxLinks is the number of short links read from a links file.
+const maxLinks = 1000
+
+// parseLinks parses a links file, mapping the short paths to their targets:
+//
+//	"/go/docs"="/docs/getting-started/"
+//	"/go/chat"="https://chat.example.com/"
+func parseLinks(content []byte) (map[string]string, error) {
+	var raw map[string]string
+	if err := toml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+
+	links := make(map[string]string, len(raw))
+
+	for from, to := range raw {
+		if len(links) == maxLinks {
+			break
+		}
+
+		if to != "" {
+			links[linkPath(from)] = to
+		}
+	}
+
+	return links, nil
+}
+
+// linkPath returns the short path p of a link or request, unescaped and
+// without a trailing slash.
+func linkPath(p string) string {
+	return "/" + strings.Trim(unescapePath(p), "/")
+}
+
+// shortLinks returns the short links of the site, read once per tree.
+func (c *Client) shortLinks(s *site, t *siteTree) map[string]string {
+	t.linksOnce.Do(func() {
+		if exists, known := t.exists(linksFile); !exists && known {
+			return
+		}
+
+		ctx, cancel := c.detached()
+		defer cancel()
+
+		raw, err := c.fetchRaw(ctx, s.owner, s.repo, linksFile, s.ref, nil)
+		if err != nil {
+			return
+		}
+
+		if t.links, err = parseLinks(raw.content); err != nil {
+			c.logger.Warn("invalid links file", zap.String("owner", s.owner), zap.String("repo", s.repo),
+				zap.Error(err))
+		}
+	})
+
+	return t.links
+}
+
+// shortLink returns a *RedirectError to the target if the request of the
+// site s is for a short link, and counts the click. Short links take
+// precedence over files.
+func (c *Client) shortLink(s *site) error {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil
+	}
+
+	p := linkPath(s.filepath)
+
+	to, ok := c.shortLinks(s, t)[p]
+	if !ok {
+		return nil
+	}
+
+	c.inventory.clicked(s.owner, s.repo, s.ref, p)
+
+	// a permanent redirect would be cached, uncounted
+	return &RedirectError{Path: "/" + s.filepath, To: to, Status: http.StatusFound}
+}
@@ -0,0 +1,132 @@
+package gitea
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupCache caches the topics and branches of repos, which are looked up
+// to resolve every request.
+type lookupCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	topics   map[string]lookupEntry
+	branches map[string]lookupEntry
+}
+
+type lookupEntry struct {
+	topics  []string
+	exists  bool
+	expires time.Time
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		ttl:      ttl,
+		topics:   make(map[string]lookupEntry),
+		branches: make(map[string]lookupEntry),
+	}
+}
+
+// repoKey is the key of owner/repo, their names are case insensitive like
+// in gitea.
+func repoKey(owner, repo string) string {
+	return strings.ToLower(owner + "/" + repo)
+}
+
+func (lc *lookupCache) get(m map[string]lookupEntry, key string) (lookupEntry, bool) {
+	if lc == nil {
+		return lookupEntry{}, false
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	e, ok := m[key]
+	if ok && time.Now().After(e.expires) {
+		delete(m, key)
+		return lookupEntry{}, false
+	}
+
+	return e, ok
+}
+
+func (lc *lookupCache) set(m map[string]lookupEntry, key string, e lookupEntry) {
+	if lc == nil {
+		return
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	e.expires = time.Now().Add(lc.ttl)
+	m[key] = e
+}
+
+// repoTopics returns the cached topics of owner/repo.
+func (lc *lookupCache) repoTopics(owner, repo string) ([]string, bool) {
+	if lc == nil {
+		return nil, false
+	}
+
+	e, ok := lc.get(lc.topics, repoKey(owner, repo))
+
+	return e.topics, ok
+}
+
+func (lc *lookupCache) setRepoTopics(owner, repo string, topics []string) {
+	if lc == nil {
+		return
+	}
+
+	lc.set(lc.topics, repoKey(owner, repo), lookupEntry{topics: topics})
+}
+
+// hasBranch returns if the branch of owner/repo exists, if it's cached.
+func (lc *lookupCache) hasBranch(owner, repo, branch string) (exists, ok bool) {
+	if lc == nil {
+		return false, false
+	}
+
+	e, ok := lc.get(lc.branches, repoKey(owner, repo)+"@"+branch)
+
+	return e.exists, ok
+}
+
+func (lc *lookupCache) setBranch(owner, repo, branch string, exists bool) {
+	if lc == nil {
+		return
+	}
+
+	lc.set(lc.branches, repoKey(owner, repo)+"@"+branch, lookupEntry{exists: exists})
+}
+
+// purge removes the topics and branches of owner/repo.
+func (lc *lookupCache) purge(owner, repo string) {
+	if lc == nil {
+		return
+	}
+
+	key := repoKey(owner, repo)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	delete(lc.topics, key)
+
+	for k := range lc.branches {
+		if strings.HasPrefix(k, key+"@") {
+			delete(lc.branches, k)
+		}
+	}
+}
+
+// InvalidateRepo forgets everything cached of owner/repo on this node: its
+// files of all refs, trees, topics, branches, custom domains, who can read it
+// and whether it exists. Use Purge to purge the whole cluster.
+func (c *Client) InvalidateRepo(owner, repo string) {
+	c.purgeRepo(owner, repo)
+
+	c.Emit(EventCachePurged, map[string]any{"cache": "repo", "owner": owner, "repo": repo})
+}
@@ -0,0 +1,200 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrMaintenance is returned while gitea is in maintenance and no stale copy
+// of the requested file is available.
+var ErrMaintenance = errors.New("gitea is in maintenance")
+
+const (
+	// maintenanceRetry is how long gitea isn't asked again after it answered
+	// 503 without a Retry-After header.
+	maintenanceRetry = 30 * time.Second
+	// maintenanceWarnInterval is how often the maintenance warning is logged.
+	maintenanceWarnInterval = time.Minute
+	// maxStaleSize is the largest file that is kept for serving stale.
+	maxStaleSize = 1 << 20
+)
+
+// maintenanceTransport is a http.RoundTripper that detects gitea
+// maintenance windows (503 responses) and stops asking gitea until it's over.
+type maintenanceTransport struct {
+	mu       sync.Mutex
+	until    time.Time
+	lastWarn time.Time
+	logger   *zap.Logger
+	next     http.RoundTripper
+}
+
+func (t *maintenanceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.active() {
+		return nil, ErrMaintenance
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, err
+	}
+
+	retry := maintenanceRetry
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		retry = time.Duration(s) * time.Second
+	}
+
+	resp.Body.Close()
+	t.enter(retry)
+
+	return nil, ErrMaintenance
+}
+
+func (t *maintenanceTransport) active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return time.Now().Before(t.until)
+}
+
+// enter switches to maintenance mode for d and logs a warning, at most once
+// per maintenanceWarnInterval.
+func (t *maintenanceTransport) enter(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.until = now.Add(d)
+
+	if now.Sub(t.lastWarn) < maintenanceWarnInterval {
+		return
+	}
+
+	t.lastWarn = now
+	t.logger.Warn("gitea is in maintenance, serving stale content", zap.Duration("retry_after", d))
+}
+
+// staleStore keeps the last served copy of files to serve them while gitea
+// is in maintenance.
+type staleStore struct {
+	mu    sync.Mutex
+	max   int
+	size  int64
+	files map[string]*openFile
+	order []string
+}
+
+func (ss *staleStore) get(key string) *openFile {
+	if ss == nil {
+		return nil
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return ss.files[key]
+}
+
+func (ss *staleStore) put(key string, f *openFile) {
+	if ss == nil || len(f.content) > maxStaleSize {
+		return
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if old, ok := ss.files[key]; ok {
+		ss.resize(-len(old.content))
+	} else {
+		ss.order = append(ss.order, key)
+	}
+
+	ss.files[key] = f
+	ss.resize(len(f.content))
+
+	// evict the oldest entries
+	for len(ss.order) > ss.max {
+		ss.evictOldest()
+	}
+}
+
+func (ss *staleStore) remove(key string) {
+	if ss == nil {
+		return
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	old, ok := ss.files[key]
+	if !ok {
+		return
+	}
+
+	delete(ss.files, key)
+	ss.resize(-len(old.content))
+
+	for i, k := range ss.order {
+		if k == key {
+			ss.order = append(ss.order[:i], ss.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Shed evicts the oldest copies until fraction of their size is freed and
+// returns the number of bytes freed.
+func (ss *staleStore) Shed(fraction float64) int64 {
+	if ss == nil {
+		return 0
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	target := ss.size - int64(float64(ss.size)*fraction)
+	freed := ss.size
+
+	for ss.size > target && len(ss.order) > 0 {
+		ss.evictOldest()
+	}
+
+	return freed - ss.size
+}
+
+func (ss *staleStore) evictOldest() {
+	ss.resize(-len(ss.files[ss.order[0]].content))
+	delete(ss.files, ss.order[0])
+	ss.order = ss.order[1:]
+}
+
+func (ss *staleStore) resize(delta int) {
+	ss.size += int64(delta)
+	memoryMetrics.held.WithLabelValues("stale").Add(float64(delta))
+}
+
+// SetServeStale keeps the last served copy of up to n files, to serve them
+// while gitea is in maintenance.
+func SetServeStale(n int) ClientOption {
+	return func(c *Client) error {
+		c.stale = &staleStore{
+			max:   n,
+			files: make(map[string]*openFile),
+		}
+
+		return nil
+	}
+}
+
+// SetLogger sets the logger of the client.
+func SetLogger(logger *zap.Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
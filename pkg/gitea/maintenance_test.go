@@ -0,0 +1,82 @@
+package gitea
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestServeStale(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddUser("pages", "token")
+
+	for _, owner := range []string{"public", "private"} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:    owner,
+			Name:     "gitea-pages",
+			Private:  owner == "private",
+			Topics:   []string{"gitea-pages"},
+			Readers:  []string{"pages"},
+			Branches: map[string]map[string]string{"gitea-pages": {"index.html": "<p>" + owner + "</p>"}},
+		})
+	}
+
+	open := func(c *Client, name string) error {
+		f, err := c.Open(name, "")
+		if err != nil {
+			return err
+		}
+
+		_, _ = io.Copy(io.Discard, f)
+
+		return f.Close()
+	}
+
+	// the copies are kept without looking the site up again
+	plain, err := NewClient(srv.URL, "token", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := open(plain, "private/gitea-pages/index.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := srv.Requests("/api/v1/repos/private/gitea-pages")
+
+	c, err := NewClient(srv.URL, "token", "", "", SetServeStale(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"public/gitea-pages/index.html", "private/gitea-pages/index.html"} {
+		if err := open(c, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := srv.Requests("/api/v1/repos/private/gitea-pages") - lookups; got != lookups {
+		t.Errorf("%d lookups of the repo with stale copies, want %d", got, lookups)
+	}
+
+	srv.SetDown(true)
+
+	f, err := c.Open("public/gitea-pages/index.html", "")
+	if err != nil {
+		t.Fatalf("public site: %v", err)
+	}
+
+	if sf, ok := f.(interface{ Stale() bool }); !ok || !sf.Stale() {
+		t.Error("public site isn't served from its stale copy")
+	}
+
+	f.Close()
+
+	if _, err := c.Open("private/gitea-pages/index.html", ""); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("private site: %v, want %v", err, ErrMaintenance)
+	}
+}
@@ -0,0 +1,70 @@
+package gitea
+
+import (
+	"context"
+	"strings"
+)
+
+// ManifestEntry describes a single file of a site.
+type ManifestEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// Manifest lists all files of a site at a ref.
+type Manifest struct {
+	Ref       string          `json:"ref"`
+	Files     []ManifestEntry `json:"files"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// Manifest returns the manifest of the site name at ref.
+// The hash of every entry is the git blob SHA-1 of the file.
+func (c *Client) Manifest(ctx context.Context, name, ref string) (*Manifest, error) {
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := c.fullTree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Ref:       tree.ref,
+		Files:     []ManifestEntry{},
+		Truncated: tree.truncated,
+	}
+
+	for _, e := range tree.entries {
+		// only list the subdirectory if we're serving a path of the gitea-pages repo
+		if s.filepath != "" && !strings.HasPrefix(e.path, s.filepath+"/") {
+			continue
+		}
+
+		m.Files = append(m.Files, ManifestEntry{
+			Path: e.path,
+			Size: e.size,
+			Hash: e.sha,
+		})
+	}
+
+	return m, nil
+}
+
+// defaultBranch returns the default branch of the repo, as last seen when
+// fetching the repo.
+func (c *Client) defaultBranch(ctx context.Context, owner, repo string) (string, error) {
+	if branch, ok := c.defaultBranches.Load(owner + "/" + repo); ok && branch.(string) != "" {
+		return branch.(string), nil
+	}
+
+	r, err := c.repoMeta(ctx, owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	return r.DefaultBranch, nil
+}
@@ -0,0 +1,146 @@
+package gitea
+
+import (
+	"errors"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// memoryCheckInterval is how often the memory use of the process is checked.
+	memoryCheckInterval = time.Second
+	// memoryHighWatermark is the part of the memory limit above which the
+	// in-memory caches are shed.
+	memoryHighWatermark = 0.9
+	// memoryShedFraction is the part of the in-memory caches shed at once.
+	memoryShedFraction = 0.5
+	// memoryWarnInterval is how often shedding is logged.
+	memoryWarnInterval = time.Minute
+)
+
+// Shedder is implemented by caches holding their entries in memory, to free
+// memory when the process gets close to its memory limit.
+type Shedder interface {
+	// Shed evicts (at least) fraction of the entries by size, least
+	// recently used first, and returns the number of bytes freed.
+	Shed(fraction float64) int64
+}
+
+// memoryGuard sheds the in-memory caches when the memory used by the
+// process gets close to its limit, instead of risking to run out of memory.
+type memoryGuard struct {
+	mu       sync.Mutex
+	limit    int64
+	last     time.Time
+	lastWarn time.Time
+	pressure bool
+}
+
+// SetMemoryLimit sheds the in-memory caches (and skips caching new files)
+// when the memory used by the process gets close to limit. A limit of 0 uses
+// the soft memory limit of the go runtime (GOMEMLIMIT); a limit is set as
+// soft memory limit if the runtime has none, so the garbage collector
+// works harder before the caches are shed.
+func SetMemoryLimit(limit int64) ClientOption {
+	return func(c *Client) error {
+		runtimeLimit := debug.SetMemoryLimit(-1)
+
+		switch {
+		case limit < 0:
+			return errors.New("memory limit must not be negative")
+		case limit == 0 && runtimeLimit == math.MaxInt64:
+			return errors.New("no memory limit and none set with GOMEMLIMIT")
+		case limit == 0:
+			limit = runtimeLimit
+		case runtimeLimit == math.MaxInt64:
+			debug.SetMemoryLimit(limit)
+		}
+
+		c.memory = &memoryGuard{limit: limit}
+
+		return nil
+	}
+}
+
+// memoryInUse returns the memory used by the go runtime as counted against
+// its soft memory limit.
+func memoryInUse() int64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+
+	metrics.Read(samples)
+
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// underPressure reports if the process uses more than the high watermark of
+// its memory limit, it's checked at most once per memoryCheckInterval.
+func (g *memoryGuard) underPressure() (bool, int64) {
+	if g == nil {
+		return false, 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Since(g.last) < memoryCheckInterval {
+		return g.pressure, 0
+	}
+
+	g.last = time.Now()
+
+	inUse := memoryInUse()
+	g.pressure = float64(inUse) > float64(g.limit)*memoryHighWatermark
+
+	return g.pressure, inUse
+}
+
+// shouldWarn reports if shedding should be logged, at most once per
+// memoryWarnInterval.
+func (g *memoryGuard) shouldWarn() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Since(g.lastWarn) < memoryWarnInterval {
+		return false
+	}
+
+	g.lastWarn = time.Now()
+
+	return true
+}
+
+// relieveMemory sheds the in-memory caches if the process is under memory
+// pressure and reports if it is, so nothing new is cached.
+func (c *Client) relieveMemory() bool {
+	pressure, inUse := c.memory.underPressure()
+	if !pressure || inUse == 0 {
+		// only shed once per check
+		return pressure
+	}
+
+	var freed int64
+
+	if s, ok := c.cache.(Shedder); ok {
+		freed += s.Shed(memoryShedFraction)
+	}
+
+	freed += c.stale.Shed(memoryShedFraction)
+	freed += c.archives.Shed(memoryShedFraction)
+
+	memoryMetrics.shed.Add(float64(freed))
+
+	if c.memory.shouldWarn() {
+		c.logger.Warn("memory limit almost reached, shedding in-memory caches",
+			zap.Int64("in_use", inUse), zap.Int64("limit", c.memory.limit), zap.Int64("freed", freed))
+	}
+
+	return true
+}
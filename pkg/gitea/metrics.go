@@ -0,0 +1,113 @@
+package gitea
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var memoryMetrics = struct {
+	held *prometheus.GaugeVec
+	shed prometheus.Counter
+}{
+	held: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "memory_held_bytes",
+		Help:      "Bytes held in memory by the in-memory caches and render buffers (cache, stale, render).",
+	}, []string{"kind"}),
+	shed: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "memory_shed_bytes_total",
+		Help:      "Counter of bytes shed from the in-memory caches under memory pressure.",
+	}),
+}
+
+var diskCacheMetrics = struct {
+	discarded *prometheus.CounterVec
+}{
+	discarded: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "disk_cache_discarded_total",
+		Help:      "Counter of disk cache entries discarded by reason (version, corrupt).",
+	}, []string{"reason"}),
+}
+
+var shieldMetrics = struct {
+	requests *prometheus.CounterVec
+}{
+	requests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "shield_requests_total",
+		Help:      "Counter of files looked up in the object storage by result (hit, miss, outdated).",
+	}, []string{"result"}),
+}
+
+var upstreamLimitMetrics = struct {
+	requests *prometheus.CounterVec
+	wait     prometheus.Histogram
+}{
+	requests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "upstream_limited_requests_total",
+		Help:      "Counter of requests to gitea by rate limit result (allowed, queued, shed).",
+	}, []string{"result"}),
+	wait: promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "upstream_limit_wait_seconds",
+		Help:      "Histogram of the time requests to gitea waited for the rate limit.",
+		Buckets:   prometheus.DefBuckets,
+	}),
+}
+
+var purgeMetrics = struct {
+	purges  *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	fanOut  *prometheus.CounterVec
+}{
+	purges: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "purges_total",
+		Help:      "Counter of purges by reason and result (applied, duplicate).",
+	}, []string{"reason", "result"}),
+	latency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "purge_latency_seconds",
+		Help:      "Histogram of the time from issuing a purge to applying it on this node, by origin (local, peer).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"origin"}),
+	fanOut: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "purge_fanout_total",
+		Help:      "Counter of purges sent to peers by result (ok, error).",
+	}, []string{"result"}),
+}
+
+var guardMetrics = struct {
+	refused *prometheus.CounterVec
+}{
+	refused: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "upstream_refused_responses_total",
+		Help:      "Counter of responses of gitea refused by reason (invalid, too_large, soft_not_found).",
+	}, []string{"reason"}),
+}
+
+var renderCacheMetrics = struct {
+	requests *prometheus.CounterVec
+}{
+	requests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "gitea",
+		Name:      "render_cache_requests_total",
+		Help:      "Counter of rendered markdown pages looked up in the cache by result (hit, miss).",
+	}, []string{"result"}),
+}
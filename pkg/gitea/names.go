@@ -0,0 +1,66 @@
+package gitea
+
+import "strings"
+
+// OwnerNames overrides the names used for the sites of an owner, e.g. for
+// orgs migrating from other conventions.
+type OwnerNames struct {
+	// GiteaPages is the topic that opts in a repo and the branch with (and
+	// the name of) its config file.
+	GiteaPages string `json:"gitea_pages,omitempty"`
+	// GiteaPagesAllowAll is the topic that exposes all refs of a repo.
+	GiteaPagesAllowAll string `json:"gitea_pages_allowall,omitempty"`
+	// DefaultRepo is the repo (and its branch) served on the root of the
+	// owner, defaults to GiteaPages.
+	DefaultRepo string `json:"default_repo,omitempty"`
+}
+
+// ownerNames are the names used for the sites of an owner.
+type ownerNames struct {
+	pages    string
+	allowAll string
+	repo     string
+	// tiers are the topic tiers by topic, the same for all owners
+	tiers map[string]TopicTier
+}
+
+// SetOwnerNames overrides the names used for the sites of owner, names that
+// aren't set keep the defaults of the client.
+func SetOwnerNames(owner string, names OwnerNames) ClientOption {
+	return func(c *Client) error {
+		if c.ownerNames == nil {
+			c.ownerNames = make(map[string]OwnerNames)
+		}
+
+		c.ownerNames[strings.ToLower(owner)] = names
+
+		return nil
+	}
+}
+
+// names returns the names used for the sites of owner.
+func (c *Client) names(owner string) ownerNames {
+	n := ownerNames{
+		pages:    c.giteapages,
+		allowAll: c.giteapagesAllowAll,
+		tiers:    c.tiers,
+	}
+
+	if o, ok := c.ownerNames[strings.ToLower(owner)]; ok {
+		if o.GiteaPages != "" {
+			n.pages = o.GiteaPages
+		}
+
+		if o.GiteaPagesAllowAll != "" {
+			n.allowAll = o.GiteaPagesAllowAll
+		}
+
+		n.repo = o.DefaultRepo
+	}
+
+	if n.repo == "" {
+		n.repo = n.pages
+	}
+
+	return n
+}
@@ -0,0 +1,130 @@
+package gitea
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxNotFoundEntries is the most entries the not found cache holds, scans
+// of random hosts don't grow it further.
+const maxNotFoundEntries = 100000
+
+// notFoundCache remembers owner/repo combinations that don't exist or don't
+// allow pages, so scans of random hosts don't hit the gitea API every time.
+// It's keyed by owner/repo rather than host: the hosts of an owner's sites
+// all resolve to these, and purges and webhooks of a repo can forget them.
+type notFoundCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+}
+
+func (nc *notFoundCache) has(key string) bool {
+	if nc == nil {
+		return false
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	expires, ok := nc.entries[key]
+	if ok && time.Now().After(expires) {
+		delete(nc.entries, key)
+		return false
+	}
+
+	return ok
+}
+
+func (nc *notFoundCache) add(key string) {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	now := time.Now()
+
+	// drop the expired entries once full, then any entries: a dropped entry
+	// only costs a lookup
+	if _, ok := nc.entries[key]; !ok && len(nc.entries) >= maxNotFoundEntries {
+		for k, expires := range nc.entries {
+			if now.After(expires) {
+				delete(nc.entries, k)
+			}
+		}
+
+		for k := range nc.entries {
+			if len(nc.entries) < maxNotFoundEntries*9/10 {
+				break
+			}
+
+			delete(nc.entries, k)
+		}
+	}
+
+	nc.entries[key] = now.Add(nc.ttl)
+}
+
+// forget removes the entry of owner/repo.
+func (nc *notFoundCache) forget(owner, repo string) {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	for key := range nc.entries {
+		if strings.EqualFold(key, owner+"/"+repo) {
+			delete(nc.entries, key)
+		}
+	}
+}
+
+// purge removes the entries of owner, or all entries if owner is empty.
+func (nc *notFoundCache) purge(owner string) int {
+	if nc == nil {
+		return 0
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	n := 0
+
+	for key := range nc.entries {
+		if owner == "" || strings.HasPrefix(key, owner+"/") {
+			delete(nc.entries, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// SetNotFoundTTL caches for ttl that an owner/repo doesn't exist or doesn't
+// allow pages.
+func SetNotFoundTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.notFound = &notFoundCache{
+			ttl:     ttl,
+			entries: make(map[string]time.Time),
+		}
+
+		return nil
+	}
+}
+
+// PurgeNotFound forgets the cached not found decisions of owner (all owners
+// if owner is empty) and returns how many were removed.
+func (c *Client) PurgeNotFound(owner string) int {
+	n := c.notFound.purge(owner)
+	if n > 0 {
+		c.Emit(EventCachePurged, map[string]any{"cache": "not_found", "owner": owner, "purged": n})
+	}
+
+	return n
+}
@@ -0,0 +1,38 @@
+package gitea
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotFoundCacheBounded(t *testing.T) {
+	nc := &notFoundCache{ttl: time.Hour, entries: make(map[string]time.Time)}
+
+	for i := 0; i < 2*maxNotFoundEntries; i++ {
+		nc.add("scan" + strconv.Itoa(i) + "/repo")
+	}
+
+	if n := len(nc.entries); n > maxNotFoundEntries {
+		t.Errorf("%d entries, want at most %d", n, maxNotFoundEntries)
+	}
+
+	// the latest entry is kept
+	if !nc.has("scan" + strconv.Itoa(2*maxNotFoundEntries-1) + "/repo") {
+		t.Error("latest entry dropped")
+	}
+}
+
+func TestNotFoundCacheExpires(t *testing.T) {
+	nc := &notFoundCache{ttl: -time.Second, entries: make(map[string]time.Time)}
+
+	nc.add("owner/repo")
+
+	if nc.has("owner/repo") {
+		t.Error("expired entry found")
+	}
+
+	if len(nc.entries) != 0 {
+		t.Errorf("expired entry kept")
+	}
+}
@@ -0,0 +1,223 @@
+package gitea
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// oauthTokenTimeout is how long getting an access token may take.
+const oauthTokenTimeout = 30 * time.Second
+
+// oauthRefreshMargin is how long before it expires an access token is
+// refreshed.
+const oauthRefreshMargin = time.Minute
+
+// oauthKeyPrefix is the prefix of the keys of the refresh tokens in the
+// store, by the hash of the refresh token they replace.
+const oauthKeyPrefix = "oauth2/"
+
+// OAuth2Credentials are the credentials of an OAuth2 application the client
+// gets its access tokens for the gitea API with, instead of a token.
+type OAuth2Credentials struct {
+	ClientID     string
+	ClientSecret string
+	// RefreshToken is the refresh token of the first authorization of the
+	// application by the service account. Gitea rotates it, the refresh
+	// token replacing it is kept in the store (see SetStore) so a restart
+	// goes on with it. Without it the client credentials grant is used,
+	// which only some providers support.
+	RefreshToken string
+	// TokenURL is the token endpoint, defaults to that of gitea
+	// (/login/oauth/access_token).
+	TokenURL string
+}
+
+// SetOAuth2 authenticates the requests of the client to gitea with access
+// tokens of the OAuth2 application of creds, got and refreshed as they're
+// needed, instead of a token. NewClient gets no token then. It comes before
+// the options wrapping the transport that look at the token of the requests
+// (SetSudoOwners).
+func SetOAuth2(creds OAuth2Credentials) ClientOption {
+	return func(c *Client) error {
+		if creds.ClientID == "" || creds.ClientSecret == "" {
+			return errors.New("oauth2 needs a client id and secret")
+		}
+
+		if c.token != "" {
+			return errors.New("oauth2 replaces the token, the client can't have both")
+		}
+
+		if creds.TokenURL == "" {
+			creds.TokenURL = strings.TrimSuffix(c.serverURL, "/") + "/login/oauth/access_token"
+		}
+
+		// the requests of the client are sent with a placeholder token
+		// that's swapped for the access token, the requests of visitors
+		// keep theirs
+		placeholder := make([]byte, 16)
+		rand.Read(placeholder)
+
+		c.token = "oauth2-" + hex.EncodeToString(placeholder)
+
+		c.httpClient.Transport = &oauthTransport{
+			auth: "token " + c.token,
+			tokens: &oauthTokens{
+				c:          c,
+				creds:      creds,
+				refresh:    creds.RefreshToken,
+				httpClient: &http.Client{Timeout: oauthTokenTimeout},
+			},
+			next: c.httpClient.Transport,
+		}
+
+		return nil
+	}
+}
+
+// oauthTransport is a http.RoundTripper sending the requests of the client
+// with an OAuth2 access token.
+type oauthTransport struct {
+	auth   string
+	tokens *oauthTokens
+	next   http.RoundTripper
+}
+
+func (t *oauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != t.auth {
+		return t.next.RoundTrip(req)
+	}
+
+	access, err := t.tokens.get()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+access)
+
+	resp, err := t.next.RoundTrip(req)
+
+	// the access token was revoked, the next request gets another one
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.tokens.expire(access)
+	}
+
+	return resp, err
+}
+
+// oauthTokens gets and refreshes the access tokens of the client.
+type oauthTokens struct {
+	c          *Client
+	creds      OAuth2Credentials
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	access  string
+	expires time.Time
+	// refresh is the current refresh token, loaded is set once the one
+	// replacing that of the config was looked up in the store
+	refresh string
+	loaded  bool
+}
+
+// get returns an access token that's valid for a while.
+func (ot *oauthTokens) get() (string, error) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+
+	if ot.access != "" && time.Until(ot.expires) > oauthRefreshMargin {
+		return ot.access, nil
+	}
+
+	if !ot.loaded && ot.c.store != nil && ot.creds.RefreshToken != "" {
+		value, err := ot.c.store.Load(ot.storeKey())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("loading the oauth2 refresh token: %w", err)
+		}
+
+		if err == nil {
+			ot.refresh = string(value)
+		}
+	}
+
+	ot.loaded = true
+
+	form := url.Values{
+		"client_id":     {ot.creds.ClientID},
+		"client_secret": {ot.creds.ClientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+
+	if ot.refresh != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", ot.refresh)
+	}
+
+	resp, err := ot.httpClient.PostForm(ot.creds.TokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("getting an oauth2 access token: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || resp.StatusCode != http.StatusOK ||
+		token.AccessToken == "" {
+		return "", fmt.Errorf("getting an oauth2 access token: unexpected status code '%d' %s", resp.StatusCode,
+			token.Error)
+	}
+
+	ot.access = token.AccessToken
+	ot.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	if token.ExpiresIn <= 0 {
+		ot.expires = time.Now().Add(time.Hour)
+	}
+
+	if token.RefreshToken != "" && token.RefreshToken != ot.refresh {
+		ot.refresh = token.RefreshToken
+
+		if ot.c.store != nil && ot.creds.RefreshToken != "" {
+			if err := ot.c.store.Store(ot.storeKey(), []byte(ot.refresh)); err != nil {
+				ot.c.logger.Warn("storing the oauth2 refresh token failed", zap.Error(err))
+			}
+		}
+	}
+
+	return ot.access, nil
+}
+
+// expire forgets the access token if it's still access.
+func (ot *oauthTokens) expire(access string) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+
+	if ot.access == access {
+		ot.access = ""
+	}
+}
+
+// storeKey is the key of the refresh token replacing that of the config,
+// a new refresh token in the config starts over.
+func (ot *oauthTokens) storeKey() string {
+	sum := sha256.Sum256([]byte(ot.creds.RefreshToken))
+	return oauthKeyPrefix + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,47 @@
+package gitea
+
+import "strings"
+
+// ownerPolicy restricts the owners that may publish sites.
+type ownerPolicy struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// allowed reports if owner may publish sites, owner names are case insensitive
+// like in gitea.
+func (op *ownerPolicy) allowed(owner string) bool {
+	if op == nil {
+		return true
+	}
+
+	owner = strings.ToLower(owner)
+
+	if op.deny[owner] {
+		return false
+	}
+
+	return len(op.allow) == 0 || op.allow[owner]
+}
+
+func ownerSet(owners []string) map[string]bool {
+	set := make(map[string]bool, len(owners))
+	for _, owner := range owners {
+		set[strings.ToLower(owner)] = true
+	}
+
+	return set
+}
+
+// SetOwners only serves the sites of the allowed owners (all owners if empty)
+// that aren't denied. Sites of other owners don't exist, without asking gitea.
+func SetOwners(allow, deny []string) ClientOption {
+	return func(c *Client) error {
+		c.owners = &ownerPolicy{
+			allow: ownerSet(allow),
+			deny:  ownerSet(deny),
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// Page is a page of a site for headless consumption: its front matter, its
+// rendered html, the last commit that changed it and its contributors.
+type Page struct {
+	Meta map[string]any `json:"meta"`
+	// Body is the rendered html, without the front matter.
+	Body string `json:"body"`
+	// Commit is nil if gitea didn't tell.
+	Commit *Commit `json:"commit,omitempty"`
+	// EditURL is the page on gitea to edit the page.
+	EditURL      string        `json:"edit_url,omitempty"`
+	Contributors []Contributor `json:"contributors,omitempty"`
+}
+
+// Page returns the markdown or html page name of the site at ref for the
+// visitor v like OpenVisitor, but as its front matter and rendered body
+// instead of the page. It returns fs.ErrNotExist for other files.
+func (c *Client) Page(ctx context.Context, name, ref string, v *Visitor) (*Page, error) {
+	s, err := c.visit(ctx, name, ref, v.Header, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.embargo(s.filepath, v); err != nil {
+		return nil, err
+	}
+
+	filepath, err := c.prettyPath(s, s.filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		if aerr := c.aliasRedirect(s); aerr != nil {
+			return nil, aerr
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.embargo(filepath, v); err != nil {
+		return nil, err
+	}
+
+	if !isPage(filepath) {
+		return nil, fs.ErrNotExist
+	}
+
+	raw, err := c.fetchRaw(s.ctx, s.owner, s.repo, filepath, s.ref, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, body, err := extractFrontMatter(string(raw.content))
+	if err != nil {
+		meta, body = nil, string(raw.content)
+	}
+
+	if err := pageRedirect(s, meta); err != nil {
+		return nil, err
+	}
+
+	if isDraft(meta) && !s.preview {
+		return nil, fs.ErrNotExist
+	}
+
+	if meta == nil {
+		meta = map[string]any{}
+	}
+
+	if isMarkdown(filepath) {
+		rendered, err := markdown([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+
+		body = string(rendered)
+	}
+
+	p := &Page{
+		Meta: meta,
+		Body: string(sanitizePage([]byte(body), s.sanitize)),
+	}
+
+	// the page is still served without its history
+	info := c.gitInfo(s, filepath)
+	p.Commit, p.EditURL, p.Contributors = info.Commit, info.EditURL, info.Contributors
+
+	c.inventory.served(s.owner, s.repo, s.ref, filepath, len(p.Body))
+
+	return p, nil
+}
@@ -0,0 +1,184 @@
+package gitea
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxFrontMatterPages is the number of pages read for their front matter
+	// per tree (for aliases, blog listings).
+	maxFrontMatterPages = 1000
+	// maxFrontMatterPageSize is the size above which pages aren't read for
+	// their front matter.
+	maxFrontMatterPageSize = 1 << 20
+	// frontMatterFetches is the number of pages fetched at once for their
+	// front matter.
+	frontMatterFetches = 8
+)
+
+// isPage reports if name is a markdown or html page, which may have front matter.
+func isPage(name string) bool {
+	switch path.Ext(name) {
+	case ".html", ".htm":
+		return true
+	}
+
+	return isMarkdown(name)
+}
+
+// pageMeta returns the front matter of the page name, or nil if it isn't a
+// page or has no (valid) front matter.
+func pageMeta(name string, content []byte) map[string]any {
+	if !isPage(name) {
+		return nil
+	}
+
+	meta, _, err := extractFrontMatter(string(content))
+	if err != nil {
+		return nil
+	}
+
+	return meta
+}
+
+// pageRedirect returns a *RedirectError if the front matter meta of a page
+// of the site s declares where it moved with redirect_to, with
+// redirect_status (301 by default, or one of the statuses of the redirect
+// rules).
+func pageRedirect(s *site, meta map[string]any) error {
+	to, _ := meta["redirect_to"].(string)
+	if to = strings.TrimSpace(to); to == "" {
+		return nil
+	}
+
+	status := http.StatusMovedPermanently
+
+	// the front matter formats decode numbers differently
+	switch v := meta["redirect_status"].(type) {
+	case int:
+		status = v
+	case int64:
+		status = int(v)
+	case float64:
+		status = int(v)
+	}
+
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		status = http.StatusMovedPermanently
+	}
+
+	return &RedirectError{Path: "/" + s.filepath, To: to, Status: status}
+}
+
+// isDraft reports if the front matter meta marks the page as draft, drafts
+// are only served on pull request previews.
+func isDraft(meta map[string]any) bool {
+	draft, _ := meta["draft"].(bool)
+	return draft
+}
+
+// frontMatterStrings returns v, a string or list of strings of front
+// matter, as list.
+func frontMatterStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var res []string
+
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				res = append(res, s)
+			}
+		}
+
+		return res
+	}
+
+	return nil
+}
+
+// readPages calls fn with the front matter and content of the pages of the
+// site s in the tree t below dir ("" for all pages), one at a time. Drafts
+// are skipped unless s is a preview.
+func (c *Client) readPages(s *site, t *siteTree, dir string, fn func(name string, meta map[string]any, content []byte)) {
+	// only the full tree has the paths of the pages, it's not cached
+	if t.filter != nil {
+		var err error
+
+		t, err = c.fetchTree(s.ctx, s.owner, s.repo, s.ref)
+		if err != nil {
+			return
+		}
+	}
+
+	var fsys fs.FS = c.siteFS(s, t)
+
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		var err error
+		if fsys, err = fs.Sub(fsys, dir); err != nil {
+			return
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		pages int
+	)
+
+	g := new(errgroup.Group)
+	g.SetLimit(frontMatterFetches)
+
+	errEnough := errors.New("enough pages")
+
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if info, err := d.Info(); err != nil || !isPage(name) || info.Size() > maxFrontMatterPageSize {
+			return nil
+		}
+
+		if pages++; pages > maxFrontMatterPages {
+			return errEnough
+		}
+
+		name = path.Join(dir, name)
+
+		g.Go(func() error {
+			raw, err := c.fetchRaw(s.ctx, s.owner, s.repo, name, s.ref, nil)
+			if err != nil {
+				return nil
+			}
+
+			meta := pageMeta(name, raw.content)
+			if isDraft(meta) && !s.preview {
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			fn(name, meta, raw.content)
+
+			return nil
+		})
+
+		return nil
+	})
+
+	g.Wait()
+}
@@ -0,0 +1,175 @@
+package gitea
+
+import (
+	"crypto/subtle"
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PeerPath is the path the nodes of a cluster fetch files from each
+	// other on, it mimics the media api of gitea below it.
+	PeerPath = "/.gitea-pages/peer"
+	// peerReplicas is the number of virtual nodes of every peer on the ring,
+	// so the repos are spread evenly.
+	peerReplicas = 128
+	// peerTimeout is how long a peer gets to answer before gitea is asked.
+	peerTimeout = 10 * time.Second
+)
+
+// peerRing consistent-hashes owner/repo to the home node of the repo, the
+// only node of the cluster fetching its files from gitea.
+type peerRing struct {
+	self       string
+	secret     string
+	hashes     []uint64
+	nodes      map[uint64]string
+	httpClient *http.Client
+}
+
+// SetPeers fetches the files of every repo through its home node, picked
+// from the peers (base urls, e.g. https://pages-2.internal:8443) by
+// consistent hashing of owner/repo, so each file is fetched from gitea once
+// per cluster instead of once per node. self is the base url of this node
+// as listed in peers, secret authenticates the nodes to each other. The
+// nodes serve each other on PeerPath, see ServePeer; gitea is asked if the
+// home node of a repo fails.
+func SetPeers(self string, peers []string, secret string) ClientOption {
+	return func(c *Client) error {
+		if secret == "" {
+			return errors.New("peers: a secret is required")
+		}
+
+		r := &peerRing{
+			self:       strings.TrimSuffix(self, "/"),
+			secret:     secret,
+			nodes:      make(map[uint64]string),
+			httpClient: &http.Client{Timeout: peerTimeout},
+		}
+
+		found := false
+
+		for _, p := range peers {
+			p = strings.TrimSuffix(p, "/")
+			found = found || p == r.self
+
+			for i := 0; i < peerReplicas; i++ {
+				h := peerHash(p + "#" + strconv.Itoa(i))
+				r.hashes = append(r.hashes, h)
+				r.nodes[h] = p
+			}
+		}
+
+		if !found {
+			return errors.New("peers: self must be one of the peers")
+		}
+
+		sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+		c.peers = r
+
+		return nil
+	}
+}
+
+func peerHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	return h.Sum64()
+}
+
+// home returns the base url of the home node of owner/repo, or "" if it's
+// this node or there are no peers.
+func (r *peerRing) home(owner, repo string) string {
+	if r == nil || len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := peerHash(owner + "/" + repo)
+
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	if node := r.nodes[r.hashes[i]]; node != r.self {
+		return node
+	}
+
+	return ""
+}
+
+// ServePeer serves the files of the repos this node is the home node of to
+// the other nodes of the cluster, on PeerPath/api/v1/repos/<owner>/<repo>/media/<path>?ref=<ref>.
+// The files are fetched from gitea (or the cache) like for any visitor.
+// Purges of the other nodes are posted to PeerPath/purge.
+func (c *Client) ServePeer(w http.ResponseWriter, r *http.Request) {
+	if c.peers == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "peer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(c.peers.secret)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == PeerPath+"/purge" {
+		c.servePeerPurge(w, r)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, PeerPath+"/api/v1/repos/"), "/", 4)
+	if len(parts) != 4 || parts[2] != "media" {
+		http.NotFound(w, r)
+		return
+	}
+
+	owner, repo, filepath, ref := parts[0], parts[1], parts[3], r.URL.Query().Get("ref")
+	if !validName(owner, repo, filepath, ref) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := c.fetchRawVia(r.Context(), owner, repo, filepath, ref, r.Header, "")
+
+	var nm *NotModifiedError
+
+	switch {
+	case errors.As(err, &nm):
+		for k, v := range nm.Header {
+			w.Header()[k] = v
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrSiteLimit):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if f.etag != "" {
+		w.Header().Set("ETag", f.etag)
+	}
+
+	if !f.modTime.IsZero() {
+		w.Header().Set("Last-Modified", f.modTime.UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(f.content)))
+	w.Write(f.content)
+}
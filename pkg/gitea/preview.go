@@ -0,0 +1,121 @@
+package gitea
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	gclient "code.gitea.io/sdk/gitea"
+)
+
+const (
+	// previewRefPrefix is the prefix of the refs serving pull request previews (pr-<index>).
+	previewRefPrefix = "pr-"
+	// previewCommentMarker marks the preview comment posted on a pull request.
+	previewCommentMarker = "<!-- gitea-pages-preview -->"
+
+	// EventPreviewAvailable is emitted when (a new version of) a pull request
+	// preview is served.
+	EventPreviewAvailable = "preview_available"
+)
+
+// previews remembers the head sha of the served pull request previews.
+type previews struct {
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+// SetPRPreviews serves the head of pull requests on the ref pr-<index> for
+// repos with the allowall topic or prpreviews enabled in their config.
+func SetPRPreviews() ClientOption {
+	return func(c *Client) error {
+		c.previews = &previews{seen: make(map[string]string)}
+		return nil
+	}
+}
+
+// previewIndex returns the pull request index of a pr-<index> ref.
+func (c *Client) previewIndex(ref string) (int64, bool) {
+	if c.previews == nil || !strings.HasPrefix(ref, previewRefPrefix) {
+		return 0, false
+	}
+
+	index, err := strconv.ParseInt(strings.TrimPrefix(ref, previewRefPrefix), 10, 64)
+	if err != nil || index <= 0 {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// previewSHA returns the head sha of pull request index of owner/repo.
+func (c *Client) previewSHA(ctx context.Context, owner, repo string, index int64) (string, error) {
+	pr, resp, err := c.api(ctx).GetPullRequest(owner, repo, index)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", fs.ErrNotExist
+		}
+
+		return "", err
+	}
+
+	if pr.Head == nil || pr.Head.Sha == "" || pr.State != gclient.StateOpen {
+		return "", fs.ErrNotExist
+	}
+
+	key := owner + "/" + repo + "#" + strconv.FormatInt(index, 10)
+
+	c.previews.mu.Lock()
+	changed := c.previews.seen[key] != pr.Head.Sha
+	c.previews.seen[key] = pr.Head.Sha
+	c.previews.mu.Unlock()
+
+	if changed {
+		c.Emit(EventPreviewAvailable, map[string]any{
+			"owner": owner,
+			"repo":  repo,
+			"ref":   previewRefPrefix + strconv.FormatInt(index, 10),
+			"index": index,
+			"sha":   pr.Head.Sha,
+		})
+	}
+
+	return pr.Head.Sha, nil
+}
+
+// CommentPreview posts a comment with the preview url on pull request index
+// of owner/repo, or updates the comment if it was posted before.
+func (c *Client) CommentPreview(owner, repo string, index int64, previewURL, sha string) error {
+	if c.scopes != nil && !c.scopes.Issues {
+		return ErrReadOnlyToken
+	}
+
+	body := previewCommentMarker + "\nPreview of " + sha + " is available at " + previewURL
+
+	for page := 1; ; page++ {
+		comments, _, err := c.gc.ListIssueComments(owner, repo, index, gclient.ListIssueCommentOptions{
+			ListOptions: gclient.ListOptions{Page: page, PageSize: 50},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, comment := range comments {
+			if strings.HasPrefix(comment.Body, previewCommentMarker) {
+				_, _, err := c.gc.EditIssueComment(owner, repo, comment.ID, gclient.EditIssueCommentOption{Body: body})
+				return err
+			}
+		}
+
+		if len(comments) < 50 {
+			break
+		}
+	}
+
+	_, _, err := c.gc.CreateIssueComment(owner, repo, index, gclient.CreateIssueCommentOption{Body: body})
+
+	return err
+}
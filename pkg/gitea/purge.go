@@ -0,0 +1,341 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The reasons of the purges issued by the client and the handlers using it.
+const (
+	PurgeReasonWebhook = "webhook"
+	PurgeReasonAdmin   = "admin"
+	PurgeReasonRefresh = "refresh"
+)
+
+// purgeLogTTL is how long the ids of applied purges are remembered, so the
+// same purge arriving again (retried, or fanned out by several nodes) is
+// applied once.
+const purgeLogTTL = 10 * time.Minute
+
+// Purge is a request to forget the cached content of a site. The webhook,
+// the admin API and scheduled refreshes issue purges, which are applied to
+// every cache of the node and fanned out to its peers.
+type Purge struct {
+	// ID is the idempotency key of the purge, a purge is applied once per
+	// node. A random id is generated if it's empty.
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+	// Ref limits the purge of Paths to a ref, the default version of the
+	// site if empty. Purges without paths purge all refs.
+	Ref string `json:"ref,omitempty"`
+	// Paths limits the purge to these files (and the files below them) of
+	// the site, the whole repo is purged if there are none.
+	Paths []string `json:"paths,omitempty"`
+	// Config limits the purge to the config of the repo (gitea-pages.toml)
+	// and what's derived from it, it's read and applied again while the
+	// cached content is kept. Ref and Paths are ignored.
+	Config bool `json:"config,omitempty"`
+	// Reason is why the content is purged, e.g. webhook, admin or refresh.
+	Reason string `json:"reason,omitempty"`
+	// Issued is when the purge was issued, for the purge latency metric.
+	Issued time.Time `json:"issued"`
+}
+
+// NewPurge returns a purge of the whole repo owner/repo with a random id,
+// issued now.
+func NewPurge(owner, repo, reason string) Purge {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return Purge{
+		ID:     hex.EncodeToString(id),
+		Owner:  owner,
+		Repo:   repo,
+		Reason: reason,
+		Issued: time.Now(),
+	}
+}
+
+// purgeLog are the ids of the purges applied recently.
+type purgeLog struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add records id and reports if it wasn't seen before.
+func (pl *purgeLog) add(id string) bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	now := time.Now()
+
+	for k, expires := range pl.seen {
+		if now.After(expires) {
+			delete(pl.seen, k)
+		}
+	}
+
+	if _, ok := pl.seen[id]; ok {
+		return false
+	}
+
+	pl.seen[id] = now.Add(purgeLogTTL)
+
+	return true
+}
+
+// Purge applies p to all caches of this node and fans it out to the peers.
+// It reports false if a purge with the same id was already applied.
+func (c *Client) Purge(p Purge) bool {
+	if p.ID == "" || p.Issued.IsZero() {
+		np := NewPurge(p.Owner, p.Repo, p.Reason)
+
+		if p.ID == "" {
+			p.ID = np.ID
+		}
+
+		if p.Issued.IsZero() {
+			p.Issued = np.Issued
+		}
+	}
+
+	if !c.applyPurge(p, "local") {
+		return false
+	}
+
+	if c.peers != nil {
+		go c.fanOutPurge(p)
+	}
+
+	return true
+}
+
+// applyPurge applies p to all caches of this node, once per id.
+func (c *Client) applyPurge(p Purge, origin string) bool {
+	if !c.purges.add(p.ID) {
+		purgeMetrics.purges.WithLabelValues(purgeReasonLabel(p.Reason), "duplicate").Inc()
+		return false
+	}
+
+	switch {
+	case p.Config:
+		c.purgeConfig(p.Owner, p.Repo)
+	case len(p.Paths) == 0:
+		c.purgeRepo(p.Owner, p.Repo)
+	default:
+		if err := c.purgePaths(p.Owner, p.Repo, p.Ref, p.Paths); err != nil {
+			// the ref can't be resolved, the whole repo is purged to be safe
+			c.logger.Warn("purging paths failed, purging repo", zap.String("owner", p.Owner),
+				zap.String("repo", p.Repo), zap.Error(err))
+			c.purgeRepo(p.Owner, p.Repo)
+		}
+	}
+
+	purgeMetrics.purges.WithLabelValues(purgeReasonLabel(p.Reason), "applied").Inc()
+	purgeMetrics.latency.WithLabelValues(origin).Observe(time.Since(p.Issued).Seconds())
+
+	data := map[string]any{
+		"cache":  "repo",
+		"owner":  p.Owner,
+		"repo":   p.Repo,
+		"id":     p.ID,
+		"reason": p.Reason,
+	}
+
+	if p.Config {
+		data["cache"] = "config"
+	} else if len(p.Paths) > 0 {
+		data["ref"] = p.Ref
+		data["paths"] = p.Paths
+	}
+
+	c.Emit(EventCachePurged, data)
+
+	return true
+}
+
+// purgeReasonLabel returns the metric label of reason, reasons given by
+// callers are counted as other.
+func purgeReasonLabel(reason string) string {
+	switch reason {
+	case PurgeReasonWebhook, PurgeReasonAdmin, PurgeReasonRefresh:
+		return reason
+	}
+
+	return "other"
+}
+
+// purgeRepo forgets everything cached of owner/repo, see InvalidateRepo.
+func (c *Client) purgeRepo(owner, repo string) {
+	if c.cache != nil {
+		// the keys have the case of the requests, hosts are lowercase; the
+		// content of the files goes with them, only MemoryCache counts the
+		// references of the blobs
+		for _, kind := range []string{"/file/", "/blob/"} {
+			c.cache.DeletePrefix(owner + "/" + repo + kind)
+
+			if lower := strings.ToLower(owner + "/" + repo); lower != owner+"/"+repo {
+				c.cache.DeletePrefix(lower + kind)
+			}
+		}
+	}
+
+	c.trees.expire(owner, repo)
+	c.lookups.purge(owner, repo)
+	c.notFound.forget(owner, repo)
+	c.domains.forget(owner, repo)
+	c.access.forget(owner, repo)
+	c.configs.forget(owner, repo)
+}
+
+// purgeConfig forgets the config of owner/repo and what's derived from it
+// (custom domains, access, refs not found), keeping its cached content. The
+// trees are expired too, an archive snapshot has the old config.
+func (c *Client) purgeConfig(owner, repo string) {
+	if c.cache != nil {
+		names := c.names(owner)
+		file := names.pages + ".toml"
+
+		c.cache.DeletePrefix(fileCacheKey(owner, repo, file, names.pages))
+
+		if lower := strings.ToLower(owner + "/" + repo); lower != owner+"/"+repo {
+			c.cache.DeletePrefix(fileCacheKey(strings.ToLower(owner), strings.ToLower(repo), file, names.pages))
+		}
+	}
+
+	c.trees.expire(owner, repo)
+	c.notFound.forget(owner, repo)
+	c.domains.forget(owner, repo)
+	c.access.forget(owner, repo)
+	c.configs.forget(owner, repo)
+}
+
+// ConfigOnly reports if a push to branch of owner/repo changing paths only
+// changed the config of the repo, so a Purge of its Config is enough.
+func (c *Client) ConfigOnly(owner, branch string, paths []string) bool {
+	names := c.names(owner)
+	if branch != names.pages || len(paths) == 0 {
+		return false
+	}
+
+	for _, p := range paths {
+		if strings.TrimPrefix(p, "/") != names.pages+".toml" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// purgePaths forgets the cached files below paths of owner/repo at ref, and
+// its trees so the archive of the next version is loaded.
+func (c *Client) purgePaths(owner, repo, ref string, paths []string) error {
+	s, err := c.resolve(context.Background(), owner+"/"+repo, ref)
+	if err != nil {
+		return err
+	}
+
+	if c.cache != nil {
+		for _, p := range paths {
+			p = strings.TrimPrefix(p, "/")
+
+			c.cache.DeletePrefix(fileCacheKey(s.owner, s.repo, p, s.ref))
+
+			if lower := strings.ToLower(s.owner + "/" + s.repo); lower != s.owner+"/"+s.repo {
+				c.cache.DeletePrefix(fileCacheKey(strings.ToLower(s.owner), strings.ToLower(s.repo), p, s.ref))
+			}
+		}
+	}
+
+	c.trees.expire(s.owner, s.repo)
+
+	return nil
+}
+
+// fanOutPurge sends p to the other nodes of the cluster.
+func (c *Client) fanOutPurge(p Purge) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+
+	for _, node := range c.peers.others() {
+		req, err := http.NewRequest(http.MethodPost, node+PeerPath+"/purge", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+
+		req.Header.Set("Authorization", "peer "+c.peers.secret)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.peers.httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNoContent {
+				err = fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+			}
+		}
+
+		if err != nil {
+			purgeMetrics.fanOut.WithLabelValues("error").Inc()
+			c.logger.Warn("sending purge to peer failed", zap.String("peer", node),
+				zap.String("id", p.ID), zap.Error(err))
+
+			continue
+		}
+
+		purgeMetrics.fanOut.WithLabelValues("ok").Inc()
+	}
+}
+
+// servePeerPurge applies a purge sent by another node of the cluster.
+func (c *Client) servePeerPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	var p Purge
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&p); err != nil ||
+		p.ID == "" || !validName(p.Owner, p.Repo, "", p.Ref) {
+		http.Error(w, "invalid purge", http.StatusBadRequest)
+		return
+	}
+
+	c.applyPurge(p, "peer")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// others returns the base urls of the other nodes of the cluster.
+func (r *peerRing) others() []string {
+	seen := make(map[string]bool)
+
+	var nodes []string
+
+	for _, node := range r.nodes {
+		if node != r.self && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Strings(nodes)
+
+	return nodes
+}
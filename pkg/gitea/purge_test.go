@@ -0,0 +1,63 @@
+package gitea
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestPurgeRepoBlobs(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:    "user",
+		Name:     "gitea-pages",
+		Topics:   []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {"index.html": "<p>home</p>"}},
+	})
+
+	cache := NewMemoryCache(1 << 20)
+
+	c, err := NewClient(srv.URL, "token", "", "", SetCache(cache, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := c.Open("user/gitea-pages/index.html", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = io.Copy(io.Discard, f)
+	f.Close()
+
+	kinds := func() map[string]bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		kinds := make(map[string]bool)
+
+		for key := range cache.entries {
+			if strings.HasPrefix(key, "user/gitea-pages/") {
+				kind, _, _ := strings.Cut(strings.TrimPrefix(key, "user/gitea-pages/"), "/")
+				kinds[kind] = true
+			}
+		}
+
+		return kinds
+	}
+
+	if k := kinds(); !k["file"] || !k["blob"] {
+		t.Fatalf("cached %v, want file and blob", k)
+	}
+
+	c.Purge(NewPurge("user", "gitea-pages", PurgeReasonAdmin))
+
+	if k := kinds(); len(k) > 0 {
+		t.Errorf("cached %v after purge", k)
+	}
+}
@@ -0,0 +1,155 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUpstreamBusy is returned when a request to gitea is shed because the
+// upstream rate limit queue is full or the wait would be too long.
+var ErrUpstreamBusy = errors.New("gitea upstream rate limit exceeded")
+
+// defaultUpstreamQueue is the number of requests that wait for a token when
+// no queue is set.
+const defaultUpstreamQueue = 100
+
+// limiter is a token bucket that allows rate requests per second with
+// bursts of up to burst requests. At most queue requests (defaultUpstreamQueue
+// if not set) wait for a token and none longer than maxWait.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting int
+	queue   int
+	maxWait time.Duration
+}
+
+func newLimiter(rate float64, burst, queue int, maxWait time.Duration) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	if queue < 1 {
+		queue = defaultUpstreamQueue
+	}
+
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		queue:   queue,
+		maxWait: maxWait,
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (l *limiter) reserve() (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, nil
+	}
+
+	wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if l.waiting >= l.queue || (l.maxWait > 0 && wait > l.maxWait) {
+		return 0, ErrUpstreamBusy
+	}
+
+	l.tokens--
+	l.waiting++
+
+	return wait, nil
+}
+
+func (l *limiter) done() {
+	l.mu.Lock()
+	l.waiting--
+	l.mu.Unlock()
+}
+
+// cancel gives back the token of a caller that stopped waiting for it, so
+// aborted requests don't use up the budget.
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.waiting--
+
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// limitedTransport is a http.RoundTripper that rate limits the requests to
+// gitea.
+type limitedTransport struct {
+	limiter *limiter
+	next    http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait, err := t.limiter.reserve()
+	if err != nil {
+		upstreamLimitMetrics.requests.WithLabelValues("shed").Inc()
+		return nil, err
+	}
+
+	if wait > 0 {
+		upstreamLimitMetrics.requests.WithLabelValues("queued").Inc()
+		upstreamLimitMetrics.wait.Observe(wait.Seconds())
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+			t.limiter.done()
+		case <-req.Context().Done():
+			timer.Stop()
+			t.limiter.cancel()
+
+			return nil, req.Context().Err()
+		}
+	} else {
+		upstreamLimitMetrics.requests.WithLabelValues("allowed").Inc()
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// SetUpstreamLimit limits the requests to gitea to qps requests per second
+// with bursts of burst requests. At most queue requests (100 if 0) wait for
+// their turn and not longer than maxWait (0 waits as long as needed), all
+// others fail with ErrUpstreamBusy.
+func SetUpstreamLimit(qps float64, burst, queue int, maxWait time.Duration) ClientOption {
+	return func(c *Client) error {
+		if qps <= 0 {
+			return errors.New("upstream qps must be positive")
+		}
+
+		c.httpClient.Transport = &limitedTransport{
+			limiter: newLimiter(qps, burst, queue, maxWait),
+			next:    c.httpClient.Transport,
+		}
+
+		return nil
+	}
+}
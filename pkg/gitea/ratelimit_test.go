@@ -0,0 +1,92 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLimiterQueuesByDefault(t *testing.T) {
+	l := newLimiter(10, 1, 0, 0)
+
+	if _, err := l.reserve(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the burst is used, the next requests wait in the default queue
+	for i := 0; i < 5; i++ {
+		wait, err := l.reserve()
+		if err != nil {
+			t.Fatalf("request %d shed: %v", i, err)
+		}
+
+		if wait <= 0 {
+			t.Fatalf("request %d didn't wait", i)
+		}
+	}
+}
+
+func TestLimiterQueueFull(t *testing.T) {
+	l := newLimiter(1, 1, 2, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.reserve(); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	if _, err := l.reserve(); !errors.Is(err, ErrUpstreamBusy) {
+		t.Errorf("request over the queue: %v, want ErrUpstreamBusy", err)
+	}
+}
+
+func TestLimitedTransportCancel(t *testing.T) {
+	l := newLimiter(1, 1, 10, 0)
+	tr := &limitedTransport{limiter: l, next: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+
+	if _, err := tr.RoundTrip(limitedRequest(context.Background())); err != nil {
+		t.Fatal(err)
+	}
+
+	// clients giving up while waiting give their tokens back
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+
+		_, err := tr.RoundTrip(limitedRequest(ctx))
+
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("request %d: %v, want deadline exceeded", i, err)
+		}
+	}
+
+	wait, err := l.reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait > time.Second {
+		t.Errorf("wait %v after cancelled requests, want at most a second", wait)
+	}
+
+	if l.waiting != 1 {
+		t.Errorf("%d waiting, want 1", l.waiting)
+	}
+}
+
+// limitedRequest returns a request to gitea with ctx.
+func limitedRequest(ctx context.Context) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://gitea.invalid/api/v1/version", nil)
+	return req
+}
@@ -0,0 +1,332 @@
+package gitea
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// redirectsFile is the file in the root of a site with its redirect rules.
+const redirectsFile = "_redirects"
+
+// maxRedirectRules is the number of rules read from a redirects file.
+const maxRedirectRules = 1000
+
+// Visitor describes who requests a file, for the conditions of redirect rules.
+type Visitor struct {
+	// Header are the request headers (conditional headers, cookies, Accept-Language).
+	Header http.Header
+	// Country is the ISO 3166 country code of the visitor, if known.
+	Country string
+	// Bucket is the A/B testing bucket (0-99) of the visitor.
+	Bucket int
+}
+
+// RedirectError is returned when a redirect rule of the site applies.
+type RedirectError struct {
+	// Path is the path of the request in the site.
+	Path string
+	// To is the url or the path in the site to redirect to.
+	To string
+	// Status is the HTTP status of the redirect.
+	Status int
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect %s to %s (%d)", e.Path, e.To, e.Status)
+}
+
+// redirectRule is a rule of a redirects file, in the netlify format:
+//
+//	/from /to [status][!] [Condition=value,value ...]
+type redirectRule struct {
+	from       []string
+	splat      bool
+	to         string
+	status     int
+	force      bool
+	conditions map[string][]string
+}
+
+// parseRedirects parses a redirects file, invalid rules are skipped.
+func parseRedirects(content []byte) ([]redirectRule, []error) {
+	var (
+		rules []redirectRule
+		errs  []error
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for line := 1; scanner.Scan() && len(rules) < maxRedirectRules; line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := parseRedirectRule(strings.Fields(text))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, errs
+}
+
+func parseRedirectRule(fields []string) (redirectRule, error) {
+	if len(fields) < 2 {
+		return redirectRule{}, fmt.Errorf("missing target")
+	}
+
+	rule := redirectRule{
+		to:         fields[1],
+		status:     http.StatusMovedPermanently,
+		conditions: make(map[string][]string),
+	}
+
+	if !strings.HasPrefix(fields[0], "/") {
+		return rule, fmt.Errorf("path %q doesn't start with /", fields[0])
+	}
+
+	// request paths are matched unescaped
+	rule.from = splitPath(unescapePath(fields[0]))
+	if n := len(rule.from); n > 0 && rule.from[n-1] == "*" {
+		rule.from = rule.from[:n-1]
+		rule.splat = true
+	}
+
+	rest := fields[2:]
+
+	if len(rest) > 0 {
+		if status, err := strconv.Atoi(strings.TrimSuffix(rest[0], "!")); err == nil {
+			rule.status = status
+			rule.force = strings.HasSuffix(rest[0], "!")
+			rest = rest[1:]
+		}
+	}
+
+	switch rule.status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	case http.StatusOK:
+		// rewrites serve another file of the site
+		if !strings.HasPrefix(rule.to, "/") {
+			return rule, fmt.Errorf("rewrite target %q isn't a path", rule.to)
+		}
+	default:
+		return rule, fmt.Errorf("unsupported status %d", rule.status)
+	}
+
+	for _, cond := range rest {
+		key, values, ok := strings.Cut(cond, "=")
+		if !ok {
+			return rule, fmt.Errorf("invalid condition %q", cond)
+		}
+
+		key = strings.ToLower(key)
+
+		switch key {
+		case "language", "country", "bucket":
+		default:
+			return rule, fmt.Errorf("unknown condition %q", key)
+		}
+
+		rule.conditions[key] = strings.Split(values, ",")
+	}
+
+	return rule, nil
+}
+
+// splitPath splits an url path into its segments, ignoring a trailing slash.
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+// match returns the target of the rule for the path, with its placeholders
+// (:name and :splat) replaced.
+func (rule *redirectRule) match(p string) (string, bool) {
+	segments := splitPath(p)
+
+	if len(segments) < len(rule.from) || !rule.splat && len(segments) != len(rule.from) {
+		return "", false
+	}
+
+	params := map[string]string{}
+
+	for i, seg := range rule.from {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			params[seg] = url.PathEscape(segments[i])
+		case seg != segments[i]:
+			return "", false
+		}
+	}
+
+	if rule.splat {
+		params[":splat"] = escapePath(strings.Join(segments[len(rule.from):], "/"))
+	}
+
+	// replace the longest placeholders first, so :slug doesn't replace part of :slugs
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	to := rule.to
+	for _, name := range names {
+		to = strings.ReplaceAll(to, name, params[name])
+	}
+
+	return to, true
+}
+
+// matches reports if v meets all conditions of the rule.
+func (rule *redirectRule) matches(v *Visitor) bool {
+	for key, values := range rule.conditions {
+		var ok bool
+
+		switch key {
+		case "language":
+			ok = matchLanguage(v.Header.Get("Accept-Language"), values)
+		case "country":
+			ok = v.Country != "" && containsFold(values, v.Country)
+		case "bucket":
+			ok = matchBucket(v.Bucket, values)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchLanguage reports if one of the languages of an Accept-Language header
+// is one of languages, "de" also matches "de-AT".
+func matchLanguage(header string, languages []string) bool {
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+
+		primary, _, _ := strings.Cut(tag, "-")
+
+		if containsFold(languages, tag) || containsFold(languages, primary) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchBucket reports if bucket is in one of the buckets ("7") or bucket
+// ranges ("0-49").
+func matchBucket(bucket int, buckets []string) bool {
+	for _, b := range buckets {
+		lo, hi, isRange := strings.Cut(b, "-")
+		if !isRange {
+			hi = lo
+		}
+
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+
+		if err1 == nil && err2 == nil && bucket >= from && bucket <= to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirectRules returns the redirect rules of the site, read once per tree.
+func (c *Client) redirectRules(s *site, t *siteTree) []redirectRule {
+	t.redirectsOnce.Do(func() {
+		if exists, known := t.exists(redirectsFile); !exists && known {
+			return
+		}
+
+		ctx, cancel := c.detached()
+		defer cancel()
+
+		raw, err := c.fetchRaw(ctx, s.owner, s.repo, redirectsFile, s.ref, nil)
+		if err != nil {
+			return
+		}
+
+		var errs []error
+
+		t.redirects, errs = parseRedirects(raw.content)
+		for _, err := range errs {
+			c.logger.Warn("invalid redirect rule", zap.String("owner", s.owner), zap.String("repo", s.repo),
+				zap.Error(err))
+		}
+	})
+
+	return t.redirects
+}
+
+// redirect returns a *RedirectError if a redirect rule of the site applies
+// to the request of v, a rewrite (status 200) changes the file served to the
+// target instead. Rules don't apply to existing files, unless forced.
+func (c *Client) redirect(s *site, v *Visitor) error {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		// without tree there's no way to tell if a file exists
+		return nil
+	}
+
+	p := "/" + s.filepath
+
+	for _, rule := range c.redirectRules(s, t) {
+		to, ok := rule.match(p)
+		if !ok || !rule.matches(v) {
+			continue
+		}
+
+		if !rule.force {
+			if _, err := c.prettyPath(s, s.filepath); err == nil {
+				continue
+			}
+		}
+
+		if rule.status == http.StatusOK {
+			to, _, _ = strings.Cut(to, "?")
+			s.filepath = strings.TrimPrefix(unescapePath(to), "/")
+
+			return nil
+		}
+
+		return &RedirectError{Path: p, To: to, Status: rule.status}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// symbolicRefs name the default version of a site.
+var symbolicRefs = map[string]bool{
+	"HEAD":    true,
+	"default": true,
+}
+
+// minShortSHA is the length of the shortest abbreviated commit sha resolved,
+// the length git abbreviates to by default.
+const minShortSHA = 7
+
+// maxShortSHAs is the number of abbreviated shas remembered, they're all
+// forgotten once there are more.
+const maxShortSHAs = 10000
+
+// shaCache maps the abbreviated commit shas of repos (owner/repo@sha) to
+// their full sha, which doesn't change.
+type shaCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func (sc *shaCache) get(key string) (string, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sha, ok := sc.entries[key]
+
+	return sha, ok
+}
+
+func (sc *shaCache) set(key, sha string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if len(sc.entries) >= maxShortSHAs {
+		sc.entries = make(map[string]string)
+	}
+
+	sc.entries[key] = sha
+}
+
+// IsSymbolicRef reports if ref names the default version of a site, unless
+// the repo has a branch named like it.
+func IsSymbolicRef(ref string) bool {
+	return symbolicRefs[ref]
+}
+
+// isShortSHA reports if ref can be an abbreviated commit sha.
+func isShortSHA(ref string) bool {
+	if len(ref) < minShortSHA || len(ref) >= 40 {
+		return false
+	}
+
+	for _, r := range ref {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CanonicalRef returns the canonical form of ref for the site of name
+// (owner/repo/filepath): "" (the default version) for the symbolic refs
+// HEAD and default, the full sha of an abbreviated commit sha and ref itself
+// for all others, so the versions of a site are cached once. Branches named
+// like them are kept. Abbreviated shas gitea doesn't know are kept too, they
+// aren't served, and so are those of sites that don't serve them: only what
+// the site would serve is looked up. Visitors must be authorized for the site
+// first, the full sha is only known to those who may read the repo.
+func (c *Client) CanonicalRef(ctx context.Context, name, ref string) (string, error) {
+	if !symbolicRefs[ref] && !isShortSHA(ref) {
+		return ref, nil
+	}
+
+	// symbolic refs name the default version, abbreviated shas must be
+	// served like any other ref
+	resolveRef := ref
+	if symbolicRefs[ref] {
+		resolveRef = ""
+	}
+
+	s, err := c.resolve(ctx, name, resolveRef)
+	if err != nil {
+		// the site isn't served, the ref doesn't matter
+		return ref, nil
+	}
+
+	key := repoKey(s.owner, s.repo) + "@" + ref
+
+	if sha, ok := c.shas.get(key); ok {
+		return sha, nil
+	}
+
+	if c.hasRepoBranch(s.ctx, s.owner, s.repo, ref) {
+		return ref, nil
+	}
+
+	if symbolicRefs[ref] {
+		// remembered with the branches, until one is named like it
+		c.lookups.setBranch(s.owner, s.repo, ref, false)
+
+		return "", nil
+	}
+
+	commit, resp, err := c.api(s.ctx).GetSingleCommit(s.owner, s.repo, ref)
+	if err != nil && resp != nil && (resp.StatusCode == http.StatusNotFound ||
+		resp.StatusCode == http.StatusUnprocessableEntity) {
+		return ref, nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("resolving %s of %s/%s: %w", ref, s.owner, s.repo, err)
+	}
+
+	// the sha may name a branch or tag too, only commits are canonicalized
+	if !strings.HasPrefix(commit.SHA, ref) || !isCommitSHA(commit.SHA) {
+		return ref, nil
+	}
+
+	c.shas.set(key, commit.SHA)
+
+	return commit.SHA, nil
+}
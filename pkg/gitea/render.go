@@ -0,0 +1,179 @@
+package gitea
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Renderer converts the content of a file into the page served for it,
+// e.g. markdown into html.
+type Renderer interface {
+	// Render returns the page for the file name with the given content.
+	// A page rendered despite problems with the file is returned together
+	// with a *RenderWarning, which is logged.
+	Render(name string, content []byte) ([]byte, error)
+}
+
+// RenderWarning is returned by a Renderer together with a page rendered
+// despite problems with the file, e.g. invalid front matter.
+type RenderWarning struct {
+	Err error
+}
+
+func (w *RenderWarning) Error() string {
+	return "render warning: " + w.Err.Error()
+}
+
+func (w *RenderWarning) Unwrap() error {
+	return w.Err
+}
+
+// RendererFunc is an adapter to use a function as Renderer.
+type RendererFunc func(name string, content []byte) ([]byte, error)
+
+// Render calls f(name, content).
+func (f RendererFunc) Render(name string, content []byte) ([]byte, error) {
+	return f(name, content)
+}
+
+// MarkdownRenderer renders markdown (with front matter) into html.
+var MarkdownRenderer Renderer = RendererFunc(renderMarkdown)
+
+func defaultRenderers() map[string]Renderer {
+	return map[string]Renderer{
+		".md": MarkdownRenderer,
+	}
+}
+
+// SetRenderer renders files with the extension (".adoc") or MIME type
+// ("text/asciidoc") key using r, replacing any renderer already set for key.
+// A nil renderer serves the files as is.
+func SetRenderer(key string, r Renderer) ClientOption {
+	return func(c *Client) error {
+		c.renderers[key] = r
+		return nil
+	}
+}
+
+// SetRenderMarkdown sets if markdown files are rendered into html (the
+// default) or served as is, repos can override it in their config.
+func SetRenderMarkdown(render bool) ClientOption {
+	return func(c *Client) error {
+		c.renderMarkdown = render
+		return nil
+	}
+}
+
+// isMarkdown reports if name is a markdown file.
+func isMarkdown(name string) bool {
+	switch path.Ext(name) {
+	case ".md", ".markdown":
+		return true
+	}
+
+	return false
+}
+
+// wantsSource reports if the request with header is served the source of
+// the file filepath instead of rendering it: its markdown source is preferred,
+// or a range is asked for, as rendered pages aren't byte-stable.
+func (c *Client) wantsSource(filepath string, header http.Header) bool {
+	if isMarkdown(filepath) && prefersMarkdown(header) {
+		return true
+	}
+
+	return header.Get("Range") != "" && c.renderer(filepath) != nil
+}
+
+// renders reports if filepath of the site s is rendered for a request with
+// header, rather than served as is.
+func (c *Client) renders(s *site, filepath string, header http.Header) bool {
+	if isMarkdown(filepath) && !s.markdown {
+		return false
+	}
+
+	return c.renderer(filepath) != nil && !c.wantsSource(filepath, header)
+}
+
+// prefersMarkdown reports if the Accept header of header prefers the
+// markdown source of a page over the rendered html.
+func prefersMarkdown(header http.Header) bool {
+	var markdownQ, htmlQ float64
+
+	for _, accept := range header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			typ, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			switch typ {
+			case "text/markdown", "text/x-markdown":
+				markdownQ = math.Max(markdownQ, q)
+			case "text/html":
+				htmlQ = math.Max(htmlQ, q)
+			}
+		}
+	}
+
+	return markdownQ > 0 && markdownQ > htmlQ
+}
+
+// renderer returns the renderer for the file name, looked up by its
+// extension first and its MIME type second, or nil if it's served as is.
+// Web app modules are always served as is.
+func (c *Client) renderer(name string) Renderer {
+	if untransformed(name) {
+		return nil
+	}
+
+	ext := path.Ext(name)
+	if r, ok := c.renderers[ext]; ok {
+		return r
+	}
+
+	typ, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil {
+		return nil
+	}
+
+	return c.renderers[typ]
+}
+
+func renderMarkdown(_ string, res []byte) ([]byte, error) {
+	// invalid front matter doesn't fail the page, it's rendered as markdown
+	meta, resbody, fmErr := extractFrontMatter(string(res))
+	if fmErr != nil {
+		meta, resbody = nil, string(res)
+	}
+
+	resmd, err := markdown([]byte(resbody))
+	if err != nil {
+		return nil, err
+	}
+
+	title, _ := meta["title"].(string)
+
+	res = append([]byte("<!DOCTYPE html>\n<html>\n<body>\n<h1>"), []byte(title)...)
+	res = append(res, []byte("</h1>")...)
+	res = append(res, resmd...)
+	res = append(res, []byte("</body></html>")...)
+
+	if fmErr != nil {
+		return res, &RenderWarning{Err: fmt.Errorf("front matter: %w", fmErr)}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,47 @@
+package gitea
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+var update = flag.Bool("update", false, "write the golden files instead of comparing with them")
+
+func TestRenderingGolden(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	for _, repo := range giteatest.RenderingRepos("user") {
+		srv.AddRepo(repo)
+	}
+
+	c, err := NewClient(srv.URL, "token", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := giteatest.Golden{Dir: "testdata/rendering", Update: *update || giteatest.UpdateGolden}
+
+	for _, page := range giteatest.RenderingPages {
+		f, err := c.Open("user/"+page, "")
+		if err != nil {
+			t.Errorf("%s: %v", page, err)
+			continue
+		}
+
+		content, err := io.ReadAll(f)
+		f.Close()
+
+		if err != nil {
+			t.Errorf("%s: %v", page, err)
+			continue
+		}
+
+		if err := golden.Check(page, content); err != nil {
+			t.Error(err)
+		}
+	}
+}
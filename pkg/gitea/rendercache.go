@@ -0,0 +1,65 @@
+package gitea
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// renderCacheKey is the key of the page rendered from the git blob with id
+// sha of owner/repo with the settings hashed into settings. The key has no
+// ref, the refs having the same blob share the page.
+func renderCacheKey(owner, repo, settings, sha string) string {
+	return owner + "/" + repo + "/render/" + settings + ":" + sha
+}
+
+// renderSettings returns the hash of what the page rendered by r depends on
+// besides the source: the renderer with its config and the sanitize policy.
+// Functions are compared by address, so the pages of other builds aren't
+// used.
+func renderSettings(r Renderer, sanitize string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%T %#v\x00%s", r, r, sanitize)))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// renderPage renders the file filepath of s with content using r into the
+// sanitized page. Markdown pages are cached by the git blob of their source
+// and the render settings, so they're rendered once for all refs having that
+// blob and aren't purged with the files of the site. Pages rendered with a
+// warning aren't cached, the warning is logged for every request.
+func (c *Client) renderPage(s *site, r Renderer, filepath string, content []byte) ([]byte, error) {
+	if c.cache == nil || !isMarkdown(filepath) {
+		rendered, err := r.Render(filepath, content)
+		if rendered == nil {
+			return nil, err
+		}
+
+		return sanitizePage(rendered, s.sanitize), err
+	}
+
+	key := renderCacheKey(s.owner, s.repo, renderSettings(r, s.sanitize), blobSHA(content))
+
+	if f, ok := c.cachedRaw(s.owner, s.repo, key); ok {
+		renderCacheMetrics.requests.WithLabelValues("hit").Inc()
+		return f.content, nil
+	}
+
+	renderCacheMetrics.requests.WithLabelValues("miss").Inc()
+
+	rendered, err := r.Render(filepath, content)
+
+	var warning *RenderWarning
+	if rendered == nil || (err != nil && !errors.As(err, &warning)) {
+		return nil, err
+	}
+
+	page := sanitizePage(rendered, s.sanitize)
+
+	if err == nil {
+		c.cacheRaw(s.ctx, s.owner, s.repo, key, &rawFile{content: page})
+	}
+
+	return page, err
+}
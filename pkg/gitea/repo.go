@@ -0,0 +1,124 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	gclient "code.gitea.io/sdk/gitea"
+)
+
+// repoMeta is the part of a gitea repo used by the client.
+type repoMeta struct {
+	Private       bool   `json:"private"`
+	Internal      bool   `json:"internal"`
+	DefaultBranch string `json:"default_branch"`
+	Description   string `json:"description"`
+	Website       string `json:"website"`
+	// Topics is nil if gitea is too old to include the topics in a repo.
+	Topics *[]string `json:"topics"`
+}
+
+// SetDefaultBranchFallback serves the default branch of a repo with the
+// allowall topic if it has neither a config nor a gitea-pages branch,
+// instead of nothing.
+func SetDefaultBranchFallback() ClientOption {
+	return func(c *Client) error {
+		c.defaultFallback = true
+		return nil
+	}
+}
+
+// The states of the detection of topics in repos.
+const (
+	topicsUnknown int32 = iota
+	topicsInRepo
+	topicsSeparate
+)
+
+// repoMeta fetches the repo from gitea, the sdk doesn't know its topics.
+func (c *Client) repoMeta(ctx context.Context, owner, repo string) (*repoMeta, error) {
+	giteaURL, err := url.JoinPath(c.serverURL+"/api/v1/repos/", owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, giteaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "token "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	}
+
+	var meta repoMeta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("decoding repo %s/%s: %w", owner, repo, err)
+	}
+
+	// remember the default branch, so resolving the tree doesn't fetch the repo
+	// again, nor does checking whether it's private
+	c.defaultBranches.Store(owner+"/"+repo, meta.DefaultBranch)
+	c.access.set(privateKey(owner, repo), accessEntry{ok: meta.Private || meta.Internal})
+
+	return &meta, nil
+}
+
+// repoTopics returns the topics of the repo, cached with the files.
+func (c *Client) repoTopics(ctx context.Context, owner, repo string) ([]string, error) {
+	if topics, ok := c.lookups.repoTopics(owner, repo); ok {
+		return topics, nil
+	}
+
+	topics, err := c.fetchTopics(ctx, owner, repo)
+	if err == nil {
+		c.lookups.setRepoTopics(owner, repo, topics)
+	}
+
+	return topics, err
+}
+
+// fetchTopics fetches the topics of the repo. Newer gitea versions include
+// them in the repo, which also returns its default branch, older ones need
+// a separate request; which one it is, is detected on the first request.
+func (c *Client) fetchTopics(ctx context.Context, owner, repo string) ([]string, error) {
+	if atomic.LoadInt32(&c.topicsMode) != topicsSeparate {
+		meta, err := c.repoMeta(ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		if meta.Topics != nil {
+			atomic.StoreInt32(&c.topicsMode, topicsInRepo)
+			return *meta.Topics, nil
+		}
+
+		atomic.StoreInt32(&c.topicsMode, topicsSeparate)
+	}
+
+	topics, resp, err := c.api(ctx).ListRepoTopics(owner, repo, gclient.ListRepoTopicsOptions{})
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+
+	return topics, err
+}
@@ -0,0 +1,213 @@
+package gitea
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectStore is a bucket of an S3 compatible object storage, addressed
+// path-style (<endpoint>/<bucket>/<key>) and signed with AWS signature v4.
+type ObjectStore struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// NewObjectStore returns the bucket of the S3 compatible object storage at
+// endpoint (e.g. https://s3.eu-west-1.amazonaws.com) in region.
+func NewObjectStore(endpoint, region, bucket, accessKey, secretKey string) (*ObjectStore, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing object storage endpoint: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("object storage endpoint %q must be a http(s) url", endpoint)
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &ObjectStore{
+		endpoint:   u,
+		region:     region,
+		bucket:     bucket,
+		accessKey:  accessKey,
+		secretKey:  secretKey,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Put stores content with contentType and the user metadata meta (sent as
+// x-amz-meta-<name> headers) under key.
+func (s *ObjectStore) Put(key string, content []byte, contentType string, meta map[string]string) error {
+	header := make(http.Header)
+	for name, value := range meta {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+
+	req, err := s.request(http.MethodPut, key, content, header)
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storing %s: unexpected status code '%d'", key, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Get returns the object stored under key with its headers, the user
+// metadata is in the X-Amz-Meta-<Name> headers. It returns fs.ErrNotExist
+// if there's no such object.
+func (s *ObjectStore) Get(key string) ([]byte, http.Header, error) {
+	req, err := s.request(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden:
+		// without list permission a missing object is forbidden
+		return nil, nil, fs.ErrNotExist
+	default:
+		return nil, nil, fmt.Errorf("getting %s: unexpected status code '%d'", key, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return content, resp.Header, nil
+}
+
+// request returns a request for key with body and the (x-amz-) headers
+// header, signed.
+func (s *ObjectStore) request(method, key string, body []byte, header http.Header) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.bucket + "/" + key
+	u.RawPath = s.endpoint.EscapedPath() + "/" + awsEscape(s.bucket) + "/" + awsEscape(key)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	return req, nil
+}
+
+// sign signs req with AWS signature v4 (covering its host and x-amz-
+// headers), payloadHash is the hex encoded SHA-256 hash of its body.
+func (s *ObjectStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			names = append(names, name)
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+
+	return h.Sum(nil)
+}
+
+// awsEscape escapes all bytes of s but the unreserved characters and
+// slashes, as AWS signature v4 expects for paths.
+func awsEscape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
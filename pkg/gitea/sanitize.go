@@ -0,0 +1,102 @@
+package gitea
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// The sanitization policies for rendered pages, from the least to the most strict.
+const (
+	SanitizeNone    = "none"
+	SanitizeRelaxed = "relaxed"
+	SanitizeStrict  = "strict"
+)
+
+var sanitizeLevels = map[string]int{
+	SanitizeNone:    0,
+	SanitizeRelaxed: 1,
+	SanitizeStrict:  2,
+}
+
+var sanitizePolicies = map[string]*bluemonday.Policy{
+	SanitizeRelaxed: relaxedPolicy(),
+	SanitizeStrict:  strictPolicy(),
+}
+
+// strictPolicy only allows safe formatting of user generated content.
+func strictPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id").OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+
+	return p
+}
+
+// relaxedPolicy also allows classes (syntax highlighting), ids and
+// collapsible sections.
+func relaxedPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class", "id").Globally()
+	p.AllowElements("details", "summary")
+
+	return p
+}
+
+// ValidSanitizePolicy reports if policy is a known sanitization policy.
+func ValidSanitizePolicy(policy string) bool {
+	_, ok := sanitizeLevels[policy]
+	return ok
+}
+
+// SetSanitize sets the minimum sanitization policy for rendered pages,
+// repos can only pick a stricter policy in their config.
+func SetSanitize(policy string) ClientOption {
+	return func(c *Client) error {
+		if !ValidSanitizePolicy(policy) {
+			return fmt.Errorf("unknown sanitize policy %q", policy)
+		}
+
+		c.sanitize = policy
+
+		return nil
+	}
+}
+
+// sanitizePolicy returns the strictest of the operator and the repo policy.
+func (c *Client) sanitizePolicy(repoPolicy string) string {
+	policy := c.sanitize
+	if policy == "" {
+		policy = SanitizeNone
+	}
+
+	if level, ok := sanitizeLevels[repoPolicy]; ok && level > sanitizeLevels[policy] {
+		policy = repoPolicy
+	}
+
+	return policy
+}
+
+// sanitizePage sanitizes the body of a rendered page using policy.
+func sanitizePage(page []byte, policy string) []byte {
+	p, ok := sanitizePolicies[policy]
+	if !ok {
+		return page
+	}
+
+	start := bytes.Index(page, []byte("<body>"))
+	end := bytes.LastIndex(page, []byte("</body>"))
+
+	if start < 0 || end < start {
+		return p.SanitizeBytes(page)
+	}
+
+	start += len("<body>")
+
+	res := make([]byte, 0, len(page))
+	res = append(res, page[:start]...)
+	res = append(res, p.SanitizeBytes(page[start:end])...)
+	res = append(res, page[end:]...)
+
+	return res
+}
@@ -0,0 +1,83 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrReadOnlyToken is returned for features that write to gitea when the
+// token lacks the scope they need.
+var ErrReadOnlyToken = errors.New("the gitea token can't write")
+
+// scopeProbeRepo is the repo the write scopes are probed on, it doesn't need
+// to exist.
+const scopeProbeRepo = "gitea-pages-scope-probe/gitea-pages-scope-probe"
+
+// TokenScopes are the write permissions of the token of a client.
+type TokenScopes struct {
+	// Statuses is whether the token can post commit statuses, which needs
+	// the write:repository scope.
+	Statuses bool
+	// Issues is whether the token can create issues and comments, which
+	// needs the write:issue scope.
+	Issues bool
+}
+
+// ReadOnly reports if the token can't write at all.
+func (ts TokenScopes) ReadOnly() bool {
+	return !ts.Statuses && !ts.Issues
+}
+
+// DetectTokenScopes finds out which write scopes the token has, as scoped
+// tokens of gitea may only read. From then on the client refuses the features
+// needing a missing scope with ErrReadOnlyToken, instead of failing at gitea.
+//
+// Gitea checks the scopes of a request before the repo, so posting an empty
+// status and issue to a repo that doesn't exist is refused with 403 without
+// the scope and with 404 with it, and writes nothing.
+func (c *Client) DetectTokenScopes() (TokenScopes, error) {
+	statuses, err := c.probeWriteScope("statuses/" + strings.Repeat("0", 40))
+	if err != nil {
+		return TokenScopes{}, err
+	}
+
+	issues, err := c.probeWriteScope("issues")
+	if err != nil {
+		return TokenScopes{}, err
+	}
+
+	ts := TokenScopes{Statuses: statuses, Issues: issues}
+	c.scopes = &ts
+
+	return ts, nil
+}
+
+// probeWriteScope reports if the token may post to endpoint of a repo.
+func (c *Client) probeWriteScope(endpoint string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, c.serverURL+"/api/v1/repos/"+scopeProbeRepo+"/"+endpoint,
+		strings.NewReader("{}"))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Add("Authorization", "token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return false, fmt.Errorf("probing token scopes: unexpected status code '%d'", resp.StatusCode)
+	case http.StatusForbidden:
+		return false, nil
+	}
+
+	return true, nil
+}
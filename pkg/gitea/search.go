@@ -0,0 +1,240 @@
+package gitea
+
+import (
+	"context"
+	"html"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	// maxSearchResults is the number of pages returned for a search.
+	maxSearchResults = 50
+	// maxSearchText is the length of the text of a page that is searched.
+	maxSearchText = 16 << 10
+	// searchContext is the number of bytes around the match in a snippet.
+	searchContext = 80
+)
+
+// textPolicy strips all html, leaving the text of a page.
+var textPolicy = bluemonday.StrictPolicy()
+
+// SearchResult is a page of a site matching a search.
+type SearchResult struct {
+	Title string `json:"title"`
+	// Path is the url path of the page, relative to the root of the site.
+	Path    string `json:"path"`
+	Snippet string `json:"snippet"`
+}
+
+// searchDoc is the text of a page.
+type searchDoc struct {
+	path  string
+	title string
+	text  string
+	lower string
+}
+
+// searchIndex is the text of the pages of a site, per tree.
+type searchIndex struct {
+	mu      sync.Mutex
+	built   bool
+	preview bool
+	docs    []searchDoc
+}
+
+// Search returns the pages (markdown and html) of the site name at ref
+// that contain all words of query, pages with the words in their title
+// first. The text of the pages is read once per tree.
+func (c *Client) Search(ctx context.Context, name, ref, query string) ([]SearchResult, error) {
+	s, err := c.resolve(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.Fields(strings.ToLower(query))
+	results := []SearchResult{}
+
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	// only search the subdirectory if we're serving a path of the gitea-pages repo
+	dir := ""
+	if s.filepath != "" {
+		dir = strings.Trim(s.filepath, "/") + "/"
+	}
+
+	type match struct {
+		doc   *searchDoc
+		title bool
+	}
+
+	var matches []match
+
+	for _, doc := range c.searchDocs(s, t) {
+		doc := doc
+
+		// embargoed pages can't be found before they unlock
+		if !strings.HasPrefix(doc.path, dir) || s.embargo(doc.path, nil) != nil {
+			continue
+		}
+
+		all, inTitle := true, true
+
+		for _, term := range terms {
+			titled := strings.Contains(strings.ToLower(doc.title), term)
+			inTitle = inTitle && titled
+
+			if !titled && !strings.Contains(doc.lower, term) {
+				all = false
+				break
+			}
+		}
+
+		if all {
+			matches = append(matches, match{doc: &doc, title: inTitle})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].title && !matches[j].title
+	})
+
+	for i, m := range matches {
+		if i == maxSearchResults {
+			break
+		}
+
+		results = append(results, SearchResult{
+			Title:   m.doc.title,
+			Path:    pageURLPath(strings.TrimPrefix(m.doc.path, dir)),
+			Snippet: m.doc.snippet(terms),
+		})
+	}
+
+	return results, nil
+}
+
+// searchDocs returns the text of the pages of the site s in the tree t.
+func (c *Client) searchDocs(s *site, t *siteTree) []searchDoc {
+	idx := &t.search
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	// previews have drafts, the same tree may be served without
+	if idx.built && idx.preview == s.preview {
+		return idx.docs
+	}
+
+	idx.built, idx.preview, idx.docs = true, s.preview, nil
+
+	c.readPages(s, t, "", func(name string, meta map[string]any, content []byte) {
+		doc := searchDoc{
+			path: name,
+			text: pageText(name, content),
+		}
+
+		doc.title, _ = meta["title"].(string)
+		if doc.title == "" {
+			doc.title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+		}
+
+		doc.lower = strings.ToLower(doc.text)
+
+		idx.docs = append(idx.docs, doc)
+	})
+
+	sort.Slice(idx.docs, func(i, j int) bool { return idx.docs[i].path < idx.docs[j].path })
+
+	return idx.docs
+}
+
+// pageText returns the text of the page name, without front matter, markup
+// and repeated whitespace.
+func pageText(name string, content []byte) string {
+	if _, body, err := extractFrontMatter(string(content)); err == nil {
+		content = []byte(body)
+	}
+
+	if isMarkdown(name) {
+		if page, err := markdown(content); err == nil {
+			content = page
+		}
+	}
+
+	text := strings.Join(strings.Fields(html.UnescapeString(textPolicy.Sanitize(string(content)))), " ")
+
+	if len(text) > maxSearchText {
+		cut := maxSearchText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+
+		text = text[:cut]
+	}
+
+	return text
+}
+
+// snippet returns the text around the first of terms in the page.
+func (d *searchDoc) snippet(terms []string) string {
+	// lowercasing may change the length of some characters
+	text := d.text
+	if len(d.lower) != len(d.text) {
+		text = d.lower
+	}
+
+	i := -1
+
+	for _, term := range terms {
+		if i = strings.Index(d.lower, term); i >= 0 {
+			break
+		}
+	}
+
+	if i < 0 {
+		i = 0
+	}
+
+	start, end := i-searchContext, i+searchContext
+
+	if start < 0 {
+		start = 0
+	}
+
+	if end > len(text) {
+		end = len(text)
+	}
+
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	snippet := text[start:end]
+
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+
+	if end < len(text) {
+		snippet += "…"
+	}
+
+	return snippet
+}
@@ -0,0 +1,91 @@
+package gitea
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// shieldTreeMeta is the user metadata of exported files with the sha of the
+// tree of the site they were exported from.
+const shieldTreeMeta = "Tree-Sha"
+
+// shield is the object storage with the exported snapshots of the sites.
+type shield struct {
+	store  *ObjectStore
+	prefix string
+}
+
+// SetShield serves files from the snapshots exported to store with prefix
+// (see ExportSite) before asking gitea, if the snapshot is of the version of
+// the site that is deployed; gitea serves all other files.
+func SetShield(store *ObjectStore, prefix string) ClientOption {
+	return func(c *Client) error {
+		c.shield = &shield{store: store, prefix: prefix}
+		return nil
+	}
+}
+
+// shielded returns filepath of the site s from the object storage, or nil
+// if it has no snapshot of the deployed version of the file.
+func (c *Client) shielded(s *site, filepath string, header http.Header) (*openFile, error) {
+	if c.shield == nil {
+		return nil, nil
+	}
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil || t.sha == "" {
+		return nil, nil
+	}
+
+	content, h, err := c.shield.store.Get(c.shield.prefix + s.owner + "/" + s.repo + "/" + t.ref + "/" + filepath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			c.logger.Warn("getting file from object storage failed", zap.String("owner", s.owner),
+				zap.String("repo", s.repo), zap.String("file", filepath), zap.Error(err))
+		}
+
+		shieldMetrics.requests.WithLabelValues("miss").Inc()
+
+		return nil, nil
+	}
+
+	if h.Get("X-Amz-Meta-"+shieldTreeMeta) != t.sha {
+		shieldMetrics.requests.WithLabelValues("outdated").Inc()
+		return nil, nil
+	}
+
+	shieldMetrics.requests.WithLabelValues("hit").Inc()
+
+	// the snapshot is rendered, it's only semantically equivalent to the source
+	etag := h.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		etag = "W/" + etag
+	}
+
+	if inm := header.Get("If-None-Match"); inm != "" && etag != "" && strings.Contains(inm, etag) {
+		nm := notModified(h)
+		nm.Header.Set("ETag", etag)
+		nm.Header.Del("Cache-Control")
+
+		return nil, nm
+	}
+
+	f := &openFile{
+		content:     content,
+		name:        filepath,
+		etag:        etag,
+		contentType: h.Get("Content-Type"),
+		variant:     s.variant,
+		headers:     siteHeaders(s, filepath),
+	}
+
+	if lm := h.Get("Last-Modified"); lm != "" {
+		f.modTime, _ = http.ParseTime(lm)
+	}
+
+	return f, nil
+}
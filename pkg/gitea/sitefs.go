@@ -0,0 +1,236 @@
+package gitea
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// siteFS is the file system of the sources of a site: the files of the git
+// tree of its repo at its ref, read as is (not rendered) from the cache or
+// gitea. Listing, matching and stating files only use the tree, nothing is
+// fetched; the listings of a truncated tree are incomplete.
+type siteFS struct {
+	c *Client
+	s *site
+	t *siteTree
+	// dir is the subtree of the file system, empty or ending with a slash
+	dir string
+}
+
+// siteFS returns the file system of the site s with the full tree t.
+func (c *Client) siteFS(s *site, t *siteTree) *siteFS {
+	return &siteFS{c: c, s: s, t: t}
+}
+
+// path returns the path in the tree of name, a valid path of the file system.
+func (f *siteFS) path(name string) string {
+	if name == "." {
+		return strings.TrimSuffix(f.dir, "/")
+	}
+
+	return f.dir + name
+}
+
+// entries returns the files and directories directly below the directory
+// p of the tree, in the order of their names, and reports if it exists.
+func (f *siteFS) entries(p string) ([]fs.DirEntry, bool) {
+	prefix := p
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var (
+		entries []fs.DirEntry
+		dirs    = make(map[string]bool)
+		exists  = p == ""
+	)
+
+	for _, e := range f.t.entries {
+		if !strings.HasPrefix(e.path, prefix) {
+			continue
+		}
+
+		exists = true
+		name := e.path[len(prefix):]
+
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			if name = name[:i]; !dirs[name] {
+				dirs[name] = true
+				entries = append(entries, fileInfo{name: name, isdir: true})
+			}
+
+			continue
+		}
+
+		entries = append(entries, fileInfo{name: name, size: e.size})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, exists
+}
+
+// Open implements fs.FS, directories implement fs.ReadDirFile.
+func (f *siteFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	p := f.path(name)
+
+	if entries, ok := f.entries(p); ok {
+		return &dirFile{info: fileInfo{name: path.Base(name), isdir: true}, entries: entries}, nil
+	}
+
+	if exists, known := f.t.exists(p); !exists && known {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	raw, err := f.c.fetchRaw(f.s.ctx, f.s.owner, f.s.repo, p, f.s.ref, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// the tree has no times, the files have none either to stat the same
+	return &openFile{content: raw.content, name: path.Base(name), etag: raw.etag}, nil
+}
+
+// Stat implements fs.StatFS.
+func (f *siteFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	p := f.path(name)
+
+	for _, e := range f.t.entries {
+		if e.path == p {
+			return fileInfo{name: path.Base(name), size: e.size}, nil
+		}
+	}
+
+	if _, ok := f.entries(p); ok {
+		return fileInfo{name: path.Base(name), isdir: true}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (f *siteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, ok := f.entries(f.path(name))
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entries, nil
+}
+
+// Glob implements fs.GlobFS, matching the files and directories of the tree
+// in a single pass instead of reading every directory of the pattern.
+func (f *siteFS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var (
+		matches []string
+		seen    = make(map[string]bool)
+	)
+
+	for _, e := range f.t.entries {
+		if !strings.HasPrefix(e.path, f.dir) {
+			continue
+		}
+
+		// the file and the directories it's in, up to the first one seen
+		for name := e.path[len(f.dir):]; !seen[name]; {
+			seen[name] = true
+
+			if ok, _ := path.Match(pattern, name); ok {
+				matches = append(matches, name)
+			}
+
+			i := strings.LastIndexByte(name, '/')
+			if i < 0 {
+				break
+			}
+
+			name = name[:i]
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// Sub implements fs.SubFS, the subtree shares the tree.
+func (f *siteFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	if dir == "." {
+		return f, nil
+	}
+
+	return &siteFS{c: f.c, s: f.s, t: f.t, dir: f.dir + dir + "/"}, nil
+}
+
+// dirFile is a directory of a siteFS.
+type dirFile struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(rest) {
+		n = len(rest)
+	}
+
+	d.offset += n
+
+	return rest[:n], nil
+}
+
+// Interface guards
+var (
+	_ fs.ReadDirFS   = (*siteFS)(nil)
+	_ fs.StatFS      = (*siteFS)(nil)
+	_ fs.GlobFS      = (*siteFS)(nil)
+	_ fs.SubFS       = (*siteFS)(nil)
+	_ fs.ReadDirFile = (*dirFile)(nil)
+)
@@ -0,0 +1,62 @@
+package gitea
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// headingIDs generates heading anchors the way gitea and github do, so links
+// to headings copied from the gitea UI keep working on the site.
+type headingIDs struct {
+	values map[string]struct{}
+}
+
+func newHeadingIDs() *headingIDs {
+	return &headingIDs{values: make(map[string]struct{})}
+}
+
+// Generate implements parser.IDs.
+func (h *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	slug := slugify(string(value))
+	if slug == "" {
+		slug = "heading"
+	}
+
+	// duplicate headings get a -1, -2, ... suffix
+	id := slug
+	for i := 1; ; i++ {
+		if _, ok := h.values[id]; !ok {
+			break
+		}
+
+		id = slug + "-" + strconv.Itoa(i)
+	}
+
+	h.values[id] = struct{}{}
+
+	return []byte(id)
+}
+
+// Put implements parser.IDs.
+func (h *headingIDs) Put(value []byte) {
+	h.values[string(value)] = struct{}{}
+}
+
+// slugify lowercases s, drops punctuation and replaces spaces with dashes.
+func slugify(s string) string {
+	var sb strings.Builder
+
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsNumber(r), unicode.IsMark(r), r == '-', r == '_':
+			sb.WriteRune(r)
+		case r == ' ':
+			sb.WriteByte('-')
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package gitea
+
+import (
+	gclient "code.gitea.io/sdk/gitea"
+	"context"
+)
+
+// DeployStatusContext is the context of the commit status posted on deploys.
+const DeployStatusContext = "pages/deployed"
+
+// PostDeployStatus posts a successful pages/deployed commit status linking to
+// siteURL (if known) on the commit ref of owner/repo points to.
+func (c *Client) PostDeployStatus(owner, repo, ref, siteURL string) error {
+	if c.scopes != nil && !c.scopes.Statuses {
+		return ErrReadOnlyToken
+	}
+
+	var err error
+
+	if ref == "" {
+		ref, err = c.defaultBranch(context.Background(), owner, repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	commit, _, err := c.gc.GetSingleCommit(owner, repo, ref)
+	if err != nil {
+		return err
+	}
+
+	description := "Published"
+	if siteURL != "" {
+		description += " to " + siteURL
+	}
+
+	_, _, err = c.gc.CreateStatus(owner, repo, commit.SHA, gclient.CreateStatusOption{
+		State:       gclient.StatusSuccess,
+		TargetURL:   siteURL,
+		Description: description,
+		Context:     DeployStatusContext,
+	})
+
+	return err
+}
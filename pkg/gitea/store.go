@@ -0,0 +1,239 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps the state of the client across restarts: the takedown list,
+// the custom domains claimed by sites and the statistics of the inventory
+// (deploys, requests and clicks), the usage reports and the OAuth2 refresh
+// token (see SetOAuth2). A store shared by several caddy instances shares
+// the takedown list and custom domains between them.
+type Store interface {
+	// Load returns the value stored for key, an error wrapping fs.ErrNotExist
+	// if there's none.
+	Load(key string) ([]byte, error)
+	// Store stores value for key.
+	Store(key string, value []byte) error
+	// Delete removes the value of key, if any.
+	Delete(key string) error
+}
+
+// The keys of the state in the store.
+const (
+	takedownsKey     = "takedowns"
+	domainsKeyPrefix = "domains/"
+	// the statistics are per instance, instances sharing a store count
+	// different requests
+	inventoryKeyPrefix = "inventory/"
+	usageKeyPrefix     = "usage/"
+)
+
+// SetStore keeps the state of the client in store. The state is loaded when
+// the client is created and written back when it changes, the statistics by
+// SaveState.
+func SetStore(store Store) ClientOption {
+	return func(c *Client) error {
+		c.store = store
+		return nil
+	}
+}
+
+// loadState loads the state of the client from its store.
+func (c *Client) loadState() error {
+	if c.store == nil {
+		return nil
+	}
+
+	c.takedowns.store = c.store
+
+	var takedowns []Takedown
+	if err := loadJSON(c.store, takedownsKey, &takedowns); err != nil {
+		return fmt.Errorf("loading takedowns: %w", err)
+	}
+
+	c.takedowns.mu.Lock()
+	for _, t := range takedowns {
+		c.takedowns.entries[t.Target] = t
+	}
+	c.takedowns.mu.Unlock()
+
+	if c.domains != nil {
+		c.domains.store = c.store
+	}
+
+	var sites []*SiteStats
+	if err := loadJSON(c.store, inventoryKey(), &sites); err != nil {
+		return fmt.Errorf("loading inventory: %w", err)
+	}
+
+	c.inventory.mu.Lock()
+	for _, s := range sites {
+		c.inventory.sites[s.Owner+"/"+s.Repo+"@"+s.Ref] = s
+	}
+	c.inventory.mu.Unlock()
+
+	return nil
+}
+
+// SaveState writes the statistics of the inventory to the store, the rest of
+// the state is written when it changes.
+func (c *Client) SaveState() error {
+	if c.store == nil {
+		return nil
+	}
+
+	c.inventory.mu.Lock()
+
+	sites := make([]*SiteStats, 0, len(c.inventory.sites))
+	for _, s := range c.inventory.sites {
+		sites = append(sites, s)
+	}
+
+	value, err := json.Marshal(sites)
+
+	c.inventory.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return c.store.Store(inventoryKey(), value)
+}
+
+// inventoryKey is the key of the statistics of this instance.
+func inventoryKey() string {
+	return inventoryKeyPrefix + instanceName()
+}
+
+// instanceName names this instance among those sharing a store.
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "local"
+	}
+
+	return host
+}
+
+// loadJSON decodes the value of key into v, a missing key leaves v alone.
+func loadJSON(store Store, key string, v any) error {
+	value, err := store.Load(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(value, v)
+}
+
+// FileStore is a Store keeping every key in a file below a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store keeping its keys below dir.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (fst *FileStore) path(key string) string {
+	return filepath.Join(fst.dir, filepath.FromSlash(key))
+}
+
+// Load implements Store.
+func (fst *FileStore) Load(key string) ([]byte, error) {
+	return os.ReadFile(fst.path(key))
+}
+
+// Store implements Store, the file is replaced atomically.
+func (fst *FileStore) Store(key string, value []byte) error {
+	name := fst.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".store")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// Delete implements Store.
+func (fst *FileStore) Delete(key string) error {
+	err := os.Remove(fst.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// redisStateKeyPrefix namespaces the keys of the store in redis, apart from
+// the cache.
+const redisStateKeyPrefix = redisKeyPrefix + "state:"
+
+// RedisStore is a Store in redis, so it can be shared by several caddy
+// instances.
+type RedisStore struct {
+	rc *RedisCache
+}
+
+// NewRedisStore returns a store in the redis server at addr.
+func NewRedisStore(addr, password string, db int) *RedisStore {
+	return &RedisStore{rc: NewRedisCache(addr, password, db)}
+}
+
+// Load implements Store.
+func (rs *RedisStore) Load(key string) ([]byte, error) {
+	v, err := rs.rc.do("GET", redisStateKeyPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return b, nil
+}
+
+// Store implements Store.
+func (rs *RedisStore) Store(key string, value []byte) error {
+	_, err := rs.rc.do("SET", redisStateKeyPrefix+key, string(value))
+	return err
+}
+
+// Delete implements Store.
+func (rs *RedisStore) Delete(key string) error {
+	_, err := rs.rc.do("DEL", redisStateKeyPrefix+key)
+	return err
+}
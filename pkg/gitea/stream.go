@@ -0,0 +1,177 @@
+package gitea
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// streamedHeaders are the headers of requests forwarded to gitea for
+// streamed files, their ranges are answered by gitea.
+var streamedHeaders = []string{"Range", "If-Range"}
+
+// SetStreamThreshold streams files larger than size (0 never streams) from
+// gitea to the client instead of reading them into memory. Only files that
+// are served as is (no pages, no rendered files) are streamed; they're never
+// cached and range requests for them are forwarded to gitea.
+func SetStreamThreshold(size int64) ClientOption {
+	return func(c *Client) error {
+		c.streamThreshold = size
+		return nil
+	}
+}
+
+// lfsPointerMaxSize is the size of the largest LFS pointer file. The tree
+// has the size of a pointer, not of the object gitea serves for it.
+const lfsPointerMaxSize = 1024
+
+// treeSize returns the size of filepath of the site s in its tree, -1 if it
+// isn't known or the file may be an LFS pointer.
+func (c *Client) treeSize(s *site, filepath string) int64 {
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return -1
+	}
+
+	size, ok := t.files[filepath]
+	if !ok || size < lfsPointerMaxSize {
+		return -1
+	}
+
+	return size
+}
+
+// streamable reports if filepath is served as is, so it may be streamed.
+func (c *Client) streamable(filepath string) bool {
+	return c.streamThreshold > 0 && !isPage(filepath) && c.renderer(filepath) == nil
+}
+
+// openStream fetches filepath of the site s from gitea and returns a file
+// streaming its body if it's larger than the stream threshold or gitea
+// answered a range request. Smaller files are read and cached like fetchRaw
+// does and returned as raw file instead. Cached files return neither.
+func (c *Client) openStream(s *site, filepath string, header http.Header) (*openFile, *rawFile, error) {
+	cacheKey := fileCacheKey(s.owner, s.repo, filepath, s.ref)
+
+	if _, ok := c.cachedRaw(s.owner, s.repo, cacheKey); ok {
+		return nil, nil, nil
+	}
+
+	c.fetches.begin()
+
+	resp, err := c.mediaResponse(s.ctx, c.httpClient, c.serverURL, "token "+c.token, s.owner, s.repo, filepath, s.ref, header, true)
+	if err != nil {
+		c.fetches.end()
+		return nil, nil, err
+	}
+
+	// chunked and compressed bodies have the size of the file in the tree
+	size := resp.ContentLength
+
+	gzipped := resp.StatusCode == http.StatusOK && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+	if gzipped {
+		size = -1
+	}
+
+	if size < 0 && resp.StatusCode == http.StatusOK {
+		size = c.treeSize(s, filepath)
+	}
+
+	if resp.StatusCode == http.StatusOK && size >= 0 && size <= c.streamThreshold {
+		defer c.fetches.end()
+		defer resp.Body.Close()
+
+		raw, err := c.readMedia(resp, s.owner, s.repo, filepath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !c.cacheFull(s.owner, s.repo, len(raw.content)) && !c.relieveMemory() {
+			c.cacheRaw(s.ctx, s.owner, s.repo, cacheKey, raw)
+		}
+
+		return nil, raw, nil
+	}
+
+	if err := c.checkFileSize(s.owner, s.repo, filepath, size); err != nil {
+		resp.Body.Close()
+		c.fetches.end()
+
+		return nil, nil, err
+	}
+
+	body := resp.Body
+
+	// gitea may compress the body though we asked for the identity
+	if gzipped {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			c.fetches.end()
+
+			return nil, nil, err
+		}
+
+		body = &gzipBody{Reader: gz, body: resp.Body}
+	}
+
+	headers := siteHeaders(s, filepath)
+	headers.Set("Accept-Ranges", "bytes")
+
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		headers.Set("Content-Range", cr)
+	}
+
+	f := &openFile{
+		name:        filepath,
+		etag:        resp.Header.Get("ETag"),
+		contentType: webAppType(filepath),
+		variant:     s.variant,
+		headers:     headers,
+		stream:      &streamBody{ReadCloser: body, done: c.fetches.end},
+		streamSize:  size,
+		status:      resp.StatusCode,
+	}
+
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		f.modTime, _ = http.ParseTime(lm)
+	}
+
+	if f.contentType == "" {
+		f.contentType = resp.Header.Get("Content-Type")
+	}
+
+	if c.gitHeaders {
+		setGitHeaders(headers, c.gitInfo(s, filepath))
+	}
+
+	return f, nil, nil
+}
+
+// streamBody is the body of a streamed file, its fetch ends when it's closed.
+type streamBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+
+	return err
+}
+
+// gzipBody decompresses a compressed body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+
+	return b.body.Close()
+}
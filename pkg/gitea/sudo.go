@@ -0,0 +1,78 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrSudoNotAdmin is returned by CheckSudo when the token of the client isn't
+// the token of an admin, which is needed to sudo.
+var ErrSudoNotAdmin = errors.New("sudo needs the token of a gitea admin")
+
+// SetSudoOwners makes the client act as the owner of a repo in the requests
+// for the repo, using the sudo of gitea, so they're allowed what the owner is
+// allowed instead of everything the admin token can. The token must be the
+// token of an admin, see CheckSudo.
+func SetSudoOwners() ClientOption {
+	return func(c *Client) error {
+		c.httpClient.Transport = &sudoTransport{
+			auth: "token " + c.token,
+			next: c.httpClient.Transport,
+		}
+
+		return nil
+	}
+}
+
+// CheckSudo returns ErrSudoNotAdmin if the token can't sudo.
+func (c *Client) CheckSudo() error {
+	u, _, err := c.gc.GetMyUserInfo()
+	if err != nil {
+		return err
+	}
+
+	if !u.IsAdmin {
+		return ErrSudoNotAdmin
+	}
+
+	return nil
+}
+
+// sudoTransport is a http.RoundTripper that sends the requests of the client
+// for a repo as the owner of the repo. Requests with other tokens, like those
+// of visitors, are sent as is.
+type sudoTransport struct {
+	auth string
+	next http.RoundTripper
+}
+
+func (t *sudoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	owner := repoOwner(req.URL.Path)
+
+	// the probe of the token scopes is for a repo of nobody
+	if owner == "" || strings.HasPrefix(scopeProbeRepo, owner+"/") || req.Header.Get("Authorization") != t.auth {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Sudo", owner)
+
+	return t.next.RoundTrip(req)
+}
+
+// repoOwner returns the owner of the repo the API path p is for, "" for other
+// paths.
+func repoOwner(p string) string {
+	i := strings.Index(p, "/api/v1/repos/")
+	if i < 0 {
+		return ""
+	}
+
+	owner, _, ok := strings.Cut(p[i+len("/api/v1/repos/"):], "/")
+	if !ok {
+		return ""
+	}
+
+	return owner
+}
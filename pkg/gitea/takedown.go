@@ -0,0 +1,224 @@
+package gitea
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrTakenDown is returned for sites on the takedown list.
+var ErrTakenDown = errors.New("site taken down")
+
+// Takedown is an entry of the takedown list.
+type Takedown struct {
+	// Target is an owner, owner/repo or a host name.
+	Target string `json:"target"`
+	// Reason is shown in the audit log.
+	Reason string `json:"reason,omitempty"`
+	// Added is when the takedown was added, zero for takedowns of the file.
+	Added time.Time `json:"added,omitempty"`
+}
+
+// takedownList are the sites that are never served, whatever the state of
+// their repo.
+type takedownList struct {
+	mu      sync.RWMutex
+	file    string
+	store   Store
+	entries map[string]Takedown
+}
+
+func newTakedownList() *takedownList {
+	return &takedownList{entries: make(map[string]Takedown)}
+}
+
+// load reads the takedown file, every line is a target optionally followed by
+// a reason, empty lines and lines starting with # are ignored.
+func (tl *takedownList) load(file string) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		tl.file = file
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	entries := make(map[string]Takedown)
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		target, reason, _ := strings.Cut(line, " ")
+		target = NormalizeHost(target)
+		entries[target] = Takedown{Target: target, Reason: strings.TrimSpace(reason)}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading takedown file %s: %w", file, err)
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.file = file
+	tl.entries = entries
+
+	return nil
+}
+
+// save writes the takedown list to its file and store, if it has them.
+func (tl *takedownList) save() error {
+	if tl.store != nil {
+		value, err := json.Marshal(tl.sorted())
+		if err != nil {
+			return err
+		}
+
+		if err := tl.store.Store(takedownsKey, value); err != nil {
+			return err
+		}
+	}
+
+	if tl.file == "" {
+		return nil
+	}
+
+	var b strings.Builder
+
+	for _, t := range tl.sorted() {
+		b.WriteString(strings.TrimSpace(t.Target + " " + t.Reason))
+		b.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(tl.file), ".takedowns")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), tl.file)
+}
+
+// sorted returns the entries sorted by target, the caller must hold a lock.
+func (tl *takedownList) sorted() []Takedown {
+	list := make([]Takedown, 0, len(tl.entries))
+	for _, t := range tl.entries {
+		list = append(list, t)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+
+	return list
+}
+
+// get returns the takedown of target.
+func (tl *takedownList) get(target string) (Takedown, bool) {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+
+	t, ok := tl.entries[NormalizeHost(target)]
+
+	return t, ok
+}
+
+// check returns ErrTakenDown if owner or owner/repo is taken down.
+func (tl *takedownList) check(owner, repo string) error {
+	if t, ok := tl.get(owner); ok {
+		return fmt.Errorf("%w: %s", ErrTakenDown, t.Target)
+	}
+
+	if repo == "" {
+		return nil
+	}
+
+	if t, ok := tl.get(owner + "/" + repo); ok {
+		return fmt.Errorf("%w: %s", ErrTakenDown, t.Target)
+	}
+
+	return nil
+}
+
+// SetTakedownFile loads the takedown list from file, changes made with
+// AddTakedown and RemoveTakedown are written back to it.
+func SetTakedownFile(file string) ClientOption {
+	return func(c *Client) error {
+		return c.takedowns.load(file)
+	}
+}
+
+// TakenDown returns ErrTakenDown if the host name is taken down.
+func (c *Client) TakenDown(host string) error {
+	if t, ok := c.takedowns.get(host); ok {
+		return fmt.Errorf("%w: %s", ErrTakenDown, t.Target)
+	}
+
+	return nil
+}
+
+// Takedowns returns the takedown list.
+func (c *Client) Takedowns() []Takedown {
+	c.takedowns.mu.RLock()
+	defer c.takedowns.mu.RUnlock()
+
+	return c.takedowns.sorted()
+}
+
+// AddTakedown takes down target (an owner, owner/repo or host name) immediately.
+func (c *Client) AddTakedown(target, reason string) error {
+	target = NormalizeHost(target)
+
+	c.takedowns.mu.Lock()
+	defer c.takedowns.mu.Unlock()
+
+	c.takedowns.entries[target] = Takedown{Target: target, Reason: reason, Added: time.Now()}
+
+	c.logger.Info("takedown added", zap.String("target", target), zap.String("reason", reason))
+
+	return c.takedowns.save()
+}
+
+// RemoveTakedown serves target again and reports if it was taken down.
+func (c *Client) RemoveTakedown(target string) (bool, error) {
+	target = NormalizeHost(target)
+
+	c.takedowns.mu.Lock()
+	defer c.takedowns.mu.Unlock()
+
+	if _, ok := c.takedowns.entries[target]; !ok {
+		return false, nil
+	}
+
+	delete(c.takedowns.entries, target)
+
+	c.logger.Info("takedown removed", zap.String("target", target))
+
+	return true, c.takedowns.save()
+}
@@ -0,0 +1,69 @@
+package gitea
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultTier is the tier of the sites opted in with the gitea-pages (or
+// gitea-pages-allowall) topic and structured topics.
+const DefaultTier = "default"
+
+// TopicTier is a topic opting in repos like the gitea-pages topic, for the
+// sites of a tier, e.g. gitea-pages-internal for the internal tier. Handlers
+// can serve some tiers only, e.g. the internal tier only on an internal
+// listener.
+type TopicTier struct {
+	Topic string `json:"topic"`
+	Tier  string `json:"tier"`
+	// Auth restricts the sites of the tier to visitors that can read their
+	// repo, like the pages:auth topic.
+	Auth bool `json:"auth,omitempty"`
+}
+
+// SetTopicTiers opts in the repos with the topics of tiers, in their tier. A
+// repo with the topics of several tiers is in the tier of its first topic.
+func SetTopicTiers(tiers []TopicTier) ClientOption {
+	return func(c *Client) error {
+		c.tiers = make(map[string]TopicTier, len(tiers))
+
+		for _, t := range tiers {
+			switch {
+			case t.Topic == "" || t.Tier == "":
+				return fmt.Errorf("topic tier needs a topic and a tier")
+			case t.Topic == c.giteapages || t.Topic == c.giteapagesAllowAll ||
+				strings.HasPrefix(t.Topic, topicNamespace+":") || strings.HasPrefix(t.Topic, topicNamespace+"."):
+				return fmt.Errorf("topic %q already opts in repos", t.Topic)
+			case t.Tier == DefaultTier:
+				return fmt.Errorf("tier %q is that of the %s topic", DefaultTier, c.giteapages)
+			}
+
+			if _, ok := c.tiers[t.Topic]; ok {
+				return fmt.Errorf("duplicate topic tier %q", t.Topic)
+			}
+
+			c.tiers[t.Topic] = t
+		}
+
+		return nil
+	}
+}
+
+// Tiers returns the tiers of the client: DefaultTier and those of its topic
+// tiers in order.
+func (c *Client) Tiers() []string {
+	tiers := []string{DefaultTier}
+	seen := map[string]bool{DefaultTier: true}
+
+	for _, t := range c.tiers {
+		if !seen[t.Tier] {
+			seen[t.Tier] = true
+			tiers = append(tiers, t.Tier)
+		}
+	}
+
+	sort.Strings(tiers[1:])
+
+	return tiers
+}
@@ -0,0 +1,82 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultOpenTimeout is how long opening a file may take by default.
+const defaultOpenTimeout = 5 * time.Second
+
+// ErrTimeout is returned when gitea doesn't answer all requests needed to
+// open a file in time and no stale copy of it is available.
+var ErrTimeout = errors.New("gitea didn't answer in time")
+
+// SetOpenTimeout limits the time of all upstream requests needed to open a
+// file to timeout (0 is unlimited), defaults to 5s.
+func SetOpenTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.openTimeout = timeout
+		return nil
+	}
+}
+
+// openWithin opens name like open, but gives up after the open timeout and
+// cancels the requests to gitea. The body of a streamed file is read after
+// that, it's only cancelled with ctx or when the file is closed.
+func (c *Client) openWithin(ctx context.Context, name, ref string, header http.Header, v *Visitor) (*openFile, error) {
+	if c.openTimeout <= 0 {
+		return c.open(ctx, name, ref, header, v)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(c.openTimeout, cancel)
+
+	f, err := c.open(ctx, name, ref, header, v)
+
+	switch {
+	case !timer.Stop() && (err != nil || f.stream != nil):
+		// the stream of a file opened too late is cancelled already
+		if f != nil {
+			f.Close()
+		}
+
+		cancel()
+
+		return nil, ErrTimeout
+	case err == nil && f.stream != nil:
+		f.stream = &cancelBody{ReadCloser: f.stream, cancel: cancel}
+	default:
+		cancel()
+	}
+
+	return f, err
+}
+
+// cancelBody is the body of a streamed file, the context of its request is
+// cancelled when it's closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// detached returns a context for the requests whose result is shared by all
+// requests of a site (read once per tree), so they aren't cancelled with the
+// one making them. They're still limited to the open timeout.
+func (c *Client) detached() (context.Context, context.CancelFunc) {
+	if c.openTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), c.openTimeout)
+}
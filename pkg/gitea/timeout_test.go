@@ -0,0 +1,48 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenTimeoutCancels(t *testing.T) {
+	var inFlight, requests int32
+
+	// a gitea that never answers
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	defer srv.CloseClientConnections()
+
+	c, err := NewClient(srv.URL, "token", "", "", SetOpenTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Open("user/gitea-pages/index.html", ""); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("open: %v, want %v", err, ErrTimeout)
+	}
+
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Fatal("gitea wasn't asked")
+	}
+
+	// the requests to gitea are cancelled, not left hanging
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&inFlight) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests to gitea still in flight", atomic.LoadInt32(&inFlight))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package gitea
+
+import "strings"
+
+// topicNamespace is the prefix of structured topics, e.g. pages:branch=docs.
+const topicNamespace = "pages"
+
+// topicConfig is the pages configuration of a repo given by its topics.
+type topicConfig struct {
+	// limited is set by the gitea-pages topic or any structured topic.
+	limited bool
+	// allowAll is set by the gitea-pages-allowall or pages:allow-all topic.
+	allowAll bool
+	// branch is the branch served by default and the only one allowed
+	// (unless allowAll), set by pages:branch=<branch>.
+	branch string
+	// domains are the custom domains of the site, set by pages:domain=<domain>.
+	domains []string
+	// websiteDomain claims the host of the website of the repo as custom
+	// domain, set by pages:domain=website.
+	websiteDomain bool
+	// auth restricts the site to visitors that can read the repo, set by
+	// pages:auth.
+	auth bool
+	// noHealthIssues opts out of the issues about the health of the site,
+	// set by pages:no-health-issues.
+	noHealthIssues bool
+	// tier is the tier of the site, set by the topic of a topic tier, or
+	// empty for the default tier.
+	tier string
+}
+
+// parseTopics returns the pages configuration of the topics of a repo.
+// Structured topics are written pages:key[=value], or pages.key[.value]
+// as gitea only allows letters, digits, dashes and dots in topics.
+// Unknown keys are ignored, so new keys can be added later.
+func parseTopics(topics []string, names ownerNames) topicConfig {
+	var tc topicConfig
+
+	for _, topic := range topics {
+		switch topic {
+		case names.allowAll:
+			tc.allowAll = true
+			continue
+		case names.pages:
+			tc.limited = true
+			continue
+		}
+
+		if t, ok := names.tiers[topic]; ok {
+			tc.limited = true
+
+			if tc.tier == "" {
+				tc.tier = t.Tier
+				tc.auth = tc.auth || t.Auth
+			}
+
+			continue
+		}
+
+		key, value, ok := parseStructuredTopic(topic)
+		if !ok {
+			continue
+		}
+
+		tc.limited = true
+
+		switch key {
+		case "allow-all":
+			tc.allowAll = true
+		case "branch":
+			tc.branch = value
+		case "domain":
+			if value == websiteDomain {
+				tc.websiteDomain = true
+			} else {
+				tc.domains = append(tc.domains, value)
+			}
+		case "auth":
+			tc.auth = true
+		case "no-health-issues":
+			tc.noHealthIssues = true
+		}
+	}
+
+	if tc.allowAll {
+		tc.limited = true
+	}
+
+	return tc
+}
+
+// parseStructuredTopic splits a structured topic into its key and value.
+func parseStructuredTopic(topic string) (string, string, bool) {
+	if strings.HasPrefix(topic, topicNamespace+":") {
+		key, value, _ := strings.Cut(strings.TrimPrefix(topic, topicNamespace+":"), "=")
+		return key, value, key != ""
+	}
+
+	if strings.HasPrefix(topic, topicNamespace+".") {
+		key, value, _ := strings.Cut(strings.TrimPrefix(topic, topicNamespace+"."), ".")
+		return key, value, key != ""
+	}
+
+	return "", "", false
+}
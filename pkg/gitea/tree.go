@@ -0,0 +1,353 @@
+package gitea
+
+import (
+	"context"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTreeTTL is how long a fetched git tree is used to resolve paths.
+const defaultTreeTTL = time.Minute
+
+// maxTrees is the most git trees the tree cache holds, refs of repos
+// allowing all refs don't grow it further.
+const maxTrees = 10000
+
+// siteTree is the (recursive) git tree of a repo at a ref.
+// Trees of large repos only keep a bloom filter of their paths.
+type siteTree struct {
+	ref     string
+	sha     string
+	entries []treeEntry
+	// files are the sizes of the entries by path.
+	files     map[string]int64
+	filter    *bloomFilter
+	count     int
+	truncated bool
+	expires   time.Time
+
+	redirectsOnce sync.Once
+	redirects     []redirectRule
+
+	linksOnce sync.Once
+	links     map[string]string
+
+	aliases aliasIndex
+	blog    blogIndex
+	search  searchIndex
+	history historyIndex
+	layouts layoutIndex
+}
+
+type treeEntry struct {
+	path string
+	size int64
+	sha  string
+}
+
+// treeCache caches git trees per owner/repo/ref.
+type treeCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	trees map[string]*siteTree
+	// bloomThreshold is the number of files above which only a bloom filter
+	// of the tree is kept (0 always keeps the full tree).
+	bloomThreshold int
+	// history are the last tree shas seen per owner/repo/ref.
+	history map[string][]string
+}
+
+func newTreeCache(ttl time.Duration) *treeCache {
+	return &treeCache{
+		ttl:     ttl,
+		trees:   make(map[string]*siteTree),
+		history: make(map[string][]string),
+	}
+}
+
+func (tc *treeCache) get(key string) *siteTree {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	t, ok := tc.trees[key]
+	if !ok || time.Now().After(t.expires) {
+		// an expired tree is replaced by put, see there
+		return nil
+	}
+
+	return t
+}
+
+// expire makes the trees of owner/repo of all refs expire, they're kept to
+// tell whether the next tree of a ref is a new version.
+func (tc *treeCache) expire(owner, repo string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	prefix := owner + "/" + repo + "@"
+
+	for key, t := range tc.trees {
+		if len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+			t.expires = time.Time{}
+		}
+	}
+}
+
+func (tc *treeCache) put(key string, t *siteTree) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// the pages of an unchanged tree don't need to be read again
+	if old, ok := tc.trees[key]; ok && old.sha != "" && old.sha == t.sha && old != t {
+		old.aliases.mu.Lock()
+		t.aliases.paths, t.aliases.preview = old.aliases.paths, old.aliases.preview
+		old.aliases.mu.Unlock()
+
+		old.blog.mu.Lock()
+		t.blog.built, t.blog.preview, t.blog.config = old.blog.built, old.blog.preview, old.blog.config
+		t.blog.posts, t.blog.template = old.blog.posts, old.blog.template
+		old.blog.mu.Unlock()
+
+		old.search.mu.Lock()
+		t.search.built, t.search.preview, t.search.docs = old.search.built, old.search.preview, old.search.docs
+		old.search.mu.Unlock()
+
+		// the commits are still added to, so they are copied
+		old.history.mu.Lock()
+		for name, commits := range old.history.commits {
+			if t.history.commits == nil {
+				t.history.commits = make(map[string][]Commit)
+			}
+
+			t.history.commits[name] = commits
+		}
+		old.history.mu.Unlock()
+
+		old.layouts.mu.Lock()
+		for name, tmpl := range old.layouts.templates {
+			if t.layouts.templates == nil {
+				t.layouts.templates = make(map[string]*template.Template)
+			}
+
+			t.layouts.templates[name] = tmpl
+		}
+		old.layouts.mu.Unlock()
+	}
+
+	if _, ok := tc.trees[key]; !ok && len(tc.trees) >= maxTrees {
+		tc.evict()
+	}
+
+	t.expires = time.Now().Add(tc.ttl)
+	tc.trees[key] = t
+}
+
+// evict drops the trees that expired longest ago, or else expire soonest,
+// until the cache is a tenth below maxTrees. The history of their refs goes
+// with them.
+func (tc *treeCache) evict() {
+	keys := make([]string, 0, len(tc.trees))
+	for key := range tc.trees {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return tc.trees[keys[i]].expires.Before(tc.trees[keys[j]].expires)
+	})
+
+	for _, key := range keys[:len(keys)-maxTrees*9/10] {
+		delete(tc.trees, key)
+		delete(tc.history, key)
+	}
+}
+
+// SetTreeTTL sets how long the git tree of a ref is cached to resolve paths.
+func SetTreeTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.trees.ttl = ttl
+		return nil
+	}
+}
+
+// SetTreeBloomThreshold only keeps a bloom filter instead of the full git
+// tree for refs with more than n files, to save memory on very large repos.
+func SetTreeBloomThreshold(n int) ClientOption {
+	return func(c *Client) error {
+		c.trees.bloomThreshold = n
+		return nil
+	}
+}
+
+// tree returns the cached git tree of owner/repo at ref, an empty ref is the default branch.
+func (c *Client) tree(ctx context.Context, owner, repo, ref string) (*siteTree, error) {
+	key := owner + "/" + repo + "@" + ref
+
+	if t := c.trees.get(key); t != nil {
+		return t, nil
+	}
+
+	t, err := c.fetchTree(ctx, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.trees.bloomThreshold > 0 && len(t.entries) > c.trees.bloomThreshold {
+		t = t.compact()
+	}
+
+	c.trees.put(key, t)
+	c.prefetchLimitPages(owner, repo, ref, t)
+
+	return t, nil
+}
+
+// fullTree returns the git tree of owner/repo at ref with all its entries.
+func (c *Client) fullTree(ctx context.Context, owner, repo, ref string) (*siteTree, error) {
+	t, err := c.tree(ctx, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.filter == nil {
+		return t, nil
+	}
+
+	return c.fetchTree(ctx, owner, repo, ref)
+}
+
+// fetchTree fetches the git tree of owner/repo at ref from gitea.
+func (c *Client) fetchTree(ctx context.Context, owner, repo, ref string) (*siteTree, error) {
+	treeRef := ref
+	if treeRef == "" {
+		var err error
+
+		treeRef, err = c.defaultBranch(ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, resp, err := c.api(ctx).GetTrees(owner, repo, treeRef, true)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fs.ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	c.observeTree(owner, repo, ref, res.SHA)
+
+	t := &siteTree{
+		ref:       treeRef,
+		sha:       res.SHA,
+		files:     make(map[string]int64),
+		truncated: res.Truncated,
+	}
+
+	for _, e := range res.Entries {
+		if e.Type != "blob" {
+			continue
+		}
+
+		t.entries = append(t.entries, treeEntry{path: e.Path, size: e.Size, sha: e.SHA})
+		t.files[e.Path] = e.Size
+	}
+
+	t.count = len(t.entries)
+
+	return t, nil
+}
+
+// compact returns a copy of the tree that only has a bloom filter of its paths.
+func (t *siteTree) compact() *siteTree {
+	filter := newBloomFilter(len(t.entries))
+
+	for _, e := range t.entries {
+		filter.add(e.path)
+	}
+
+	return &siteTree{
+		ref:       t.ref,
+		sha:       t.sha,
+		filter:    filter,
+		count:     t.count,
+		truncated: t.truncated,
+	}
+}
+
+// exists reports if the file exists in the tree. A truncated tree can't tell
+// and a bloom filter may report a file that doesn't exist.
+func (t *siteTree) exists(name string) (exists, known bool) {
+	if t.filter != nil {
+		if t.filter.has(name) {
+			return true, true
+		}
+
+		return false, !t.truncated
+	}
+
+	if _, ok := t.files[name]; ok {
+		return true, true
+	}
+
+	return false, !t.truncated
+}
+
+// prettyPath resolves name to an existing file by trying name, name.html and
+// the index files of the site below name (name/index.html) in the tree of the
+// site, without fetching any content. If the tree isn't available it falls
+// back to name (or the first index file).
+func (c *Client) prettyPath(s *site, name string) (string, error) {
+	candidates := prettyCandidates(name, s.indexFiles)
+
+	// the file may be committed in another unicode normalization
+	if alt := otherForm(name); alt != "" {
+		candidates = append(candidates, prettyCandidates(alt, s.indexFiles)...)
+	}
+
+	t, err := c.tree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return candidates[0], nil
+	}
+
+	for _, candidate := range candidates {
+		exists, known := t.exists(candidate)
+		if exists {
+			return candidate, nil
+		}
+
+		// we can't resolve on a truncated tree, try the content api
+		if !known {
+			return candidates[0], nil
+		}
+	}
+
+	return "", fs.ErrNotExist
+}
+
+// prettyCandidates returns the files the pretty url path name may be: name,
+// name.html and the index files below name (index.html if there are none).
+func prettyCandidates(name string, indexFiles []string) []string {
+	candidates := []string{}
+
+	if name != "" && !strings.HasSuffix(name, "/") {
+		candidates = append(candidates, name, name+".html")
+	}
+
+	if len(indexFiles) == 0 {
+		indexFiles = defaultIndexFiles
+	}
+
+	for _, index := range indexFiles {
+		candidates = append(candidates, path.Join(name, index))
+	}
+
+	return candidates
+}
@@ -0,0 +1,37 @@
+package gitea
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTreeCacheBounded(t *testing.T) {
+	tc := newTreeCache(time.Hour)
+
+	// the first tree has expired, it's dropped before the others
+	tc.put("owner/repo@expired", &siteTree{})
+	tc.trees["owner/repo@expired"].expires = time.Now().Add(-time.Minute)
+
+	for i := 1; i < 2*maxTrees; i++ {
+		key := "owner/repo@ref" + strconv.Itoa(i)
+		tc.put(key, &siteTree{})
+		tc.history[key] = []string{"sha"}
+	}
+
+	if n := len(tc.trees); n > maxTrees {
+		t.Errorf("%d trees, want at most %d", n, maxTrees)
+	}
+
+	if len(tc.history) > len(tc.trees) {
+		t.Errorf("history of %d refs kept for %d trees", len(tc.history), len(tc.trees))
+	}
+
+	if tc.trees["owner/repo@expired"] != nil {
+		t.Error("expired tree kept")
+	}
+
+	if tc.get("owner/repo@ref"+strconv.Itoa(2*maxTrees-1)) == nil {
+		t.Error("latest tree dropped")
+	}
+}
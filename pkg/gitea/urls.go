@@ -0,0 +1,71 @@
+package gitea
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// SetPagesDomain sets the domain the sites are served below, as
+// <owner>.<domain> for the gitea-pages repo and [<ref>.]<repo>.<owner>.<domain>
+// for the other repos, which their public urls are built with.
+func SetPagesDomain(domain string) ClientOption {
+	return func(c *Client) error {
+		c.pagesDomain = strings.Trim(NormalizeHost(domain), ".")
+		return nil
+	}
+}
+
+// PublicURL returns the https url of the path p (unescaped) on host, with
+// the query rawQuery if it isn't empty.
+func PublicURL(host, p, rawQuery string) string {
+	u := url.URL{Scheme: "https", Host: host, Path: "/" + strings.TrimPrefix(p, "/"), RawQuery: rawQuery}
+
+	return u.String()
+}
+
+// SiteURL returns the public url of the path p (unescaped, "/" for the root)
+// of the site of owner/repo (the gitea-pages repo if repo is empty) at ref
+// ("" for its default version). Redirects, previews, deploy statuses and
+// layouts all get the urls of sites from here. The default version of a site
+// with a custom domain is on its canonical domain, the others are below the
+// pages domain; the url is "" if there's none.
+func (c *Client) SiteURL(owner, repo, ref, p string) string {
+	if repo == "" {
+		repo = c.PagesRepo(owner)
+	}
+
+	s, err := c.resolve(context.Background(), owner+"/"+repo, ref)
+	if err != nil {
+		// the site isn't published (yet), it has no custom domain
+		s = &site{owner: owner, repo: repo, urlRef: ref}
+	}
+
+	return c.siteURL(s, p)
+}
+
+// siteURL returns the public url of the path p of the site s, see SiteURL.
+func (c *Client) siteURL(s *site, p string) string {
+	if s.urlRef == "" && c.domains != nil {
+		if domain, err := c.siteCanonicalDomain(s); err == nil && domain != "" {
+			return PublicURL(domain, p, "")
+		}
+	}
+
+	if c.pagesDomain == "" {
+		return ""
+	}
+
+	host := strings.ToLower(s.owner) + "." + c.pagesDomain
+
+	// the gitea-pages repo serves a single version
+	if s.repo != c.PagesRepo(s.owner) {
+		host = strings.ToLower(s.repo) + "." + host
+
+		if s.urlRef != "" {
+			host = strings.ToLower(s.urlRef) + "." + host
+		}
+	}
+
+	return PublicURL(host, p, "")
+}
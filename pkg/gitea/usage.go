@@ -0,0 +1,144 @@
+package gitea
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// maxUsagePages is the number of pages of an owner counted in a period, the
+// requests of other pages only count in its totals.
+const maxUsagePages = 10000
+
+// UsageReport is the usage of the sites of every owner over a period, for
+// chargeback or showback.
+type UsageReport struct {
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Owners []OwnerUsage `json:"owners"`
+}
+
+// OwnerUsage is the usage of the sites of an owner.
+type OwnerUsage struct {
+	Owner    string `json:"owner"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+	// TopPages are the most requested pages, most requested first.
+	TopPages []PageUsage `json:"top_pages,omitempty"`
+}
+
+// PageUsage is the number of requests of a page of a repo.
+type PageUsage struct {
+	Repo     string `json:"repo"`
+	Path     string `json:"path"`
+	Requests int64  `json:"requests"`
+}
+
+// usagePeriod counts the usage of the owners since from.
+type usagePeriod struct {
+	from   time.Time
+	owners map[string]*ownerUsage
+}
+
+type ownerUsage struct {
+	requests, bytes int64
+	pages           map[PageUsage]int64
+}
+
+func newUsagePeriod() *usagePeriod {
+	return &usagePeriod{from: time.Now(), owners: make(map[string]*ownerUsage)}
+}
+
+// served records a request of the file path of repo of owner.
+func (up *usagePeriod) served(owner, repo, path string, size int) {
+	ou, ok := up.owners[owner]
+	if !ok {
+		ou = &ownerUsage{pages: make(map[PageUsage]int64)}
+		up.owners[owner] = ou
+	}
+
+	ou.requests++
+	ou.bytes += int64(size)
+
+	page := PageUsage{Repo: repo, Path: path}
+	if _, ok := ou.pages[page]; ok || len(ou.pages) < maxUsagePages {
+		ou.pages[page]++
+	}
+}
+
+// report returns the usage of the period until to, with the top most
+// requested pages of every owner, ordered by owner.
+func (up *usagePeriod) report(to time.Time, top int) UsageReport {
+	r := UsageReport{From: up.from, To: to, Owners: make([]OwnerUsage, 0, len(up.owners))}
+
+	for owner, ou := range up.owners {
+		usage := OwnerUsage{Owner: owner, Requests: ou.requests, Bytes: ou.bytes}
+
+		pages := make([]PageUsage, 0, len(ou.pages))
+		for page, requests := range ou.pages {
+			page.Requests = requests
+			pages = append(pages, page)
+		}
+
+		sort.Slice(pages, func(i, j int) bool {
+			a, b := pages[i], pages[j]
+			if a.Requests != b.Requests {
+				return a.Requests > b.Requests
+			}
+
+			return a.Repo+"/"+a.Path < b.Repo+"/"+b.Path
+		})
+
+		if len(pages) > top {
+			pages = pages[:top]
+		}
+
+		if len(pages) > 0 {
+			usage.TopPages = pages
+		}
+
+		r.Owners = append(r.Owners, usage)
+	}
+
+	sort.Slice(r.Owners, func(i, j int) bool { return r.Owners[i].Owner < r.Owners[j].Owner })
+
+	return r
+}
+
+// TakeUsageReport returns the usage of every owner since the last report (or
+// since the client was created), with its top most requested pages, and
+// starts the next period. The usage is counted per instance, like the
+// statistics of the inventory.
+func (c *Client) TakeUsageReport(top int) UsageReport {
+	now := time.Now()
+
+	c.inventory.mu.Lock()
+	period := c.inventory.usage
+	c.inventory.usage = newUsagePeriod()
+	c.inventory.usage.from = now
+	c.inventory.mu.Unlock()
+
+	return period.report(now, top)
+}
+
+// SaveUsageReport writes the usage of every owner of r to the store, each
+// under usage/<owner>/<from>_<instance>.
+func (c *Client) SaveUsageReport(r UsageReport) error {
+	if c.store == nil {
+		return nil
+	}
+
+	for _, usage := range r.Owners {
+		value, err := json.Marshal(UsageReport{From: r.From, To: r.To, Owners: []OwnerUsage{usage}})
+		if err != nil {
+			return err
+		}
+
+		key := usageKeyPrefix + usage.Owner + "/" + r.From.UTC().Format("20060102T150405Z") + "_" + instanceName()
+		if err := c.store.Store(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
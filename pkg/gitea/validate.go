@@ -0,0 +1,74 @@
+package gitea
+
+import (
+	"strings"
+)
+
+// validOwnerRepo reports if s is a valid gitea owner or repo name: ascii
+// letters, digits, dash, underscore and dots that don't start or end it.
+func validOwnerRepo(s string) bool {
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		case b == '-', b == '_', b == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// validFilepath reports if filepath is safe to use in gitea API urls: it
+// can't climb out of the repo with dot segments or contain control characters
+// or backslashes.
+func validFilepath(filepath string) bool {
+	for _, segment := range strings.Split(filepath, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	for i := 0; i < len(filepath); i++ {
+		if b := filepath[i]; b < 0x20 || b == 0x7f || b == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validRef reports if ref is a valid git ref name (following the rules of
+// git check-ref-format) or empty for the default ref; refs are sent to gitea
+// unescaped in some API urls.
+func validRef(ref string) bool {
+	if ref == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "-") ||
+		strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".") || strings.HasSuffix(ref, ".lock") ||
+		strings.Contains(ref, "..") || strings.Contains(ref, "//") || strings.Contains(ref, "@{") ||
+		strings.Contains(ref, "/.") || strings.HasPrefix(ref, ".") {
+		return false
+	}
+
+	for i := 0; i < len(ref); i++ {
+		if b := ref[i]; b <= 0x20 || b == 0x7f || strings.IndexByte("~^:?*[\\#%", b) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validName reports if the owner, repo, filepath and ref of a request are
+// safe to embed in gitea API urls. The repo may be a directory of the pages
+// repo, so it only needs to be a valid path.
+func validName(owner, repo, filepath, ref string) bool {
+	return validOwnerRepo(owner) && validFilepath(repo+"/"+filepath) && validRef(ref)
+}
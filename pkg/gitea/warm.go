@@ -0,0 +1,119 @@
+package gitea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// EventSiteWarmed is emitted when the files of a site are fetched into the cache.
+const EventSiteWarmed = "site_warmed"
+
+// defaultWarmConcurrency is the number of files fetched at once when warming.
+const defaultWarmConcurrency = 4
+
+// ErrNoCache is returned when warming a site without a cache.
+var ErrNoCache = errors.New("no cache configured")
+
+// WarmSite fetches all files of the site of owner/repo at ref (the default
+// version if empty) into the cache, concurrency at once (4 if 0), and
+// returns the number of files fetched. Files that are streamed, blocked or
+// embargoed aren't cached and are left out.
+func (c *Client) WarmSite(owner, repo, ref string, concurrency int) (int, error) {
+	if c.cache == nil {
+		return 0, ErrNoCache
+	}
+
+	if concurrency <= 0 {
+		concurrency = defaultWarmConcurrency
+	}
+
+	s, err := c.resolve(context.Background(), owner+"/"+repo, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	// a cached tree may only be a bloom filter, warming needs the paths
+	t, err := c.fetchTree(s.ctx, s.owner, s.repo, s.ref)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		g      errgroup.Group
+		warmed = make([]bool, len(t.entries))
+	)
+
+	g.SetLimit(concurrency)
+
+	for i, e := range t.entries {
+		if s.embargo(e.path, nil) != nil || c.checkExtension(s, e.path) != nil ||
+			c.streamable(e.path) && e.size > c.streamThreshold {
+			continue
+		}
+
+		if c.relieveMemory() {
+			break
+		}
+
+		i, e := i, e
+
+		g.Go(func() error {
+			_, err := c.fetchRaw(s.ctx, s.owner, s.repo, e.path, s.ref, nil)
+
+			switch {
+			case err == nil:
+				warmed[i] = true
+			case errors.Is(err, fs.ErrNotExist), errors.Is(err, ErrSiteLimit):
+			default:
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+
+	n := 0
+
+	for _, ok := range warmed {
+		if ok {
+			n++
+		}
+	}
+
+	if err != nil {
+		c.logger.Warn("warming site failed", zap.String("owner", s.owner), zap.String("repo", s.repo),
+			zap.String("ref", s.ref), zap.Int("files", n), zap.Error(err))
+
+		return n, err
+	}
+
+	c.Emit(EventSiteWarmed, map[string]any{
+		"owner": s.owner,
+		"repo":  s.repo,
+		"ref":   t.ref,
+		"files": n,
+	})
+
+	return n, nil
+}
+
+// RefreshSite purges everything cached of owner/repo and fetches the files
+// of its site at ref into the cache again, like WarmSite. The purge has the
+// same id on all nodes refreshing the site in the same minute, so it's
+// applied once per node.
+func (c *Client) RefreshSite(owner, repo, ref string, concurrency int) (int, error) {
+	p := NewPurge(owner, repo, PurgeReasonRefresh)
+	p.ID = fmt.Sprintf("refresh:%s/%s@%s:%d", owner, repo, ref, p.Issued.Truncate(time.Minute).Unix())
+
+	c.Purge(p)
+
+	return c.WarmSite(owner, repo, ref, concurrency)
+}
@@ -0,0 +1,49 @@
+package gitea
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// webAppTypes are the content types of files that browsers check strictly,
+// so they must not depend on the MIME types known to the host or sniffing.
+var webAppTypes = map[string]string{
+	".wasm":        "application/wasm",
+	".mjs":         "text/javascript; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".webmanifest": "application/manifest+json",
+}
+
+// untransformed reports if name is a web app module (.wasm, .mjs) that is
+// always served byte for byte, never rendered or rewritten.
+func untransformed(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".wasm", ".mjs":
+		return true
+	}
+
+	return false
+}
+
+// webAppType returns the content type of a web app file, or "" for others.
+func webAppType(name string) string {
+	return webAppTypes[strings.ToLower(path.Ext(name))]
+}
+
+// webAppHeaders returns the headers of name for the site, which enable
+// cross-origin isolation (needed for SharedArrayBuffer) if configured.
+func webAppHeaders(s *site, name string) http.Header {
+	h := http.Header{}
+
+	if s.crossOriginIsolated {
+		h.Set("Cross-Origin-Embedder-Policy", "require-corp")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+	}
+
+	if untransformed(name) {
+		h.Set("Cache-Control", "no-transform")
+	}
+
+	return h
+}
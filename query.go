@@ -0,0 +1,72 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// How the query parameters of file requests that don't change the response
+// are handled, see Middleware.QueryPolicy.
+const (
+	queryIgnore   = "ignore"
+	queryRedirect = "redirect"
+	queryReject   = "reject"
+)
+
+// fileQueryParams are the query parameters that change the response of file
+// requests: the ref served and the page JSON. Files are cached by site, path
+// and ref, whatever the query.
+var fileQueryParams = []string{"ref", "format"}
+
+// validQueryPolicy reports if p is a query policy.
+func validQueryPolicy(p string) bool {
+	switch p {
+	case "", queryIgnore, queryRedirect, queryReject:
+		return true
+	}
+
+	return false
+}
+
+// strayQuery returns the query of the file request r without the parameters
+// that don't change the response (and aren't in QueryParams), and whether it
+// had any.
+func (m Middleware) strayQuery(r *http.Request) (url.Values, bool) {
+	query := r.URL.Query()
+	kept := make(url.Values)
+
+	for _, params := range [][]string{fileQueryParams, m.QueryParams} {
+		for _, p := range params {
+			if v, ok := query[p]; ok {
+				kept[p] = v
+			}
+		}
+	}
+
+	return kept, len(kept) != len(query)
+}
+
+// checkQuery applies the query policy to the file request r. It returns the
+// url without the stray parameters the request is redirected to, or "".
+func (m Middleware) checkQuery(r *http.Request) (string, error) {
+	if m.QueryPolicy == "" || m.QueryPolicy == queryIgnore || r.URL.RawQuery == "" {
+		return "", nil
+	}
+
+	kept, stray := m.strayQuery(r)
+	if !stray {
+		return "", nil
+	}
+
+	if m.QueryPolicy == queryReject {
+		return "", caddyhttp.Error(http.StatusBadRequest, errors.New("unexpected query parameters"))
+	}
+
+	u := *r.URL
+	u.RawQuery = kept.Encode()
+
+	return u.RequestURI(), nil
+}
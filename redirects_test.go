@@ -0,0 +1,112 @@
+package gitea
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+)
+
+func TestRedirectRules(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	srv.AddRepo(&giteatest.Repo{
+		Owner:  "user",
+		Name:   "gitea-pages",
+		Topics: []string{"gitea-pages"},
+		Branches: map[string]map[string]string{"gitea-pages": {
+			"_redirects": `# from            to                  status  conditions
+/old-page         /new-page
+/blog/*           /posts/:splat       302
+/news/:year/:slug /articles/:year/:slug
+/                 /de/                302!    Language=de
+/                 /fr/                302!    Language=fr Country=FR,BE
+/api/*            /data/:splat.json   200
+/exists.html      /elsewhere.html
+/landing/         /landing-b/         302!    Bucket=0-49
+`,
+			"index.html":         "<p>home</p>",
+			"exists.html":        "<p>exists</p>",
+			"data/x.json":        `{"x":1}`,
+			"landing/index.html": "<p>landing</p>",
+		}},
+	})
+
+	m := newTestHandler(t, srv)
+	m.geoip = HeaderGeoIP{}
+
+	tests := []struct {
+		name     string
+		path     string
+		language string
+		country  string
+
+		status   int
+		location string
+		body     string
+	}{
+		{name: "permanent by default", path: "/old-page", status: http.StatusMovedPermanently, location: "/new-page"},
+		{name: "splat", path: "/blog/2024/a.html", status: http.StatusFound, location: "/posts/2024/a.html"},
+		{name: "placeholders", path: "/news/2024/hello", status: http.StatusMovedPermanently, location: "/articles/2024/hello"},
+		{name: "language", path: "/", language: "de-AT,en;q=0.5", status: http.StatusFound, location: "/de/"},
+		{name: "language and country", path: "/", language: "fr", country: "BE", status: http.StatusFound, location: "/fr/"},
+		{name: "language of another country", path: "/", language: "fr", country: "CA", status: http.StatusOK, body: "<p>home</p>"},
+		{name: "other language", path: "/", language: "en", status: http.StatusOK, body: "<p>home</p>"},
+		{name: "rewrite", path: "/api/x", status: http.StatusOK, body: `{"x":1}`},
+		{name: "existing file", path: "/exists.html", status: http.StatusOK, body: "<p>exists</p>"},
+	}
+
+	for _, tt := range tests {
+		r := siteRequest("user", tt.path)
+		if tt.language != "" {
+			r.Header.Set("Accept-Language", tt.language)
+		}
+
+		if tt.country != "" {
+			r.Header.Set("CF-IPCountry", tt.country)
+		}
+
+		resp := serve(m, r)
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != tt.status || resp.Header.Get("Location") != tt.location {
+			t.Errorf("%s: %s to %q, want %d to %q", tt.name, resp.Status, resp.Header.Get("Location"), tt.status, tt.location)
+		}
+
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.body)
+		}
+	}
+
+	// the forced rule of the bucket applies to the existing page, for half
+	// of the visitors
+	m.BucketHeader = true
+	redirected := 0
+
+	for i := 0; i < 50; i++ {
+		r := siteRequest("user", "/landing/")
+		r.RemoteAddr = "192.0.2." + strconv.Itoa(i) + ":1234"
+
+		resp := serve(m, r)
+
+		bucket, err := strconv.Atoi(resp.Header.Get(bucketHeader))
+		if err != nil {
+			t.Fatalf("bucket header %q", resp.Header.Get(bucketHeader))
+		}
+
+		if want := bucket < 50; (resp.StatusCode == http.StatusFound) != want {
+			t.Errorf("bucket %d: %s to %q", bucket, resp.Status, resp.Header.Get("Location"))
+		}
+
+		if resp.StatusCode == http.StatusFound {
+			redirected++
+		}
+	}
+
+	if redirected == 0 || redirected == 50 {
+		t.Errorf("%d of 50 visitors redirected by bucket", redirected)
+	}
+}
@@ -0,0 +1,237 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Refresh refreshes the cached files of sites on a schedule, e.g. at night,
+// so they aren't fetched from gitea when they expire during peak traffic.
+type Refresh struct {
+	// Windows are the schedules and the sites refreshed on them.
+	Windows []RefreshWindow `json:"windows,omitempty"`
+	// Concurrency is the number of files fetched at once, defaults to 4.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// RefreshWindow refreshes sites whenever its schedule matches.
+type RefreshWindow struct {
+	// Schedule is a cron spec (minute hour day-of-month month day-of-week) in
+	// local time, e.g. "0 3 * * *" for every night at 3.
+	Schedule string `json:"schedule"`
+	// Sites are the refreshed sites, owner/repo or owner/repo@ref.
+	Sites []string `json:"sites"`
+
+	schedule *cronSchedule
+}
+
+func (r *Refresh) provision() error {
+	for i := range r.Windows {
+		w := &r.Windows[i]
+
+		var err error
+		if w.schedule, err = parseCron(w.Schedule); err != nil {
+			return err
+		}
+
+		for _, site := range w.Sites {
+			if _, _, _, ok := parseRefreshSite(site); !ok {
+				return fmt.Errorf("site %q must be owner/repo or owner/repo@ref", site)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseRefreshSite splits a site of a refresh window into owner, repo and ref.
+func parseRefreshSite(site string) (string, string, string, bool) {
+	name, ref, _ := strings.Cut(site, "@")
+	owner, repo, ok := strings.Cut(name, "/")
+
+	return owner, repo, ref, ok && owner != "" && repo != "" && !strings.Contains(repo, "/")
+}
+
+// run refreshes the sites of c whenever the schedule of their window matches,
+// until ctx is done.
+func (r *Refresh) run(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case t := <-timer.C:
+			for _, w := range r.Windows {
+				if w.schedule.matches(t) {
+					// a large window may take longer than a minute
+					go r.refresh(c, w.Sites, logger)
+				}
+			}
+		}
+	}
+}
+
+// refresh refreshes sites one after another.
+func (r *Refresh) refresh(c *gitea.Client, sites []string, logger *zap.Logger) {
+	for _, site := range sites {
+		owner, repo, ref, _ := parseRefreshSite(site)
+
+		n, err := c.RefreshSite(owner, repo, ref, r.Concurrency)
+		if err != nil {
+			logger.Warn("refreshing site failed", zap.String("site", site), zap.Error(err))
+			continue
+		}
+
+		logger.Info("refreshed site", zap.String("site", site), zap.Int("files", n))
+	}
+}
+
+// UnmarshalCaddyfile unmarshals the refresh block of a Caddyfile.
+//
+//	refresh {
+//		at "0 3 * * *" yourorg/docs yourorg/www@main
+//		at "*/30 8-18 * * 1-5" yourorg/status
+//		concurrency 8
+//	}
+func (r *Refresh) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "at":
+			var w RefreshWindow
+			if !d.Args(&w.Schedule) {
+				return d.ArgErr()
+			}
+
+			w.Sites = d.RemainingArgs()
+			if len(w.Sites) == 0 {
+				return d.ArgErr()
+			}
+
+			r.Windows = append(r.Windows, w)
+		case "concurrency":
+			if err := parseIntArg(d, &r.Concurrency); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unknown refresh option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// cronSchedule is a parsed cron spec, a bit set of the matching values of
+// each field.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches either day field if both are restricted, as in cron
+	domAny, dowAny bool
+}
+
+// cronFields are the bounds of the fields of a cron spec, 7 is sunday too.
+var cronFields = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron parses a cron spec of 5 fields, each * or a list of values,
+// ranges (1-5) and steps (*/15, 0-30/10).
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q must have 5 fields (minute hour day-of-month month day-of-week)", spec)
+	}
+
+	var bits [5]uint64
+
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i][0], cronFields[i][1]); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+	}
+
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, lowest, highest int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+
+		from, to := lowest, highest
+
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+
+			switch {
+			case isRange:
+				if to, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			case !hasStep:
+				to = from
+			}
+		}
+
+		n := 1
+
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		if from < lowest || to > highest || from > to {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, lowest, highest)
+		}
+
+		for v := from; v <= to; v += n {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// matches reports if the schedule matches the minute of t.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
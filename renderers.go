@@ -0,0 +1,75 @@
+package gitea
+
+import (
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(MarkdownRenderer{})
+}
+
+// Renderer is implemented by the modules in the http.handlers.gitea.renderers
+// namespace, which render files of a type (e.g. asciidoc) into pages.
+type Renderer interface {
+	gitea.Renderer
+
+	// Types returns the file extensions (".adoc") and MIME types
+	// ("text/asciidoc") that are rendered.
+	Types() []string
+}
+
+// MarkdownRenderer renders markdown files into html.
+type MarkdownRenderer struct {
+	// Extensions are the extensions of markdown files, defaults to .md.
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (MarkdownRenderer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.renderers.markdown",
+		New: func() caddy.Module { return new(MarkdownRenderer) },
+	}
+}
+
+// Render implements gitea.Renderer.
+func (MarkdownRenderer) Render(name string, content []byte) ([]byte, error) {
+	return gitea.MarkdownRenderer.Render(name, content)
+}
+
+// Types implements Renderer.
+func (r MarkdownRenderer) Types() []string {
+	if len(r.Extensions) == 0 {
+		return []string{".md"}
+	}
+
+	return r.Extensions
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	renderer markdown {
+//		extensions .md .markdown
+//	}
+func (r *MarkdownRenderer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "extensions":
+				r.Extensions = append(r.Extensions, d.RemainingArgs()...)
+			default:
+				return d.Errf("unknown markdown renderer option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ Renderer              = (*MarkdownRenderer)(nil)
+	_ caddyfile.Unmarshaler = (*MarkdownRenderer)(nil)
+)
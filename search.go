@@ -0,0 +1,108 @@
+package gitea
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// searchPath is the path suffix on which a site is searched.
+	searchPath = "/.gitea-pages/search"
+	// openSearchPath is the path suffix of the OpenSearch description of a site.
+	openSearchPath = "/.gitea-pages/opensearch.xml"
+)
+
+// searchTemplate renders the search results of browsers.
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Query}} - search</title>
+</head>
+<body>
+<form><input type="search" name="q" value="{{.Query}}"></form>
+<ul>
+{{range .Results}}<li><a href="{{$.Root}}{{.Path}}">{{.Title}}</a><p>{{.Snippet}}</p></li>
+{{else}}<li>Nothing found.</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// openSearchDescription is an OpenSearch description document.
+type openSearchDescription struct {
+	XMLName       xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string   `xml:"ShortName"`
+	Description   string   `xml:"Description"`
+	InputEncoding string   `xml:"InputEncoding"`
+	URLs          []openSearchURL
+}
+
+type openSearchURL struct {
+	XMLName  xml.Name `xml:"Url"`
+	Type     string   `xml:"type,attr"`
+	Method   string   `xml:"method,attr"`
+	Template string   `xml:"template,attr"`
+}
+
+// serveSearch serves the pages of the site fp at ref matching the query q,
+// as json if asked for and as html page otherwise. root is the url path of
+// the site.
+func (m Middleware) serveSearch(w http.ResponseWriter, r *http.Request, fp, ref, root string) error {
+	query := r.URL.Query().Get("q")
+
+	results, err := m.Client.Search(r.Context(), fp, ref, query)
+	if err != nil {
+		return m.httpError(err)
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(results)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	return searchTemplate.Execute(w, map[string]any{
+		"Query":   query,
+		"Results": results,
+		"Root":    strings.TrimSuffix(root, "/"),
+	})
+}
+
+// serveOpenSearch serves the OpenSearch description of the site at the url
+// path root, so browsers can add it as search engine.
+func (m Middleware) serveOpenSearch(w http.ResponseWriter, r *http.Request, root string) error {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	searchURL := scheme + "://" + r.Host + strings.TrimSuffix(root, "/") + searchPath + "?q={searchTerms}"
+
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		searchURL += "&ref=" + url.QueryEscape(ref)
+	}
+
+	desc := openSearchDescription{
+		ShortName:     r.Host,
+		Description:   "Search " + r.Host + strings.TrimSuffix(root, "/"),
+		InputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: "text/html", Method: "get", Template: searchURL},
+			{Type: "application/json", Method: "get", Template: searchURL + "&format=json"},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(desc)
+}
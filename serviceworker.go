@@ -0,0 +1,43 @@
+package gitea
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// errPreviewServiceWorker is returned for service worker scripts of previews.
+var errPreviewServiceWorker = errors.New("service workers aren't served from previews")
+
+// isServiceWorkerScript reports if the browser fetches r to register a service worker.
+func isServiceWorkerScript(r *http.Request) bool {
+	return r.Header.Get("Service-Worker") == "script"
+}
+
+// checkServiceWorker refuses service worker scripts of another ref than the
+// default one (branches and pull request previews), so a preview can't take
+// over the scope of the site on its origin.
+func (m Middleware) checkServiceWorker(r *http.Request, ref string) error {
+	if !m.SafeServiceWorkers || !isServiceWorkerScript(r) || ref == "" {
+		return nil
+	}
+
+	return caddyhttp.Error(http.StatusForbidden, errPreviewServiceWorker)
+}
+
+// limitServiceWorkerScope limits the scope of a service worker script to its
+// directory, whatever headers its site sets.
+func (m Middleware) limitServiceWorkerScope(w http.ResponseWriter, r *http.Request) {
+	if !m.SafeServiceWorkers || !isServiceWorkerScript(r) {
+		return
+	}
+
+	scope := path.Dir(r.URL.Path)
+	if scope != "/" {
+		scope += "/"
+	}
+
+	w.Header().Set("Service-Worker-Allowed", scope)
+}
@@ -0,0 +1,222 @@
+package gitea
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(CaddyStore{})
+	caddy.RegisterModule(FileStore{})
+	caddy.RegisterModule(RedisStore{})
+}
+
+// stateSaveInterval is how often the statistics of the inventory are saved
+// to the store.
+const stateSaveInterval = time.Minute
+
+// The stores are modules in the http.handlers.gitea.store namespace
+// implementing gitea.Store, other storage can be plugged in the same way.
+
+// CaddyStore keeps the state in the storage of caddy (where it keeps its
+// certificates), below the gitea-pages prefix. The state is saved while the
+// config unloads, so it doesn't use the context of the module.
+type CaddyStore struct {
+	storage interface {
+		Load(ctx context.Context, key string) ([]byte, error)
+		Store(ctx context.Context, key string, value []byte) error
+		Delete(ctx context.Context, key string) error
+	}
+}
+
+// CaddyModule returns the Caddy module information.
+func (CaddyStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.store.caddy",
+		New: func() caddy.Module { return new(CaddyStore) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (s *CaddyStore) Provision(ctx caddy.Context) error {
+	s.storage = ctx.Storage()
+
+	return nil
+}
+
+// Load implements gitea.Store.
+func (s *CaddyStore) Load(key string) ([]byte, error) {
+	return s.storage.Load(context.Background(), "gitea-pages/"+key)
+}
+
+// Store implements gitea.Store.
+func (s *CaddyStore) Store(key string, value []byte) error {
+	return s.storage.Store(context.Background(), "gitea-pages/"+key, value)
+}
+
+// Delete implements gitea.Store.
+func (s *CaddyStore) Delete(key string) error {
+	return s.storage.Delete(context.Background(), "gitea-pages/"+key)
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	store caddy
+func (s *CaddyStore) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			return d.Errf("unknown caddy store option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// FileStore keeps the state in files of a directory.
+type FileStore struct {
+	// Dir is the directory of the state, defaults to gitea-pages in the data
+	// directory of caddy.
+	Dir string `json:"dir,omitempty"`
+
+	*gitea.FileStore
+}
+
+// CaddyModule returns the Caddy module information.
+func (FileStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.store.file",
+		New: func() caddy.Module { return new(FileStore) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (s *FileStore) Provision(ctx caddy.Context) error {
+	if s.Dir == "" {
+		s.Dir = filepath.Join(caddy.AppDataDir(), "gitea-pages")
+	}
+
+	var err error
+	s.FileStore, err = gitea.NewFileStore(s.Dir)
+
+	return err
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	store file {
+//		dir /var/lib/caddy-gitea
+//	}
+func (s *FileStore) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "dir":
+				if !d.Args(&s.Dir) {
+					return d.ArgErr()
+				}
+			default:
+				return d.Errf("unknown file store option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// RedisStore keeps the state in redis.
+type RedisStore struct {
+	// Address of the redis server, defaults to localhost:6379.
+	Address  string `json:"address,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+
+	*gitea.RedisStore
+}
+
+// CaddyModule returns the Caddy module information.
+func (RedisStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gitea.store.redis",
+		New: func() caddy.Module { return new(RedisStore) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (s *RedisStore) Provision(ctx caddy.Context) error {
+	if s.Address == "" {
+		s.Address = "localhost:6379"
+	}
+
+	s.RedisStore = gitea.NewRedisStore(s.Address, s.Password, s.DB)
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals a Caddyfile.
+//
+//	store redis {
+//		address localhost:6379
+//		password <password>
+//		db 0
+//	}
+func (s *RedisStore) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for n := d.Nesting(); d.NextBlock(n); {
+			switch d.Val() {
+			case "address":
+				if !d.Args(&s.Address) {
+					return d.ArgErr()
+				}
+			case "password":
+				if !d.Args(&s.Password) {
+					return d.ArgErr()
+				}
+			case "db":
+				if err := parseIntArg(d, &s.DB); err != nil {
+					return err
+				}
+			default:
+				return d.Errf("unknown redis store option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// saveState saves the statistics of the inventory to the store every
+// stateSaveInterval until ctx is done.
+func saveState(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.SaveState(); err != nil {
+				logger.Warn("saving state failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Interface guards
+var (
+	_ gitea.Store           = (*CaddyStore)(nil)
+	_ gitea.Store           = (*FileStore)(nil)
+	_ gitea.Store           = (*RedisStore)(nil)
+	_ caddy.Provisioner     = (*CaddyStore)(nil)
+	_ caddy.Provisioner     = (*FileStore)(nil)
+	_ caddy.Provisioner     = (*RedisStore)(nil)
+	_ caddyfile.Unmarshaler = (*CaddyStore)(nil)
+	_ caddyfile.Unmarshaler = (*FileStore)(nil)
+	_ caddyfile.Unmarshaler = (*RedisStore)(nil)
+)
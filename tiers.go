@@ -0,0 +1,56 @@
+package gitea
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// tierVar is the request variable holding the tier of the site, e.g. for the
+// access log as {http.vars.gitea_tier}.
+const tierVar = "gitea_tier"
+
+// checkTiers returns an error for served tiers that aren't the default tier
+// or the tier of a topic tier.
+func checkTiers(tiers []string, topicTiers []gitea.TopicTier) error {
+	for _, tier := range tiers {
+		known := tier == gitea.DefaultTier
+
+		for _, t := range topicTiers {
+			known = known || t.Tier == tier
+		}
+
+		if !known {
+			return fmt.Errorf("unknown tier %q, it has no topic_tier", tier)
+		}
+	}
+
+	return nil
+}
+
+// checkTier sets the tier of the site fp at ref as request variable and
+// returns a not found error if the handler doesn't serve it.
+func (m Middleware) checkTier(r *http.Request, fp, ref string) error {
+	info, err := m.Client.RepoInfo(r.Context(), fp, ref)
+	if err != nil {
+		// errors are handled when serving the file
+		return nil
+	}
+
+	caddyhttp.SetVar(r.Context(), tierVar, info.Tier)
+
+	if len(m.Tiers) == 0 {
+		return nil
+	}
+
+	for _, tier := range m.Tiers {
+		if tier == info.Tier {
+			return nil
+		}
+	}
+
+	// like for private repos we don't tell the site exists
+	return caddyhttp.Error(http.StatusNotFound, nil)
+}
@@ -0,0 +1,90 @@
+package gitea
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/42wim/caddy-gitea/pkg/gitea/giteatest"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestTopicTiers(t *testing.T) {
+	srv := giteatest.NewServer()
+	defer srv.Close()
+
+	for owner, topics := range map[string][]string{
+		"public":   {"gitea-pages"},
+		"team":     {"gitea-pages-internal"},
+		"both":     {"gitea-pages-lab", "gitea-pages-internal"},
+		"optedout": {"docs"},
+		"staff":    {"gitea-pages-staff"},
+	} {
+		srv.AddRepo(&giteatest.Repo{
+			Owner:    owner,
+			Name:     "gitea-pages",
+			Topics:   topics,
+			Branches: map[string]map[string]string{"gitea-pages": {"index.html": "<p>" + owner + "</p>"}},
+		})
+	}
+
+	topicTiers := []gitea.TopicTier{
+		{Topic: "gitea-pages-internal", Tier: "internal"},
+		{Topic: "gitea-pages-lab", Tier: "lab"},
+		// the sites of tiers with auth are private, refused without auth
+		{Topic: "gitea-pages-staff", Tier: "staff", Auth: true},
+	}
+
+	tests := []struct {
+		tiers []string
+		// the tier of the served sites by owner, "" if it isn't found
+		served map[string]string
+	}{
+		{
+			served: map[string]string{"public": gitea.DefaultTier, "team": "internal", "both": "lab"},
+		},
+		{
+			tiers:  []string{gitea.DefaultTier},
+			served: map[string]string{"public": gitea.DefaultTier},
+		},
+		{
+			tiers:  []string{gitea.DefaultTier, "internal"},
+			served: map[string]string{"public": gitea.DefaultTier, "team": "internal"},
+		},
+	}
+
+	for _, tt := range tests {
+		m := newTestHandler(t, srv, gitea.SetTopicTiers(topicTiers))
+		m.TopicTiers, m.Tiers = topicTiers, tt.tiers
+		m.ForbidPrivate = true
+
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, owner := range []string{"public", "team", "both", "optedout", "staff"} {
+			vars := make(map[string]any)
+			r := siteRequest(owner, "/")
+			r = r.WithContext(context.WithValue(r.Context(), caddyhttp.VarsCtxKey, vars))
+
+			resp := serve(m, r)
+
+			tier, ok := tt.served[owner]
+			if (resp.StatusCode == http.StatusOK) != ok {
+				t.Errorf("tiers %v: %s: %s", tt.tiers, owner, resp.Status)
+			}
+
+			if ok && vars[tierVar] != tier {
+				t.Errorf("tiers %v: %s in tier %v, want %s", tt.tiers, owner, vars[tierVar], tier)
+			}
+		}
+	}
+
+	m := newTestHandler(t, srv, gitea.SetTopicTiers(topicTiers))
+	m.TopicTiers, m.Tiers = topicTiers, []string{"external"}
+
+	if err := m.Validate(); err == nil {
+		t.Error("tier without topic tier validated")
+	}
+}
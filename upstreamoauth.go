@@ -0,0 +1,81 @@
+package gitea
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// UpstreamOAuth2 is the OAuth2 application of the service account the
+// requests to the gitea API are authenticated with, instead of a personal
+// access token: its access tokens are got and refreshed as they're needed.
+type UpstreamOAuth2 struct {
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// RefreshToken is the refresh token of the first authorization of the
+	// application by the service account, gitea has no client credentials
+	// grant. The refresh tokens replacing it are kept in the store.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// TokenURL is the token endpoint, defaults to that of gitea.
+	TokenURL string `json:"token_url,omitempty"`
+}
+
+// validate returns an error for missing credentials or an invalid token url.
+func (o *UpstreamOAuth2) validate() error {
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return errors.New("client_id and client_secret are required")
+	}
+
+	if o.TokenURL != "" {
+		if u, err := url.Parse(o.TokenURL); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid token_url %q", o.TokenURL)
+		}
+	}
+
+	return nil
+}
+
+func (o *UpstreamOAuth2) option() gitea.ClientOption {
+	return gitea.SetOAuth2(gitea.OAuth2Credentials{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		RefreshToken: o.RefreshToken,
+		TokenURL:     o.TokenURL,
+	})
+}
+
+// UnmarshalCaddyfile unmarshals the upstream_oauth2 block of a Caddyfile.
+//
+//	upstream_oauth2 {
+//		client_id <id>
+//		client_secret <secret>
+//		refresh_token <token>
+//		token_url https://yourgitea.yourdomain.com/login/oauth/access_token
+//	}
+func (o *UpstreamOAuth2) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		var dst *string
+
+		switch d.Val() {
+		case "client_id":
+			dst = &o.ClientID
+		case "client_secret":
+			dst = &o.ClientSecret
+		case "refresh_token":
+			dst = &o.RefreshToken
+		case "token_url":
+			dst = &o.TokenURL
+		default:
+			return d.Errf("unknown upstream_oauth2 option %q", d.Val())
+		}
+
+		if !d.Args(dst) {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// defaultUsageSchedule reports the usage every night at midnight.
+const defaultUsageSchedule = "0 0 * * *"
+
+// usageWebhookTimeout is how long posting a usage report may take.
+const usageWebhookTimeout = 30 * time.Second
+
+// UsageReports reports the usage of the sites of every owner (requests,
+// bytes and top pages) on a schedule, to the store and/or a webhook, for
+// chargeback or showback.
+type UsageReports struct {
+	// Schedule is a cron spec (minute hour day-of-month month day-of-week) in
+	// local time, defaults to every night at midnight; "0 0 * * 1" reports
+	// weekly.
+	Schedule string `json:"schedule,omitempty"`
+	// Top is the number of most requested pages reported per owner,
+	// defaults to 10.
+	Top int `json:"top,omitempty"`
+	// Webhook is the url the reports are posted to as JSON, signed with
+	// Secret in the X-Gitea-Pages-Signature header (the hex HMAC-SHA256 of
+	// the body) if it's set.
+	Webhook string `json:"webhook,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+
+	schedule   *cronSchedule
+	httpClient *http.Client
+}
+
+func (u *UsageReports) provision() error {
+	if u.Schedule == "" {
+		u.Schedule = defaultUsageSchedule
+	}
+
+	if u.Top == 0 {
+		u.Top = 10
+	}
+
+	var err error
+	if u.schedule, err = parseCron(u.Schedule); err != nil {
+		return err
+	}
+
+	u.httpClient = &http.Client{Timeout: usageWebhookTimeout}
+
+	return nil
+}
+
+// validate returns an error for an invalid schedule or webhook.
+func (u *UsageReports) validate() error {
+	if u.Schedule != "" {
+		if _, err := parseCron(u.Schedule); err != nil {
+			return err
+		}
+	}
+
+	if u.Top < 0 {
+		return fmt.Errorf("top must not be negative, not %d", u.Top)
+	}
+
+	if u.Webhook != "" {
+		if wu, err := url.Parse(u.Webhook); err != nil || wu.Scheme != "http" && wu.Scheme != "https" {
+			return fmt.Errorf("invalid webhook %q", u.Webhook)
+		}
+	}
+
+	return nil
+}
+
+// run reports the usage of c whenever the schedule matches, until ctx is
+// done.
+func (u *UsageReports) run(ctx context.Context, c *gitea.Client, logger *zap.Logger) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case t := <-timer.C:
+			if u.schedule.matches(t) {
+				go u.report(c.TakeUsageReport(u.Top), c, logger)
+			}
+		}
+	}
+}
+
+// report saves r to the store of c and posts it to the webhook.
+func (u *UsageReports) report(r gitea.UsageReport, c *gitea.Client, logger *zap.Logger) {
+	if err := c.SaveUsageReport(r); err != nil {
+		logger.Warn("saving usage report failed", zap.Error(err))
+	}
+
+	if u.Webhook != "" {
+		if err := u.post(r); err != nil {
+			logger.Warn("posting usage report failed", zap.String("webhook", u.Webhook), zap.Error(err))
+		}
+	}
+
+	logger.Info("reported usage", zap.Time("from", r.From), zap.Int("owners", len(r.Owners)))
+}
+
+// post posts r to the webhook.
+func (u *UsageReports) post(r gitea.UsageReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if u.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(u.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Gitea-Pages-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code '%d'", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile unmarshals the usage_reports block of a Caddyfile.
+//
+//	usage_reports {
+//		schedule "0 0 * * 1"
+//		top 20
+//		webhook https://billing.example.com/pages
+//		secret <secret>
+//	}
+func (u *UsageReports) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for n := d.Nesting(); d.NextBlock(n); {
+		switch d.Val() {
+		case "schedule":
+			if !d.Args(&u.Schedule) {
+				return d.ArgErr()
+			}
+		case "top":
+			if err := parseIntArg(d, &u.Top); err != nil {
+				return err
+			}
+		case "webhook":
+			if !d.Args(&u.Webhook) {
+				return d.ArgErr()
+			}
+		case "secret":
+			if !d.Args(&u.Secret) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown usage_reports option %q", d.Val())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package gitea
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/caddy-gitea/pkg/gitea"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// webhookPath is the path gitea webhooks are posted to, on any host.
+const webhookPath = "/.well-known/gitea-pages-hook"
+
+// maxWebhookSize is the size of the largest webhook payload accepted.
+const maxWebhookSize = 5 << 20
+
+// serveWebhook serves the webhook of the handler.
+func (m Middleware) serveWebhook(w http.ResponseWriter, r *http.Request) error {
+	wh := webhook{
+		secret: m.WebhookSecret,
+		each:   func(fn func(*gitea.Client)) { fn(m.Client) },
+		logger: m.logger,
+	}
+
+	return wh.serve(w, r)
+}
+
+// webhook purges the caches of clients for the gitea webhooks signed with
+// secret.
+type webhook struct {
+	secret string
+	each   func(fn func(*gitea.Client))
+	logger *zap.Logger
+}
+
+// serve purges the cached content of the repo of a gitea webhook (push,
+// create, delete, repository), signed with the webhook secret. The delivery
+// id is the id of the purge, so redelivered webhooks are applied once. A push
+// only changing the config of the repo (gitea-pages.toml) on the branch it's
+// read from purges the config, the content stays cached.
+func (wh webhook) serve(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return caddyhttp.Error(http.StatusMethodNotAllowed, errors.New("webhooks are posted"))
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(wh.secret))
+	mac.Write(body)
+
+	signature, err := hex.DecodeString(r.Header.Get("X-Gitea-Signature"))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return caddyhttp.Error(http.StatusUnauthorized, errors.New("invalid webhook signature"))
+	}
+
+	var payload struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Commits []struct {
+			Added    []string `json:"added"`
+			Removed  []string `json:"removed"`
+			Modified []string `json:"modified"`
+		} `json:"commits"`
+		TotalCommits int `json:"total_commits"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	owner, repo, ok := strings.Cut(payload.Repository.FullName, "/")
+	if !ok || owner == "" || repo == "" {
+		return caddyhttp.Error(http.StatusBadRequest, errors.New("webhook without repository"))
+	}
+
+	p := gitea.NewPurge(owner, repo, gitea.PurgeReasonWebhook)
+	if delivery := r.Header.Get("X-Gitea-Delivery"); delivery != "" {
+		p.ID = "webhook:" + delivery
+	}
+
+	// the changed paths are only known if the payload has all commits
+	var (
+		branch string
+		paths  []string
+	)
+
+	if b := strings.TrimPrefix(payload.Ref, "refs/heads/"); r.Header.Get("X-Gitea-Event") == "push" &&
+		b != payload.Ref && len(payload.Commits) > 0 && len(payload.Commits) >= payload.TotalCommits {
+		branch = b
+
+		for _, c := range payload.Commits {
+			paths = append(append(append(paths, c.Added...), c.Removed...), c.Modified...)
+		}
+	}
+
+	wh.each(func(c *gitea.Client) {
+		// the config of the repo is read from its branch of the client
+		p := p
+		p.Config = branch != "" && c.ConfigOnly(owner, branch, paths)
+
+		wh.logger.Debug("purging repo from webhook", zap.String("owner", owner), zap.String("repo", repo),
+			zap.String("event", r.Header.Get("X-Gitea-Event")), zap.Bool("config_only", p.Config))
+
+		c.Purge(p)
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}